@@ -1,75 +1,136 @@
 package auth
 
 import (
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
+	"github.com/amaraliou/stakeout/common"
 	uuid "github.com/satori/go.uuid"
 
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
-// CreateToken ...
-func CreateToken(userID uuid.UUID) (string, error) {
+// ErrInvalidToken -> the token doesn't carry the claims of an access token
+var ErrInvalidToken = errors.New("Invalid token")
+
+var (
+	// apiSecret -> HS256 key used when there is no keyring
+	apiSecret []byte
+
+	// Token lifetimes, set by Configure
+	accessTokenTTL      = common.DefaultConfig().Auth.AccessTokenTTL
+	adminAccessTokenTTL = common.DefaultConfig().Auth.AdminAccessTokenTTL
+	challengeTokenTTL   = common.DefaultConfig().Auth.ChallengeTokenTTL
+)
+
+// Configure -> sets the signing secret and the token lifetimes, and loads the keyring when a keys directory is configured
+func Configure(config common.AuthConfig) error {
+	apiSecret = []byte(config.APISecret)
+	accessTokenTTL = config.AccessTokenTTL
+	adminAccessTokenTTL = config.AdminAccessTokenTTL
+	challengeTokenTTL = config.ChallengeTokenTTL
+
+	if config.KeysDir == "" {
+		SetKeyring(nil)
+		return nil
+	}
+
+	keys, err := LoadKeyring(config.KeysDir, config.SigningKey)
+	if err != nil {
+		return err
+	}
+	SetKeyring(keys)
+	return nil
+}
+
+// CreateToken -> access token of a student, sessionID is the refresh token family it belongs to (uuid.Nil for none)
+func CreateToken(userID, sessionID uuid.UUID) (string, error) {
 	claims := jwt.MapClaims{}
 	claims["authorized"] = true
 	claims["user_id"] = userID.String()
 	claims["is_admin"] = false
-	claims["exp"] = time.Now().Add(time.Hour * 1).Unix() //Token expires after 1 hour
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("API_SECRET")))
+	claims["role"] = string(RoleStudent)
+	claims["exp"] = time.Now().Add(accessTokenTTL).Unix()
+	return signToken(claims, sessionID)
 }
 
-// CreateAdminToken ...
-func CreateAdminToken(adminID uuid.UUID) (string, error) {
+// CreateAdminToken -> access token of an admin of shopID (uuid.Nil for none), sessionID is the refresh token family it belongs to (uuid.Nil for none)
+func CreateAdminToken(adminID, shopID, sessionID uuid.UUID, role Role) (string, error) {
 	claims := jwt.MapClaims{}
 	claims["authorized"] = true
 	claims["admin_id"] = adminID.String()
 	claims["is_admin"] = true
-	claims["exp"] = time.Now().Add(time.Hour * 2).Unix()
+	claims["role"] = string(role)
+	claims["exp"] = time.Now().Add(adminAccessTokenTTL).Unix()
+	if shopID != uuid.Nil {
+		claims["shop_id"] = shopID.String()
+	}
+	return signToken(claims, sessionID)
+}
+
+// signToken -> gives the claims a unique jti and the session, then signs them
+func signToken(claims jwt.MapClaims, sessionID uuid.UUID) (string, error) {
+	jti, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+
+	claims["jti"] = jti.String()
+	if sessionID != uuid.Nil {
+		claims["sid"] = sessionID.String()
+	}
+
+	if keyring != nil {
+		return keyring.sign(claims)
+	}
+
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString([]byte(os.Getenv("API_SECRET")))
+	return token.SignedString(apiSecret)
 }
 
-// TokenValid ...
-func TokenValid(r *http.Request) error {
-	tokenString := ExtractToken(r)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("API_SECRET")), nil
-	})
+// ParseToken -> the one place a token is verified and read into Claims
+func ParseToken(tokenString string) (*Claims, error) {
+	token, err := jwt.Parse(tokenString, verificationKey)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		Pretty(claims)
+
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, ErrInvalidToken
 	}
-	return nil
+
+	return claimsFromMap(mapClaims)
 }
 
-// IsAdminToken ...
-func IsAdminToken(r *http.Request) (bool, error) {
-	tokenString := ExtractToken(r)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("API_SECRET")), nil
-	})
+// verificationKey -> the keyring's key named by the kid header, or the API secret for HS256 tokens when there is no keyring
+func verificationKey(token *jwt.Token) (interface{}, error) {
+	if keyring != nil {
+		return keyring.verificationKey(token)
+	}
+
+	if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+	return apiSecret, nil
+}
+
+// Authenticate -> parses the request token and checks it hasn't been revoked
+func Authenticate(r *http.Request) (*Claims, error) {
+	claims, err := ParseToken(ExtractToken(r))
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return claims["is_admin"].(bool), nil
+
+	err = checkRevoked(claims)
+	if err != nil {
+		return nil, err
 	}
-	return false, nil
+
+	return claims, nil
 }
 
 // ExtractToken ...
@@ -85,56 +146,3 @@ func ExtractToken(r *http.Request) string {
 	}
 	return ""
 }
-
-// ExtractTokenID ...
-func ExtractTokenID(r *http.Request) (string, error) {
-
-	tokenString := ExtractToken(r)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("API_SECRET")), nil
-	})
-	if err != nil {
-		return "", err
-	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if ok && token.Valid {
-		studentID := claims["user_id"].(string)
-		return studentID, nil
-	}
-	return "", nil
-}
-
-// ExtractTokenAdminID ...
-func ExtractTokenAdminID(r *http.Request) (string, error) {
-
-	tokenString := ExtractToken(r)
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(os.Getenv("API_SECRET")), nil
-	})
-	if err != nil {
-		return "", err
-	}
-	claims, ok := token.Claims.(jwt.MapClaims)
-	if ok && token.Valid {
-		adminID := claims["admin_id"].(string)
-		return adminID, nil
-	}
-	return "", nil
-}
-
-//Pretty display the claims licely in the terminal
-func Pretty(data interface{}) {
-	b, err := json.MarshalIndent(data, "", " ")
-	if err != nil {
-		log.Println(err)
-		return
-	}
-
-	fmt.Println(string(b))
-}
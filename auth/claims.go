@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Claims -> identity carried by an access token
+type Claims struct {
+	SubjectID uuid.UUID
+	IsAdmin   bool
+	Role      Role
+	ShopID    uuid.UUID
+	ExpiresAt time.Time
+	JTI       string
+	SessionID string
+}
+
+type contextKey int
+
+const claimsKey contextKey = iota
+
+// claimsFromMap -> reads the claims written by CreateToken and CreateAdminToken,
+// admin tokens issued before roles existed are shop owners
+func claimsFromMap(mapClaims jwt.MapClaims) (*Claims, error) {
+
+	// Challenge tokens only get exchanged for a session, they never authenticate a request
+	if _, ok := mapClaims["challenge"]; ok {
+		return nil, ErrInvalidToken
+	}
+
+	claims := &Claims{}
+	claims.IsAdmin, _ = mapClaims["is_admin"].(bool)
+
+	subjectKey := "user_id"
+	if claims.IsAdmin {
+		subjectKey = "admin_id"
+	}
+
+	subject, _ := mapClaims[subjectKey].(string)
+	subjectID, err := uuid.FromString(subject)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	claims.SubjectID = subjectID
+
+	role, _ := mapClaims["role"].(string)
+	claims.Role = Role(role)
+	if role == "" && claims.IsAdmin {
+		claims.Role = RoleShopOwner
+	} else if role == "" {
+		claims.Role = RoleStudent
+	}
+
+	if shop, ok := mapClaims["shop_id"].(string); ok {
+		claims.ShopID, err = uuid.FromString(shop)
+		if err != nil {
+			return nil, ErrInvalidToken
+		}
+	}
+
+	exp, _ := mapClaims["exp"].(float64)
+	claims.ExpiresAt = time.Unix(int64(exp), 0)
+	claims.JTI, _ = mapClaims["jti"].(string)
+	claims.SessionID, _ = mapClaims["sid"].(string)
+
+	return claims, nil
+}
+
+// IsStudent -> checks the token was issued to the given student
+func (claims *Claims) IsStudent(studentID string) bool {
+	return !claims.IsAdmin && claims.SubjectID.String() == studentID
+}
+
+// IsAdminOf -> checks the token was issued to the given admin
+func (claims *Claims) IsAdminOf(adminID string) bool {
+	return claims.IsAdmin && claims.SubjectID.String() == adminID
+}
+
+// WithClaims -> the request carrying the claims for the handlers down the chain
+func WithClaims(r *http.Request, claims *Claims) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), claimsKey, claims))
+}
+
+// RequestClaims -> claims put on the request by the auth middlewares,
+// the token is authenticated here when nothing upstream did it
+func RequestClaims(r *http.Request) (*Claims, error) {
+	if claims, ok := r.Context().Value(claimsKey).(*Claims); ok {
+		return claims, nil
+	}
+
+	return Authenticate(r)
+}
@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	jwt "github.com/dgrijalva/jwt-go"
+)
+
+// Key -> one key of the keyring, Private is nil for keys only kept to verify tokens signed before a rotation
+type Key struct {
+	ID      string
+	Method  jwt.SigningMethod
+	Private crypto.PrivateKey
+	Public  crypto.PublicKey
+}
+
+// Keyring -> the key new tokens are signed with, and every key tokens are still verified against
+type Keyring struct {
+	Signing *Key
+	Keys    map[string]*Key
+}
+
+// JWK -> a public key in JSON Web Key format (RFC 7517)
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
+
+var keyring *Keyring
+
+// SetKeyring -> signs and verifies tokens with the keyring, without one HS256 and the API secret are used
+func SetKeyring(k *Keyring) {
+	keyring = k
+}
+
+// LoadKeyring -> reads the <kid>.pem files of dir, private keys (PKCS#1 or PKCS#8, RSA or Ed25519) sign and verify,
+// public keys only verify. Tokens are signed with signingKID or, when empty, the last private key by name,
+// so naming keys by date rotates a new key in by adding its file
+func LoadKeyring(dir, signingKID string) (*Keyring, error) {
+
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+
+	k := &Keyring{Keys: map[string]*Key{}}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		kid := strings.TrimSuffix(filepath.Base(path), ".pem")
+		key, err := parseKey(kid, data)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+
+		k.Keys[kid] = key
+		if key.Private != nil && (signingKID == "" || signingKID == kid) {
+			k.Signing = key
+		}
+	}
+
+	if k.Signing == nil {
+		return nil, fmt.Errorf("No private key to sign tokens with in %s", dir)
+	}
+
+	return k, nil
+}
+
+// parseKey -> reads the first PEM block of a key file
+func parseKey(kid string, data []byte) (*Key, error) {
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("No PEM data")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		private, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newKey(kid, private, &private.PublicKey)
+
+	case "PRIVATE KEY":
+		private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		switch private := private.(type) {
+		case *rsa.PrivateKey:
+			return newKey(kid, private, &private.PublicKey)
+		case ed25519.PrivateKey:
+			return newKey(kid, private, private.Public())
+		}
+		return nil, errors.New("Unsupported private key type")
+
+	case "RSA PUBLIC KEY":
+		public, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newKey(kid, nil, public)
+
+	case "PUBLIC KEY":
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newKey(kid, nil, public)
+	}
+
+	return nil, fmt.Errorf("Unsupported PEM block %s", block.Type)
+}
+
+// newKey -> picks the signing method matching the public key
+func newKey(kid string, private crypto.PrivateKey, public crypto.PublicKey) (*Key, error) {
+
+	switch public.(type) {
+	case *rsa.PublicKey:
+		return &Key{ID: kid, Method: jwt.SigningMethodRS256, Private: private, Public: public}, nil
+	case ed25519.PublicKey:
+		return &Key{ID: kid, Method: SigningMethodEd25519, Private: private, Public: public}, nil
+	}
+
+	return nil, errors.New("Unsupported public key type")
+}
+
+// sign -> signs the claims with the signing key and names it in the kid header
+func (k *Keyring) sign(claims jwt.MapClaims) (string, error) {
+	token := jwt.NewWithClaims(k.Signing.Method, claims)
+	token.Header["kid"] = k.Signing.ID
+	return token.SignedString(k.Signing.Private)
+}
+
+// verificationKey -> jwt.Keyfunc returning the public key named by the kid header
+func (k *Keyring) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := k.Keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("Unknown signing key: %v", token.Header["kid"])
+	}
+
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+
+	return key.Public, nil
+}
+
+// JWKS -> the public keys of the keyring, what other services verify tokens with
+func JWKS() []JWK {
+
+	keys := []JWK{}
+	if keyring == nil {
+		return keys
+	}
+
+	for _, key := range keyring.Keys {
+		jwk := JWK{KeyID: key.ID, Use: "sig", Algorithm: key.Method.Alg()}
+		switch public := key.Public.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		}
+		keys = append(keys, jwk)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].KeyID < keys[j].KeyID
+	})
+
+	return keys
+}
@@ -0,0 +1,35 @@
+package auth
+
+import "errors"
+
+// ErrTokenRevoked -> the token was logged out or its session was revoked
+var ErrTokenRevoked = errors.New("Token has been revoked")
+
+// Revoker -> tells Authenticate whether a token was revoked, either by its jti or by its session (refresh token family)
+type Revoker interface {
+	IsRevoked(jti, sessionID string) (bool, error)
+}
+
+var revoker Revoker
+
+// SetRevoker -> plugs the revocation store into Authenticate, without one tokens are only checked for signature and expiry
+func SetRevoker(r Revoker) {
+	revoker = r
+}
+
+// checkRevoked -> asks the revoker about the token's jti and session
+func checkRevoked(claims *Claims) error {
+	if revoker == nil {
+		return nil
+	}
+
+	revoked, err := revoker.IsRevoked(claims.JTI, claims.SessionID)
+	if err != nil {
+		return err
+	}
+
+	if revoked {
+		return ErrTokenRevoked
+	}
+	return nil
+}
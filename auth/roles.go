@@ -0,0 +1,80 @@
+package auth
+
+// Role -> what kind of account a token was issued to
+type Role string
+
+const (
+	RoleSuperuser Role = "superuser"
+	RoleShopOwner Role = "shop_owner"
+	RoleShopStaff Role = "shop_staff"
+	RoleStudent   Role = "student"
+)
+
+// Permission -> a named action checked per route
+type Permission string
+
+const (
+	PermListStudents      Permission = "students:list"
+	PermListAdmins        Permission = "admins:list"
+	PermManageRoles       Permission = "admins:manage_roles"
+	PermCreateShop        Permission = "shops:create"
+	PermUpdateShop        Permission = "shops:update"
+	PermDeleteShop        Permission = "shops:delete"
+	PermManageProducts    Permission = "products:manage"
+	PermListAllOrders     Permission = "orders:list_all"
+	PermViewShopOrders    Permission = "orders:view_shop"
+	PermUpdateOrderStatus Permission = "orders:update_status"
+	PermDeleteOrder       Permission = "orders:delete"
+	PermPlaceOrder        Permission = "orders:place"
+	PermRequireTwoFactor  Permission = "shops:require_2fa"
+)
+
+// rolePermissions -> the permission matrix, superusers are allowed everything
+var rolePermissions = map[Role][]Permission{
+	RoleShopOwner: {
+		PermCreateShop,
+		PermUpdateShop,
+		PermDeleteShop,
+		PermManageProducts,
+		PermViewShopOrders,
+		PermUpdateOrderStatus,
+		PermDeleteOrder,
+	},
+	RoleShopStaff: {
+		PermManageProducts,
+		PermViewShopOrders,
+		PermUpdateOrderStatus,
+	},
+	RoleStudent: {
+		PermPlaceOrder,
+	},
+}
+
+// Can -> checks the role is granted the permission
+func (role Role) Can(permission Permission) bool {
+	if role == RoleSuperuser {
+		return true
+	}
+
+	for _, granted := range rolePermissions[role] {
+		if granted == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdminRole -> checks the role belongs to an admin account rather than a student
+func (role Role) IsAdminRole() bool {
+	return role == RoleSuperuser || role == RoleShopOwner || role == RoleShopStaff
+}
+
+// ValidRole -> checks the given string is a known role
+func ValidRole(role string) bool {
+	switch Role(role) {
+	case RoleSuperuser, RoleShopOwner, RoleShopStaff, RoleStudent:
+		return true
+	default:
+		return false
+	}
+}
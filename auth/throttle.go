@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Attempts -> the failed logins counted against a key since its last reset
+type Attempts struct {
+	Count       int
+	LastFailure time.Time
+}
+
+// AttemptStore -> keeps the failed login counters, keys name either an account or a client IP
+type AttemptStore interface {
+	Attempts(key string) (Attempts, error)
+	RecordFailure(key string, at time.Time) (Attempts, error)
+	Reset(key string) error
+}
+
+// Backoff -> how long a key has to wait after its failures: nothing for the first FreeAttempts,
+// then BaseDelay doubling up to MaxDelay, then LockoutFor once LockoutAfter failures are reached.
+// Counters older than ResetAfter are forgotten
+type Backoff struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	LockoutAfter int
+	LockoutFor   time.Duration
+	ResetAfter   time.Duration
+}
+
+// Wait -> how long the key still has to wait before its next attempt
+func (backoff Backoff) Wait(attempts Attempts, now time.Time) time.Duration {
+	if attempts.Count == 0 || backoff.stale(attempts, now) {
+		return 0
+	}
+
+	var delay time.Duration
+	switch {
+	case attempts.Count >= backoff.LockoutAfter:
+		delay = backoff.LockoutFor
+	case attempts.Count >= backoff.FreeAttempts:
+		doublings := uint(attempts.Count - backoff.FreeAttempts)
+		delay = backoff.MaxDelay
+		if doublings < 32 && backoff.BaseDelay<<doublings < backoff.MaxDelay {
+			delay = backoff.BaseDelay << doublings
+		}
+	default:
+		return 0
+	}
+
+	wait := attempts.LastFailure.Add(delay).Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait
+}
+
+// stale -> the last failure is old enough for the counter to start over
+func (backoff Backoff) stale(attempts Attempts, now time.Time) bool {
+	return now.Sub(attempts.LastFailure) > backoff.ResetAfter
+}
+
+// AccountBackoff -> default backoff of a single account, a handful of typos are free
+var AccountBackoff = Backoff{
+	FreeAttempts: 3,
+	BaseDelay:    time.Second,
+	MaxDelay:     5 * time.Minute,
+	LockoutAfter: 10,
+	LockoutFor:   15 * time.Minute,
+	ResetAfter:   time.Hour,
+}
+
+// IPBackoff -> default backoff of a client IP, looser as many users can share one address
+var IPBackoff = Backoff{
+	FreeAttempts: 20,
+	BaseDelay:    time.Second,
+	MaxDelay:     time.Minute,
+	LockoutAfter: 100,
+	LockoutFor:   time.Hour,
+	ResetAfter:   time.Hour,
+}
+
+// Throttle -> tracks failed logins per account and per client IP
+type Throttle struct {
+	Store   AttemptStore
+	Account Backoff
+	IP      Backoff
+	Now     func() time.Time
+}
+
+// NewThrottle -> throttle with the default backoffs
+func NewThrottle(store AttemptStore) *Throttle {
+	return &Throttle{Store: store, Account: AccountBackoff, IP: IPBackoff, Now: time.Now}
+}
+
+// accountKey and ipKey -> the store keys of an account (emails are case insensitive) and of a client IP
+func accountKey(account string) string {
+	return "account:" + strings.ToLower(account)
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Wait -> how long the account or the IP, whichever is longer, still has to wait before trying again
+func (throttle *Throttle) Wait(account, ip string) (time.Duration, error) {
+	now := throttle.Now()
+
+	attempts, err := throttle.Store.Attempts(accountKey(account))
+	if err != nil {
+		return 0, err
+	}
+	wait := throttle.Account.Wait(attempts, now)
+
+	attempts, err = throttle.Store.Attempts(ipKey(ip))
+	if err != nil {
+		return 0, err
+	}
+	if ipWait := throttle.IP.Wait(attempts, now); ipWait > wait {
+		wait = ipWait
+	}
+
+	return wait, nil
+}
+
+// Failed -> counts a failed login against both the account and the IP
+func (throttle *Throttle) Failed(account, ip string) error {
+	err := throttle.recordFailure(accountKey(account), throttle.Account)
+	if err != nil {
+		return err
+	}
+
+	return throttle.recordFailure(ipKey(ip), throttle.IP)
+}
+
+// Succeeded -> clears the account's counter, the IP's is left to expire so one good account can't clear it
+func (throttle *Throttle) Succeeded(account string) error {
+	return throttle.Store.Reset(accountKey(account))
+}
+
+// recordFailure -> counts a failure, starting over when the previous ones are stale
+func (throttle *Throttle) recordFailure(key string, backoff Backoff) error {
+	now := throttle.Now()
+
+	attempts, err := throttle.Store.Attempts(key)
+	if err != nil {
+		return err
+	}
+
+	if attempts.Count > 0 && backoff.stale(attempts, now) {
+		err = throttle.Store.Reset(key)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = throttle.Store.RecordFailure(key, now)
+	return err
+}
+
+// MemoryAttempts -> AttemptStore kept in memory, for tests and single instance deployments
+type MemoryAttempts struct {
+	mutex    sync.Mutex
+	attempts map[string]Attempts
+}
+
+// NewMemoryAttempts -> empty in memory store
+func NewMemoryAttempts() *MemoryAttempts {
+	return &MemoryAttempts{attempts: map[string]Attempts{}}
+}
+
+// Attempts -> the key's counter, zero when it has none
+func (store *MemoryAttempts) Attempts(key string) (Attempts, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.attempts[key], nil
+}
+
+// RecordFailure -> adds a failure to the key's counter
+func (store *MemoryAttempts) RecordFailure(key string, at time.Time) (Attempts, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	attempts := store.attempts[key]
+	attempts.Count++
+	attempts.LastFailure = at
+	store.attempts[key] = attempts
+	return attempts, nil
+}
+
+// Reset -> forgets the key's counter
+func (store *MemoryAttempts) Reset(key string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	delete(store.attempts, key)
+	return nil
+}
@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	jwt "github.com/dgrijalva/jwt-go"
+	uuid "github.com/satori/go.uuid"
+)
+
+// TOTP parameters (RFC 6238), the defaults every authenticator app understands
+const (
+	TOTPPeriod = 30
+	TOTPDigits = 6
+
+	// totpSkew -> codes of the steps either side of the current one are accepted too, for clock drift
+	totpSkew = 1
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// NewTOTPSecret -> random secret to share with the authenticator app, base32 encoded
+func NewTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return totpEncoding.EncodeToString(b), nil
+}
+
+// TOTPURI -> otpauth URI to show as a QR code for the authenticator app to scan
+func TOTPURI(issuer, account, secret string) string {
+	query := url.Values{}
+	query.Set("secret", secret)
+	query.Set("issuer", issuer)
+	query.Set("algorithm", "SHA1")
+	query.Set("digits", fmt.Sprint(TOTPDigits))
+	query.Set("period", fmt.Sprint(TOTPPeriod))
+
+	label := url.PathEscape(issuer + ":" + account)
+	return "otpauth://totp/" + label + "?" + query.Encode()
+}
+
+// TOTPStep -> the time step a code is valid for
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / TOTPPeriod
+}
+
+// TOTPCode -> code of the secret for the given time step
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return "", err
+	}
+
+	counter := make([]byte, 8)
+	binary.BigEndian.PutUint64(counter, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(counter)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < TOTPDigits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", TOTPDigits, value%modulo), nil
+}
+
+// ValidateTOTP -> checks the code against the steps around now, returns the step it matched so it can't be used twice
+func ValidateTOTP(secret, code string, now time.Time) (int64, bool) {
+	current := TOTPStep(now)
+	for step := current - totpSkew; step <= current+totpSkew; step++ {
+		expected, err := TOTPCode(secret, step)
+		if err != nil {
+			return 0, false
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// CreateChallengeToken -> short lived token proving the admin got the password right, only exchangeable for a session with a TOTP code
+func CreateChallengeToken(adminID uuid.UUID) (string, error) {
+	claims := jwt.MapClaims{}
+	claims["challenge"] = "2fa"
+	claims["admin_id"] = adminID.String()
+	claims["exp"] = time.Now().Add(challengeTokenTTL).Unix()
+	return signToken(claims, uuid.Nil)
+}
+
+// ParseChallengeToken -> the admin a challenge token was issued to
+func ParseChallengeToken(tokenString string) (uuid.UUID, error) {
+	token, err := jwt.Parse(tokenString, verificationKey)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	mapClaims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid || mapClaims["challenge"] != "2fa" {
+		return uuid.Nil, ErrInvalidToken
+	}
+
+	admin, _ := mapClaims["admin_id"].(string)
+	adminID, err := uuid.FromString(admin)
+	if err != nil {
+		return uuid.Nil, ErrInvalidToken
+	}
+	return adminID, nil
+}
@@ -1 +1,294 @@
 package common
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Config -> every setting of the API. Values come from the defaults, then the YAML file named by CONFIG_FILE,
+// then the environment (a .env file in the working directory is read into it first), each overriding the one before
+type Config struct {
+	Server             ServerConfig   `yaml:"server"`
+	Database           DatabaseConfig `yaml:"database"`
+	Auth               AuthConfig     `yaml:"auth"`
+	SMTP               SMTPConfig     `yaml:"smtp"`
+	UniversityRegistry string         `yaml:"university_registry"`
+	PostcodeDirectory  string         `yaml:"postcode_directory"`
+}
+
+// ServerConfig -> the HTTP listener
+type ServerConfig struct {
+	Port int `yaml:"port"`
+}
+
+// DatabaseConfig -> the Postgres connection
+type DatabaseConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+	Name     string `yaml:"name"`
+	SSLMode  string `yaml:"sslmode"`
+}
+
+// AuthConfig -> token signing and lifetimes
+type AuthConfig struct {
+	APISecret           string        `yaml:"api_secret"`
+	KeysDir             string        `yaml:"keys_dir"`
+	SigningKey          string        `yaml:"signing_key"`
+	AccessTokenTTL      time.Duration `yaml:"access_token_ttl"`
+	AdminAccessTokenTTL time.Duration `yaml:"admin_access_token_ttl"`
+	RefreshTokenTTL     time.Duration `yaml:"refresh_token_ttl"`
+	ChallengeTokenTTL   time.Duration `yaml:"challenge_token_ttl"`
+	VerifyEmailTTL      time.Duration `yaml:"verify_email_ttl"`
+	PasswordResetTTL    time.Duration `yaml:"password_reset_ttl"`
+}
+
+// SMTPConfig -> the outgoing mail server, emails are only logged when Host is empty
+type SMTPConfig struct {
+	Host     string `yaml:"host"`
+	Port     int    `yaml:"port"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+}
+
+// DefaultConfig -> the settings used for anything left unset
+func DefaultConfig() *Config {
+	return &Config{
+		Server: ServerConfig{
+			Port: 8080,
+		},
+		Database: DatabaseConfig{
+			Host:    "localhost",
+			Port:    5432,
+			SSLMode: "disable",
+		},
+		Auth: AuthConfig{
+			AccessTokenTTL:      time.Hour,
+			AdminAccessTokenTTL: 2 * time.Hour,
+			RefreshTokenTTL:     30 * 24 * time.Hour,
+			ChallengeTokenTTL:   5 * time.Minute,
+			VerifyEmailTTL:      24 * time.Hour,
+			PasswordResetTTL:    time.Hour,
+		},
+		SMTP: SMTPConfig{
+			Port: 587,
+		},
+		UniversityRegistry: "verification/universities.csv",
+		PostcodeDirectory:  "geocoding/postcodes.csv",
+	}
+}
+
+// LoadConfig -> reads and validates the configuration, the file given here wins over CONFIG_FILE
+func LoadConfig(path string) (*Config, error) {
+
+	err := godotenv.Load()
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("Cannot read .env: %v", err)
+	}
+
+	config := DefaultConfig()
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		err = yaml.UnmarshalStrict(data, config)
+		if err != nil {
+			return nil, fmt.Errorf("Cannot read %s: %v", path, err)
+		}
+	}
+
+	err = config.readEnv(os.LookupEnv)
+	if err != nil {
+		return nil, err
+	}
+
+	err = config.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+// readEnv -> overrides the settings whose variable is set. DB_PASS is the old name of DB_PASSWORD, still used by CI
+func (config *Config) readEnv(lookup func(string) (string, bool)) error {
+
+	texts := []struct {
+		names  []string
+		target *string
+	}{
+		{[]string{"DB_HOST"}, &config.Database.Host},
+		{[]string{"DB_USER"}, &config.Database.User},
+		{[]string{"DB_PASSWORD", "DB_PASS"}, &config.Database.Password},
+		{[]string{"DB_NAME"}, &config.Database.Name},
+		{[]string{"DB_SSLMODE"}, &config.Database.SSLMode},
+		{[]string{"API_SECRET"}, &config.Auth.APISecret},
+		{[]string{"JWT_KEYS_DIR"}, &config.Auth.KeysDir},
+		{[]string{"JWT_SIGNING_KEY"}, &config.Auth.SigningKey},
+		{[]string{"SMTP_HOST"}, &config.SMTP.Host},
+		{[]string{"SMTP_USER"}, &config.SMTP.Username},
+		{[]string{"SMTP_PASSWORD"}, &config.SMTP.Password},
+		{[]string{"SMTP_FROM"}, &config.SMTP.From},
+		{[]string{"UNIVERSITY_REGISTRY"}, &config.UniversityRegistry},
+		{[]string{"POSTCODE_DIRECTORY"}, &config.PostcodeDirectory},
+	}
+
+	for _, setting := range texts {
+		if value, ok := lookupFirst(lookup, setting.names); ok {
+			*setting.target = value
+		}
+	}
+
+	ints := []struct {
+		name   string
+		target *int
+	}{
+		{"PORT", &config.Server.Port},
+		{"DB_PORT", &config.Database.Port},
+		{"SMTP_PORT", &config.SMTP.Port},
+	}
+
+	for _, setting := range ints {
+		value, ok := lookup(setting.name)
+		if !ok || value == "" {
+			continue
+		}
+
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("Invalid %s: %q is not a number", setting.name, value)
+		}
+		*setting.target = number
+	}
+
+	durations := []struct {
+		name   string
+		target *time.Duration
+	}{
+		{"ACCESS_TOKEN_TTL", &config.Auth.AccessTokenTTL},
+		{"ADMIN_ACCESS_TOKEN_TTL", &config.Auth.AdminAccessTokenTTL},
+		{"REFRESH_TOKEN_TTL", &config.Auth.RefreshTokenTTL},
+		{"CHALLENGE_TOKEN_TTL", &config.Auth.ChallengeTokenTTL},
+		{"VERIFY_EMAIL_TTL", &config.Auth.VerifyEmailTTL},
+		{"PASSWORD_RESET_TTL", &config.Auth.PasswordResetTTL},
+	}
+
+	for _, setting := range durations {
+		value, ok := lookup(setting.name)
+		if !ok || value == "" {
+			continue
+		}
+
+		duration, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("Invalid %s: %q is not a duration like 30m or 24h", setting.name, value)
+		}
+		*setting.target = duration
+	}
+
+	return nil
+}
+
+// lookupFirst -> the value of the first of the variables that is set
+func lookupFirst(lookup func(string) (string, bool), names []string) (string, bool) {
+	for _, name := range names {
+		if value, ok := lookup(name); ok {
+			return value, true
+		}
+	}
+	return "", false
+}
+
+// Validate -> checks the settings make sense together, every problem is reported at once
+func (config *Config) Validate() error {
+
+	problems := []string{}
+	check := func(ok bool, problem string) {
+		if !ok {
+			problems = append(problems, problem)
+		}
+	}
+
+	check(validPort(config.Server.Port), "server port must be between 1 and 65535")
+	check(config.Database.Host != "", "database host is required (DB_HOST)")
+	check(validPort(config.Database.Port), "database port must be between 1 and 65535")
+	check(config.Database.User != "", "database user is required (DB_USER)")
+	check(config.Database.Name != "", "database name is required (DB_NAME)")
+	check(config.Auth.APISecret != "" || config.Auth.KeysDir != "", "either an API secret (API_SECRET) or a keys directory (JWT_KEYS_DIR) is required to sign tokens")
+
+	ttls := []struct {
+		name string
+		ttl  time.Duration
+	}{
+		{"access token", config.Auth.AccessTokenTTL},
+		{"admin access token", config.Auth.AdminAccessTokenTTL},
+		{"refresh token", config.Auth.RefreshTokenTTL},
+		{"challenge token", config.Auth.ChallengeTokenTTL},
+		{"email verification", config.Auth.VerifyEmailTTL},
+		{"password reset", config.Auth.PasswordResetTTL},
+	}
+	for _, v := range ttls {
+		check(v.ttl > 0, v.name+" lifetime must be positive")
+	}
+	check(config.Auth.RefreshTokenTTL >= config.Auth.AccessTokenTTL, "refresh tokens can't expire before access tokens")
+
+	if config.SMTP.Host != "" {
+		check(validPort(config.SMTP.Port), "SMTP port must be between 1 and 65535")
+		check(config.SMTP.From != "", "SMTP sender is required (SMTP_FROM) when SMTP_HOST is set")
+	}
+
+	if len(problems) > 0 {
+		return errors.New("Invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func validPort(port int) bool {
+	return port > 0 && port <= 65535
+}
+
+// DSN -> connection string for gorm.Open("postgres", ...)
+func (database DatabaseConfig) DSN() string {
+	return fmt.Sprintf("host=%s port=%d user=%s dbname=%s sslmode=%s password=%s", database.Host, database.Port, database.User, database.Name, database.SSLMode, database.Password)
+}
+
+// Addr -> address for http.ListenAndServe
+func (server ServerConfig) Addr() string {
+	return fmt.Sprintf(":%d", server.Port)
+}
+
+// redacted -> stands in for secrets in Redacted, empty secrets stay empty so a missing one still shows
+const redacted = "********"
+
+// Redacted -> the configuration as YAML with its secrets hidden, safe for the startup logs
+func (config Config) Redacted() string {
+
+	for _, secret := range []*string{&config.Database.Password, &config.Auth.APISecret, &config.SMTP.Password} {
+		if *secret != "" {
+			*secret = redacted
+		}
+	}
+
+	out, err := yaml.Marshal(config)
+	if err != nil {
+		return err.Error()
+	}
+	return string(out)
+}
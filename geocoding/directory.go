@@ -0,0 +1,188 @@
+package geocoding
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Location -> the centroid of a postcode
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// Directory -> maps postcodes to their centroid, a nil directory knows no postcodes
+type Directory struct {
+	locations map[string]Location
+}
+
+// Column names of the postcode, latitude and longitude in the files read by ReadDirectory, in order of preference.
+// pcds, lat and long are the ones of the ONS Postcode Directory
+var (
+	postcodeColumns  = []string{"pcds", "pcd", "postcode"}
+	latitudeColumns  = []string{"lat", "latitude"}
+	longitudeColumns = []string{"long", "longitude", "lng"}
+)
+
+// NewDirectory -> directory of the given postcode -> location pairs, invalid postcodes are left out
+func NewDirectory(locations map[string]Location) *Directory {
+	directory := &Directory{locations: map[string]Location{}}
+	for postcode, location := range locations {
+		normalised, err := NormalisePostcode(postcode)
+		if err == nil {
+			directory.locations[normalised] = location
+		}
+	}
+	return directory
+}
+
+// LoadDirectory -> reads a directory file, see ReadDirectory
+func LoadDirectory(path string) (*Directory, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadDirectory(file)
+}
+
+// ReadDirectory -> reads a CSV file whose header names a postcode, a latitude and a longitude column, like the ONS Postcode
+// Directory. Other columns, blank lines and # comments are skipped, so are postcodes without a location, which the ONS gives
+// a latitude of 99.999999. Rows that can't be read are logged with their line number and skipped, one bad row doesn't lose
+// the rest of the file. Records are read a line each, quoted fields can't span lines
+func ReadDirectory(reader io.Reader) (*Directory, error) {
+
+	lines := bufio.NewScanner(reader)
+	line := 0
+	var header []string
+	for header == nil && lines.Scan() {
+		line++
+		record, err := readRecord(lines.Text())
+		if err != nil {
+			return nil, fmt.Errorf("Invalid postcode directory header on line %d: %v", line, err)
+		}
+		header = record
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+	if header == nil {
+		return nil, errors.New("Postcode directory has no header")
+	}
+
+	postcodeColumn, latitudeColumn, longitudeColumn := findColumn(header, postcodeColumns), findColumn(header, latitudeColumns), findColumn(header, longitudeColumns)
+	if postcodeColumn < 0 || latitudeColumn < 0 || longitudeColumn < 0 {
+		return nil, fmt.Errorf("Postcode directory header needs a postcode, a latitude and a longitude column: %s", strings.Join(header, ","))
+	}
+
+	directory := &Directory{locations: map[string]Location{}}
+	skipped := 0
+	for lines.Scan() {
+		line++
+		record, err := readRecord(lines.Text())
+		if err != nil {
+			log.Printf("Skipping postcode directory line %d: %v", line, err)
+			skipped++
+			continue
+		}
+		if record == nil {
+			continue
+		}
+
+		if len(record) <= postcodeColumn || len(record) <= latitudeColumn || len(record) <= longitudeColumn {
+			log.Printf("Skipping postcode directory line %d: missing columns in %s", line, strings.Join(record, ","))
+			skipped++
+			continue
+		}
+
+		postcode, err := NormalisePostcode(record[postcodeColumn])
+		if err != nil {
+			log.Printf("Skipping postcode directory line %d: %q is not a postcode", line, record[postcodeColumn])
+			skipped++
+			continue
+		}
+
+		latitude, latitudeErr := strconv.ParseFloat(strings.TrimSpace(record[latitudeColumn]), 64)
+		longitude, longitudeErr := strconv.ParseFloat(strings.TrimSpace(record[longitudeColumn]), 64)
+		if latitudeErr != nil || longitudeErr != nil {
+			log.Printf("Skipping postcode directory line %d: invalid location in %s", line, strings.Join(record, ","))
+			skipped++
+			continue
+		}
+
+		if latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 {
+			continue
+		}
+		directory.locations[postcode] = Location{Latitude: latitude, Longitude: longitude}
+	}
+	if err := lines.Err(); err != nil {
+		return nil, err
+	}
+
+	if skipped > 0 {
+		log.Printf("Skipped %d unreadable postcode directory lines, %d postcodes loaded", skipped, len(directory.locations))
+	}
+	return directory, nil
+}
+
+// readRecord -> the fields of a CSV line, nil for blank lines and # comments
+func readRecord(text string) ([]string, error) {
+
+	if strings.TrimSpace(text) == "" || strings.HasPrefix(text, "#") {
+		return nil, nil
+	}
+
+	record, err := csv.NewReader(strings.NewReader(text)).Read()
+	if parseErr, ok := err.(*csv.ParseError); ok {
+		// Its line and column are within the one line read
+		return nil, parseErr.Err
+	}
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// findColumn -> index of the first of the names found in the header, -1 when none is
+func findColumn(header []string, names []string) int {
+	for _, name := range names {
+		for i, column := range header {
+			if strings.EqualFold(strings.TrimSpace(column), name) {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Locate -> the centroid of the postcode, whatever its case and spacing
+func (directory *Directory) Locate(postcode string) (Location, bool) {
+
+	if directory == nil {
+		return Location{}, false
+	}
+
+	normalised, err := NormalisePostcode(postcode)
+	if err != nil {
+		return Location{}, false
+	}
+
+	location, ok := directory.locations[normalised]
+	return location, ok
+}
+
+// Len -> how many postcodes the directory knows
+func (directory *Directory) Len() int {
+	if directory == nil {
+		return 0
+	}
+	return len(directory.locations)
+}
@@ -0,0 +1,33 @@
+package geocoding
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalidPostcode -> returned for anything that isn't shaped like a UK postcode
+var ErrInvalidPostcode = errors.New("Invalid postcode")
+
+// The outward code (area and district, e.g. G12 or SW1A) and the inward code (sector and unit, e.g. 8QQ) of a postcode,
+// with the letters Royal Mail doesn't use in each position left out. GIR 0AA is the one postcode breaking the rules
+var (
+	outwardCode = regexp.MustCompile(`^([A-PR-UWYZ][0-9][0-9]?|[A-PR-UWYZ][A-HK-Y][0-9][0-9]?|[A-PR-UWYZ][0-9][A-HJKPSTUW]|[A-PR-UWYZ][A-HK-Y][0-9][ABEHMNPRVWXY]|GIR)$`)
+	inwardCode  = regexp.MustCompile(`^[0-9][ABD-HJLNP-UW-Z]{2}$`)
+)
+
+// NormalisePostcode -> the postcode in capitals with a single space before the inward code, e.g. " g128qq" gives "G12 8QQ"
+func NormalisePostcode(postcode string) (string, error) {
+
+	compact := strings.ToUpper(strings.Join(strings.Fields(postcode), ""))
+	if len(compact) < 5 || len(compact) > 7 {
+		return "", ErrInvalidPostcode
+	}
+
+	outward, inward := compact[:len(compact)-3], compact[len(compact)-3:]
+	if !outwardCode.MatchString(outward) || !inwardCode.MatchString(inward) {
+		return "", ErrInvalidPostcode
+	}
+
+	return outward + " " + inward, nil
+}
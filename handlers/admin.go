@@ -11,9 +11,10 @@ import (
 	"github.com/amaraliou/stakeout/models"
 	"github.com/amaraliou/stakeout/responses"
 	"github.com/gorilla/mux"
+	uuid "github.com/satori/go.uuid"
 )
 
-// CreateAdmin -> handles POST /api/v1/admin/
+// CreateAdmin -> handles POST /api/v1/admins
 func (server *Server) CreateAdmin(writer http.ResponseWriter, request *http.Request) {
 
 	body, err := ioutil.ReadAll(request.Body)
@@ -34,35 +35,50 @@ func (server *Server) CreateAdmin(writer http.ResponseWriter, request *http.Requ
 		return
 	}
 
-	adminCreated, err := admin.CreateAdmin(server.DB)
+	// Self registered admins own their shop, other roles are given out by a superuser
+	admin.Role = string(auth.RoleShopOwner)
+
+	// Verified once the emailed token comes back, see VerifyEmail
+	admin.IsVerified = false
+
+	// Two-factor authentication is only set up through EnrollTwoFactor
+	admin.TwoFactor = models.TwoFactor{}
+
+	adminCreated, err := server.Admins.CreateAdmin(&admin)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
+	server.sendVerificationEmail(adminCreated.ID, true, adminCreated.Email)
+
 	writer.Header().Set("Location", fmt.Sprintf("%s%s/%s", request.Host, request.RequestURI, adminCreated.ID.String()))
 	responses.JSON(writer, http.StatusCreated, adminCreated)
 }
 
-// GetAdmins -> handles GET /api/v1/admin/
+// GetAdmins -> handles GET /api/v1/admins
 func (server *Server) GetAdmins(writer http.ResponseWriter, request *http.Request) {
 
-	admin := models.Admin{}
-	admins, err := admin.FindAllAdmins(server.DB)
+	query, err := readListQuery(request, models.AdminList, nil)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	admins, next, err := server.Admins.ListAdmins(query)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
-	responses.JSON(writer, http.StatusOK, admins)
+	responses.PAGE(writer, admins, next)
 }
 
-// GetAdminByID -> handles GET /api/v1/admin/<id:uuid>
+// GetAdminByID -> handles GET /api/v1/admins/<id:uuid>
 func (server *Server) GetAdminByID(writer http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
-	admin := models.Admin{}
-	adminRetrieved, err := admin.FindAdminByID(server.DB, vars["id"])
+	adminRetrieved, err := server.Admins.FindAdminByID(vars["id"])
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -71,7 +87,7 @@ func (server *Server) GetAdminByID(writer http.ResponseWriter, request *http.Req
 	responses.JSON(writer, http.StatusOK, adminRetrieved)
 }
 
-// UpdateAdmin -> handles PUT /api/v1/admin/<id:uuid>
+// UpdateAdmin -> handles PUT /api/v1/admins/<id:uuid>
 func (server *Server) UpdateAdmin(writer http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
@@ -89,24 +105,18 @@ func (server *Server) UpdateAdmin(writer http.ResponseWriter, request *http.Requ
 		return
 	}
 
-	isAdmin, err := auth.IsAdminToken(request)
+	claims, err := auth.RequestClaims(request)
 	if err != nil {
 		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
 		return
 	}
 
-	if !isAdmin {
+	if !claims.IsAdmin {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized: This is not an admin token"))
 		return
 	}
 
-	tokenID, err := auth.ExtractTokenAdminID(request)
-	if err != nil {
-		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized"))
-		return
-	}
-
-	if tokenID != adminID {
+	if !claims.IsAdminOf(adminID) {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
 		return
 	}
@@ -117,45 +127,52 @@ func (server *Server) UpdateAdmin(writer http.ResponseWriter, request *http.Requ
 		return
 	}
 
-	updatedAdmin, err := admin.UpdateAdmin(server.DB, adminID)
+	// Roles are changed through UpdateAdminRole only
+	admin.Role = ""
+
+	currentAdmin, err := server.Admins.FindAdminByID(adminID)
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	updatedAdmin, err := server.Admins.UpdateAdmin(adminID, &admin)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
+	// The new email is unverified until the admin reads this
+	if updatedAdmin.Email != currentAdmin.Email {
+		server.sendVerificationEmail(updatedAdmin.ID, true, updatedAdmin.Email)
+	}
+
 	responses.JSON(writer, http.StatusOK, updatedAdmin)
 }
 
-// DeleteAdmin -> handles DELETE /api/v1/admin/<id:uuid>
+// DeleteAdmin -> handles DELETE /api/v1/admins/<id:uuid>
 func (server *Server) DeleteAdmin(writer http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
 	adminID := vars["id"]
-	admin := models.Admin{}
 
-	isAdmin, err := auth.IsAdminToken(request)
+	claims, err := auth.RequestClaims(request)
 	if err != nil {
 		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
 		return
 	}
 
-	if !isAdmin {
+	if !claims.IsAdmin {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized: This is not an admin token"))
 		return
 	}
 
-	tokenID, err := auth.ExtractTokenAdminID(request)
-	if err != nil {
-		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized"))
-		return
-	}
-
-	if tokenID != "" && tokenID != adminID {
+	if !claims.IsAdminOf(adminID) {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
 		return
 	}
 
-	_, err = admin.DeleteAdmin(server.DB, adminID)
+	_, err = server.Admins.DeleteAdmin(adminID)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -166,3 +183,58 @@ func (server *Server) DeleteAdmin(writer http.ResponseWriter, request *http.Requ
 	writer.Header().Set("Entity", fmt.Sprintf("%s", adminID))
 	responses.JSON(writer, http.StatusNoContent, "")
 }
+
+// UpdateAdminRole -> handles PUT /api/v1/admins/<id:uuid>/role
+func (server *Server) UpdateAdminRole(writer http.ResponseWriter, request *http.Request) {
+
+	vars := mux.Vars(request)
+	adminID := vars["id"]
+	admin := models.Admin{}
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	roleUpdate := struct {
+		Role   string `json:"role"`
+		ShopID string `json:"shop_id"`
+	}{}
+	err = json.Unmarshal(body, &roleUpdate)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if !auth.ValidRole(roleUpdate.Role) || !auth.Role(roleUpdate.Role).IsAdminRole() {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, errors.New("Invalid role"))
+		return
+	}
+
+	if roleUpdate.ShopID != "" {
+		shopUUID, err := uuid.FromString(roleUpdate.ShopID)
+		if err != nil {
+			responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+			return
+		}
+
+		_, err = server.Shops.FindShopByID(roleUpdate.ShopID)
+		if err != nil {
+			responses.ERROR(writer, http.StatusInternalServerError, err)
+			return
+		}
+
+		admin.ShopID = shopUUID
+	}
+
+	admin.Role = roleUpdate.Role
+
+	updatedAdmin, err := server.Admins.UpdateAdminRole(adminID, &admin)
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(writer, http.StatusOK, updatedAdmin)
+}
@@ -4,24 +4,37 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"sync"
 
+	"github.com/amaraliou/stakeout/auth"
+	"github.com/amaraliou/stakeout/common"
+	"github.com/amaraliou/stakeout/geocoding"
+	"github.com/amaraliou/stakeout/mailer"
+	"github.com/amaraliou/stakeout/models"
+	"github.com/amaraliou/stakeout/verification"
 	"github.com/gorilla/mux"
 	"github.com/jinzhu/gorm"
 )
 
 // Server ...
 type Server struct {
-	DB     *gorm.DB
-	Router *mux.Router
+	models.Repositories
+	DB        *gorm.DB
+	Router    *mux.Router
+	Mailer    mailer.Mailer
+	Verifier  verification.Provider
+	Throttle  *auth.Throttle
+	Postcodes *geocoding.Directory
+
+	emails sync.WaitGroup // Emails still being sent after their response, see sendInBackground
 }
 
-// Initialize -> Function to initialize a server with Postgres given the credentials
-func (server *Server) Initialize(User, Password, Port, Host, Name string) {
+// Initialize -> Function to initialize a server with the configured Postgres database
+func (server *Server) Initialize(database common.DatabaseConfig) {
 
 	var err error
 
-	DBURI := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable password=%s", Host, Port, User, Name, Password)
-	server.DB, err = gorm.Open("postgres", DBURI)
+	server.DB, err = gorm.Open("postgres", database.DSN())
 	if err != nil {
 		fmt.Printf("Cannot connect to Postgres database")
 		log.Fatal("This is the error:", err)
@@ -29,13 +42,45 @@ func (server *Server) Initialize(User, Password, Port, Host, Name string) {
 		fmt.Printf("We are connected to the Postgres database")
 	}
 
-	server.DB.Debug().AutoMigrate()
+	if server.Students == nil {
+		server.Repositories = models.GormRepositories(server.DB)
+	}
+
+	auth.SetRevoker(server.Sessions)
+
+	if server.Mailer == nil {
+		server.Mailer = mailer.Log{}
+	}
+
+	if server.Verifier == nil {
+		server.Verifier = verification.DomainProvider{Registry: verification.NewRegistry(nil)}
+	}
+
+	if server.Throttle == nil {
+		server.Throttle = auth.NewThrottle(models.AttemptStore{DB: server.DB})
+	}
+
 	server.Router = mux.NewRouter()
 	server.initializeRoutes()
 }
 
 // Run ... making my linter happy
 func (server *Server) Run(addr string) {
-	fmt.Println("Listening to port 8080")
+	fmt.Printf("Listening on %s\n", addr)
 	log.Fatal(http.ListenAndServe(addr, server.Router))
 }
+
+// sendInBackground -> runs send once the handler returned, so how long a request takes doesn't tell
+// whether its email is registered
+func (server *Server) sendInBackground(send func()) {
+	server.emails.Add(1)
+	go func() {
+		defer server.emails.Done()
+		send()
+	}()
+}
+
+// WaitForEmails -> waits for the emails sent in the background, for tests and shutdowns
+func (server *Server) WaitForEmails() {
+	server.emails.Wait()
+}
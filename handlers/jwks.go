@@ -0,0 +1,13 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/amaraliou/stakeout/auth"
+	"github.com/amaraliou/stakeout/responses"
+)
+
+// JWKS -> handles GET /.well-known/jwks.json
+func (server *Server) JWKS(writer http.ResponseWriter, request *http.Request) {
+	responses.JSON(writer, http.StatusOK, map[string]interface{}{"keys": auth.JWKS()})
+}
@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/amaraliou/stakeout/models"
+)
+
+// readListQuery -> the page a list handler was asked for. scope pins filters to the path of nested routes,
+// e.g. the shop of /shops/<shop_id>/products, whatever the query string says
+func readListQuery(request *http.Request, spec models.ListSpec, scope map[string]string) (models.ListQuery, error) {
+
+	values := request.URL.Query()
+	for name, value := range scope {
+		values.Set(name, value)
+	}
+
+	return models.ParseListQuery(values, spec)
+}
@@ -2,15 +2,28 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
+	"log"
+	"math"
+	"net"
 	"net/http"
+	"strconv"
 
+	"github.com/amaraliou/stakeout/auth"
 	"github.com/amaraliou/stakeout/models"
 	"github.com/amaraliou/stakeout/responses"
-	"github.com/amaraliou/stakeout/auth"
-	"golang.org/x/crypto/bcrypt"
+	uuid "github.com/satori/go.uuid"
 )
 
+// tokenPair -> body of a successful login or token refresh, admins using two-factor authentication get a challenge token instead
+type tokenPair struct {
+	Token          string `json:"token,omitempty"`
+	RefreshToken   string `json:"refresh_token,omitempty"`
+	ChallengeToken string `json:"challenge_token,omitempty"`
+}
+
 // Login -> handles POST /api/v1/login
 func (server *Server) Login(writer http.ResponseWriter, request *http.Request) {
 
@@ -33,16 +46,12 @@ func (server *Server) Login(writer http.ResponseWriter, request *http.Request) {
 		return
 	}
 
-	token, err := server.SignIn(student.Email, student.Password)
-	if err != nil {
-		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
-		return
-	}
-
-	responses.JSON(writer, http.StatusOK, token)
+	server.throttledSignIn(writer, request, "student:"+student.Email, func() (tokenPair, error) {
+		return server.studentSignIn(student.Email, student.Password)
+	})
 }
 
-// AdminLogin -> handles POST /api/v1/admin/login
+// AdminLogin -> handles POST /api/v1/admins/login
 func (server *Server) AdminLogin(writer http.ResponseWriter, request *http.Request) {
 
 	body, err := ioutil.ReadAll(request.Body)
@@ -64,47 +73,279 @@ func (server *Server) AdminLogin(writer http.ResponseWriter, request *http.Reque
 		return
 	}
 
-	token, err := server.AdminSignIn(admin.Email, admin.Password)
+	server.throttledSignIn(writer, request, "admin:"+admin.Email, func() (tokenPair, error) {
+		return server.adminSignIn(admin.Email, admin.Password)
+	})
+}
+
+// RefreshToken -> handles POST /api/v1/token/refresh
+func (server *Server) RefreshToken(writer http.ResponseWriter, request *http.Request) {
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	tokens := tokenPair{}
+	err = json.Unmarshal(body, &tokens)
 	if err != nil {
 		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
 		return
 	}
 
-	responses.JSON(writer, http.StatusOK, token)
+	if tokens.RefreshToken == "" {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, errors.New("Required Refresh Token"))
+		return
+	}
+
+	next, refreshToken, err := server.Sessions.RotateRefreshToken(tokens.RefreshToken)
+	if err == models.ErrInvalidRefreshToken || err == models.ErrRefreshTokenReused {
+		responses.ERROR(writer, http.StatusUnauthorized, err)
+		return
+	}
+
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	token, err := server.accessToken(next)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnauthorized, models.ErrInvalidRefreshToken)
+		return
+	}
+
+	responses.JSON(writer, http.StatusOK, tokenPair{Token: token, RefreshToken: refreshToken})
 }
 
-// SignIn -> retrieves user JWT token given username and password
-func (server *Server) SignIn(email, password string) (string, error) {
+// Logout -> handles POST /api/v1/logout
+func (server *Server) Logout(writer http.ResponseWriter, request *http.Request) {
 
-	var err error
-	student := models.Student{}
-	err = server.DB.Debug().Model(models.Student{}).Where("email = ?", email).Take(&student).Error
+	claims, err := auth.RequestClaims(request)
 	if err != nil {
-		return "", err
+		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	err = server.Sessions.RevokeSession(claims.JTI, claims.SessionID, claims.ExpiresAt)
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(writer, http.StatusNoContent, "")
+}
+
+// throttledSignIn -> runs signIn unless the account or the client is backing off from failed logins, and writes the response
+func (server *Server) throttledSignIn(writer http.ResponseWriter, request *http.Request, account string, signIn func() (tokenPair, error)) {
+
+	ip := clientIP(request)
+	if server.backingOff(writer, account, ip, "failed logins") {
+		return
+	}
+
+	tokens, err := signIn()
+	if err == models.ErrInvalidCredentials {
+		server.loginFailed(account, ip)
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if err == models.ErrEmailNotVerified || err == models.ErrPasswordResetRequired {
+		responses.ERROR(writer, http.StatusForbidden, err)
+		return
 	}
 
-	err = models.VerifyPassword(student.Password, password)
-	if err != nil && err == bcrypt.ErrMismatchedHashAndPassword {
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	server.loginSucceeded(account)
+	responses.JSON(writer, http.StatusOK, tokens)
+}
+
+// backingOff -> answers 429 when the account or the client still has to wait after its failed attempts
+func (server *Server) backingOff(writer http.ResponseWriter, account, ip, attempts string) bool {
+
+	wait, err := server.Throttle.Wait(account, ip)
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return true
+	}
+
+	if wait > 0 {
+		seconds := int(math.Ceil(wait.Seconds()))
+		writer.Header().Set("Retry-After", strconv.Itoa(seconds))
+		responses.ERROR(writer, http.StatusTooManyRequests, fmt.Errorf("Too many %s, try again in %d seconds", attempts, seconds))
+		return true
+	}
+
+	return false
+}
+
+// loginFailed and loginSucceeded -> keep the throttle's counters, failures are only logged as the login was answered anyway
+func (server *Server) loginFailed(account, ip string) {
+	err := server.Throttle.Failed(account, ip)
+	if err != nil {
+		log.Printf("Cannot count the failed login of %s: %v", account, err)
+	}
+}
+
+func (server *Server) loginSucceeded(account string) {
+	err := server.Throttle.Succeeded(account)
+	if err != nil {
+		log.Printf("Cannot reset the failed logins of %s: %v", account, err)
+	}
+}
+
+// emailRequested -> throttles the routes emailing a link, every request counts against the email and the client whether or not
+// the email is registered. The client's counter is kept apart from its failed logins, which a shared address shouldn't lose to emails
+func (server *Server) emailRequested(writer http.ResponseWriter, request *http.Request, purpose, email string) bool {
+
+	account := purpose + ":" + email
+	ip := purpose + ":" + clientIP(request)
+	if server.backingOff(writer, account, ip, "emails requested") {
+		return false
+	}
+
+	err := server.Throttle.Failed(account, ip)
+	if err != nil {
+		log.Printf("Cannot count the email requested for %s: %v", account, err)
+	}
+	return true
+}
+
+// clientIP -> address the request came from. Forwarding headers are ignored, anyone can set them
+func clientIP(request *http.Request) string {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return request.RemoteAddr
+	}
+	return host
+}
+
+// SignIn -> retrieves user JWT token given username and password
+func (server *Server) SignIn(email, password string) (string, error) {
+
+	tokens, err := server.studentSignIn(email, password)
+	if err != nil {
 		return "", err
 	}
 
-	return auth.CreateToken(student.ID)
+	return tokens.Token, nil
 }
 
 // AdminSignIn -> retrieves admin JWT token given username and password
 func (server *Server) AdminSignIn(email, password string) (string, error) {
 
-	var err error
-	admin := models.Admin{}
-	err = server.DB.Debug().Model(&models.Admin{}).Where("email = ?", email).Take(&admin).Error
+	tokens, err := server.adminSignIn(email, password)
 	if err != nil {
 		return "", err
 	}
 
-	err = models.VerifyPassword(admin.Password, password)
-	if err != nil && err == bcrypt.ErrMismatchedHashAndPassword {
+	if tokens.ChallengeToken != "" {
+		return "", models.ErrTwoFactorRequired
+	}
+
+	return tokens.Token, nil
+}
+
+// studentSignIn -> checks the student's credentials and starts a session
+func (server *Server) studentSignIn(email, password string) (tokenPair, error) {
+
+	student, err := server.Students.FindStudentByEmail(email)
+	if err != nil && err != models.ErrEmailNotFound {
+		return tokenPair{}, err
+	}
+
+	// Unknown emails leave the password empty and get the same answer, in the same time, as wrong passwords
+	err = models.CheckCredentials(student.Password, password)
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	// Only told once the password matched, anyone could otherwise find out which accounts are flagged
+	if student.PasswordResetRequired {
+		return tokenPair{}, models.ErrPasswordResetRequired
+	}
+
+	if !student.IsVerified {
+		return tokenPair{}, models.ErrEmailNotVerified
+	}
+
+	return server.startSession(student.ID, false)
+}
+
+// adminSignIn -> checks the admin's credentials and starts a session
+func (server *Server) adminSignIn(email, password string) (tokenPair, error) {
+
+	admin, err := server.Admins.FindAdminByEmail(email)
+	if err != nil && err != models.ErrEmailNotFound {
+		return tokenPair{}, err
+	}
+
+	// Unknown emails leave the password empty and get the same answer, in the same time, as wrong passwords
+	err = models.CheckCredentials(admin.Password, password)
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	// Only told once the password matched, anyone could otherwise find out which accounts are flagged
+	if admin.PasswordResetRequired {
+		return tokenPair{}, models.ErrPasswordResetRequired
+	}
+
+	if !admin.IsVerified {
+		return tokenPair{}, models.ErrEmailNotVerified
+	}
+
+	// The session only starts once the TOTP code is checked, see AdminLoginTwoFactor
+	if admin.TwoFactorEnabled {
+		challengeToken, err := auth.CreateChallengeToken(admin.ID)
+		if err != nil {
+			return tokenPair{}, err
+		}
+		return tokenPair{ChallengeToken: challengeToken}, nil
+	}
+
+	return server.startSession(admin.ID, true)
+}
+
+// startSession -> issues the first refresh token of a new family and an access token bound to it
+func (server *Server) startSession(subjectID uuid.UUID, isAdmin bool) (tokenPair, error) {
+
+	refresh := &models.RefreshToken{SubjectID: subjectID, IsAdmin: isAdmin}
+	refreshToken, err := server.Sessions.CreateRefreshToken(refresh)
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	token, err := server.accessToken(refresh)
+	if err != nil {
+		return tokenPair{}, err
+	}
+
+	return tokenPair{Token: token, RefreshToken: refreshToken}, nil
+}
+
+// accessToken -> access token for the subject of a refresh token, admins get their current role
+func (server *Server) accessToken(refresh *models.RefreshToken) (string, error) {
+
+	if !refresh.IsAdmin {
+		return auth.CreateToken(refresh.SubjectID, refresh.FamilyID)
+	}
+
+	admin, err := server.Admins.FindAdminByID(refresh.SubjectID.String())
+	if err != nil {
 		return "", err
 	}
 
-	return auth.CreateAdminToken(admin.ID)
+	role := auth.Role(admin.Role)
+	if !auth.ValidRole(admin.Role) {
+		role = auth.RoleShopOwner
+	}
+
+	return auth.CreateAdminToken(admin.ID, admin.ShopID, refresh.FamilyID, role)
 }
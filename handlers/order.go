@@ -14,13 +14,11 @@ import (
 	uuid "github.com/satori/go.uuid"
 )
 
-// CreateOrder -> handles POST /api/v1/students/<student_id:uuid>/orders/
+// CreateOrder -> handles POST /api/v1/students/<student_id:uuid>/orders
 func (server *Server) CreateOrder(writer http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
 	studentID := vars["student_id"]
-	student := models.Student{}
-	shop := models.Shop{}
 
 	body, err := ioutil.ReadAll(request.Body)
 	if err != nil {
@@ -34,18 +32,18 @@ func (server *Server) CreateOrder(writer http.ResponseWriter, request *http.Requ
 		return
 	}
 
-	tokenID, err := auth.ExtractTokenID(request)
+	claims, err := auth.RequestClaims(request)
 	if err != nil {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized"))
 		return
 	}
 
-	if tokenID != studentID {
+	if !claims.IsStudent(studentID) {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
 		return
 	}
 
-	_, err = student.FindStudentByID(server.DB, studentID)
+	_, err = server.Students.FindStudentByID(studentID)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -65,13 +63,18 @@ func (server *Server) CreateOrder(writer http.ResponseWriter, request *http.Requ
 		return
 	}
 
-	_, err = shop.FindShopByID(server.DB, order.ShopID.String())
+	_, err = server.Shops.FindShopByID(order.ShopID.String())
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
-	orderCreated, err := order.CreateOrder(server.DB)
+	orderCreated, err := server.Orders.CreateOrder(&order)
+	if err == models.ErrRedemptionDisabled || err == models.ErrInsufficientPoints || err == models.ErrRedemptionExceedsTotal {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -81,106 +84,147 @@ func (server *Server) CreateOrder(writer http.ResponseWriter, request *http.Requ
 	responses.JSON(writer, http.StatusCreated, orderCreated)
 }
 
-// GetAllOrders -> handles GET /api/v1/orders/
+// GetAllOrders -> handles GET /api/v1/orders
 func (server *Server) GetAllOrders(writer http.ResponseWriter, request *http.Request) {
 
-	order := models.Order{}
-	orders, err := order.FindAllOrders(server.DB)
+	query, err := readListQuery(request, models.OrderList, nil)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	orders, next, err := server.Orders.ListOrders(query)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
-	responses.JSON(writer, http.StatusOK, orders)
+	responses.PAGE(writer, orders, next)
 }
 
-// GetAllOrdersByStudent -> handles GET /api/v1/students/<student_id:uuid>/orders/
+// GetAllOrdersByStudent -> handles GET /api/v1/students/<student_id:uuid>/orders
 func (server *Server) GetAllOrdersByStudent(writer http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
 	studentID := vars["student_id"]
-	student := models.Student{}
-	order := models.Order{}
 
-	tokenID, err := auth.ExtractTokenID(request)
+	claims, err := auth.RequestClaims(request)
 	if err != nil {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized"))
 		return
 	}
 
-	if tokenID != studentID {
+	if !claims.IsStudent(studentID) {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
 		return
 	}
 
-	_, err = student.FindStudentByID(server.DB, studentID)
+	query, err := readListQuery(request, models.OrderList, map[string]string{"student_id": studentID})
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	_, err = server.Students.FindStudentByID(studentID)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
-	orders, err := order.FindAllOrdersByStudent(server.DB, studentID)
+	orders, next, err := server.Orders.ListOrders(query)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
-	responses.JSON(writer, http.StatusOK, orders)
+	responses.PAGE(writer, orders, next)
 }
 
-// GetAllOrdersByShop -> handles GET /api/v1/shops/<shop_id:uuid>/orders/
+// GetAllOrdersByShop -> handles GET /api/v1/shops/<shop_id:uuid>/orders
 func (server *Server) GetAllOrdersByShop(writer http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
 	shopID := vars["shop_id"]
-	shop := models.Shop{}
-	order := models.Order{}
 
-	// Verify that it's admin, student or owner (soon to be introduced)
-	err := auth.TokenValid(request)
+	query, err := readListQuery(request, models.OrderList, map[string]string{"shop_id": shopID})
 	if err != nil {
-		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized: Admin, Student or Owner not authenticated"))
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
 		return
 	}
 
-	_, err = shop.FindShopByID(server.DB, shopID)
+	// Only the shop's admins get here, see middlewares.SetMiddlewareShopAdmin
+	_, err = server.Shops.FindShopByID(shopID)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
-	orders, err := order.FindAllOrdersByShop(server.DB, shopID)
+	orders, next, err := server.Orders.ListOrders(query)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
-	responses.JSON(writer, http.StatusOK, orders)
+	responses.PAGE(writer, orders, next)
 }
 
-// GetOrderByID -> handles GET /api/v1/orders/<id:uuid>
+// GetOrderByID -> handles GET /api/v1/orders/<id:uuid>, for the student who placed the order and the admins of its shop
 func (server *Server) GetOrderByID(writer http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
-	order := models.Order{}
-	orderRetrieved, err := order.FindOrderByID(server.DB, vars["id"])
+
+	claims, err := auth.RequestClaims(request)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	orderRetrieved, err := server.Orders.FindOrderByID(vars["id"])
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
+	// Other students' and shops' orders aren't there as far as the client can tell
+	if !server.canSeeOrder(claims, orderRetrieved) {
+		responses.ERROR(writer, http.StatusNotFound, errors.New("Order not found"))
+		return
+	}
+
 	responses.JSON(writer, http.StatusOK, orderRetrieved)
 }
 
+// canSeeOrder -> the order was placed by the student of the token, or belongs to the shop of the admin of the token.
+// Like SetMiddlewareShopAdmin, superusers see every order and the admin's shop is looked up rather than read from the token
+func (server *Server) canSeeOrder(claims *auth.Claims, order *models.Order) bool {
+
+	if !claims.IsAdmin {
+		return claims.IsStudent(order.UserID.String())
+	}
+
+	if claims.Role == auth.RoleSuperuser {
+		return true
+	}
+
+	admin, err := server.Admins.FindAdminByID(claims.SubjectID.String())
+	if err != nil {
+		return false
+	}
+
+	if admin.Shop.TwoFactorRequired && !admin.TwoFactorEnabled {
+		return false
+	}
+
+	return admin.ShopID == order.ShopID
+}
+
 // UpdateOrder -> handles PUT /api/v1/shops/<shop_id:uuid>/orders/<order_id:uuid>
 func (server *Server) UpdateOrder(writer http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
 	shopID := vars["shop_id"]
 	orderID := vars["order_id"]
-	shop := models.Shop{}
 	order := models.Order{}
-	orderFinder := models.Order{}
 
 	body, err := ioutil.ReadAll(request.Body)
 	if err != nil {
@@ -199,18 +243,18 @@ func (server *Server) UpdateOrder(writer http.ResponseWriter, request *http.Requ
 		return
 	}
 
-	isAdmin, err := auth.IsAdminToken(request)
+	claims, err := auth.RequestClaims(request)
 	if err != nil {
 		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
 		return
 	}
 
-	if !isAdmin {
+	if !claims.IsAdmin {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized: This is not an admin token"))
 		return
 	}
 
-	currentOrder, err := orderFinder.FindOrderByID(server.DB, orderID)
+	currentOrder, err := server.Orders.FindOrderByID(orderID)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -221,13 +265,21 @@ func (server *Server) UpdateOrder(writer http.ResponseWriter, request *http.Requ
 		return
 	}
 
-	_, err = shop.FindShopByID(server.DB, currentOrder.ShopID.String())
+	_, err = server.Shops.FindShopByID(currentOrder.ShopID.String())
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
-	updatedOrder, err := order.UpdateOrder(server.DB, orderID)
+	order.UpdatedBy = claims.SubjectID
+
+	updatedOrder, err := server.Orders.UpdateOrder(orderID, &order)
+	var transitionErr *models.InvalidTransitionError
+	if errors.As(err, &transitionErr) || err == models.ErrOrderStatusChanged {
+		responses.ERROR(writer, http.StatusConflict, err)
+		return
+	}
+
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -242,22 +294,19 @@ func (server *Server) DeleteOrder(writer http.ResponseWriter, request *http.Requ
 	vars := mux.Vars(request)
 	shopID := vars["shop_id"]
 	orderID := vars["order_id"]
-	shop := models.Shop{}
-	order := models.Order{}
-	orderFinder := models.Order{}
 
-	isAdmin, err := auth.IsAdminToken(request)
+	claims, err := auth.RequestClaims(request)
 	if err != nil {
 		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
 		return
 	}
 
-	if !isAdmin {
+	if !claims.IsAdmin {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized: This is not an admin token"))
 		return
 	}
 
-	currentOrder, err := orderFinder.FindOrderByID(server.DB, orderID)
+	currentOrder, err := server.Orders.FindOrderByID(orderID)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -268,13 +317,13 @@ func (server *Server) DeleteOrder(writer http.ResponseWriter, request *http.Requ
 		return
 	}
 
-	_, err = shop.FindShopByID(server.DB, currentOrder.ShopID.String())
+	_, err = server.Shops.FindShopByID(currentOrder.ShopID.String())
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
-	_, err = order.DeleteOrder(server.DB, orderID)
+	_, err = server.Orders.DeleteOrder(orderID, claims.SubjectID)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -283,3 +332,68 @@ func (server *Server) DeleteOrder(writer http.ResponseWriter, request *http.Requ
 	writer.Header().Set("Entity", fmt.Sprintf("%s", orderID))
 	responses.JSON(writer, http.StatusNoContent, "")
 }
+
+// GetOrderHistoryByShop -> handles GET /api/v1/shops/<shop_id:uuid>/orders/<order_id:uuid>/history
+func (server *Server) GetOrderHistoryByShop(writer http.ResponseWriter, request *http.Request) {
+
+	vars := mux.Vars(request)
+	shopID := vars["shop_id"]
+	orderID := vars["order_id"]
+
+	claims, err := auth.RequestClaims(request)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if !claims.IsAdmin {
+		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized: This is not an admin token"))
+		return
+	}
+
+	// Deleted orders keep their history
+	currentOrder, events, err := server.Orders.FindOrderHistory(orderID)
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	if currentOrder.ShopID.String() != shopID {
+		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized: This order does not belong to the given shop"))
+		return
+	}
+
+	responses.JSON(writer, http.StatusOK, events)
+}
+
+// GetOrderHistoryByStudent -> handles GET /api/v1/students/<student_id:uuid>/orders/<order_id:uuid>/history
+func (server *Server) GetOrderHistoryByStudent(writer http.ResponseWriter, request *http.Request) {
+
+	vars := mux.Vars(request)
+	studentID := vars["student_id"]
+	orderID := vars["order_id"]
+
+	claims, err := auth.RequestClaims(request)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	if !claims.IsStudent(studentID) {
+		responses.ERROR(writer, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+		return
+	}
+
+	currentOrder, events, err := server.Orders.FindOrderHistory(orderID)
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	if currentOrder.UserID.String() != studentID {
+		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized: This order does not belong to the given student"))
+		return
+	}
+
+	responses.JSON(writer, http.StatusOK, events)
+}
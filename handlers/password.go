@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/amaraliou/stakeout/auth"
+	"github.com/amaraliou/stakeout/mailer"
+	"github.com/amaraliou/stakeout/models"
+	"github.com/amaraliou/stakeout/responses"
+	"github.com/gorilla/mux"
+	uuid "github.com/satori/go.uuid"
+)
+
+// ForgotPassword -> handles POST /api/v1/password/forgot
+func (server *Server) ForgotPassword(writer http.ResponseWriter, request *http.Request) {
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	forgot := struct {
+		Email string `json:"email"`
+	}{}
+	err = json.Unmarshal(body, &forgot)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if forgot.Email == "" {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, errors.New("Required Email"))
+		return
+	}
+
+	if !server.emailRequested(writer, request, models.EmailTokenPasswordReset, forgot.Email) {
+		return
+	}
+
+	// The answer is the same, and as quick, whether or not the email is registered
+	server.sendInBackground(func() {
+		student, err := server.Students.FindStudentByEmail(forgot.Email)
+		if err == nil {
+			server.sendPasswordResetEmail(student.ID, false, student.Email)
+		}
+
+		admin, err := server.Admins.FindAdminByEmail(forgot.Email)
+		if err == nil {
+			server.sendPasswordResetEmail(admin.ID, true, admin.Email)
+		}
+	})
+
+	responses.JSON(writer, http.StatusAccepted, "If this email is registered, a reset link is on its way")
+}
+
+// ResetPassword -> handles POST /api/v1/password/reset
+func (server *Server) ResetPassword(writer http.ResponseWriter, request *http.Request) {
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	reset := struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}{}
+	err = json.Unmarshal(body, &reset)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if reset.Token == "" {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, errors.New("Required Token"))
+		return
+	}
+
+	if reset.Password == "" {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, errors.New("Required Password"))
+		return
+	}
+
+	_, err = server.EmailTokens.ResetPassword(reset.Token, reset.Password)
+	if err == models.ErrInvalidEmailToken {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(writer, http.StatusOK, "Password updated")
+}
+
+// passwordChange -> body of the change password routes
+type passwordChange struct {
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// readPasswordChange -> reads and checks the body of the change password routes
+func readPasswordChange(request *http.Request) (passwordChange, error) {
+
+	change := passwordChange{}
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return change, err
+	}
+
+	err = json.Unmarshal(body, &change)
+	if err != nil {
+		return change, err
+	}
+
+	if change.CurrentPassword == "" {
+		return change, errors.New("Required Current Password")
+	}
+
+	if change.NewPassword == "" {
+		return change, errors.New("Required New Password")
+	}
+
+	return change, nil
+}
+
+// UpdateStudentPassword -> handles PUT /api/v1/students/<id:uuid>/password
+func (server *Server) UpdateStudentPassword(writer http.ResponseWriter, request *http.Request) {
+
+	studentID := mux.Vars(request)["id"]
+
+	claims, err := auth.RequestClaims(request)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	if !claims.IsStudent(studentID) {
+		responses.ERROR(writer, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+		return
+	}
+
+	change, err := readPasswordChange(request)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	err = server.Students.UpdateStudentPassword(studentID, change.CurrentPassword, change.NewPassword)
+	if err == models.ErrWrongPassword {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	server.revokeOtherSessions(claims)
+	responses.JSON(writer, http.StatusOK, "Password updated")
+}
+
+// UpdateAdminPassword -> handles PUT /api/v1/admins/<id:uuid>/password
+func (server *Server) UpdateAdminPassword(writer http.ResponseWriter, request *http.Request) {
+
+	adminID := mux.Vars(request)["id"]
+
+	claims, err := auth.RequestClaims(request)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	if !claims.IsAdminOf(adminID) {
+		responses.ERROR(writer, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+		return
+	}
+
+	change, err := readPasswordChange(request)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	err = server.Admins.UpdateAdminPassword(adminID, change.CurrentPassword, change.NewPassword)
+	if err == models.ErrWrongPassword {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	server.revokeOtherSessions(claims)
+	responses.JSON(writer, http.StatusOK, "Password updated")
+}
+
+// revokeOtherSessions -> logs out everywhere but the session that changed the password, failures are only logged as the password is already changed
+func (server *Server) revokeOtherSessions(claims *auth.Claims) {
+	err := server.Sessions.RevokeOtherSessions(claims.SubjectID.String(), claims.SessionID)
+	if err != nil {
+		log.Printf("Cannot revoke the other sessions of %s: %v", claims.SubjectID, err)
+	}
+}
+
+// sendPasswordResetEmail -> emails a password reset token, failures are only logged so they don't show in the response
+func (server *Server) sendPasswordResetEmail(subjectID uuid.UUID, isAdmin bool, email string) {
+
+	emailToken := &models.EmailToken{SubjectID: subjectID, IsAdmin: isAdmin, Email: email, Purpose: models.EmailTokenPasswordReset}
+	token, err := server.EmailTokens.CreateEmailToken(emailToken)
+	if err != nil {
+		log.Printf("Cannot create the password reset token for %s: %v", subjectID, err)
+		return
+	}
+
+	err = server.Mailer.Send(mailer.Message{
+		To:      email,
+		Subject: "Reset your password",
+		Body:    fmt.Sprintf("Someone asked to reset the password of your Apetitoso account. If it wasn't you, ignore this email.\n\nUse this code to choose a new password, it expires in %s:\n\n%s\n", models.EmailTokenTTL(models.EmailTokenPasswordReset), token),
+	})
+	if err != nil {
+		log.Printf("Cannot send the password reset email to %s: %v", subjectID, err)
+	}
+}
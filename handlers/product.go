@@ -14,8 +14,7 @@ import (
 	uuid "github.com/satori/go.uuid"
 )
 
-// CreateProduct -> handles POST /api/v1/shops/<shop_id:uuid>/products/
-// Might need admin id
+// CreateProduct -> handles POST /api/v1/shops/<shop_id:uuid>/products
 func (server *Server) CreateProduct(writer http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
@@ -33,18 +32,18 @@ func (server *Server) CreateProduct(writer http.ResponseWriter, request *http.Re
 		return
 	}
 
-	isAdmin, err := auth.IsAdminToken(request)
+	claims, err := auth.RequestClaims(request)
 	if err != nil {
 		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
 		return
 	}
 
-	if !isAdmin {
+	if !claims.IsAdmin {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized: This is not an admin token"))
 		return
 	}
 
-	// Check if the current admin is the same admin as the shop, if not, they are unauthorized
+	// Shop ownership is checked by middlewares.SetMiddlewareShopAdmin
 
 	shopUUID, err := uuid.FromString(shopID)
 	if err != nil {
@@ -60,7 +59,7 @@ func (server *Server) CreateProduct(writer http.ResponseWriter, request *http.Re
 		return
 	}
 
-	productCreated, err := product.CreateProduct(server.DB)
+	productCreated, err := server.Products.CreateProduct(&product)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -70,25 +69,29 @@ func (server *Server) CreateProduct(writer http.ResponseWriter, request *http.Re
 	responses.JSON(writer, http.StatusCreated, productCreated)
 }
 
-// GetProducts -> handles GET /api/v1/products/
+// GetProducts -> handles GET /api/v1/products
 func (server *Server) GetProducts(writer http.ResponseWriter, request *http.Request) {
 
-	product := models.Product{}
-	products, err := product.FindAllProducts(server.DB)
+	query, err := readListQuery(request, models.ProductList, nil)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	products, next, err := server.Products.ListProducts(query)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
-	responses.JSON(writer, http.StatusOK, products)
+	responses.PAGE(writer, products, next)
 }
 
-// GetProductByID -> handles Get /api/v1/products/<id:uuid>
+// GetProductByID -> handles GET /api/v1/products/<id:uuid>
 func (server *Server) GetProductByID(writer http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
-	product := models.Product{}
-	productRetrieved, err := product.FindProductByID(server.DB, vars["id"])
+	productRetrieved, err := server.Products.FindProductByID(vars["id"])
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -97,29 +100,38 @@ func (server *Server) GetProductByID(writer http.ResponseWriter, request *http.R
 	responses.JSON(writer, http.StatusOK, productRetrieved)
 }
 
-// GetProductsByShop -> handles GET /api/v1/shops/<shop_id:uuid>/products/
+// GetProductsByShop -> handles GET /api/v1/shops/<shop_id:uuid>/products
 func (server *Server) GetProductsByShop(writer http.ResponseWriter, request *http.Request) {
 
-	vars := mux.Vars(request)
-	product := models.Product{}
-	products, err := product.FindAllProductsByShop(server.DB, vars["shop_id"])
+	shopID := mux.Vars(request)["shop_id"]
+	query, err := readListQuery(request, models.ProductList, map[string]string{"shop_id": shopID})
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	_, err = server.Shops.FindShopByID(shopID)
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	products, next, err := server.Products.ListProducts(query)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
-	responses.JSON(writer, http.StatusOK, map[string]interface{}{"products": products})
+	responses.PAGE(writer, products, next)
 }
 
 // UpdateProduct -> handles PUT /api/v1/shops/<shop_id:uuid>/products/<product_id:uuid>
-// Might need admin id
 func (server *Server) UpdateProduct(writer http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
 	shopID := vars["shop_id"]
 	productID := vars["product_id"]
 	product := models.Product{}
-	productFinder := models.Product{}
 
 	body, err := ioutil.ReadAll(request.Body)
 	if err != nil {
@@ -132,24 +144,18 @@ func (server *Server) UpdateProduct(writer http.ResponseWriter, request *http.Re
 		return
 	}
 
-	err = product.Validate("")
+	claims, err := auth.RequestClaims(request)
 	if err != nil {
 		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
 		return
 	}
 
-	isAdmin, err := auth.IsAdminToken(request)
-	if err != nil {
-		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
-		return
-	}
-
-	if !isAdmin {
+	if !claims.IsAdmin {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized: This is not an admin token"))
 		return
 	}
 
-	currentProduct, err := productFinder.FindProductByID(server.DB, productID)
+	currentProduct, err := server.Products.FindProductByID(productID)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -160,7 +166,15 @@ func (server *Server) UpdateProduct(writer http.ResponseWriter, request *http.Re
 		return
 	}
 
-	updatedProduct, err := product.UpdateProduct(server.DB, productID)
+	// A discount or a unit on its own can be fine and still not make sense with the rest of the product
+	merged := currentProduct.WithUpdates(&product)
+	err = merged.Validate("update")
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	updatedProduct, err := server.Products.UpdateProduct(productID, &product)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -169,28 +183,25 @@ func (server *Server) UpdateProduct(writer http.ResponseWriter, request *http.Re
 	responses.JSON(writer, http.StatusOK, updatedProduct)
 }
 
-// DeleteProduct -> handles DELETE /api/v1/shops/<shop_id:uuid>/products/<id:uuid>
-// Might need admin id
+// DeleteProduct -> handles DELETE /api/v1/shops/<shop_id:uuid>/products/<product_id:uuid>
 func (server *Server) DeleteProduct(writer http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
 	shopID := vars["shop_id"]
 	productID := vars["product_id"]
-	product := models.Product{}
-	productFinder := models.Product{}
 
-	isAdmin, err := auth.IsAdminToken(request)
+	claims, err := auth.RequestClaims(request)
 	if err != nil {
 		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
 		return
 	}
 
-	if !isAdmin {
+	if !claims.IsAdmin {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized: This is not an admin token"))
 		return
 	}
 
-	currentProduct, err := productFinder.FindProductByID(server.DB, productID)
+	currentProduct, err := server.Products.FindProductByID(productID)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -201,7 +212,7 @@ func (server *Server) DeleteProduct(writer http.ResponseWriter, request *http.Re
 		return
 	}
 
-	_, err = product.DeleteProduct(server.DB, productID)
+	_, err = server.Products.DeleteProduct(productID)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -1,39 +1,111 @@
 package handlers
 
-import "github.com/amaraliou/stakeout/middlewares"
+import (
+	"net/http"
+
+	"github.com/amaraliou/stakeout/auth"
+	"github.com/amaraliou/stakeout/middlewares"
+)
+
+// APIPrefix -> every route is served under this versioned prefix
+const APIPrefix = "/api/v1"
+
+// Route -> one entry of the route table, Name is the name of the Server handler it serves
+type Route struct {
+	Name    string
+	Method  string
+	Path    string
+	Handler http.HandlerFunc
+}
+
+// Routes -> the route table, paths are relative to APIPrefix
+func (server *Server) Routes() []Route {
+	return []Route{
+		// Home route
+		{"Home", "GET", "/", middlewares.SetMiddlewareJSON(server.Home)},
+
+		// Login routes
+		{"Login", "POST", "/login", middlewares.SetMiddlewareJSON(server.Login)},
+		{"AdminLogin", "POST", "/admins/login", middlewares.SetMiddlewareJSON(server.AdminLogin)},
+		{"AdminLoginTwoFactor", "POST", "/admins/login/2fa", middlewares.SetMiddlewareJSON(server.AdminLoginTwoFactor)},
+		{"RefreshToken", "POST", "/token/refresh", middlewares.SetMiddlewareJSON(server.RefreshToken)},
+		{"Logout", "POST", "/logout", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(server.Logout))},
+		{"VerifyEmail", "POST", "/verify-email", middlewares.SetMiddlewareJSON(server.VerifyEmail)},
+		{"ResendVerificationEmail", "POST", "/verify-email/resend", middlewares.SetMiddlewareJSON(server.ResendVerificationEmail)},
+		{"ForgotPassword", "POST", "/password/forgot", middlewares.SetMiddlewareJSON(server.ForgotPassword)},
+		{"ResetPassword", "POST", "/password/reset", middlewares.SetMiddlewareJSON(server.ResetPassword)},
+
+		// Students routes
+		{"CreateStudent", "POST", "/students", middlewares.SetMiddlewareJSON(server.CreateStudent)},
+		{"GetStudents", "GET", "/students", middlewares.SetMiddlewarePermission(auth.PermListStudents, middlewares.SetMiddlewareJSON(server.GetStudents))},
+		{"GetStudentByID", "GET", "/students/{id}", middlewares.SetMiddlewareJSON(server.GetStudentByID)},
+		{"UpdateStudent", "PUT", "/students/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(server.UpdateStudent))},
+		{"DeleteStudent", "DELETE", "/students/{id}", middlewares.SetMiddlewareAuthentication(server.DeleteStudent)},
+		{"UpdateStudentPassword", "PUT", "/students/{id}/password", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(server.UpdateStudentPassword))},
+		{"GetStudentPoints", "GET", "/students/{id}/points", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(server.GetStudentPoints))},
+
+		// Admin routes
+		{"CreateAdmin", "POST", "/admins", middlewares.SetMiddlewareJSON(server.CreateAdmin)},
+		{"GetAdmins", "GET", "/admins", middlewares.SetMiddlewarePermission(auth.PermListAdmins, middlewares.SetMiddlewareJSON(server.GetAdmins))},
+		{"GetAdminByID", "GET", "/admins/{id}", middlewares.SetMiddlewareJSON(server.GetAdminByID)},
+		{"UpdateAdmin", "PUT", "/admins/{id}", middlewares.SetMiddlewareAdminAuthentication(middlewares.SetMiddlewareJSON(server.UpdateAdmin))},
+		{"DeleteAdmin", "DELETE", "/admins/{id}", middlewares.SetMiddlewareAuthentication(server.DeleteAdmin)},
+		{"UpdateAdminPassword", "PUT", "/admins/{id}/password", middlewares.SetMiddlewareAdminAuthentication(middlewares.SetMiddlewareJSON(server.UpdateAdminPassword))},
+		{"EnrollTwoFactor", "POST", "/admins/{id}/2fa", middlewares.SetMiddlewareAdminAuthentication(middlewares.SetMiddlewareJSON(server.EnrollTwoFactor))},
+		{"ConfirmTwoFactor", "POST", "/admins/{id}/2fa/confirm", middlewares.SetMiddlewareAdminAuthentication(middlewares.SetMiddlewareJSON(server.ConfirmTwoFactor))},
+		{"DisableTwoFactor", "DELETE", "/admins/{id}/2fa", middlewares.SetMiddlewareAdminAuthentication(middlewares.SetMiddlewareJSON(server.DisableTwoFactor))},
+		{"UpdateAdminRole", "PUT", "/admins/{id}/role", middlewares.SetMiddlewarePermission(auth.PermManageRoles, middlewares.SetMiddlewareJSON(server.UpdateAdminRole))},
+
+		// Shop routes
+		{"CreateShop", "POST", "/admins/{admin_id}/shops", middlewares.SetMiddlewarePermission(auth.PermCreateShop, middlewares.SetMiddlewareJSON(server.CreateShop))},
+		{"GetShops", "GET", "/shops", middlewares.SetMiddlewareAuthentication(server.GetShops)},
+		{"GetNearbyShops", "GET", "/shops/nearby", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(server.GetNearbyShops))},
+		{"GetShopByID", "GET", "/shops/{id}", middlewares.SetMiddlewareJSON(server.GetShopByID)},
+		{"RequireShopTwoFactor", "PUT", "/shops/{id}/2fa", middlewares.SetMiddlewarePermission(auth.PermRequireTwoFactor, middlewares.SetMiddlewareJSON(server.RequireShopTwoFactor))},
+		{"UpdateShop", "PUT", "/admins/{admin_id}/shops/{shop_id}", middlewares.SetMiddlewarePermission(auth.PermUpdateShop, middlewares.SetMiddlewareShopAdmin(server.Admins, middlewares.SetMiddlewareJSON(server.UpdateShop)))},
+		{"DeleteShop", "DELETE", "/admins/{admin_id}/shops/{shop_id}", middlewares.SetMiddlewarePermission(auth.PermDeleteShop, middlewares.SetMiddlewareShopAdmin(server.Admins, middlewares.SetMiddlewareJSON(server.DeleteShop)))},
+
+		// Product routes
+		{"CreateProduct", "POST", "/shops/{shop_id}/products", middlewares.SetMiddlewarePermission(auth.PermManageProducts, middlewares.SetMiddlewareShopAdmin(server.Admins, middlewares.SetMiddlewareJSON(server.CreateProduct)))},
+		{"GetProducts", "GET", "/products", middlewares.SetMiddlewareJSON(server.GetProducts)},
+		{"GetProductByID", "GET", "/products/{id}", middlewares.SetMiddlewareJSON(server.GetProductByID)},
+		{"GetProductsByShop", "GET", "/shops/{shop_id}/products", middlewares.SetMiddlewareJSON(server.GetProductsByShop)},
+		{"UpdateProduct", "PUT", "/shops/{shop_id}/products/{product_id}", middlewares.SetMiddlewarePermission(auth.PermManageProducts, middlewares.SetMiddlewareShopAdmin(server.Admins, middlewares.SetMiddlewareJSON(server.UpdateProduct)))},
+		{"DeleteProduct", "DELETE", "/shops/{shop_id}/products/{product_id}", middlewares.SetMiddlewarePermission(auth.PermManageProducts, middlewares.SetMiddlewareShopAdmin(server.Admins, middlewares.SetMiddlewareJSON(server.DeleteProduct)))},
+
+		// Search routes
+		{"Search", "GET", "/search", middlewares.SetMiddlewareJSON(server.Search)},
+
+		// Order routes
+		{"CreateOrder", "POST", "/students/{student_id}/orders", middlewares.SetMiddlewarePermission(auth.PermPlaceOrder, middlewares.SetMiddlewareJSON(server.CreateOrder))},
+		{"GetAllOrders", "GET", "/orders", middlewares.SetMiddlewarePermission(auth.PermListAllOrders, middlewares.SetMiddlewareJSON(server.GetAllOrders))},
+		{"GetOrderByID", "GET", "/orders/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(server.GetOrderByID))},
+		{"GetAllOrdersByStudent", "GET", "/students/{student_id}/orders", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(server.GetAllOrdersByStudent))},
+		{"GetAllOrdersByShop", "GET", "/shops/{shop_id}/orders", middlewares.SetMiddlewarePermission(auth.PermViewShopOrders, middlewares.SetMiddlewareShopAdmin(server.Admins, middlewares.SetMiddlewareJSON(server.GetAllOrdersByShop)))},
+		{"UpdateOrder", "PUT", "/shops/{shop_id}/orders/{order_id}", middlewares.SetMiddlewarePermission(auth.PermUpdateOrderStatus, middlewares.SetMiddlewareShopAdmin(server.Admins, middlewares.SetMiddlewareJSON(server.UpdateOrder)))},
+		{"DeleteOrder", "DELETE", "/shops/{shop_id}/orders/{order_id}", middlewares.SetMiddlewarePermission(auth.PermDeleteOrder, middlewares.SetMiddlewareShopAdmin(server.Admins, middlewares.SetMiddlewareJSON(server.DeleteOrder)))},
+
+		// Order history routes
+		{"GetOrderHistoryByShop", "GET", "/shops/{shop_id}/orders/{order_id}/history", middlewares.SetMiddlewarePermission(auth.PermViewShopOrders, middlewares.SetMiddlewareShopAdmin(server.Admins, middlewares.SetMiddlewareJSON(server.GetOrderHistoryByShop)))},
+		{"GetOrderHistoryByStudent", "GET", "/students/{student_id}/orders/{order_id}/history", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(server.GetOrderHistoryByStudent))},
+	}
+}
+
+// WellKnownRoutes -> routes whose paths are fixed by a standard, served outside APIPrefix
+func (server *Server) WellKnownRoutes() []Route {
+	return []Route{
+		{"JWKS", "GET", "/.well-known/jwks.json", middlewares.SetMiddlewareJSON(server.JWKS)},
+	}
+}
 
 func (server *Server) initializeRoutes() {
 
-	// Look into splitting the routes into multiple files
-
-	// /api/v1 prefix
-	server.Router.PathPrefix("/api/v1") //.Subrouter()
-
-	// Home route
-	server.Router.HandleFunc("/", middlewares.SetMiddlewareJSON(server.Home)).Methods("GET")
-
-	// Login Route
-	server.Router.HandleFunc("/login", middlewares.SetMiddlewareJSON(server.Login)).Methods("POST")
-	server.Router.HandleFunc("/admins/login", middlewares.SetMiddlewareJSON(server.AdminLogin)).Methods("POST")
-
-	// Students routes
-	server.Router.HandleFunc("/students", middlewares.SetMiddlewareJSON(server.CreateStudent)).Methods("POST")
-	server.Router.HandleFunc("/students", middlewares.SetMiddlewareJSON(server.GetStudents)).Methods("GET")
-	server.Router.HandleFunc("/students/{id}", middlewares.SetMiddlewareJSON(server.GetStudentByID)).Methods("GET")
-	server.Router.HandleFunc("/students/{id}", middlewares.SetMiddlewareJSON(middlewares.SetMiddlewareAuthentication(server.UpdateStudent))).Methods("PUT")
-	server.Router.HandleFunc("/students/{id}", middlewares.SetMiddlewareAuthentication(server.DeleteStudent)).Methods("DELETE")
-
-	// Admin routes
-	server.Router.HandleFunc("/admins", middlewares.SetMiddlewareJSON(server.CreateAdmin)).Methods("POST")
-	server.Router.HandleFunc("/admins", middlewares.SetMiddlewareJSON(server.GetAdmins)).Methods("GET") // Add additional Auth permissions where owners of the systems can only do this
-	server.Router.HandleFunc("/admins/{id}", middlewares.SetMiddlewareJSON(server.GetAdminByID)).Methods("GET")
-	server.Router.HandleFunc("/admins/{id}", middlewares.SetMiddlewareAdminAuthentication(middlewares.SetMiddlewareJSON(server.UpdateAdmin))).Methods("PUT")
-	server.Router.HandleFunc("/admins/{id}", middlewares.SetMiddlewareAuthentication(server.DeleteAdmin)).Methods("DELETE")
-
-	// Shop routes
-	server.Router.HandleFunc("/admins/{admin_id}/shops", middlewares.SetMiddlewareAdminAuthentication(middlewares.SetMiddlewareJSON(server.CreateShop))).Methods("POST")
-	server.Router.HandleFunc("/shops", middlewares.SetMiddlewareAuthentication(server.GetShops)).Methods("GET")
-	server.Router.HandleFunc("/shops/{id}", middlewares.SetMiddlewareJSON(server.GetShopByID)).Methods("GET")
-	server.Router.HandleFunc("/admins/{admin_id}/shops/{shop_id}", middlewares.SetMiddlewareAdminAuthentication(middlewares.SetMiddlewareJSON(server.UpdateShop))).Methods("PUT")
-	server.Router.HandleFunc("/admins/{admin_id}/shops/{shop_id}", middlewares.SetMiddlewareAdminAuthentication(middlewares.SetMiddlewareJSON(server.DeleteShop))).Methods("DELETE")
+	api := server.Router.PathPrefix(APIPrefix).Subrouter()
+	for _, route := range server.Routes() {
+		api.HandleFunc(route.Path, route.Handler).Methods(route.Method).Name(route.Name)
+	}
+
+	for _, route := range server.WellKnownRoutes() {
+		server.Router.HandleFunc(route.Path, route.Handler).Methods(route.Method).Name(route.Name)
+	}
 }
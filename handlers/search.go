@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/amaraliou/stakeout/models"
+	"github.com/amaraliou/stakeout/responses"
+)
+
+// Search -> handles GET /api/v1/search?q=, the products and the shops matching q. Products are filtered by is_in_sale,
+// max_price and shop_id, shops by shop_id. Each list is bounded by limit rather than paged, next_cursor is always empty
+func (server *Server) Search(writer http.ResponseWriter, request *http.Request) {
+
+	query, err := models.ParseSearchQuery(request.URL.Query())
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	products, err := server.Products.SearchProducts(query)
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	shops, err := server.Shops.SearchShops(query)
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.PAGE(writer, models.SearchResults{Products: *products, Shops: *shops}, "")
+}
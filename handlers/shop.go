@@ -13,12 +13,26 @@ import (
 	"github.com/gorilla/mux"
 )
 
-// CreateShop -> handles POST /api/v1/admins/<admin_id:uuid>/shops/
+// locateShop -> fills in the location of a shop from its postcode when the admin didn't give one. Postcodes the
+// directory doesn't know leave it unset
+func (server *Server) locateShop(shop *models.Shop) {
+
+	if shop.Latitude != 0 || shop.Longitude != 0 || shop.Postcode == "" {
+		return
+	}
+
+	location, ok := server.Postcodes.Locate(shop.Postcode)
+	if ok {
+		shop.Latitude = location.Latitude
+		shop.Longitude = location.Longitude
+	}
+}
+
+// CreateShop -> handles POST /api/v1/admins/<admin_id:uuid>/shops
 func (server *Server) CreateShop(writer http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
 	adminID := vars["admin_id"]
-	admin := models.Admin{}
 
 	body, err := ioutil.ReadAll(request.Body)
 	if err != nil {
@@ -32,24 +46,18 @@ func (server *Server) CreateShop(writer http.ResponseWriter, request *http.Reque
 		return
 	}
 
-	isAdmin, err := auth.IsAdminToken(request)
+	claims, err := auth.RequestClaims(request)
 	if err != nil {
 		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
 		return
 	}
 
-	if !isAdmin {
+	if !claims.IsAdmin {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized: This is not an admin token"))
 		return
 	}
 
-	tokenID, err := auth.ExtractTokenAdminID(request)
-	if err != nil {
-		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized"))
-		return
-	}
-
-	if tokenID != adminID {
+	if !claims.IsAdminOf(adminID) {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized"))
 		return
 	}
@@ -59,14 +67,15 @@ func (server *Server) CreateShop(writer http.ResponseWriter, request *http.Reque
 		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
 		return
 	}
+	server.locateShop(&shop)
 
-	shopCreated, err := shop.CreateShop(server.DB)
+	shopCreated, err := server.Shops.CreateShop(&shop)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
-	_, err = admin.FindAdminByID(server.DB, adminID)
+	_, err = server.Admins.FindAdminByID(adminID)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -76,7 +85,7 @@ func (server *Server) CreateShop(writer http.ResponseWriter, request *http.Reque
 		ShopID: shopCreated.ID,
 	}
 
-	_, err = currentAdmin.UpdateAdmin(server.DB, adminID)
+	_, err = server.Admins.UpdateAdmin(adminID, &currentAdmin)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -86,25 +95,48 @@ func (server *Server) CreateShop(writer http.ResponseWriter, request *http.Reque
 	responses.JSON(writer, http.StatusCreated, shopCreated)
 }
 
-// GetShops -> handles GET /api/v1/shops/
+// GetShops -> handles GET /api/v1/shops
 func (server *Server) GetShops(writer http.ResponseWriter, request *http.Request) {
 
-	shop := models.Shop{}
-	shops, err := shop.FindAllShops(server.DB)
+	query, err := readListQuery(request, models.ShopList, nil)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	shops, next, err := server.Shops.ListShops(query)
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.PAGE(writer, shops, next)
+}
+
+// GetNearbyShops -> handles GET /api/v1/shops/nearby?lat=&lng=&radius=, the radius is in kilometres. Results are bounded
+// by the radius and limit rather than paged, next_cursor is always empty
+func (server *Server) GetNearbyShops(writer http.ResponseWriter, request *http.Request) {
+
+	query, err := models.ParseNearbyQuery(request.URL.Query())
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	shops, err := server.Shops.FindShopsNearby(query)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
-	responses.JSON(writer, http.StatusOK, shops)
+	responses.PAGE(writer, shops, "")
 }
 
-// GetShopByID -> handles GET /api/v1/shop/<id:uuid>
+// GetShopByID -> handles GET /api/v1/shops/<id:uuid>
 func (server *Server) GetShopByID(writer http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
-	shop := models.Shop{}
-	shopRetrieved, err := shop.FindShopByID(server.DB, vars["id"])
+	shopRetrieved, err := server.Shops.FindShopByID(vars["id"])
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -113,14 +145,13 @@ func (server *Server) GetShopByID(writer http.ResponseWriter, request *http.Requ
 	responses.JSON(writer, http.StatusOK, shopRetrieved)
 }
 
-// UpdateShop -> handles PUT /api/v1/admins/<admin_id:uuid>/shop/<shop_id:uuid>
+// UpdateShop -> handles PUT /api/v1/admins/<admin_id:uuid>/shops/<shop_id:uuid>
 func (server *Server) UpdateShop(writer http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
 	shopID := vars["shop_id"]
 	adminID := vars["admin_id"]
 	shop := models.Shop{}
-	admin := models.Admin{}
 
 	body, err := ioutil.ReadAll(request.Body)
 	if err != nil {
@@ -133,51 +164,63 @@ func (server *Server) UpdateShop(writer http.ResponseWriter, request *http.Reque
 		return
 	}
 
-	err = shop.Validate("")
+	// A points rate of 0 disables redemption, it has to be told apart from one that wasn't sent
+	rate := struct {
+		PointsRate *float32 `json:"points_rate"`
+	}{}
+	err = json.Unmarshal(body, &rate)
 	if err != nil {
 		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
 		return
 	}
 
-	isAdmin, err := auth.IsAdminToken(request)
+	err = shop.Validate("")
 	if err != nil {
 		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
 		return
 	}
 
-	if !isAdmin {
-		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized: This is not an admin token"))
+	claims, err := auth.RequestClaims(request)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
 		return
 	}
 
-	tokenID, err := auth.ExtractTokenAdminID(request)
-	if err != nil {
-		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized"))
+	if !claims.IsAdmin {
+		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized: This is not an admin token"))
 		return
 	}
 
-	if tokenID != adminID {
+	if !claims.IsAdminOf(adminID) {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
 		return
 	}
 
-	currentAdmin, err := admin.FindAdminByID(server.DB, adminID)
+	currentAdmin, err := server.Admins.FindAdminByID(adminID)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
 	if currentAdmin.ShopID.String() != shopID {
-		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized: You are not the admin for this shop"))
+		responses.ERROR(writer, http.StatusForbidden, errors.New("Forbidden: You are not the admin for this shop"))
 		return
 	}
 
-	updatedShop, err := shop.UpdateShop(server.DB, shopID)
+	updatedShop, err := server.Shops.UpdateShop(shopID, &shop)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
+	if rate.PointsRate != nil {
+		updatedShop, err = server.Shops.SetPointsRate(shopID, *rate.PointsRate)
+		if err != nil {
+			responses.ERROR(writer, http.StatusInternalServerError, err)
+			return
+		}
+	}
+
 	responses.JSON(writer, http.StatusOK, updatedShop)
 }
 
@@ -187,43 +230,35 @@ func (server *Server) DeleteShop(writer http.ResponseWriter, request *http.Reque
 	vars := mux.Vars(request)
 	shopID := vars["shop_id"]
 	adminID := vars["admin_id"]
-	shop := models.Shop{}
-	admin := models.Admin{}
 
-	isAdmin, err := auth.IsAdminToken(request)
+	claims, err := auth.RequestClaims(request)
 	if err != nil {
 		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
 		return
 	}
 
-	if !isAdmin {
+	if !claims.IsAdmin {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized: This is not an admin token"))
 		return
 	}
 
-	tokenID, err := auth.ExtractTokenAdminID(request)
-	if err != nil {
-		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized"))
-		return
-	}
-
-	if tokenID != adminID {
+	if !claims.IsAdminOf(adminID) {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
 		return
 	}
 
-	currentAdmin, err := admin.FindAdminByID(server.DB, adminID)
+	currentAdmin, err := server.Admins.FindAdminByID(adminID)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
 	if currentAdmin.ShopID.String() != shopID {
-		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized: You are not the admin for this shop"))
+		responses.ERROR(writer, http.StatusForbidden, errors.New("Forbidden: You are not the admin for this shop"))
 		return
 	}
 
-	_, err = shop.DeleteShop(server.DB, shopID)
+	_, err = server.Shops.DeleteShop(shopID)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -14,7 +14,7 @@ import (
 	"github.com/amaraliou/stakeout/auth"
 )
 
-// CreateStudent -> handles POST /api/v1/student/
+// CreateStudent -> handles POST /api/v1/students
 func (server *Server) CreateStudent(writer http.ResponseWriter, request *http.Request) {
 
 	body, err := ioutil.ReadAll(request.Body)
@@ -35,35 +35,46 @@ func (server *Server) CreateStudent(writer http.ResponseWriter, request *http.Re
 		return
 	}
 
-	studentCreated, err := student.CreateStudent(server.DB)
+	// Verified once the emailed token comes back, see VerifyEmail
+	student.IsVerified = false
+	student.IsStudent = false
+	student.University = ""
+
+	studentCreated, err := server.Students.CreateStudent(&student)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
+	server.sendVerificationEmail(studentCreated.ID, false, studentCreated.Email)
+
 	writer.Header().Set("Location", fmt.Sprintf("%s%s/%s", request.Host, request.RequestURI, studentCreated.ID.String()))
 	responses.JSON(writer, http.StatusCreated, studentCreated)
 }
 
-// GetStudents -> handles GET /api/v1/student/
+// GetStudents -> handles GET /api/v1/students
 func (server *Server) GetStudents(writer http.ResponseWriter, request *http.Request) {
 
-	student := models.Student{}
-	students, err := student.FindAllStudents(server.DB)
+	query, err := readListQuery(request, models.StudentList, nil)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	students, next, err := server.Students.ListStudents(query)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
-	responses.JSON(writer, http.StatusOK, students)
+	responses.PAGE(writer, students, next)
 }
 
-// GetStudentByID -> handles GET /api/v1/student/<id:uuid>
+// GetStudentByID -> handles GET /api/v1/students/<id:uuid>
 func (server *Server) GetStudentByID(writer http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
-	student := models.Student{}
-	studentRetrieved, err := student.FindStudentByID(server.DB, vars["id"])
+	studentRetrieved, err := server.Students.FindStudentByID(vars["id"])
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -72,7 +83,7 @@ func (server *Server) GetStudentByID(writer http.ResponseWriter, request *http.R
 	responses.JSON(writer, http.StatusOK, studentRetrieved)
 }
 
-// UpdateStudent -> handles PUT /api/v1/student/<id:uuid>
+// UpdateStudent -> handles PUT /api/v1/students/<id:uuid>
 func (server *Server) UpdateStudent(writer http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
@@ -90,13 +101,13 @@ func (server *Server) UpdateStudent(writer http.ResponseWriter, request *http.Re
 		return
 	}
 
-	tokenID, err := auth.ExtractTokenID(request)
+	claims, err := auth.RequestClaims(request)
 	if err != nil {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized"))
 		return
 	}
 
-	if tokenID != studentID {
+	if !claims.IsStudent(studentID) {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
 		return
 	}
@@ -107,34 +118,44 @@ func (server *Server) UpdateStudent(writer http.ResponseWriter, request *http.Re
 		return
 	}
 
-	updatedStudent, err := student.UpdateStudent(server.DB, studentID)
+	currentStudent, err := server.Students.FindStudentByID(studentID)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
 	}
 
+	updatedStudent, err := server.Students.UpdateStudent(studentID, &student)
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	// The new email is unverified until the student reads this
+	if updatedStudent.Email != currentStudent.Email {
+		server.sendVerificationEmail(updatedStudent.ID, false, updatedStudent.Email)
+	}
+
 	responses.JSON(writer, http.StatusOK, updatedStudent)
 }
 
-// DeleteStudent -> handles DELETE /api/v1/student/<id:uuid>
+// DeleteStudent -> handles DELETE /api/v1/students/<id:uuid>
 func (server *Server) DeleteStudent(writer http.ResponseWriter, request *http.Request) {
 
 	vars := mux.Vars(request)
 	studentID := vars["id"]
-	student := models.Student{}
 
-	tokenID, err := auth.ExtractTokenID(request)
+	claims, err := auth.RequestClaims(request)
 	if err != nil {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized"))
 		return
 	}
 
-	if tokenID != "" && tokenID != studentID {
+	if !claims.IsStudent(studentID) {
 		responses.ERROR(writer, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
 		return
 	}
 
-	_, err = student.DeleteStudent(server.DB, studentID)
+	_, err = server.Students.DeleteStudent(studentID)
 	if err != nil {
 		responses.ERROR(writer, http.StatusInternalServerError, err)
 		return
@@ -143,3 +164,38 @@ func (server *Server) DeleteStudent(writer http.ResponseWriter, request *http.Re
 	writer.Header().Set("Entity", fmt.Sprintf("%s", studentID))
 	responses.JSON(writer, http.StatusNoContent, "")
 }
+
+// GetStudentPoints -> handles GET /api/v1/students/<id:uuid>/points
+func (server *Server) GetStudentPoints(writer http.ResponseWriter, request *http.Request) {
+
+	vars := mux.Vars(request)
+	studentID := vars["id"]
+
+	claims, err := auth.RequestClaims(request)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return
+	}
+
+	if !claims.IsStudent(studentID) {
+		responses.ERROR(writer, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+		return
+	}
+
+	studentRetrieved, err := server.Students.FindStudentByID(studentID)
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	entries, err := server.Students.FindEntriesByStudent(studentID)
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(writer, http.StatusOK, map[string]interface{}{
+		"points":  studentRetrieved.Points,
+		"entries": entries,
+	})
+}
@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/amaraliou/stakeout/auth"
+	"github.com/amaraliou/stakeout/models"
+	"github.com/amaraliou/stakeout/responses"
+	"github.com/gorilla/mux"
+)
+
+// TOTPIssuer -> name authenticator apps show next to the code
+const TOTPIssuer = "Apetitoso"
+
+// twoFactorCode -> body of the routes taking a TOTP or recovery code
+type twoFactorCode struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code"`
+}
+
+// readTwoFactorCode -> reads the body of the routes taking a TOTP or recovery code
+func readTwoFactorCode(request *http.Request) (twoFactorCode, error) {
+
+	code := twoFactorCode{}
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		return code, err
+	}
+
+	err = json.Unmarshal(body, &code)
+	if err != nil {
+		return code, err
+	}
+
+	if code.Code == "" {
+		return code, errors.New("Required Code")
+	}
+
+	return code, nil
+}
+
+// twoFactorAdmin -> checks the request is made by the admin of the {id} route variable
+func twoFactorAdmin(writer http.ResponseWriter, request *http.Request) (string, bool) {
+
+	adminID := mux.Vars(request)["id"]
+
+	claims, err := auth.RequestClaims(request)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Unauthorized"))
+		return "", false
+	}
+
+	if !claims.IsAdminOf(adminID) {
+		responses.ERROR(writer, http.StatusUnauthorized, errors.New(http.StatusText(http.StatusUnauthorized)))
+		return "", false
+	}
+
+	return adminID, true
+}
+
+// EnrollTwoFactor -> handles POST /api/v1/admins/<id:uuid>/2fa
+func (server *Server) EnrollTwoFactor(writer http.ResponseWriter, request *http.Request) {
+
+	adminID, ok := twoFactorAdmin(writer, request)
+	if !ok {
+		return
+	}
+
+	secret, err := server.Admins.EnrollTwoFactor(adminID)
+	if err == models.ErrTwoFactorEnabled {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	// The email is only read for the authenticator app's label
+	admin, err := server.Admins.FindAdminByID(adminID)
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(writer, http.StatusOK, struct {
+		Secret string `json:"secret"`
+		URI    string `json:"otpauth_uri"`
+	}{
+		Secret: secret,
+		URI:    auth.TOTPURI(TOTPIssuer, admin.Email, secret),
+	})
+}
+
+// ConfirmTwoFactor -> handles POST /api/v1/admins/<id:uuid>/2fa/confirm
+func (server *Server) ConfirmTwoFactor(writer http.ResponseWriter, request *http.Request) {
+
+	adminID, ok := twoFactorAdmin(writer, request)
+	if !ok {
+		return
+	}
+
+	code, err := readTwoFactorCode(request)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	recoveryCodes, err := server.Admins.ConfirmTwoFactor(adminID, code.Code)
+	if err == models.ErrInvalidTwoFactorCode || err == models.ErrTwoFactorEnabled || err == models.ErrTwoFactorNotEnrolled {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(writer, http.StatusOK, struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}{
+		RecoveryCodes: recoveryCodes,
+	})
+}
+
+// DisableTwoFactor -> handles DELETE /api/v1/admins/<id:uuid>/2fa
+func (server *Server) DisableTwoFactor(writer http.ResponseWriter, request *http.Request) {
+
+	adminID, ok := twoFactorAdmin(writer, request)
+	if !ok {
+		return
+	}
+
+	code, err := readTwoFactorCode(request)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	currentAdmin, err := server.Admins.FindAdminByID(adminID)
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	if currentAdmin.Shop.TwoFactorRequired {
+		responses.ERROR(writer, http.StatusForbidden, errors.New("Forbidden: This shop requires two-factor authentication"))
+		return
+	}
+
+	err = server.Admins.DisableTwoFactor(adminID, code.Code)
+	if err == models.ErrInvalidTwoFactorCode || err == models.ErrTwoFactorNotEnabled {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(writer, http.StatusNoContent, "")
+}
+
+// AdminLoginTwoFactor -> handles POST /api/v1/admins/login/2fa, exchanges the challenge token of AdminLogin and a code for a session
+func (server *Server) AdminLoginTwoFactor(writer http.ResponseWriter, request *http.Request) {
+
+	code, err := readTwoFactorCode(request)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	adminID, err := auth.ParseChallengeToken(code.ChallengeToken)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Invalid or expired challenge token"))
+		return
+	}
+
+	// Codes are short, guesses are throttled like passwords
+	account := "admin-2fa:" + adminID.String()
+	ip := clientIP(request)
+	if server.backingOff(writer, account, ip, "failed logins") {
+		return
+	}
+
+	err = server.Admins.CheckTwoFactor(adminID.String(), code.Code)
+	if err == models.ErrInvalidTwoFactorCode {
+		server.loginFailed(account, ip)
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnauthorized, errors.New("Invalid or expired challenge token"))
+		return
+	}
+	server.loginSucceeded(account)
+
+	tokens, err := server.startSession(adminID, true)
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(writer, http.StatusOK, tokens)
+}
+
+// RequireShopTwoFactor -> handles PUT /api/v1/shops/<id:uuid>/2fa
+func (server *Server) RequireShopTwoFactor(writer http.ResponseWriter, request *http.Request) {
+
+	shopID := mux.Vars(request)["id"]
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	requirement := struct {
+		Required *bool `json:"require_2fa"`
+	}{}
+	err = json.Unmarshal(body, &requirement)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if requirement.Required == nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, errors.New("Required require_2fa"))
+		return
+	}
+
+	updatedShop, err := server.Shops.SetTwoFactorRequired(shopID, *requirement.Required)
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	responses.JSON(writer, http.StatusOK, updatedShop)
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/amaraliou/stakeout/mailer"
+	"github.com/amaraliou/stakeout/models"
+	"github.com/amaraliou/stakeout/responses"
+	"github.com/amaraliou/stakeout/verification"
+	uuid "github.com/satori/go.uuid"
+)
+
+// VerifyEmail -> handles POST /api/v1/verify-email
+func (server *Server) VerifyEmail(writer http.ResponseWriter, request *http.Request) {
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	verify := struct {
+		Token string `json:"token"`
+	}{}
+	err = json.Unmarshal(body, &verify)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if verify.Token == "" {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, errors.New("Required Token"))
+		return
+	}
+
+	verified, err := server.EmailTokens.VerifyEmail(verify.Token)
+	if err == models.ErrInvalidEmailToken {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if err != nil {
+		responses.ERROR(writer, http.StatusInternalServerError, err)
+		return
+	}
+
+	if !verified.IsAdmin {
+		server.verifyStudentStatus(verified.SubjectID.String())
+	}
+
+	responses.JSON(writer, http.StatusOK, "Email verified")
+}
+
+// ResendVerificationEmail -> handles POST /api/v1/verify-email/resend
+func (server *Server) ResendVerificationEmail(writer http.ResponseWriter, request *http.Request) {
+
+	body, err := ioutil.ReadAll(request.Body)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	resend := struct {
+		Email string `json:"email"`
+	}{}
+	err = json.Unmarshal(body, &resend)
+	if err != nil {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, err)
+		return
+	}
+
+	if resend.Email == "" {
+		responses.ERROR(writer, http.StatusUnprocessableEntity, errors.New("Required Email"))
+		return
+	}
+
+	if !server.emailRequested(writer, request, models.EmailTokenVerify, resend.Email) {
+		return
+	}
+
+	// The answer is the same, and as quick, whether or not the email belongs to an unverified account
+	server.sendInBackground(func() {
+		student, err := server.Students.FindStudentByEmail(resend.Email)
+		if err == nil && !student.IsVerified {
+			server.sendVerificationEmail(student.ID, false, student.Email)
+		}
+
+		admin, err := server.Admins.FindAdminByEmail(resend.Email)
+		if err == nil && !admin.IsVerified {
+			server.sendVerificationEmail(admin.ID, true, admin.Email)
+		}
+	})
+
+	responses.JSON(writer, http.StatusAccepted, "If this email needs verifying, a new link is on its way")
+}
+
+// sendVerificationEmail -> emails a verification token, failures are only logged as the token can be sent again
+func (server *Server) sendVerificationEmail(subjectID uuid.UUID, isAdmin bool, email string) {
+
+	emailToken := &models.EmailToken{SubjectID: subjectID, IsAdmin: isAdmin, Email: email, Purpose: models.EmailTokenVerify}
+	token, err := server.EmailTokens.CreateEmailToken(emailToken)
+	if err != nil {
+		log.Printf("Cannot create the verification token for %s: %v", subjectID, err)
+		return
+	}
+
+	err = server.Mailer.Send(mailer.Message{
+		To:      email,
+		Subject: "Verify your email",
+		Body:    fmt.Sprintf("Welcome to Apetitoso!\n\nUse this code to verify your email, it expires in %s:\n\n%s\n", models.EmailTokenTTL(models.EmailTokenVerify), token),
+	})
+	if err != nil {
+		log.Printf("Cannot send the verification email to %s: %v", subjectID, err)
+	}
+}
+
+// verifyStudentStatus -> asks the verifier whether a student whose email was just verified is one, failures are only logged
+// and leave the student as not one
+func (server *Server) verifyStudentStatus(studentID string) {
+
+	current, err := server.Students.FindStudentByID(studentID)
+	if err != nil {
+		log.Printf("Cannot find the student %s to verify: %v", studentID, err)
+		return
+	}
+
+	result, err := server.Verifier.Verify(verification.Subject{
+		Email:     current.Email,
+		FirstName: current.FirstName,
+		LastName:  current.LastName,
+		BirthDate: current.BirthDate,
+	})
+	if err != nil {
+		log.Printf("Cannot verify the student status of %s: %v", studentID, err)
+		return
+	}
+
+	_, err = server.Students.UpdateStudentStatus(studentID, result.IsStudent, result.University)
+	if err != nil {
+		log.Printf("Cannot save the student status of %s: %v", studentID, err)
+	}
+}
@@ -0,0 +1,114 @@
+package mailer
+
+import (
+	"fmt"
+	"log"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/amaraliou/stakeout/common"
+)
+
+// Message -> an email to send
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer -> sends emails, SMTP in production, Memory in tests
+type Mailer interface {
+	Send(message Message) error
+}
+
+// SMTP -> Mailer sending through an SMTP server, with PLAIN auth when Username is set
+type SMTP struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send -> sends the message as a plain text email
+func (mailer *SMTP) Send(message Message) error {
+
+	var smtpAuth smtp.Auth
+	if mailer.Username != "" {
+		smtpAuth = smtp.PlainAuth("", mailer.Username, mailer.Password, mailer.Host)
+	}
+
+	headers := []string{
+		fmt.Sprintf("From: %s", mailer.From),
+		fmt.Sprintf("To: %s", message.To),
+		fmt.Sprintf("Subject: %s", message.Subject),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=\"utf-8\"",
+	}
+	data := strings.Join(headers, "\r\n") + "\r\n\r\n" + message.Body
+
+	return smtp.SendMail(mailer.Host+":"+mailer.Port, smtpAuth, mailer.From, []string{message.To}, []byte(data))
+}
+
+// Memory -> Mailer keeping the messages it is given, for tests
+type Memory struct {
+	mutex    sync.Mutex
+	messages []Message
+}
+
+// Send -> keeps the message
+func (mailer *Memory) Send(message Message) error {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	mailer.messages = append(mailer.messages, message)
+	return nil
+}
+
+// Messages -> every message sent so far
+func (mailer *Memory) Messages() []Message {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	return append([]Message{}, mailer.messages...)
+}
+
+// Last -> the last message sent to the given address
+func (mailer *Memory) Last(to string) (Message, bool) {
+	mailer.mutex.Lock()
+	defer mailer.mutex.Unlock()
+
+	for i := len(mailer.messages) - 1; i >= 0; i-- {
+		if mailer.messages[i].To == to {
+			return mailer.messages[i], true
+		}
+	}
+	return Message{}, false
+}
+
+// Log -> Mailer printing messages to the log, used when no SMTP server is configured
+type Log struct{}
+
+// Send -> logs the message
+func (mailer Log) Send(message Message) error {
+	log.Printf("Email to %s: %s\n%s\n", message.To, message.Subject, message.Body)
+	return nil
+}
+
+// FromConfig -> SMTP mailer for the configured server, Log when no SMTP host is configured
+func FromConfig(config common.SMTPConfig) Mailer {
+
+	if config.Host == "" {
+		return Log{}
+	}
+
+	return &SMTP{
+		Host:     config.Host,
+		Port:     strconv.Itoa(config.Port),
+		Username: config.Username,
+		Password: config.Password,
+		From:     config.From,
+	}
+}
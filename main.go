@@ -1,7 +1,21 @@
 package main
 
-import "github.com/amaraliou/stakeout/server"
+import (
+	"os"
+
+	"github.com/amaraliou/stakeout/server"
+)
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "flag-passwords" {
+		server.FlagPasswords()
+		return
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		server.Migrate(os.Args[2:])
+		return
+	}
+
 	server.Run()
 }
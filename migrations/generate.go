@@ -0,0 +1,56 @@
+//go:build ignore
+// +build ignore
+
+// Compiles the SQL files of sql/ into files.go, run through go generate from this directory
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func main() {
+
+	paths, err := filepath.Glob(filepath.Join("sql", "*.sql"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	sort.Strings(paths)
+
+	out := bytes.Buffer{}
+	out.WriteString("// Code generated by generate.go from the files in sql/. DO NOT EDIT.\n\n")
+	out.WriteString("package migrations\n\n")
+	out.WriteString("var files = map[string]string{\n")
+
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		// Raw strings keep the SQL readable in diffs, quoting is only needed for SQL with a backtick
+		source := "`" + string(data) + "`"
+		if strings.Contains(string(data), "`") {
+			source = strconv.Quote(string(data))
+		}
+		fmt.Fprintf(&out, "\t%q: %s,\n", filepath.Base(path), source)
+	}
+	out.WriteString("}\n")
+
+	formatted, err := format.Source(out.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = ioutil.WriteFile("files.go", formatted, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,145 @@
+// Package migrations holds the versioned SQL schema of the API. The files in sql/ are compiled into the
+// binary by `go generate ./migrations`, which has to be run again whenever one of them changes.
+package migrations
+
+//go:generate go run generate.go
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// SourceDir -> where the SQL files live, relative to the root of the repository
+const SourceDir = "migrations/sql"
+
+// Migration -> one numbered schema change, Down undoes what Up did
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// fileName -> 0001_initial_schema.up.sql
+var fileName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// All -> the migrations compiled into the binary, oldest first
+func All() ([]Migration, error) {
+	return Parse(files)
+}
+
+// Parse -> turns SQL files, keyed by file name, into migrations. Every version needs both an up and a down file
+func Parse(sources map[string]string) ([]Migration, error) {
+
+	byVersion := map[int64]*Migration{}
+	for name, source := range sources {
+		match := fileName.FindStringSubmatch(name)
+		if match == nil {
+			return nil, fmt.Errorf("Invalid migration file name %s, expected <version>_<name>.up.sql or .down.sql", name)
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("Invalid migration version in %s", name)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("Migration %d has two names, %s and %s", version, migration.Name, match[2])
+		}
+
+		if match[3] == "up" {
+			migration.Up = source
+		} else {
+			migration.Down = source
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if strings.TrimSpace(migration.Up) == "" || strings.TrimSpace(migration.Down) == "" {
+			return nil, fmt.Errorf("Migration %d_%s needs both an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// ReadDir -> reads the SQL files of a directory, for Parse
+func ReadDir(dir string) (map[string]string, error) {
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := map[string]string{}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".sql" {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		sources[entry.Name()] = string(data)
+	}
+
+	return sources, nil
+}
+
+// Create -> writes the empty up and down files of a new migration numbered after the last one in dir, returns their paths
+func Create(dir, name string) (string, string, error) {
+
+	name = strings.Trim(nonWord.ReplaceAllString(strings.ToLower(name), "_"), "_")
+	if name == "" {
+		return "", "", errors.New("Required migration name")
+	}
+
+	sources, err := ReadDir(dir)
+	if err != nil {
+		return "", "", err
+	}
+
+	existing, err := Parse(sources)
+	if err != nil {
+		return "", "", err
+	}
+
+	version := int64(1)
+	if len(existing) > 0 {
+		version = existing[len(existing)-1].Version + 1
+	}
+
+	up := filepath.Join(dir, fmt.Sprintf("%04d_%s.up.sql", version, name))
+	down := filepath.Join(dir, fmt.Sprintf("%04d_%s.down.sql", version, name))
+
+	for _, path := range []string{up, down} {
+		err = ioutil.WriteFile(path, []byte("-- "+filepath.Base(path)+"\n"), 0644)
+		if err != nil {
+			os.Remove(up)
+			return "", "", err
+		}
+	}
+
+	return up, down, nil
+}
+
+var nonWord = regexp.MustCompile(`[^a-z0-9]+`)
@@ -0,0 +1,205 @@
+package migrations
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// SchemaMigration -> a row of schema_migrations, one for every migration applied to the database
+type SchemaMigration struct {
+	Version   int64     `gorm:"primary_key;auto_increment:false"`
+	Name      string    `gorm:"not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+// TableName -> schema_migrations
+func (SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+// Status -> whether a migration has been applied, and when
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+}
+
+// lockID -> key of the advisory lock making concurrent runs wait for each other, any constant works
+const lockID = 7365726
+
+// Migrator -> applies and rolls back migrations on a database
+type Migrator struct {
+	DB         *gorm.DB
+	Migrations []Migration
+}
+
+// New -> a migrator for the migrations compiled into the binary
+func New(db *gorm.DB) (*Migrator, error) {
+
+	migrations, err := All()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// prepare -> creates schema_migrations on the first run
+func (migrator *Migrator) prepare() error {
+	return migrator.DB.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamp with time zone NOT NULL
+	)`).Error
+}
+
+// applied -> the rows of schema_migrations by version
+func applied(db *gorm.DB) (map[int64]SchemaMigration, error) {
+
+	rows := []SchemaMigration{}
+	err := db.Order("version").Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]SchemaMigration{}
+	for _, row := range rows {
+		byVersion[row.Version] = row
+	}
+	return byVersion, nil
+}
+
+// Status -> every migration known to the binary, and whether it has been applied
+func (migrator *Migrator) Status() ([]Status, error) {
+
+	err := migrator.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := applied(migrator.DB)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(migrator.Migrations))
+	for _, migration := range migrator.Migrations {
+		row, ok := rows[migration.Version]
+		statuses = append(statuses, Status{Migration: migration, Applied: ok, AppliedAt: row.AppliedAt})
+	}
+	return statuses, nil
+}
+
+// Up -> applies every pending migration in order, each in its own transaction. Returns the ones it applied
+func (migrator *Migrator) Up() ([]Migration, error) {
+
+	err := migrator.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for _, migration := range migrator.Migrations {
+		ran := false
+		err := migrator.DB.Transaction(func(tx *gorm.DB) error {
+			rows, err := lock(tx)
+			if err != nil {
+				return err
+			}
+
+			// Another process may have got there while this one waited for the lock
+			if _, ok := rows[migration.Version]; ok {
+				return nil
+			}
+
+			// Straight to the driver, gorm would read any ? in the SQL as a placeholder
+			_, err = tx.CommonDB().Exec(migration.Up)
+			if err != nil {
+				return fmt.Errorf("Migration %d_%s failed: %v", migration.Version, migration.Name, err)
+			}
+
+			ran = true
+			return tx.Create(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+		})
+		if err != nil {
+			return done, err
+		}
+
+		if ran {
+			done = append(done, migration)
+		}
+	}
+
+	return done, nil
+}
+
+// Down -> rolls back the last steps applied migrations, newest first. Returns the ones it rolled back
+func (migrator *Migrator) Down(steps int) ([]Migration, error) {
+
+	err := migrator.prepare()
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int64]Migration{}
+	for _, migration := range migrator.Migrations {
+		byVersion[migration.Version] = migration
+	}
+
+	done := []Migration{}
+	for len(done) < steps {
+		var rolledBack *Migration
+		err := migrator.DB.Transaction(func(tx *gorm.DB) error {
+			_, err := lock(tx)
+			if err != nil {
+				return err
+			}
+
+			last := SchemaMigration{}
+			err = tx.Order("version desc").Take(&last).Error
+			if gorm.IsRecordNotFoundError(err) {
+				return nil
+			}
+
+			if err != nil {
+				return err
+			}
+
+			migration, ok := byVersion[last.Version]
+			if !ok {
+				return fmt.Errorf("Migration %d_%s is applied but not in this binary, it can't be rolled back", last.Version, last.Name)
+			}
+
+			_, err = tx.CommonDB().Exec(migration.Down)
+			if err != nil {
+				return fmt.Errorf("Rollback of migration %d_%s failed: %v", migration.Version, migration.Name, err)
+			}
+
+			rolledBack = &migration
+			return tx.Delete(&SchemaMigration{}, "version = ?", last.Version).Error
+		})
+		if err != nil {
+			return done, err
+		}
+
+		if rolledBack == nil {
+			break
+		}
+		done = append(done, *rolledBack)
+	}
+
+	return done, nil
+}
+
+// lock -> takes the migration lock for the rest of the transaction and reads schema_migrations under it
+func lock(tx *gorm.DB) (map[int64]SchemaMigration, error) {
+
+	err := tx.Exec("SELECT pg_advisory_xact_lock(?)", lockID).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return applied(tx)
+}
@@ -0,0 +1,222 @@
+-- The schema AutoMigrate used to create. IF NOT EXISTS everywhere so databases it already set up can be adopted as they are
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+CREATE TABLE IF NOT EXISTS shops (
+    id uuid DEFAULT uuid_generate_v4(),
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    deleted_at timestamp with time zone,
+    name text,
+    logo text,
+    latitude numeric,
+    longitude numeric,
+    description text,
+    points_rate numeric,
+    two_factor_required boolean,
+    address_number integer,
+    address_line1 text,
+    address_line2 text,
+    town_or_city text,
+    county text,
+    postcode text,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_shops_deleted_at ON shops (deleted_at);
+
+CREATE TABLE IF NOT EXISTS admins (
+    id uuid DEFAULT uuid_generate_v4(),
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    deleted_at timestamp with time zone,
+    email text NOT NULL UNIQUE,
+    password text,
+    is_verified boolean,
+    password_reset_required boolean,
+    first_name text,
+    last_name text,
+    role text DEFAULT 'shop_owner',
+    shop_id uuid,
+    totp_secret text,
+    two_factor_enabled boolean,
+    totp_last_step bigint,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_admins_deleted_at ON admins (deleted_at);
+
+ALTER TABLE admins DROP CONSTRAINT IF EXISTS admins_shop_id_shops_id_foreign;
+ALTER TABLE admins ADD CONSTRAINT admins_shop_id_shops_id_foreign FOREIGN KEY (shop_id) REFERENCES shops (id) ON DELETE CASCADE ON UPDATE CASCADE;
+
+CREATE TABLE IF NOT EXISTS students (
+    id uuid DEFAULT uuid_generate_v4(),
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    deleted_at timestamp with time zone,
+    email text NOT NULL UNIQUE,
+    password text,
+    is_verified boolean,
+    password_reset_required boolean,
+    is_student boolean,
+    first_name text,
+    last_name text,
+    birth_date text,
+    university text,
+    mobile_number text,
+    country_code text,
+    graduation_year integer,
+    points integer,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_students_deleted_at ON students (deleted_at);
+
+CREATE TABLE IF NOT EXISTS products (
+    id uuid DEFAULT uuid_generate_v4(),
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    deleted_at timestamp with time zone,
+    name text,
+    description text,
+    code text,
+    price numeric,
+    price_currency text,
+    in_sale boolean,
+    discount integer,
+    discount_unit text,
+    shop_id uuid,
+    reward integer,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_products_deleted_at ON products (deleted_at);
+
+CREATE TABLE IF NOT EXISTS orders (
+    id uuid DEFAULT uuid_generate_v4(),
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    deleted_at timestamp with time zone,
+    user_id uuid,
+    shop_id uuid,
+    order_total numeric,
+    status integer,
+    redeemed_points integer,
+    points_discount numeric,
+    updated_by uuid,
+    status_updated_at timestamp with time zone,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_orders_deleted_at ON orders (deleted_at);
+
+CREATE TABLE IF NOT EXISTS order_lines (
+    id uuid DEFAULT uuid_generate_v4(),
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    deleted_at timestamp with time zone,
+    order_id uuid,
+    product_id uuid,
+    product_name text,
+    quantity integer,
+    unit_price numeric,
+    discount numeric,
+    line_total numeric,
+    reward integer,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_order_lines_deleted_at ON order_lines (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_order_lines_order_id ON order_lines (order_id);
+
+CREATE TABLE IF NOT EXISTS order_events (
+    id uuid DEFAULT uuid_generate_v4(),
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    deleted_at timestamp with time zone,
+    order_id uuid,
+    action text,
+    from_status integer,
+    to_status integer,
+    actor_id uuid,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_order_events_deleted_at ON order_events (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_order_events_order_id ON order_events (order_id);
+
+CREATE TABLE IF NOT EXISTS points_entries (
+    id uuid DEFAULT uuid_generate_v4(),
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    deleted_at timestamp with time zone,
+    student_id uuid,
+    order_id uuid,
+    amount integer,
+    reason text,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_points_entries_deleted_at ON points_entries (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_points_entries_student_id ON points_entries (student_id);
+CREATE INDEX IF NOT EXISTS idx_points_entries_order_id ON points_entries (order_id);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id uuid DEFAULT uuid_generate_v4(),
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    deleted_at timestamp with time zone,
+    family_id uuid,
+    subject_id uuid,
+    is_admin boolean,
+    token_hash text,
+    expires_at timestamp with time zone,
+    used_at timestamp with time zone,
+    revoked_at timestamp with time zone,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_deleted_at ON refresh_tokens (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_subject_id ON refresh_tokens (subject_id);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+
+CREATE TABLE IF NOT EXISTS revoked_tokens (
+    id uuid DEFAULT uuid_generate_v4(),
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    deleted_at timestamp with time zone,
+    jti text,
+    expires_at timestamp with time zone,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_deleted_at ON revoked_tokens (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_revoked_tokens_jti ON revoked_tokens (jti);
+CREATE INDEX IF NOT EXISTS idx_revoked_tokens_expires_at ON revoked_tokens (expires_at);
+
+CREATE TABLE IF NOT EXISTS email_tokens (
+    id uuid DEFAULT uuid_generate_v4(),
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    deleted_at timestamp with time zone,
+    subject_id uuid,
+    is_admin boolean,
+    purpose text,
+    token_hash text,
+    expires_at timestamp with time zone,
+    used_at timestamp with time zone,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_email_tokens_deleted_at ON email_tokens (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_email_tokens_subject_id ON email_tokens (subject_id);
+CREATE UNIQUE INDEX IF NOT EXISTS uix_email_tokens_token_hash ON email_tokens (token_hash);
+
+CREATE TABLE IF NOT EXISTS login_attempts (
+    key text,
+    count integer NOT NULL,
+    last_failure timestamp with time zone NOT NULL,
+    PRIMARY KEY (key)
+);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id uuid DEFAULT uuid_generate_v4(),
+    created_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    updated_at timestamp with time zone DEFAULT CURRENT_TIMESTAMP,
+    deleted_at timestamp with time zone,
+    admin_id uuid NOT NULL,
+    code_hash text NOT NULL UNIQUE,
+    used_at timestamp with time zone,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_deleted_at ON recovery_codes (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_admin_id ON recovery_codes (admin_id);
@@ -0,0 +1,2 @@
+-- Nearby searches narrow shops down to a latitude/longitude box before measuring distances, this keeps that a range scan
+CREATE INDEX IF NOT EXISTS idx_shops_location ON shops (latitude, longitude);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_shops_search_trgm;
+DROP INDEX IF EXISTS idx_shops_search;
+DROP INDEX IF EXISTS idx_products_search_trgm;
+DROP INDEX IF EXISTS idx_products_search;
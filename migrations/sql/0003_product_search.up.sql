@@ -0,0 +1,8 @@
+-- Text search of products and shops, the expressions are the ones models/search.go searches and have to be changed with them
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_products_search ON products USING gin ((setweight(to_tsvector('simple', coalesce(name, '') || ' ' || coalesce(code, '')), 'A') || setweight(to_tsvector('simple', coalesce(description, '')), 'C')));
+CREATE INDEX IF NOT EXISTS idx_products_search_trgm ON products USING gin ((coalesce(name, '') || ' ' || coalesce(code, '') || ' ' || coalesce(description, '')) gin_trgm_ops);
+
+CREATE INDEX IF NOT EXISTS idx_shops_search ON shops USING gin ((setweight(to_tsvector('simple', coalesce(name, '')), 'B') || setweight(to_tsvector('simple', coalesce(description, '')), 'D')));
+CREATE INDEX IF NOT EXISTS idx_shops_search_trgm ON shops USING gin ((coalesce(name, '') || ' ' || coalesce(description, '')) gin_trgm_ops);
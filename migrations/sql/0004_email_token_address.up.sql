@@ -0,0 +1,2 @@
+-- The address a token was emailed to, a token only works while the account still has it
+ALTER TABLE email_tokens ADD COLUMN IF NOT EXISTS email text;
@@ -8,8 +8,8 @@ import (
 
 // CurrentLocation -> struct to hold current location of a student
 type CurrentLocation struct {
-	Latitude  float32 `json:"latitude"`
-	Longitude float32 `json:"longitude"`
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
 }
 
 // Address -> struct to hold address information
@@ -31,7 +31,7 @@ type ShopAddress struct {
 	AddressLine2  string `json:"address_2"`
 	TownOrCity    string `json:"town_or_city"`
 	County        string `json:"county"`
-	Postcode      string `json:"postcode"` // To implement postcode validation
+	Postcode      string `json:"postcode"` // Checked and normalised by Shop.Validate
 }
 
 // Validate address -> to configure
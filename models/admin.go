@@ -2,7 +2,7 @@ package models
 
 import (
 	"errors"
-	"log"
+	"reflect"
 	"strings"
 
 	"github.com/badoux/checkmail"
@@ -16,8 +16,10 @@ type Admin struct {
 	User
 	FirstName string    `json:"first_name"`
 	LastName  string    `json:"last_name"`
+	Role      string    `json:"role" gorm:"default:'shop_owner'"`
 	Shop      Shop      `json:"shop" gorm:"foreignkey:ShopID"`
 	ShopID    uuid.UUID `json:"-" gorm:"shop_id"`
+	TwoFactor
 }
 
 // Validate ...
@@ -36,20 +38,22 @@ func (admin *Admin) Validate(action string) error {
 			return errors.New("Invalid Email")
 		}
 
+	case "update":
+		if admin.Email != "" {
+			if err := checkmail.ValidateFormat(admin.Email); err != nil {
+				return errors.New("Invalid Email")
+			}
+		}
+
 	default:
 		return nil
 	}
 	return nil
 }
 
-// BeforeSave will check hashes for passwords
+// BeforeSave will hash passwords, only the hash read from the database is left alone
 func (admin *Admin) BeforeSave() error {
-	hashedPassword, err := Hash(admin.Password)
-	if err != nil {
-		return err
-	}
-	admin.Password = string(hashedPassword)
-	return nil
+	return admin.hashPassword()
 }
 
 // CreateAdmin -> Function to create a new admin
@@ -63,16 +67,31 @@ func (admin *Admin) CreateAdmin(db *gorm.DB) (*Admin, error) {
 	return admin, nil
 }
 
-// FindAllAdmins -> Function to retrieve all admins
-func (admin *Admin) FindAllAdmins(db *gorm.DB) (*[]Admin, error) {
+// AdminList -> what admin lists can be sorted and filtered on
+var AdminList = ListSpec{
+	Fields: map[string]ListField{
+		"created_at": {Column: "created_at", Field: "CreatedAt", Kind: FieldTime, Sort: true},
+		"email":      {Column: "email", Field: "Email", Kind: FieldString, Sort: true, Filter: true},
+		"first_name": {Column: "first_name", Field: "FirstName", Kind: FieldString, Sort: true, Filter: true},
+		"last_name":  {Column: "last_name", Field: "LastName", Kind: FieldString, Sort: true, Filter: true},
+		"role":       {Column: "role", Field: "Role", Kind: FieldString, Filter: true},
+		"verified":   {Column: "is_verified", Field: "IsVerified", Kind: FieldBool, Filter: true},
+	},
+	DefaultSort: "created_at",
+}
+
+// ListAdmins -> a page of admins and the cursor to the next one, empty on the last page
+func (admin *Admin) ListAdmins(db *gorm.DB, query ListQuery) (*[]Admin, string, error) {
 
 	admins := []Admin{}
-	err := db.Debug().Model(&Admin{}).Limit(100).Find(&admins).Error
+	err := query.scope(db.Debug().Model(&Admin{}), AdminList).Find(&admins).Error
 	if err != nil {
-		return &[]Admin{}, err
+		return &[]Admin{}, "", err
 	}
 
-	return &admins, nil
+	count, next := query.nextPage(reflect.ValueOf(admins), AdminList)
+	admins = admins[:count]
+	return &admins, next, nil
 }
 
 // FindAllAdminsWithShopID -> Function to retrieve all admins of a given shop
@@ -111,15 +130,78 @@ func (admin *Admin) FindAdminByID(db *gorm.DB, id string) (*Admin, error) {
 	return admin, nil
 }
 
-// UpdateAdmin -> Function to update a given admin
+// FindAdminByEmail -> Function to find the admin with the given email, the one lookup logins and emailed links need.
+// Unlike FindAdminByID the shop isn't loaded
+func (admin *Admin) FindAdminByEmail(db *gorm.DB, email string) (*Admin, error) {
+
+	err := db.Debug().Model(Admin{}).Where("email = ?", email).Take(&admin).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &Admin{}, ErrEmailNotFound
+	}
+
+	if err != nil {
+		return &Admin{}, err
+	}
+
+	return admin, nil
+}
+
+// UpdateAdmin -> Function to update a given admin, a new email has to be verified again
 func (admin *Admin) UpdateAdmin(db *gorm.DB, id string) (*Admin, error) {
 
-	err := admin.BeforeSave()
+	current, err := (&Admin{}).FindAdminByID(db, id)
 	if err != nil {
-		log.Fatal(err)
+		return &Admin{}, err
+	}
+
+	// Passwords are only changed through UpdatePassword and password resets
+	admin.Password = ""
+
+	// Emails are only verified through VerifyEmail
+	admin.IsVerified = false
+
+	// Two-factor authentication is only set up through EnrollTwoFactor
+	admin.TwoFactor = TwoFactor{}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Debug().Model(&Admin{}).Where("id = ?", id).Omit("password").Updates(&admin).Error
+		if err != nil || admin.Email == "" || admin.Email == current.Email {
+			return err
+		}
+
+		// Updates skips false, the flag is cleared on its own
+		return tx.Debug().Model(&Admin{}).Where("id = ?", id).UpdateColumn("is_verified", false).Error
+	})
+	if err != nil {
+		return &Admin{}, err
+	}
+
+	return admin.FindAdminByID(db, id)
+}
+
+// UpdatePassword -> Function to change the password of an admin who knows the current one
+func (admin *Admin) UpdatePassword(db *gorm.DB, id, currentPassword, newPassword string) error {
+
+	current, err := admin.FindAdminByID(db, id)
+	if err != nil {
+		return err
+	}
+
+	return changePassword(db, &Admin{}, id, current.Password, currentPassword, newPassword)
+}
+
+// UpdateAdminRole -> Function to change the role of an admin and, when given, the shop they work for
+func (admin *Admin) UpdateAdminRole(db *gorm.DB, id string) (*Admin, error) {
+
+	columns := map[string]interface{}{
+		"role": admin.Role,
+	}
+
+	if admin.ShopID.String() != "00000000-0000-0000-0000-000000000000" {
+		columns["shop_id"] = admin.ShopID
 	}
 
-	err = db.Debug().Model(Admin{}).Updates(&admin).Error
+	err := db.Debug().Model(&Admin{}).Where("id = ?", id).UpdateColumns(columns).Error
 	if err != nil {
 		return &Admin{}, err
 	}
@@ -1,18 +1,14 @@
 package models
 
 import (
-	"fmt"
-	"os"
 	"time"
 
+	"github.com/amaraliou/stakeout/common"
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
-	"github.com/joho/godotenv"
 	uuid "github.com/satori/go.uuid"
 )
 
-var db *gorm.DB
-
 // Base -> Struct to substitute gorm.Model when I want a UUID
 type Base struct {
 	ID        uuid.UUID  `gorm:"primary_key" sql:"type:uuid;primary_key;default:uuid_generate_v4()"`
@@ -30,32 +26,9 @@ func (base *Base) BeforeCreate(scope *gorm.Scope) error {
 	return scope.SetColumn("ID", uuid)
 }
 
-func init() {
-
-	e := godotenv.Load()
-	if e != nil {
-		fmt.Print(e)
-	}
-
-	username := os.Getenv("DB_USER")
-	password := os.Getenv("DB_PASS")
-	dbName := os.Getenv("DB_NAME")
-	dbHost := os.Getenv("DB_HOST")
-
-	dbURI := fmt.Sprintf("host=%s user=%s dbname=%s sslmode=disable password=%s", dbHost, username, dbName, password)
-	fmt.Println(dbURI)
-
-	conn, err := gorm.Open("postgres", dbURI)
-	if err != nil {
-		fmt.Print(err)
-	}
-
-	db = conn
-	db.Debug().AutoMigrate(&Admin{}, &Shop{}, &Student{})
-	err = db.Model(&Admin{}).AddForeignKey("shop_id", "shops(id)", "CASCADE", "CASCADE").Error
-}
-
-// GetDB -> Return current DB instance
-func GetDB() *gorm.DB {
-	return db
+// Configure -> sets the lifetimes of refresh tokens and emailed tokens
+func Configure(config common.AuthConfig) {
+	RefreshTokenTTL = config.RefreshTokenTTL
+	emailTokenTTLs[EmailTokenVerify] = config.VerifyEmailTTL
+	emailTokenTTLs[EmailTokenPasswordReset] = config.PasswordResetTTL
 }
@@ -0,0 +1,195 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/amaraliou/stakeout/auth"
+	"github.com/amaraliou/stakeout/common"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	EmailTokenVerify        = "verify_email"
+	EmailTokenPasswordReset = "password_reset"
+)
+
+// emailTokenTTLs -> how long an emailed token can be used for, by purpose, set by Configure
+var emailTokenTTLs = map[string]time.Duration{
+	EmailTokenVerify:        common.DefaultConfig().Auth.VerifyEmailTTL,
+	EmailTokenPasswordReset: common.DefaultConfig().Auth.PasswordResetTTL,
+}
+
+var (
+	// ErrInvalidEmailToken -> the emailed token is unknown, used, expired or meant for something else
+	ErrInvalidEmailToken = errors.New("Invalid or expired token")
+	// ErrEmailNotVerified -> the account hasn't verified its email yet
+	ErrEmailNotVerified = errors.New("Email not verified")
+)
+
+// EmailToken -> Struct to hold a hashed single use token sent by email, only the last one sent for a Purpose is usable, and
+// only while the account still has the Email it was sent to
+type EmailToken struct {
+	Base
+	SubjectID uuid.UUID  `json:"-" gorm:"index"`
+	IsAdmin   bool       `json:"-"`
+	Email     string     `json:"-"` // The address the token was sent to
+	Purpose   string     `json:"-"`
+	TokenHash string     `json:"-" gorm:"unique_index"`
+	ExpiresAt time.Time  `json:"-"`
+	UsedAt    *time.Time `json:"-"`
+}
+
+// EmailTokenTTL -> how long a token emailed for the purpose can be used for
+func EmailTokenTTL(purpose string) time.Duration {
+	return emailTokenTTLs[purpose]
+}
+
+// CreateEmailToken -> Function to issue a token for SubjectID and Purpose, earlier tokens for the same purpose stop working.
+// Returns the token itself, only its hash is stored
+func (emailToken *EmailToken) CreateEmailToken(db *gorm.DB) (string, error) {
+
+	token, err := emailToken.prepare()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Debug().Model(&EmailToken{}).Where("subject_id = ? AND purpose = ? AND used_at IS NULL", emailToken.SubjectID, emailToken.Purpose).UpdateColumn("used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Debug().Create(&emailToken).Error
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// prepare -> draws the token and fills in what is stored of it and the expiry of its purpose
+func (emailToken *EmailToken) prepare() (string, error) {
+
+	token, err := auth.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	emailToken.TokenHash = auth.HashOpaqueToken(token)
+	emailToken.ExpiresAt = time.Now().Add(EmailTokenTTL(emailToken.Purpose))
+	return token, nil
+}
+
+// UseEmailToken -> Function to spend a token issued for the given purpose, meant to be called inside a transaction.
+// A token sent to an address the account has changed since is refused, it would vouch for the wrong one
+func (emailToken *EmailToken) UseEmailToken(db *gorm.DB, token, purpose string) (*EmailToken, error) {
+
+	err := db.Debug().Set("gorm:query_option", "FOR UPDATE").Model(&EmailToken{}).Where("token_hash = ?", auth.HashOpaqueToken(token)).Take(&emailToken).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &EmailToken{}, ErrInvalidEmailToken
+	}
+
+	if err != nil {
+		return &EmailToken{}, err
+	}
+
+	if emailToken.Purpose != purpose || emailToken.UsedAt != nil || time.Now().After(emailToken.ExpiresAt) {
+		return &EmailToken{}, ErrInvalidEmailToken
+	}
+
+	sentToCurrentEmail, err := emailToken.sentToCurrentEmail(db)
+	if err != nil {
+		return &EmailToken{}, err
+	}
+	if !sentToCurrentEmail {
+		return &EmailToken{}, ErrInvalidEmailToken
+	}
+
+	err = db.Debug().Model(&EmailToken{}).Where("id = ?", emailToken.ID).UpdateColumn("used_at", time.Now()).Error
+	if err != nil {
+		return &EmailToken{}, err
+	}
+
+	return emailToken, nil
+}
+
+// VerifyEmail -> Function to mark the student or admin a verification token was sent to as verified, returns the spent token
+func (emailToken *EmailToken) VerifyEmail(db *gorm.DB, token string) (*EmailToken, error) {
+
+	verified := &EmailToken{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		used, err := emailToken.UseEmailToken(tx, token, EmailTokenVerify)
+		if err != nil {
+			return err
+		}
+		verified = used
+
+		if used.IsAdmin {
+			return tx.Debug().Model(&Admin{}).Where("id = ?", used.SubjectID).UpdateColumn("is_verified", true).Error
+		}
+
+		return tx.Debug().Model(&Student{}).Where("id = ?", used.SubjectID).UpdateColumn("is_verified", true).Error
+	})
+	if err != nil {
+		return &EmailToken{}, err
+	}
+
+	return verified, nil
+}
+
+// ResetPassword -> Function to set the password of the student or admin a reset token was sent to.
+// Every session of the account is revoked, and the email counts as verified as the token was read from it
+func (emailToken *EmailToken) ResetPassword(db *gorm.DB, token, password string) (*EmailToken, error) {
+
+	hashedPassword, err := Hash(password)
+	if err != nil {
+		return &EmailToken{}, err
+	}
+
+	reset := &EmailToken{}
+	err = db.Transaction(func(tx *gorm.DB) error {
+		used, err := emailToken.UseEmailToken(tx, token, EmailTokenPasswordReset)
+		if err != nil {
+			return err
+		}
+		reset = used
+
+		var account interface{} = &Student{}
+		if used.IsAdmin {
+			account = &Admin{}
+		}
+
+		// UpdateColumns skips BeforeSave, the password is already hashed
+		err = tx.Debug().Model(account).Where("id = ?", used.SubjectID).UpdateColumns(map[string]interface{}{"password": string(hashedPassword), "is_verified": true, "password_reset_required": false}).Error
+		if err != nil {
+			return err
+		}
+
+		return RevokeSubjectSessions(tx, used.SubjectID.String())
+	})
+	if err != nil {
+		return &EmailToken{}, err
+	}
+
+	return reset, nil
+}
+
+// sentToCurrentEmail -> the account the token was sent to still has the address it was sent to
+func (emailToken *EmailToken) sentToCurrentEmail(db *gorm.DB) (bool, error) {
+
+	var account interface{} = &Student{}
+	if emailToken.IsAdmin {
+		account = &Admin{}
+	}
+
+	emails := []string{}
+	err := db.Debug().Model(account).Where("id = ?", emailToken.SubjectID).Pluck("email", &emails).Error
+	if err != nil {
+		return false, err
+	}
+
+	return emailToken.Email != "" && len(emails) == 1 && emails[0] == emailToken.Email, nil
+}
@@ -0,0 +1,197 @@
+package models
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/jinzhu/gorm"
+)
+
+// EarthRadius -> mean radius of the Earth in kilometres, distances are great-circle distances on a sphere this size
+const EarthRadius = 6371.0
+
+// DefaultNearbyRadius and MaxNearbyRadius -> in kilometres, how far nearby searches look when the client doesn't say,
+// and the furthest they can
+const (
+	DefaultNearbyRadius = 5.0
+	MaxNearbyRadius     = 50.0
+)
+
+// NearbyShop -> a shop found around a location and how far it is from it
+type NearbyShop struct {
+	Shop
+	Distance float64 `json:"distance_km"`
+}
+
+// NearbyQuery -> where to look around, how far and for how many shops at most
+type NearbyQuery struct {
+	Location CurrentLocation
+	Radius   float64
+	Limit    int
+}
+
+// ParseNearbyQuery -> reads lat, lng, radius and limit from a query string. lat and lng are required
+func ParseNearbyQuery(values url.Values) (NearbyQuery, error) {
+
+	query := NearbyQuery{Radius: DefaultNearbyRadius, Limit: DefaultListLimit}
+
+	if values.Get("lat") == "" || values.Get("lng") == "" {
+		return NearbyQuery{}, errors.New("Required lat and lng")
+	}
+
+	latitude, err := strconv.ParseFloat(values.Get("lat"), 64)
+	if err != nil || math.IsNaN(latitude) || latitude < -90 || latitude > 90 {
+		return NearbyQuery{}, errors.New("Invalid latitude, expected a number between -90 and 90")
+	}
+
+	longitude, err := strconv.ParseFloat(values.Get("lng"), 64)
+	if err != nil || math.IsNaN(longitude) || longitude < -180 || longitude > 180 {
+		return NearbyQuery{}, errors.New("Invalid longitude, expected a number between -180 and 180")
+	}
+	query.Location = CurrentLocation{Latitude: latitude, Longitude: longitude}
+
+	if raw := values.Get("radius"); raw != "" {
+		radius, err := strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(radius) || radius <= 0 || radius > MaxNearbyRadius {
+			return NearbyQuery{}, fmt.Errorf("Invalid radius, expected a number of kilometres up to %g", MaxNearbyRadius)
+		}
+		query.Radius = radius
+	}
+
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > MaxListLimit {
+			return NearbyQuery{}, fmt.Errorf("Invalid limit, expected a number between 1 and %d", MaxListLimit)
+		}
+		query.Limit = limit
+	}
+
+	return query, nil
+}
+
+// withDefaults -> fills what a query built in code left out
+func (query NearbyQuery) withDefaults() NearbyQuery {
+	if query.Radius <= 0 || query.Radius > MaxNearbyRadius {
+		query.Radius = DefaultNearbyRadius
+	}
+
+	if query.Limit <= 0 || query.Limit > MaxListLimit {
+		query.Limit = DefaultListLimit
+	}
+	return query
+}
+
+// Distance -> great-circle distance in kilometres between two locations, with the haversine formula
+func Distance(from, to CurrentLocation) float64 {
+
+	fromLatitude, toLatitude := radians(from.Latitude), radians(to.Latitude)
+	latitudes := math.Sin((toLatitude - fromLatitude) / 2)
+	longitudes := math.Sin(radians(to.Longitude-from.Longitude) / 2)
+
+	haversine := latitudes*latitudes + math.Cos(fromLatitude)*math.Cos(toLatitude)*longitudes*longitudes
+	return 2 * EarthRadius * math.Asin(math.Min(1, math.Sqrt(haversine)))
+}
+
+func radians(degrees float64) float64 {
+	return degrees * math.Pi / 180
+}
+
+func degrees(radians float64) float64 {
+	return radians * 180 / math.Pi
+}
+
+// boundingBox -> the smallest latitude and longitude ranges holding every point within a radius. Cheap to check and
+// indexable, it only narrows the shops down, Distance decides. MinLongitude is greater than MaxLongitude when the box
+// crosses the antimeridian
+type boundingBox struct {
+	MinLatitude  float64
+	MaxLatitude  float64
+	MinLongitude float64
+	MaxLongitude float64
+}
+
+// box -> the bounding box of the query's circle
+func (query NearbyQuery) box() boundingBox {
+
+	query = query.withDefaults()
+	angle := query.Radius / EarthRadius
+	box := boundingBox{
+		MinLatitude:  query.Location.Latitude - degrees(angle),
+		MaxLatitude:  query.Location.Latitude + degrees(angle),
+		MinLongitude: -180,
+		MaxLongitude: 180,
+	}
+
+	// Around a pole the circle takes in every longitude
+	spread := math.Sin(angle) / math.Cos(radians(query.Location.Latitude))
+	if box.MinLatitude <= -90 || box.MaxLatitude >= 90 || spread >= 1 {
+		box.MinLatitude = math.Max(box.MinLatitude, -90)
+		box.MaxLatitude = math.Min(box.MaxLatitude, 90)
+		return box
+	}
+
+	box.MinLongitude = query.Location.Longitude - degrees(math.Asin(spread))
+	box.MaxLongitude = query.Location.Longitude + degrees(math.Asin(spread))
+	if box.MinLongitude < -180 {
+		box.MinLongitude += 360
+	}
+	if box.MaxLongitude > 180 {
+		box.MaxLongitude -= 360
+	}
+
+	return box
+}
+
+// contains -> the location is in the box
+func (box boundingBox) contains(location CurrentLocation) bool {
+
+	if location.Latitude < box.MinLatitude || location.Latitude > box.MaxLatitude {
+		return false
+	}
+
+	if box.MinLongitude > box.MaxLongitude {
+		return location.Longitude >= box.MinLongitude || location.Longitude <= box.MaxLongitude
+	}
+	return location.Longitude >= box.MinLongitude && location.Longitude <= box.MaxLongitude
+}
+
+// scope -> what contains checks, as conditions of a gorm query on the latitude and longitude columns
+func (box boundingBox) scope(db *gorm.DB) *gorm.DB {
+
+	db = db.Where("latitude BETWEEN ? AND ?", box.MinLatitude, box.MaxLatitude)
+	if box.MinLongitude > box.MaxLongitude {
+		return db.Where("longitude >= ? OR longitude <= ?", box.MinLongitude, box.MaxLongitude)
+	}
+	return db.Where("longitude BETWEEN ? AND ?", box.MinLongitude, box.MaxLongitude)
+}
+
+// nearest -> the shops within the radius, nearest first and at most Limit of them
+func (query NearbyQuery) nearest(shops []Shop) []NearbyShop {
+
+	query = query.withDefaults()
+	nearby := []NearbyShop{}
+	for _, shop := range shops {
+		distance := Distance(query.Location, CurrentLocation{Latitude: shop.Latitude, Longitude: shop.Longitude})
+		if distance <= query.Radius {
+			nearby = append(nearby, NearbyShop{Shop: shop, Distance: distance})
+		}
+	}
+
+	// Shops as far away are kept in the same order on every call
+	sort.SliceStable(nearby, func(a, b int) bool {
+		if nearby[a].Distance != nearby[b].Distance {
+			return nearby[a].Distance < nearby[b].Distance
+		}
+		return bytes.Compare(nearby[a].ID.Bytes(), nearby[b].ID.Bytes()) < 0
+	})
+
+	if len(nearby) > query.Limit {
+		nearby = nearby[:query.Limit]
+	}
+	return nearby
+}
@@ -0,0 +1,374 @@
+package models
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// Kinds of list fields, they decide how query string values are parsed and how records compare
+const (
+	FieldString = "string"
+	FieldNumber = "number"
+	FieldBool   = "bool"
+	FieldTime   = "time"
+	FieldUUID   = "uuid"
+)
+
+// DefaultListLimit and MaxListLimit -> page sizes when the client doesn't ask for one, and the most it can ask for
+const (
+	DefaultListLimit = 20
+	MaxListLimit     = 100
+)
+
+// ErrInvalidCursor -> returned when the cursor wasn't handed out for this list and sort
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+// ListField -> a field lists can be sorted or filtered on, Field is the name in the Go struct
+type ListField struct {
+	Column string
+	Field  string
+	Kind   string
+	Sort   bool
+	Filter bool
+}
+
+// ListSpec -> the fields of a model clients may sort and filter on, keyed by their JSON name
+type ListSpec struct {
+	Fields      map[string]ListField
+	DefaultSort string
+}
+
+// ListQuery -> one page of a list: how many records, in which order, filtered on what and where the previous page ended
+type ListQuery struct {
+	Limit      int
+	Sort       string
+	Descending bool
+	Filters    map[string]interface{}
+	after      *listCursor
+}
+
+// listCursor -> what an opaque cursor carries, the sort it was made for and the last record of the page
+type listCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    string `json:"id"`
+}
+
+// ParseListQuery -> reads limit, sort, cursor and the filters of the spec from a query string. sort takes a field name,
+// prefixed with - for descending order. Parameters the spec doesn't know are ignored
+func ParseListQuery(values url.Values, spec ListSpec) (ListQuery, error) {
+
+	query := ListQuery{Limit: DefaultListLimit, Sort: spec.DefaultSort, Filters: map[string]interface{}{}}
+
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > MaxListLimit {
+			return ListQuery{}, fmt.Errorf("Invalid limit, expected a number between 1 and %d", MaxListLimit)
+		}
+		query.Limit = limit
+	}
+
+	if raw := values.Get("sort"); raw != "" {
+		query.Descending = strings.HasPrefix(raw, "-")
+		query.Sort = strings.TrimPrefix(raw, "-")
+
+		field, ok := spec.Fields[query.Sort]
+		if !ok || !field.Sort {
+			return ListQuery{}, fmt.Errorf("Invalid sort field %s", query.Sort)
+		}
+	}
+
+	for name, field := range spec.Fields {
+		raw, ok := values[name]
+		if !ok || !field.Filter {
+			continue
+		}
+
+		value, err := parseListValue(field.Kind, raw[0])
+		if err != nil {
+			return ListQuery{}, fmt.Errorf("Invalid value for %s", name)
+		}
+		query.Filters[name] = value
+	}
+
+	if raw := values.Get("cursor"); raw != "" {
+		cursor, err := decodeCursor(raw)
+		if err != nil || cursor.Sort != query.sortKey() {
+			return ListQuery{}, ErrInvalidCursor
+		}
+
+		_, err = parseListValue(spec.Fields[query.Sort].Kind, cursor.Value)
+		if err != nil {
+			return ListQuery{}, ErrInvalidCursor
+		}
+
+		_, err = uuid.FromString(cursor.ID)
+		if err != nil {
+			return ListQuery{}, ErrInvalidCursor
+		}
+		query.after = &cursor
+	}
+
+	return query, nil
+}
+
+// sortKey -> the sort as written in the query string, cursors are only valid for the sort they were made with
+func (query ListQuery) sortKey() string {
+	if query.Descending {
+		return "-" + query.Sort
+	}
+	return query.Sort
+}
+
+// withDefaults -> fills what a query built in code left out
+func (query ListQuery) withDefaults(spec ListSpec) ListQuery {
+	if query.Limit <= 0 || query.Limit > MaxListLimit {
+		query.Limit = DefaultListLimit
+	}
+
+	if query.Sort == "" {
+		query.Sort = spec.DefaultSort
+	}
+	return query
+}
+
+func decodeCursor(raw string) (listCursor, error) {
+
+	cursor := listCursor{}
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return cursor, err
+	}
+
+	err = json.Unmarshal(data, &cursor)
+	return cursor, err
+}
+
+func encodeCursor(cursor listCursor) string {
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// parseListValue -> a query string or cursor value as the Go type records are compared with
+func parseListValue(kind, raw string) (interface{}, error) {
+	switch kind {
+	case FieldNumber:
+		return strconv.ParseFloat(raw, 64)
+	case FieldBool:
+		return strconv.ParseBool(raw)
+	case FieldTime:
+		return time.Parse(time.RFC3339Nano, raw)
+	case FieldUUID:
+		return uuid.FromString(raw)
+	default:
+		return raw, nil
+	}
+}
+
+// formatListValue -> the reverse of parseListValue, for cursors
+func formatListValue(value interface{}) string {
+	switch value := value.(type) {
+	case float64:
+		return strconv.FormatFloat(value, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(value)
+	case time.Time:
+		return value.Format(time.RFC3339Nano)
+	case uuid.UUID:
+		return value.String()
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// recordValue -> the field of a record as the Go type parseListValue gives
+func recordValue(record reflect.Value, field ListField) interface{} {
+
+	value := record.FieldByName(field.Field)
+	switch value.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(value.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(value.Uint())
+	case reflect.Float32:
+		// Through its shortest decimal form, like the database stores it
+		number, _ := strconv.ParseFloat(strconv.FormatFloat(value.Float(), 'g', -1, 32), 64)
+		return number
+	case reflect.Float64:
+		return value.Float()
+	case reflect.Bool:
+		return value.Bool()
+	case reflect.String:
+		return value.String()
+	default:
+		return value.Interface()
+	}
+}
+
+// compareListValues -> -1, 0 or 1 like strings.Compare, for two values of the same kind
+func compareListValues(a, b interface{}) int {
+	switch a := a.(type) {
+	case float64:
+		b := b.(float64)
+		if a < b {
+			return -1
+		} else if a > b {
+			return 1
+		}
+		return 0
+	case bool:
+		b := b.(bool)
+		if a == b {
+			return 0
+		} else if b {
+			return -1
+		}
+		return 1
+	case time.Time:
+		b := b.(time.Time)
+		if a.Before(b) {
+			return -1
+		} else if a.After(b) {
+			return 1
+		}
+		return 0
+	case uuid.UUID:
+		return bytes.Compare(a.Bytes(), b.(uuid.UUID).Bytes())
+	default:
+		return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+	}
+}
+
+// column -> the column of the field, strings compared byte by byte so the database pages like the memory store
+func (field ListField) column() string {
+	if field.Kind == FieldString {
+		return field.Column + ` COLLATE "C"`
+	}
+	return field.Column
+}
+
+// scope -> the filters, order, cursor and limit of the query on a gorm query. One more record than the limit is
+// fetched to know whether there's a next page
+func (query ListQuery) scope(db *gorm.DB, spec ListSpec) *gorm.DB {
+
+	query = query.withDefaults(spec)
+
+	for name, value := range query.Filters {
+		db = db.Where(spec.Fields[name].Column+" = ?", value)
+	}
+
+	field := spec.Fields[query.Sort]
+	operator, direction := ">", "asc"
+	if query.Descending {
+		operator, direction = "<", "desc"
+	}
+
+	if query.after != nil {
+		value, _ := parseListValue(field.Kind, query.after.Value)
+		db = db.Where(
+			fmt.Sprintf("(%s %s ?) OR (%s = ? AND id %s ?)", field.column(), operator, field.column(), operator),
+			value, value, query.after.ID,
+		)
+	}
+
+	return db.Order(field.column() + " " + direction).Order("id " + direction).Limit(query.Limit + 1)
+}
+
+// nextPage -> how many of the records fetched by scope belong on the page, and the cursor to the next one
+// when there's more
+func (query ListQuery) nextPage(records reflect.Value, spec ListSpec) (int, string) {
+
+	query = query.withDefaults(spec)
+	if records.Len() <= query.Limit {
+		return records.Len(), ""
+	}
+
+	last := records.Index(query.Limit - 1)
+	return query.Limit, encodeCursor(listCursor{
+		Sort:  query.sortKey(),
+		Value: formatListValue(recordValue(last, spec.Fields[query.Sort])),
+		ID:    recordID(last).String(),
+	})
+}
+
+// page -> what scope and nextPage do, for records held in memory. Returns the indexes of the page's records in order
+func (query ListQuery) page(records reflect.Value, spec ListSpec, live func(i int) bool) ([]int, string) {
+
+	query = query.withDefaults(spec)
+	field := spec.Fields[query.Sort]
+
+	var after interface{}
+	if query.after != nil {
+		after, _ = parseListValue(field.Kind, query.after.Value)
+	}
+
+	// compare -> the order of two records, the ID breaking ties like in scope
+	compare := func(a, b interface{}, aID, bID uuid.UUID) int {
+		order := compareListValues(a, b)
+		if order == 0 {
+			order = bytes.Compare(aID.Bytes(), bID.Bytes())
+		}
+		if query.Descending {
+			return -order
+		}
+		return order
+	}
+
+	indexes := []int{}
+	for i := 0; i < records.Len(); i++ {
+		if !live(i) || !query.matches(records.Index(i), spec) {
+			continue
+		}
+
+		if query.after != nil {
+			afterID, _ := uuid.FromString(query.after.ID)
+			if compare(recordValue(records.Index(i), field), after, recordID(records.Index(i)), afterID) <= 0 {
+				continue
+			}
+		}
+		indexes = append(indexes, i)
+	}
+
+	sort.SliceStable(indexes, func(a, b int) bool {
+		recordA, recordB := records.Index(indexes[a]), records.Index(indexes[b])
+		return compare(recordValue(recordA, field), recordValue(recordB, field), recordID(recordA), recordID(recordB)) < 0
+	})
+
+	if len(indexes) > query.Limit+1 {
+		indexes = indexes[:query.Limit+1]
+	}
+
+	page := reflect.MakeSlice(records.Type(), len(indexes), len(indexes))
+	for i, index := range indexes {
+		page.Index(i).Set(records.Index(index))
+	}
+
+	count, next := query.nextPage(page, spec)
+	return indexes[:count], next
+}
+
+// matches -> the record passes every filter of the query
+func (query ListQuery) matches(record reflect.Value, spec ListSpec) bool {
+	for name, value := range query.Filters {
+		if compareListValues(recordValue(record, spec.Fields[name]), value) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func recordID(record reflect.Value) uuid.UUID {
+	return record.FieldByName("ID").Interface().(uuid.UUID)
+}
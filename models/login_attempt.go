@@ -0,0 +1,55 @@
+package models
+
+import (
+	"time"
+
+	"github.com/amaraliou/stakeout/auth"
+	"github.com/jinzhu/gorm"
+)
+
+// LoginAttempt -> Struct to hold the failed logins of an account or a client IP
+type LoginAttempt struct {
+	Key         string    `gorm:"primary_key"`
+	Count       int       `gorm:"not null"`
+	LastFailure time.Time `gorm:"not null"`
+}
+
+// AttemptStore -> auth.AttemptStore backed by the login_attempts table, shared by every instance of the API
+type AttemptStore struct {
+	DB *gorm.DB
+}
+
+// Attempts -> the key's counter, zero when it has none
+func (store AttemptStore) Attempts(key string) (auth.Attempts, error) {
+
+	attempt := LoginAttempt{}
+	err := store.DB.Model(&LoginAttempt{}).Where("key = ?", key).Take(&attempt).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return auth.Attempts{}, nil
+	}
+
+	if err != nil {
+		return auth.Attempts{}, err
+	}
+
+	return auth.Attempts{Count: attempt.Count, LastFailure: attempt.LastFailure}, nil
+}
+
+// RecordFailure -> adds a failure to the key's counter in one statement, so concurrent failures are all counted
+func (store AttemptStore) RecordFailure(key string, at time.Time) (auth.Attempts, error) {
+
+	attempt := LoginAttempt{}
+	err := store.DB.Raw(`INSERT INTO login_attempts (key, count, last_failure) VALUES (?, 1, ?)
+		ON CONFLICT (key) DO UPDATE SET count = login_attempts.count + 1, last_failure = EXCLUDED.last_failure
+		RETURNING key, count, last_failure`, key, at).Scan(&attempt).Error
+	if err != nil {
+		return auth.Attempts{}, err
+	}
+
+	return auth.Attempts{Count: attempt.Count, LastFailure: attempt.LastFailure}, nil
+}
+
+// Reset -> forgets the key's counter
+func (store AttemptStore) Reset(key string) error {
+	return store.DB.Where("key = ?", key).Delete(&LoginAttempt{}).Error
+}
@@ -2,7 +2,9 @@ package models
 
 import (
 	"errors"
+	"reflect"
 	"strings"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	uuid "github.com/satori/go.uuid"
@@ -31,13 +33,19 @@ var statusScope = []uint8{
 // Order -> Struct to hold information about a specific order from a customer
 type Order struct {
 	Base
-	UserID      uuid.UUID `json:"-" gorm:"user_id"`
-	OrderedBy   Student   `json:"ordered_by" gorm:"foreignkey:UserID"`
-	ShopID      uuid.UUID `json:"shop_id" gorm:"shop_id"`
-	OrderedFrom Shop      `json:"ordered_from" gorm:"foreignkey:ShopID"`
-	OrderItems  []Product `json:"ordered_items" gorm:"many2many:order_products;"`
-	OrderTotal  float32   `json:"total_price"`
-	Status      uint8     `json:"status"`
+	UserID      uuid.UUID   `json:"-" gorm:"user_id"`
+	OrderedBy   Student     `json:"ordered_by" gorm:"foreignkey:UserID"`
+	ShopID      uuid.UUID   `json:"shop_id" gorm:"shop_id"`
+	OrderedFrom Shop        `json:"ordered_from" gorm:"foreignkey:ShopID"`
+	OrderItems  []OrderLine `json:"ordered_items" gorm:"foreignkey:OrderID"`
+	OrderTotal  float32     `json:"total_price"` // Computed from the order lines, never taken from the client
+	Status      uint8       `json:"status"`
+	// RedeemedPoints are spent at the shop's PointsRate, the resulting PointsDiscount comes off the total
+	RedeemedPoints int     `json:"redeemed_points"`
+	PointsDiscount float32 `json:"points_discount"`
+	// UpdatedBy and StatusUpdatedAt record who made the last status transition and when
+	UpdatedBy       uuid.UUID  `json:"updated_by" gorm:"updated_by"`
+	StatusUpdatedAt *time.Time `json:"status_updated_at"`
 }
 
 // Validate ...
@@ -56,8 +64,22 @@ func (order *Order) Validate(action string) error {
 			return errors.New("Required order items")
 		}
 
+		if order.RedeemedPoints < 0 {
+			return errors.New("Invalid redeemed points")
+		}
+
+		for _, line := range order.OrderItems {
+			if line.ProductID.String() == "00000000-0000-0000-0000-000000000000" {
+				return errors.New("Required product")
+			}
+
+			if line.Quantity <= 0 {
+				return errors.New("Invalid quantity")
+			}
+		}
+
 	case "updatestatus":
-		if order.Status < 0 {
+		if !IsValidOrderStatus(order.Status) {
 			return errors.New("Invalid status")
 		}
 
@@ -68,58 +90,36 @@ func (order *Order) Validate(action string) error {
 	return nil
 }
 
-// FindAllOrders ...
-func (order *Order) FindAllOrders(db *gorm.DB) (*[]Order, error) {
-
-	orders := []Order{}
-	err := db.Debug().Model(&Order{}).Limit(100).Find(&orders).Error
-	if err != nil {
-		return &[]Order{}, err
-	}
-
-	return &orders, err
-}
-
-// FindAllOrdersByShop ...
-func (order *Order) FindAllOrdersByShop(db *gorm.DB, shopID string) (*[]Order, error) {
-
-	orders := []Order{}
-	shop := Shop{}
-	_, err := shop.FindShopByID(db, shopID)
-	if err != nil {
-		return &[]Order{}, err
-	}
-
-	err = db.Debug().Model(&Order{}).Where("shop_id = ?", shopID).Find(&orders).Error
-	if err != nil {
-		return &[]Order{}, err
-	}
-
-	return &orders, err
+// OrderList -> what order lists can be sorted and filtered on
+var OrderList = ListSpec{
+	Fields: map[string]ListField{
+		"created_at":  {Column: "created_at", Field: "CreatedAt", Kind: FieldTime, Sort: true},
+		"total_price": {Column: "order_total", Field: "OrderTotal", Kind: FieldNumber, Sort: true},
+		"status":      {Column: "status", Field: "Status", Kind: FieldNumber, Sort: true, Filter: true},
+		"shop_id":     {Column: "shop_id", Field: "ShopID", Kind: FieldUUID, Filter: true},
+		"student_id":  {Column: "user_id", Field: "UserID", Kind: FieldUUID, Filter: true},
+	},
+	DefaultSort: "created_at",
 }
 
-// FindAllOrdersByStudent ...
-func (order *Order) FindAllOrdersByStudent(db *gorm.DB, studentID string) (*[]Order, error) {
+// ListOrders -> a page of orders with their lines and the cursor to the next one, empty on the last page
+func (order *Order) ListOrders(db *gorm.DB, query ListQuery) (*[]Order, string, error) {
 
 	orders := []Order{}
-	student := Student{}
-	_, err := student.FindStudentByID(db, studentID)
-	if err != nil {
-		return &[]Order{}, err
-	}
-
-	err = db.Debug().Model(&Order{}).Where("user_id = ?", studentID).Find(&orders).Error
+	err := query.scope(db.Debug().Model(&Order{}).Preload("OrderItems"), OrderList).Find(&orders).Error
 	if err != nil {
-		return &[]Order{}, err
+		return &[]Order{}, "", err
 	}
 
-	return &orders, err
+	count, next := query.nextPage(reflect.ValueOf(orders), OrderList)
+	orders = orders[:count]
+	return &orders, next, nil
 }
 
 // FindOrderByID ...
 func (order *Order) FindOrderByID(db *gorm.DB, id string) (*Order, error) {
 
-	err := db.Debug().Model(Order{}).Where("id = ?", id).Take(&order).Error
+	err := db.Debug().Model(Order{}).Preload("OrderItems").Where("id = ?", id).Take(&order).Error
 	if gorm.IsRecordNotFoundError(err) {
 		return &Order{}, errors.New("Order not found")
 	}
@@ -149,7 +149,7 @@ func (order *Order) FindOrderByID(db *gorm.DB, id string) (*Order, error) {
 	return order, nil
 }
 
-// CreateOrder ...
+// CreateOrder -> Function to create a pending order, the total is computed from the current product prices
 func (order *Order) CreateOrder(db *gorm.DB) (*Order, error) {
 
 	student := &Student{}
@@ -164,7 +164,40 @@ func (order *Order) CreateOrder(db *gorm.DB) (*Order, error) {
 		return &Order{}, errors.New("Shop doesn't exist, can't create order")
 	}
 
-	err = db.Debug().Create(&order).Error
+	// Orders start pending whatever the client sent, only UpdateOrder moves them on
+	order.Status = OrderPending
+	order.UpdatedBy = uuid.Nil
+	order.StatusUpdatedAt = nil
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		total, err := priceOrderLines(tx, order.ShopID, order.OrderItems)
+		if err != nil {
+			return err
+		}
+
+		order.PointsDiscount = 0
+		if order.RedeemedPoints > 0 {
+			order.PointsDiscount, err = pointsDiscount(tx, order.UserID, shop, order.RedeemedPoints, total)
+			if err != nil {
+				return err
+			}
+		}
+		order.OrderTotal = roundPrice(float64(total) - float64(order.PointsDiscount))
+
+		err = tx.Debug().Create(&order).Error
+		if err != nil {
+			return err
+		}
+
+		if order.RedeemedPoints > 0 {
+			err = addPointsEntry(tx, order.UserID, order.ID, -order.RedeemedPoints, PointsRedeemed)
+			if err != nil {
+				return err
+			}
+		}
+
+		return recordOrderEvent(tx, order.ID, OrderEventCreated, order.Status, order.Status, order.UserID)
+	})
 	if err != nil {
 		return &Order{}, err
 	}
@@ -175,40 +208,76 @@ func (order *Order) CreateOrder(db *gorm.DB) (*Order, error) {
 	return order, nil
 }
 
-// UpdateOrder ...
+// UpdateOrder -> moves the order to order.Status, rejecting illegal transitions with an *InvalidTransitionError
 func (order *Order) UpdateOrder(db *gorm.DB, id string) (*Order, error) {
 
-	err := db.Debug().Model(Order{}).Updates(&order).Error
+	current := &Order{}
+	err := db.Debug().Model(Order{}).Where("id = ?", id).Take(&current).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &Order{}, errors.New("Order not found")
+	}
+
 	if err != nil {
 		return &Order{}, err
 	}
 
-	/* student := &Student{}
-	err = db.Debug().Model(Student{}).Where("id = ?", order.UserID.String()).Take(&student).Error
+	err = CheckTransition(current.Status, order.Status)
 	if err != nil {
-		return &Order{}, errors.New("Student doesn't exist, can't create order")
+		return &Order{}, err
 	}
 
-	shop := &Shop{}
-	err = db.Debug().Model(Shop{}).Where("id = ?", order.ShopID.String()).Take(&shop).Error
+	err = db.Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		update := tx.Debug().Model(&Order{}).Where("id = ? AND status = ?", id, current.Status).Updates(map[string]interface{}{
+			"status":            order.Status,
+			"updated_by":        order.UpdatedBy,
+			"status_updated_at": now,
+		})
+		if update.Error != nil {
+			return update.Error
+		}
+
+		// Someone else moved the order between our read and our write
+		if update.RowsAffected == 0 {
+			return ErrOrderStatusChanged
+		}
+
+		err := recordOrderEvent(tx, current.ID, OrderEventStatusChanged, current.Status, order.Status, order.UpdatedBy)
+		if err != nil {
+			return err
+		}
+
+		return applyOrderPoints(tx, current, order.Status)
+	})
 	if err != nil {
-		return &Order{}, errors.New("Shop doesn't exist, can't create order")
+		return &Order{}, err
 	}
 
-	order.OrderedBy = *student
-	order.OrderedFrom = *shop */
-	// More to cover
-
-	return order, nil
+	return order.FindOrderByID(db, id)
 }
 
-// DeleteOrder ...
+// DeleteOrder -> Function to delete an order, order.UpdatedBy is recorded as the actor in the audit trail
 func (order *Order) DeleteOrder(db *gorm.DB, id string) (int64, error) {
 
-	db = db.Debug().Model(&Order{}).Where("id = ?", id).Take(&Order{}).Delete(&Order{})
-	if db.Error != nil {
-		return 0, db.Error
+	var rowsAffected int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		current := &Order{}
+		err := tx.Debug().Model(&Order{}).Where("id = ?", id).Take(&current).Error
+		if err != nil {
+			return err
+		}
+
+		deletion := tx.Debug().Delete(current)
+		if deletion.Error != nil {
+			return deletion.Error
+		}
+		rowsAffected = deletion.RowsAffected
+
+		return recordOrderEvent(tx, current.ID, OrderEventDeleted, current.Status, current.Status, order.UpdatedBy)
+	})
+	if err != nil {
+		return 0, err
 	}
 
-	return db.RowsAffected, nil
+	return rowsAffected, nil
 }
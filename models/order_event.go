@@ -0,0 +1,48 @@
+package models
+
+import (
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	OrderEventCreated       = "created"
+	OrderEventStatusChanged = "status_changed"
+	OrderEventDeleted       = "deleted"
+)
+
+// OrderEvent -> Struct to hold one entry of an order's audit trail
+type OrderEvent struct {
+	Base
+	OrderID    uuid.UUID `json:"order_id" gorm:"order_id;index"`
+	Action     string    `json:"action"`
+	FromStatus uint8     `json:"from_status"`
+	ToStatus   uint8     `json:"to_status"`
+	ActorID    uuid.UUID `json:"actor_id" gorm:"actor_id"`
+}
+
+// recordOrderEvent -> appends an event to the order's audit trail, meant to be called inside the same transaction as the change
+func recordOrderEvent(db *gorm.DB, orderID uuid.UUID, action string, from, to uint8, actorID uuid.UUID) error {
+
+	event := OrderEvent{
+		OrderID:    orderID,
+		Action:     action,
+		FromStatus: from,
+		ToStatus:   to,
+		ActorID:    actorID,
+	}
+
+	return db.Debug().Create(&event).Error
+}
+
+// FindEventsByOrder -> Function to retrieve the audit trail of an order, oldest first
+func (event *OrderEvent) FindEventsByOrder(db *gorm.DB, orderID string) (*[]OrderEvent, error) {
+
+	events := []OrderEvent{}
+	err := db.Debug().Model(&OrderEvent{}).Where("order_id = ?", orderID).Order("created_at asc").Find(&events).Error
+	if err != nil {
+		return &[]OrderEvent{}, err
+	}
+
+	return &events, nil
+}
@@ -0,0 +1,67 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// OrderLine -> Struct to hold one line of an order, prices are snapshotted when the order is created
+type OrderLine struct {
+	Base
+	OrderID     uuid.UUID `json:"-" gorm:"order_id;index"`
+	ProductID   uuid.UUID `json:"product_id" gorm:"product_id"`
+	ProductName string    `json:"product_name"`
+	Quantity    int       `json:"quantity"`
+	UnitPrice   float32   `json:"unit_price"`
+	Discount    float32   `json:"discount"` // Discount applied to each unit
+	LineTotal   float32   `json:"line_total"`
+	Reward      int       `json:"reward"` // Loyalty points earned for each unit
+}
+
+// roundPrice -> rounds a price to the nearest minor currency unit
+func roundPrice(price float64) float32 {
+	return float32(math.Round(price*100) / 100)
+}
+
+// price -> fills the snapshot fields of the line from the given product
+func (line *OrderLine) price(product *Product) {
+	unitPrice := product.DiscountedPrice()
+
+	line.ProductName = product.Name
+	line.UnitPrice = product.Price
+	line.Discount = roundPrice(float64(product.Price) - float64(unitPrice))
+	line.LineTotal = roundPrice(float64(unitPrice) * float64(line.Quantity))
+	line.Reward = product.Reward
+}
+
+// priceOrderLines -> snapshots the current price of every line's product and returns the order total,
+// products have to be sold by the given shop
+func priceOrderLines(db *gorm.DB, shopID uuid.UUID, lines []OrderLine) (float32, error) {
+
+	var total float64
+	for i := range lines {
+		line := &lines[i]
+		if line.Quantity <= 0 {
+			return 0, errors.New("Invalid quantity")
+		}
+
+		product := &Product{}
+		err := db.Debug().Model(Product{}).Where("id = ? AND shop_id = ?", line.ProductID.String(), shopID.String()).Take(&product).Error
+		if gorm.IsRecordNotFoundError(err) {
+			return 0, fmt.Errorf("Product %s not found in this shop", line.ProductID.String())
+		}
+
+		if err != nil {
+			return 0, err
+		}
+
+		line.price(product)
+		total += float64(line.LineTotal)
+	}
+
+	return roundPrice(total), nil
+}
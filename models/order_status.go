@@ -0,0 +1,77 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrOrderStatusChanged -> returned when the order status changed while a transition was being applied
+var ErrOrderStatusChanged = errors.New("Order status changed while updating, try again")
+
+// orderTransitions -> legal order status transitions, keyed by the current status
+var orderTransitions = map[uint8][]uint8{
+	OrderPending:   {OrderPayed, OrderCancel},
+	OrderPayed:     {OrderReceived, OrderRefunding},
+	OrderReceived:  {OrderConfirmed, OrderRefunding},
+	OrderConfirmed: {OrderRefunding},
+	OrderRefunding: {OrderRefunded},
+	OrderRefunded:  {},
+	OrderCancel:    {},
+}
+
+var orderStatusNames = map[uint8]string{
+	OrderPending:   "pending",
+	OrderPayed:     "payed",
+	OrderReceived:  "received",
+	OrderConfirmed: "confirmed",
+	OrderRefunding: "refunding",
+	OrderRefunded:  "refunded",
+	OrderCancel:    "cancel",
+}
+
+// InvalidTransitionError -> returned when an order is moved to a status it can't reach from its current one
+type InvalidTransitionError struct {
+	From uint8
+	To   uint8
+}
+
+func (err *InvalidTransitionError) Error() string {
+	return fmt.Sprintf("Invalid status transition from %s to %s", OrderStatusName(err.From), OrderStatusName(err.To))
+}
+
+// IsValidOrderStatus -> checks that the status is one of the known order statuses
+func IsValidOrderStatus(status uint8) bool {
+	for _, s := range statusScope {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// OrderStatusName -> human readable name of an order status
+func OrderStatusName(status uint8) string {
+	name, ok := orderStatusNames[status]
+	if !ok {
+		return fmt.Sprintf("unknown(%d)", status)
+	}
+	return name
+}
+
+// CanTransition -> checks if an order can move from one status to another
+func CanTransition(from, to uint8) bool {
+	for _, next := range orderTransitions[from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckTransition -> same as CanTransition but returns an *InvalidTransitionError when the move is illegal
+func CheckTransition(from, to uint8) error {
+	if !CanTransition(from, to) {
+		return &InvalidTransitionError{From: from, To: to}
+	}
+	return nil
+}
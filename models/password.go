@@ -0,0 +1,128 @@
+package models
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	// ErrWrongPassword -> the current password given to change it doesn't match
+	ErrWrongPassword = errors.New("Current password is incorrect")
+	// ErrInvalidCredentials -> the one answer to a login with an unknown email or a wrong password
+	ErrInvalidCredentials = errors.New("Invalid credentials")
+	// ErrPasswordResetRequired -> the stored password can't be trusted, the account has to go through a password reset
+	ErrPasswordResetRequired = errors.New("Password reset required, use the forgotten password link")
+	// ErrEmailNotFound -> no student or admin, depending on the lookup, has the email
+	ErrEmailNotFound = errors.New("Email not found")
+)
+
+// isPasswordHash -> checks the value is a bcrypt hash
+func isPasswordHash(password string) bool {
+	_, err := bcrypt.Cost([]byte(password))
+	return err == nil
+}
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// CheckCredentials -> compares the password with the stored hash, an empty hash (unknown email) is compared with a dummy one
+// so unknown emails take as long as wrong passwords. Any failure is ErrInvalidCredentials
+func CheckCredentials(storedPassword, password string) error {
+
+	if storedPassword == "" {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = Hash("dummy password of unknown emails")
+		})
+		VerifyPassword(string(dummyHash), password)
+		return ErrInvalidCredentials
+	}
+
+	if VerifyPassword(storedPassword, password) != nil {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// hashPassword -> hashes the password to store. Only an empty password and the hash read from the database are kept as
+// they are, anything else came from a client and is hashed even when it looks like a hash
+func (user *User) hashPassword() error {
+	if user.Password == "" || user.Password == user.storedPassword {
+		return nil
+	}
+
+	hashedPassword, err := Hash(user.Password)
+	if err != nil {
+		return err
+	}
+	user.Password = string(hashedPassword)
+	user.storedPassword = user.Password
+	return nil
+}
+
+// changePassword -> checks the current password against the stored hash, then stores the new one
+func changePassword(db *gorm.DB, account interface{}, id, storedPassword, currentPassword, newPassword string) error {
+
+	err := VerifyPassword(storedPassword, currentPassword)
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return ErrWrongPassword
+	}
+
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := Hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	// UpdateColumns skips BeforeSave, the password is already hashed
+	return db.Debug().Model(account).Where("id = ?", id).UpdateColumns(map[string]interface{}{"password": string(hashedPassword), "password_reset_required": false}).Error
+}
+
+// unusablePassword -> checks a stored password is one profile updates used to write: the hash of an empty password, or no hash at all
+func unusablePassword(storedPassword string) bool {
+	if !isPasswordHash(storedPassword) {
+		return true
+	}
+
+	return VerifyPassword(storedPassword, "") == nil
+}
+
+// FlagUnusablePasswords -> Function to find the students and admins whose stored password was overwritten by a profile update
+// and make them reset it. Passwords hashed twice can't be told apart from good ones, their owners have to use the forgotten password link.
+// Returns how many accounts were flagged
+func FlagUnusablePasswords(db *gorm.DB) (int, error) {
+
+	flagged := 0
+	for _, table := range []interface{}{&Student{}, &Admin{}} {
+		rows := []struct {
+			ID       string
+			Password string
+		}{}
+
+		err := db.Debug().Model(table).Select("id, password").Where("password_reset_required = ?", false).Scan(&rows).Error
+		if err != nil {
+			return flagged, err
+		}
+
+		for _, row := range rows {
+			if !unusablePassword(row.Password) {
+				continue
+			}
+
+			err = db.Debug().Model(table).Where("id = ?", row.ID).UpdateColumn("password_reset_required", true).Error
+			if err != nil {
+				return flagged, err
+			}
+			flagged++
+		}
+	}
+
+	return flagged, nil
+}
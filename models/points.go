@@ -0,0 +1,177 @@
+package models
+
+import (
+	"errors"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+const (
+	PointsOrderConfirmed     = "order_confirmed"
+	PointsOrderRefunded      = "order_refunded"
+	PointsRedeemed           = "redeemed"
+	PointsRedemptionReversed = "redemption_reversed"
+)
+
+var (
+	// ErrRedemptionDisabled -> the shop doesn't accept loyalty points
+	ErrRedemptionDisabled = errors.New("This shop doesn't accept points")
+	// ErrInsufficientPoints -> the student's balance doesn't cover the redeemed points
+	ErrInsufficientPoints = errors.New("Not enough points")
+	// ErrRedemptionExceedsTotal -> the redeemed points are worth more than the order
+	ErrRedemptionExceedsTotal = errors.New("Redeemed points exceed the order total")
+)
+
+// PointsEntry -> Struct to hold one movement of a student's loyalty points, Student.Points is the sum of these
+type PointsEntry struct {
+	Base
+	StudentID uuid.UUID `json:"-" gorm:"student_id;index"`
+	OrderID   uuid.UUID `json:"order_id" gorm:"order_id;index"`
+	Amount    int       `json:"amount"`
+	Reason    string    `json:"reason"`
+}
+
+// addPointsEntry -> writes a ledger entry and refreshes the student's balance, meant to be called inside a transaction
+func addPointsEntry(db *gorm.DB, studentID, orderID uuid.UUID, amount int, reason string) error {
+
+	entry := PointsEntry{
+		StudentID: studentID,
+		OrderID:   orderID,
+		Amount:    amount,
+		Reason:    reason,
+	}
+
+	err := db.Debug().Create(&entry).Error
+	if err != nil {
+		return err
+	}
+
+	return db.Debug().Exec(
+		"UPDATE students SET points = (SELECT COALESCE(SUM(amount), 0) FROM points_entries WHERE student_id = ? AND deleted_at IS NULL) WHERE id = ?",
+		studentID, studentID,
+	).Error
+}
+
+// orderPoints -> net points already booked for an order with the given reason
+func orderPoints(db *gorm.DB, orderID uuid.UUID, reason string) (int, error) {
+
+	var result struct {
+		Total int
+	}
+
+	err := db.Debug().Model(&PointsEntry{}).Select("COALESCE(SUM(amount), 0) AS total").Where("order_id = ? AND reason = ?", orderID, reason).Scan(&result).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return result.Total, nil
+}
+
+// pointsDiscount -> checks the student can spend the given points at the shop and returns what they are worth.
+// The student row stays locked until the surrounding transaction ends so concurrent orders can't spend the same points
+func pointsDiscount(db *gorm.DB, studentID uuid.UUID, shop *Shop, points int, total float32) (float32, error) {
+
+	if shop.PointsRate <= 0 {
+		return 0, ErrRedemptionDisabled
+	}
+
+	student := &Student{}
+	err := db.Debug().Set("gorm:query_option", "FOR UPDATE").Model(Student{}).Where("id = ?", studentID.String()).Take(&student).Error
+	if err != nil {
+		return 0, err
+	}
+
+	return redemptionDiscount(shop, student.Points, points, total)
+}
+
+// redemptionDiscount -> what the points are worth at the shop, checked against the student's balance and the order total
+func redemptionDiscount(shop *Shop, balance, points int, total float32) (float32, error) {
+
+	if shop.PointsRate <= 0 {
+		return 0, ErrRedemptionDisabled
+	}
+
+	if balance < points {
+		return 0, ErrInsufficientPoints
+	}
+
+	discount := roundPrice(float64(points) * float64(shop.PointsRate))
+	if discount > total {
+		return 0, ErrRedemptionExceedsTotal
+	}
+
+	return discount, nil
+}
+
+// orderReward -> points a confirmed order earns, Reward x Quantity of every line
+func orderReward(lines []OrderLine) int {
+	reward := 0
+	for _, line := range lines {
+		reward += line.Reward * line.Quantity
+	}
+	return reward
+}
+
+// applyOrderPoints -> books the points movements caused by an order reaching the given status:
+// confirmed orders credit Reward x Quantity of every line, refunded orders take back what was credited,
+// and cancelled or refunded orders give back the points redeemed on them
+func applyOrderPoints(db *gorm.DB, order *Order, status uint8) error {
+
+	if status == OrderCancel || status == OrderRefunded {
+		redeemed, err := orderPoints(db, order.ID, PointsRedeemed)
+		if err != nil {
+			return err
+		}
+
+		if redeemed < 0 {
+			err = addPointsEntry(db, order.UserID, order.ID, -redeemed, PointsRedemptionReversed)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	switch status {
+	case OrderConfirmed:
+		lines := []OrderLine{}
+		err := db.Debug().Model(&OrderLine{}).Where("order_id = ?", order.ID).Find(&lines).Error
+		if err != nil {
+			return err
+		}
+
+		reward := orderReward(lines)
+		if reward <= 0 {
+			return nil
+		}
+
+		return addPointsEntry(db, order.UserID, order.ID, reward, PointsOrderConfirmed)
+
+	case OrderRefunded:
+		credited, err := orderPoints(db, order.ID, PointsOrderConfirmed)
+		if err != nil {
+			return err
+		}
+
+		if credited <= 0 {
+			return nil
+		}
+
+		return addPointsEntry(db, order.UserID, order.ID, -credited, PointsOrderRefunded)
+
+	default:
+		return nil
+	}
+}
+
+// FindEntriesByStudent -> Function to retrieve the points ledger of a student, newest first
+func (entry *PointsEntry) FindEntriesByStudent(db *gorm.DB, studentID string) (*[]PointsEntry, error) {
+
+	entries := []PointsEntry{}
+	err := db.Debug().Model(&PointsEntry{}).Where("student_id = ?", studentID).Order("created_at desc").Find(&entries).Error
+	if err != nil {
+		return &[]PointsEntry{}, err
+	}
+
+	return &entries, nil
+}
@@ -2,6 +2,8 @@ package models
 
 import (
 	"errors"
+	"math"
+	"reflect"
 	"strings"
 
 	"github.com/jinzhu/gorm"
@@ -14,16 +16,48 @@ type Product struct {
 	Name          string    `json:"name"`
 	Description   string    `json:"description"`
 	Code          string    `json:"code"`
-	Price         float32   `json:"price"`
+	Price         float32   `json:"price"` // In major units of PriceCurrency (pounds for GBP)
 	PriceCurrency string    `json:"price_currency"`
 	InSale        bool      `json:"is_in_sale"`
-	Discount      int       `json:"discount"`
-	DiscountUnit  string    `json:"discount_unit"`
+	Discount      int       `json:"discount"`      // A percentage of Price, or minor units of PriceCurrency (pence for GBP) for amount discounts
+	DiscountUnit  string    `json:"discount_unit"` // DiscountPercent or DiscountAmount
 	SoldBy        Shop      `json:"sold_by" gorm:"foreignkey:ShopID"`
 	ShopID        uuid.UUID `json:"-" gorm:"shop_id"`
 	Reward        int       `json:"reward"`
 }
 
+// The units a product discount can be given in, see Product.Discount
+const (
+	DiscountPercent = "percent"
+	DiscountAmount  = "amount"
+)
+
+// DiscountedPrice -> unit price of the product once its sale discount is applied.
+// Percent discounts are a percentage of the price, amount discounts are in minor currency units (e.g. pence).
+// A discount in any other unit isn't applied
+func (product *Product) DiscountedPrice() float32 {
+
+	if !product.InSale || product.Discount <= 0 {
+		return product.Price
+	}
+
+	price := float64(product.Price)
+	switch product.DiscountUnit {
+	case DiscountPercent:
+		price = price * float64(100-product.Discount) / 100
+	case DiscountAmount:
+		price = price - float64(product.Discount)/100
+	default:
+		return product.Price
+	}
+
+	if price < 0 {
+		price = 0
+	}
+
+	return roundPrice(price)
+}
+
 // Validate ...
 func (product *Product) Validate(action string) error {
 	switch strings.ToLower(action) {
@@ -40,41 +74,205 @@ func (product *Product) Validate(action string) error {
 			return errors.New("Required shop")
 		}
 
+		return product.validatePricing()
+
+	case "update":
+		return product.validatePricing()
+
 	default:
-		return nil
+		return product.validateDiscount()
+	}
+}
+
+// validatePricing -> the price and the discount of a whole product make sense, orders are priced from them
+func (product *Product) validatePricing() error {
+
+	if product.Price <= 0 {
+		return errors.New("Invalid product price")
+	}
+
+	if product.Discount != 0 && product.DiscountUnit == "" {
+		return errors.New("Required discount unit")
+	}
+
+	err := product.validateDiscount()
+	if err != nil {
+		return err
+	}
+
+	// Amount discounts are in minor units, they can't take more than the price off
+	if product.DiscountUnit == DiscountAmount && float64(product.Discount) > math.Round(float64(product.Price)*100) {
+		return errors.New("Invalid product discount")
+	}
+
+	return nil
+}
+
+// validateDiscount -> the discount unit is one of the known ones and the discount makes sense in it
+func (product *Product) validateDiscount() error {
+
+	if product.DiscountUnit != "" && product.DiscountUnit != DiscountPercent && product.DiscountUnit != DiscountAmount {
+		return errors.New("Invalid discount unit, expected percent or amount")
+	}
+
+	if product.Discount < 0 || product.DiscountUnit == DiscountPercent && product.Discount > 100 {
+		return errors.New("Invalid product discount")
 	}
 
 	return nil
 }
 
-// FindAllProducts ...
-func (product *Product) FindAllProducts(db *gorm.DB) (*[]Product, error) {
+// WithUpdates -> the product once update is written to it like UpdateProduct does, fields left at their zero value in
+// update are kept
+func (product *Product) WithUpdates(update *Product) Product {
+
+	merged := *product
+	setString(&merged.Name, update.Name)
+	setString(&merged.Description, update.Description)
+	setString(&merged.Code, update.Code)
+	setString(&merged.PriceCurrency, update.PriceCurrency)
+	setString(&merged.DiscountUnit, update.DiscountUnit)
+	if update.Price != 0 {
+		merged.Price = update.Price
+	}
+	if update.InSale {
+		merged.InSale = true
+	}
+	if update.Discount != 0 {
+		merged.Discount = update.Discount
+	}
+	if update.Reward != 0 {
+		merged.Reward = update.Reward
+	}
+	if update.ShopID != uuid.Nil {
+		merged.ShopID = update.ShopID
+	}
+
+	return merged
+}
+
+// ProductList -> what product lists can be sorted and filtered on
+var ProductList = ListSpec{
+	Fields: map[string]ListField{
+		"created_at":     {Column: "created_at", Field: "CreatedAt", Kind: FieldTime, Sort: true},
+		"name":           {Column: "name", Field: "Name", Kind: FieldString, Sort: true, Filter: true},
+		"price":          {Column: "price", Field: "Price", Kind: FieldNumber, Sort: true},
+		"reward":         {Column: "reward", Field: "Reward", Kind: FieldNumber, Sort: true},
+		"price_currency": {Column: "price_currency", Field: "PriceCurrency", Kind: FieldString, Filter: true},
+		"is_in_sale":     {Column: "in_sale", Field: "InSale", Kind: FieldBool, Filter: true},
+		"shop_id":        {Column: "shop_id", Field: "ShopID", Kind: FieldUUID, Filter: true},
+	},
+	DefaultSort: "created_at",
+}
+
+// ListProducts -> a page of products and the cursor to the next one, empty on the last page
+func (product *Product) ListProducts(db *gorm.DB, query ListQuery) (*[]Product, string, error) {
 
 	products := []Product{}
-	err := db.Debug().Model(&Product{}).Limit(100).Find(&products).Error
+	err := query.scope(db.Debug().Model(&Product{}), ProductList).Find(&products).Error
 	if err != nil {
-		return &[]Product{}, err
+		return &[]Product{}, "", err
 	}
 
-	return &products, nil
+	count, next := query.nextPage(reflect.ValueOf(products), ProductList)
+	products = products[:count]
+	return &products, next, nil
 }
 
-// FindAllProductsByShop ...
-func (product *Product) FindAllProductsByShop(db *gorm.DB, shopID string) (*[]Product, error) {
+// SearchProducts -> the products of live shops matching every term of the query, best match first. A term matches the
+// words it starts and, through pg_trgm, words it is a typo away from, in the product's or its shop's name and description
+func (product *Product) SearchProducts(db *gorm.DB, query SearchQuery) (*[]ProductMatch, error) {
+
+	query = query.withDefaults()
+	if len(query.Terms) == 0 {
+		return &[]ProductMatch{}, nil
+	}
+
+	words := strings.Join(query.Terms, " ")
+	matching, values := matchingIDs(query.Terms, productBranches)
+	search := db.Debug().Table("products").
+		Select("products.id, "+productRank+" AS search_rank", tsQuery(query.Terms), words, words).
+		Joins("JOIN shops ON shops.id = products.shop_id AND shops.deleted_at IS NULL").
+		Where("products.deleted_at IS NULL").
+		Where("products.id IN ("+matching+")", values...)
+
+	if query.InSale != nil {
+		search = search.Where("products.in_sale = ?", *query.InSale)
+	}
+
+	if query.MaxPrice != nil {
+		search = search.Where(discountedPrice+" <= ?", *query.MaxPrice)
+	}
+
+	if query.ShopID != uuid.Nil {
+		search = search.Where("products.shop_id = ?", query.ShopID)
+	}
+
+	rows, err := search.Order("search_rank DESC").Order("products.id").Limit(query.Limit).Rows()
+	if err != nil {
+		return &[]ProductMatch{}, err
+	}
+	defer rows.Close()
+
+	order := []uuid.UUID{}
+	ids := []string{}
+	ranks := map[uuid.UUID]float64{}
+	for rows.Next() {
+		var id uuid.UUID
+		var rank float64
+		err = rows.Scan(&id, &rank)
+		if err != nil {
+			return &[]ProductMatch{}, err
+		}
+
+		order = append(order, id)
+		ids = append(ids, id.String())
+		ranks[id] = rank
+	}
+
+	err = rows.Err()
+	if err != nil || len(ids) == 0 {
+		return &[]ProductMatch{}, err
+	}
 
 	products := []Product{}
-	shop := Shop{}
-	_, err := shop.FindShopByID(db, shopID)
+	err = db.Debug().Model(&Product{}).Where("id IN (?)", ids).Find(&products).Error
 	if err != nil {
-		return &[]Product{}, err
+		return &[]ProductMatch{}, err
+	}
+
+	shopIDs := []string{}
+	for _, found := range products {
+		shopIDs = append(shopIDs, found.ShopID.String())
 	}
 
-	err = db.Debug().Model(&Product{}).Where("shop_id = ?", shopID).Find(&products).Error
+	shops := []Shop{}
+	err = db.Debug().Model(&Shop{}).Where("id IN (?)", shopIDs).Find(&shops).Error
 	if err != nil {
-		return &[]Product{}, err
+		return &[]ProductMatch{}, err
+	}
+
+	soldBy := map[uuid.UUID]Shop{}
+	for _, shop := range shops {
+		soldBy[shop.ID] = shop
+	}
+
+	// Back in the order of the search
+	found := map[uuid.UUID]Product{}
+	for _, match := range products {
+		match.SoldBy = soldBy[match.ShopID]
+		found[match.ID] = match
 	}
 
-	return &products, nil
+	matches := make([]ProductMatch, 0, len(order))
+	for _, id := range order {
+		match, ok := found[id]
+		if ok {
+			matches = append(matches, ProductMatch{Product: match, Rank: ranks[id]})
+		}
+	}
+
+	return &matches, nil
 }
 
 // FindProductByID ...
@@ -123,13 +321,12 @@ func (product *Product) CreateProduct(db *gorm.DB) (*Product, error) {
 // UpdateProduct ...
 func (product *Product) UpdateProduct(db *gorm.DB, id string) (*Product, error) {
 
-	err := db.Debug().Model(Product{}).Updates(&product).Error
+	err := db.Debug().Model(&Product{}).Where("id = ?", id).Updates(&product).Error
 	if err != nil {
 		return &Product{}, err
 	}
-	// More to cover
 
-	return product, nil
+	return product.FindProductByID(db, id)
 }
 
 // DeleteProduct ...
@@ -0,0 +1,198 @@
+package models
+
+import (
+	"errors"
+	"time"
+
+	"github.com/amaraliou/stakeout/auth"
+	"github.com/amaraliou/stakeout/common"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// RefreshTokenTTL -> how long a refresh token can be exchanged for a new access token, set by Configure
+var RefreshTokenTTL = common.DefaultConfig().Auth.RefreshTokenTTL
+
+var (
+	// ErrInvalidRefreshToken -> the refresh token is unknown, expired or revoked
+	ErrInvalidRefreshToken = errors.New("Invalid refresh token")
+	// ErrRefreshTokenReused -> an already rotated refresh token came back, its whole family has been revoked
+	ErrRefreshTokenReused = errors.New("Refresh token reuse detected")
+)
+
+// RefreshToken -> Struct to hold a hashed refresh token, every token rotated from the same login shares its FamilyID
+// which is also the session ID carried by the access tokens of that login
+type RefreshToken struct {
+	Base
+	FamilyID  uuid.UUID  `json:"-" gorm:"index"`
+	SubjectID uuid.UUID  `json:"-" gorm:"index"`
+	IsAdmin   bool       `json:"-"`
+	TokenHash string     `json:"-" gorm:"unique_index"`
+	ExpiresAt time.Time  `json:"-"`
+	UsedAt    *time.Time `json:"-"`
+	RevokedAt *time.Time `json:"-"`
+}
+
+// RevokedToken -> Struct to hold the jti of a logged out access token until the token expires
+type RevokedToken struct {
+	Base
+	JTI       string    `gorm:"column:jti;unique_index"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// CreateRefreshToken -> Function to issue a refresh token for SubjectID, a nil FamilyID starts a new family.
+// Returns the token itself, only its hash is stored
+func (refresh *RefreshToken) CreateRefreshToken(db *gorm.DB) (string, error) {
+
+	token, err := refresh.prepare()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.Debug().Create(&refresh).Error
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// prepare -> draws the token and fills in what is stored of it, the family and the expiry
+func (refresh *RefreshToken) prepare() (string, error) {
+
+	token, err := auth.NewOpaqueToken()
+	if err != nil {
+		return "", err
+	}
+
+	if refresh.FamilyID == uuid.Nil {
+		refresh.FamilyID, err = uuid.NewV4()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	refresh.TokenHash = auth.HashOpaqueToken(token)
+	refresh.ExpiresAt = time.Now().Add(RefreshTokenTTL)
+	return token, nil
+}
+
+// RotateRefreshToken -> Function to exchange a refresh token for the next one of its family.
+// Presenting a token that was already rotated revokes the whole family
+func (refresh *RefreshToken) RotateRefreshToken(db *gorm.DB, token string) (*RefreshToken, string, error) {
+
+	next := &RefreshToken{}
+	nextToken := ""
+	reused := false
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		current := &RefreshToken{}
+		err := tx.Debug().Set("gorm:query_option", "FOR UPDATE").Model(&RefreshToken{}).Where("token_hash = ?", auth.HashOpaqueToken(token)).Take(current).Error
+		if gorm.IsRecordNotFoundError(err) {
+			return ErrInvalidRefreshToken
+		}
+
+		if err != nil {
+			return err
+		}
+
+		if current.RevokedAt != nil || time.Now().After(current.ExpiresAt) {
+			return ErrInvalidRefreshToken
+		}
+
+		if current.UsedAt != nil {
+			reused = true
+			return revokeFamily(tx, current.FamilyID.String())
+		}
+
+		err = tx.Debug().Model(&RefreshToken{}).Where("id = ?", current.ID).UpdateColumn("used_at", time.Now()).Error
+		if err != nil {
+			return err
+		}
+
+		next.FamilyID = current.FamilyID
+		next.SubjectID = current.SubjectID
+		next.IsAdmin = current.IsAdmin
+		nextToken, err = next.CreateRefreshToken(tx)
+		return err
+	})
+	if err != nil {
+		return &RefreshToken{}, "", err
+	}
+
+	if reused {
+		return &RefreshToken{}, "", ErrRefreshTokenReused
+	}
+
+	return next, nextToken, nil
+}
+
+// revokeFamily -> revokes every refresh token of a family, which also revokes the access tokens of that session
+func revokeFamily(db *gorm.DB, familyID string) error {
+	return db.Debug().Model(&RefreshToken{}).Where("family_id = ? AND revoked_at IS NULL", familyID).UpdateColumn("revoked_at", time.Now()).Error
+}
+
+// RevokeSession -> logs out an access token: its jti is denied until it expires and its session, if any, is revoked
+func RevokeSession(db *gorm.DB, jti, sessionID string, expiresAt time.Time) error {
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		// Expired tokens fail validation anyway
+		err := tx.Debug().Unscoped().Where("expires_at < ?", time.Now()).Delete(&RevokedToken{}).Error
+		if err != nil {
+			return err
+		}
+
+		if jti != "" {
+			err = tx.Debug().Create(&RevokedToken{JTI: jti, ExpiresAt: expiresAt}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		if sessionID == "" {
+			return nil
+		}
+
+		return revokeFamily(tx, sessionID)
+	})
+}
+
+// RevokeSubjectSessions -> revokes every session of a student or admin, used when the account goes away
+func RevokeSubjectSessions(db *gorm.DB, subjectID string) error {
+	return db.Debug().Model(&RefreshToken{}).Where("subject_id = ? AND revoked_at IS NULL", subjectID).UpdateColumn("revoked_at", time.Now()).Error
+}
+
+// RevokeOtherSessions -> revokes every session of a student or admin but the one given, used when the password changes
+func RevokeOtherSessions(db *gorm.DB, subjectID, keepSessionID string) error {
+	if keepSessionID == "" {
+		return RevokeSubjectSessions(db, subjectID)
+	}
+
+	return db.Debug().Model(&RefreshToken{}).Where("subject_id = ? AND family_id <> ? AND revoked_at IS NULL", subjectID, keepSessionID).UpdateColumn("revoked_at", time.Now()).Error
+}
+
+// SessionRevoker -> auth.Revoker backed by the revoked_tokens and refresh_tokens tables
+type SessionRevoker struct {
+	DB *gorm.DB
+}
+
+// IsRevoked -> checks the jti was logged out or the session's refresh token family was revoked
+func (revoker SessionRevoker) IsRevoked(jti, sessionID string) (bool, error) {
+
+	count := 0
+	if jti != "" {
+		err := revoker.DB.Model(&RevokedToken{}).Where("jti = ?", jti).Count(&count).Error
+		if err != nil || count > 0 {
+			return count > 0, err
+		}
+	}
+
+	if sessionID != "" {
+		err := revoker.DB.Model(&RefreshToken{}).Where("family_id = ? AND revoked_at IS NOT NULL", sessionID).Count(&count).Error
+		if err != nil || count > 0 {
+			return count > 0, err
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,114 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// StudentRepository -> storage of students and their points ledger
+type StudentRepository interface {
+	CreateStudent(student *Student) (*Student, error)
+	ListStudents(query ListQuery) (*[]Student, string, error)
+	FindStudentByID(id string) (*Student, error)
+	// FindStudentByEmail -> ErrEmailNotFound when no student has the email
+	FindStudentByEmail(email string) (*Student, error)
+	UpdateStudent(id string, student *Student) (*Student, error)
+	UpdateStudentPassword(id, currentPassword, newPassword string) error
+	UpdateStudentStatus(id string, isStudent bool, university string) (*Student, error)
+	DeleteStudent(id string) (int64, error)
+	FindEntriesByStudent(studentID string) (*[]PointsEntry, error)
+}
+
+// AdminRepository -> storage of admins and their two-factor authentication
+type AdminRepository interface {
+	CreateAdmin(admin *Admin) (*Admin, error)
+	ListAdmins(query ListQuery) (*[]Admin, string, error)
+	FindAdminByID(id string) (*Admin, error)
+	// FindAdminByEmail -> ErrEmailNotFound when no admin has the email
+	FindAdminByEmail(email string) (*Admin, error)
+	UpdateAdmin(id string, admin *Admin) (*Admin, error)
+	UpdateAdminPassword(id, currentPassword, newPassword string) error
+	UpdateAdminRole(id string, admin *Admin) (*Admin, error)
+	DeleteAdmin(id string) (int64, error)
+	EnrollTwoFactor(id string) (string, error)
+	ConfirmTwoFactor(id, code string) ([]string, error)
+	CheckTwoFactor(id, code string) error
+	DisableTwoFactor(id, code string) error
+}
+
+// ShopRepository -> storage of shops
+type ShopRepository interface {
+	CreateShop(shop *Shop) (*Shop, error)
+	ListShops(query ListQuery) (*[]Shop, string, error)
+	FindShopsNearby(query NearbyQuery) (*[]NearbyShop, error)
+	SearchShops(query SearchQuery) (*[]ShopMatch, error)
+	FindShopByID(id string) (*Shop, error)
+	// UpdateShop -> writes the fields set, except the points rate and the two-factor requirement
+	UpdateShop(id string, shop *Shop) (*Shop, error)
+	SetPointsRate(id string, rate float32) (*Shop, error)
+	SetTwoFactorRequired(id string, required bool) (*Shop, error)
+	DeleteShop(id string) (int64, error)
+}
+
+// ProductRepository -> storage of the products shops sell
+type ProductRepository interface {
+	CreateProduct(product *Product) (*Product, error)
+	ListProducts(query ListQuery) (*[]Product, string, error)
+	SearchProducts(query SearchQuery) (*[]ProductMatch, error)
+	FindProductByID(id string) (*Product, error)
+	UpdateProduct(id string, product *Product) (*Product, error)
+	DeleteProduct(id string) (int64, error)
+}
+
+// OrderRepository -> storage of orders, with their lines, audit trail and the points they move
+type OrderRepository interface {
+	CreateOrder(order *Order) (*Order, error)
+	ListOrders(query ListQuery) (*[]Order, string, error)
+	FindOrderByID(id string) (*Order, error)
+	// FindOrderHistory -> the order, deleted or not, and its audit trail oldest first
+	FindOrderHistory(id string) (*Order, *[]OrderEvent, error)
+	UpdateOrder(id string, order *Order) (*Order, error)
+	DeleteOrder(id string, deletedBy uuid.UUID) (int64, error)
+}
+
+// SessionRepository -> storage of the refresh token families behind sessions and of logged out access tokens.
+// It is the auth.Revoker of the tokens it issued
+type SessionRepository interface {
+	CreateRefreshToken(refresh *RefreshToken) (string, error)
+	RotateRefreshToken(token string) (*RefreshToken, string, error)
+	RevokeSession(jti, sessionID string, expiresAt time.Time) error
+	RevokeOtherSessions(subjectID, keepSessionID string) error
+	IsRevoked(jti, sessionID string) (bool, error)
+}
+
+// EmailTokenRepository -> storage of the single use tokens sent by email, and what spending them does to the account
+type EmailTokenRepository interface {
+	CreateEmailToken(emailToken *EmailToken) (string, error)
+	VerifyEmail(token string) (*EmailToken, error)
+	ResetPassword(token, password string) (*EmailToken, error)
+}
+
+// Repositories -> one repository per aggregate, what handlers read and write through
+type Repositories struct {
+	Students    StudentRepository
+	Admins      AdminRepository
+	Shops       ShopRepository
+	Products    ProductRepository
+	Orders      OrderRepository
+	Sessions    SessionRepository
+	EmailTokens EmailTokenRepository
+}
+
+// GormRepositories -> the repositories backed by the database
+func GormRepositories(db *gorm.DB) Repositories {
+	store := GormStore{DB: db}
+	return Repositories{Students: store, Admins: store, Shops: store, Products: store, Orders: store, Sessions: store, EmailTokens: store}
+}
+
+// MemoryRepositories -> repositories kept in memory, sharing one store so orders see the students, shops and products
+func MemoryRepositories() Repositories {
+	store := NewMemoryStore()
+	return Repositories{Students: store, Admins: store, Shops: store, Products: store, Orders: store, Sessions: store, EmailTokens: store}
+}
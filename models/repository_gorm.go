@@ -0,0 +1,304 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// GormStore -> every repository backed by the database, through the model functions
+type GormStore struct {
+	DB *gorm.DB
+}
+
+// CreateStudent ...
+func (store GormStore) CreateStudent(student *Student) (*Student, error) {
+	return student.CreateStudent(store.DB)
+}
+
+// ListStudents ...
+func (store GormStore) ListStudents(query ListQuery) (*[]Student, string, error) {
+	return (&Student{}).ListStudents(store.DB, query)
+}
+
+// FindStudentByID ...
+func (store GormStore) FindStudentByID(id string) (*Student, error) {
+	return (&Student{}).FindStudentByID(store.DB, id)
+}
+
+// FindStudentByEmail ...
+func (store GormStore) FindStudentByEmail(email string) (*Student, error) {
+	return (&Student{}).FindStudentByEmail(store.DB, email)
+}
+
+// UpdateStudent ...
+func (store GormStore) UpdateStudent(id string, student *Student) (*Student, error) {
+	return student.UpdateStudent(store.DB, id)
+}
+
+// UpdateStudentPassword ...
+func (store GormStore) UpdateStudentPassword(id, currentPassword, newPassword string) error {
+	return (&Student{}).UpdatePassword(store.DB, id, currentPassword, newPassword)
+}
+
+// UpdateStudentStatus ...
+func (store GormStore) UpdateStudentStatus(id string, isStudent bool, university string) (*Student, error) {
+	return (&Student{}).UpdateStudentStatus(store.DB, id, isStudent, university)
+}
+
+// DeleteStudent -> deletes the student and revokes their sessions, tokens already handed out must stop working with the account
+func (store GormStore) DeleteStudent(id string) (int64, error) {
+
+	var rowsAffected int64
+	err := store.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		rowsAffected, err = (&Student{}).DeleteStudent(tx, id)
+		if err != nil {
+			return err
+		}
+
+		return RevokeSubjectSessions(tx, id)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return rowsAffected, nil
+}
+
+// FindEntriesByStudent ...
+func (store GormStore) FindEntriesByStudent(studentID string) (*[]PointsEntry, error) {
+	return (&PointsEntry{}).FindEntriesByStudent(store.DB, studentID)
+}
+
+// CreateAdmin ...
+func (store GormStore) CreateAdmin(admin *Admin) (*Admin, error) {
+	return admin.CreateAdmin(store.DB)
+}
+
+// ListAdmins ...
+func (store GormStore) ListAdmins(query ListQuery) (*[]Admin, string, error) {
+	return (&Admin{}).ListAdmins(store.DB, query)
+}
+
+// FindAdminByID ...
+func (store GormStore) FindAdminByID(id string) (*Admin, error) {
+	return (&Admin{}).FindAdminByID(store.DB, id)
+}
+
+// FindAdminByEmail ...
+func (store GormStore) FindAdminByEmail(email string) (*Admin, error) {
+	return (&Admin{}).FindAdminByEmail(store.DB, email)
+}
+
+// UpdateAdmin ...
+func (store GormStore) UpdateAdmin(id string, admin *Admin) (*Admin, error) {
+	return admin.UpdateAdmin(store.DB, id)
+}
+
+// UpdateAdminPassword ...
+func (store GormStore) UpdateAdminPassword(id, currentPassword, newPassword string) error {
+	return (&Admin{}).UpdatePassword(store.DB, id, currentPassword, newPassword)
+}
+
+// UpdateAdminRole ...
+func (store GormStore) UpdateAdminRole(id string, admin *Admin) (*Admin, error) {
+	return admin.UpdateAdminRole(store.DB, id)
+}
+
+// DeleteAdmin -> deletes the admin and revokes their sessions, tokens already handed out must stop working with the account
+func (store GormStore) DeleteAdmin(id string) (int64, error) {
+
+	var rowsAffected int64
+	err := store.DB.Transaction(func(tx *gorm.DB) error {
+		var err error
+		rowsAffected, err = (&Admin{}).DeleteAdmin(tx, id)
+		if err != nil {
+			return err
+		}
+
+		return RevokeSubjectSessions(tx, id)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return rowsAffected, nil
+}
+
+// EnrollTwoFactor ...
+func (store GormStore) EnrollTwoFactor(id string) (string, error) {
+	return (&Admin{}).EnrollTwoFactor(store.DB, id)
+}
+
+// ConfirmTwoFactor ...
+func (store GormStore) ConfirmTwoFactor(id, code string) ([]string, error) {
+	return (&Admin{}).ConfirmTwoFactor(store.DB, id, code)
+}
+
+// CheckTwoFactor ...
+func (store GormStore) CheckTwoFactor(id, code string) error {
+	return (&Admin{}).CheckTwoFactor(store.DB, id, code)
+}
+
+// DisableTwoFactor ...
+func (store GormStore) DisableTwoFactor(id, code string) error {
+	return (&Admin{}).DisableTwoFactor(store.DB, id, code)
+}
+
+// CreateShop ...
+func (store GormStore) CreateShop(shop *Shop) (*Shop, error) {
+	return shop.CreateShop(store.DB)
+}
+
+// ListShops ...
+func (store GormStore) ListShops(query ListQuery) (*[]Shop, string, error) {
+	return (&Shop{}).ListShops(store.DB, query)
+}
+
+// FindShopsNearby ...
+func (store GormStore) FindShopsNearby(query NearbyQuery) (*[]NearbyShop, error) {
+	return (&Shop{}).FindShopsNearby(store.DB, query)
+}
+
+// SearchShops ...
+func (store GormStore) SearchShops(query SearchQuery) (*[]ShopMatch, error) {
+	return (&Shop{}).SearchShops(store.DB, query)
+}
+
+// FindShopByID ...
+func (store GormStore) FindShopByID(id string) (*Shop, error) {
+	return (&Shop{}).FindShopByID(store.DB, id)
+}
+
+// UpdateShop ...
+func (store GormStore) UpdateShop(id string, shop *Shop) (*Shop, error) {
+	return shop.UpdateShop(store.DB, id)
+}
+
+// SetPointsRate ...
+func (store GormStore) SetPointsRate(id string, rate float32) (*Shop, error) {
+	return (&Shop{}).SetPointsRate(store.DB, id, rate)
+}
+
+// SetTwoFactorRequired ...
+func (store GormStore) SetTwoFactorRequired(id string, required bool) (*Shop, error) {
+	return (&Shop{}).SetTwoFactorRequired(store.DB, id, required)
+}
+
+// DeleteShop ...
+func (store GormStore) DeleteShop(id string) (int64, error) {
+	return (&Shop{}).DeleteShop(store.DB, id)
+}
+
+// CreateProduct ...
+func (store GormStore) CreateProduct(product *Product) (*Product, error) {
+	return product.CreateProduct(store.DB)
+}
+
+// ListProducts ...
+func (store GormStore) ListProducts(query ListQuery) (*[]Product, string, error) {
+	return (&Product{}).ListProducts(store.DB, query)
+}
+
+// SearchProducts ...
+func (store GormStore) SearchProducts(query SearchQuery) (*[]ProductMatch, error) {
+	return (&Product{}).SearchProducts(store.DB, query)
+}
+
+// FindProductByID ...
+func (store GormStore) FindProductByID(id string) (*Product, error) {
+	return (&Product{}).FindProductByID(store.DB, id)
+}
+
+// UpdateProduct ...
+func (store GormStore) UpdateProduct(id string, product *Product) (*Product, error) {
+	return product.UpdateProduct(store.DB, id)
+}
+
+// DeleteProduct ...
+func (store GormStore) DeleteProduct(id string) (int64, error) {
+	return (&Product{}).DeleteProduct(store.DB, id)
+}
+
+// CreateOrder ...
+func (store GormStore) CreateOrder(order *Order) (*Order, error) {
+	return order.CreateOrder(store.DB)
+}
+
+// ListOrders ...
+func (store GormStore) ListOrders(query ListQuery) (*[]Order, string, error) {
+	return (&Order{}).ListOrders(store.DB, query)
+}
+
+// FindOrderByID ...
+func (store GormStore) FindOrderByID(id string) (*Order, error) {
+	return (&Order{}).FindOrderByID(store.DB, id)
+}
+
+// FindOrderHistory -> unscoped so the history of a deleted order can still be retrieved
+func (store GormStore) FindOrderHistory(id string) (*Order, *[]OrderEvent, error) {
+
+	order, err := (&Order{}).FindOrderByID(store.DB.Unscoped(), id)
+	if err != nil {
+		return order, &[]OrderEvent{}, err
+	}
+
+	events, err := (&OrderEvent{}).FindEventsByOrder(store.DB, id)
+	if err != nil {
+		return order, events, err
+	}
+
+	return order, events, nil
+}
+
+// UpdateOrder ...
+func (store GormStore) UpdateOrder(id string, order *Order) (*Order, error) {
+	return order.UpdateOrder(store.DB, id)
+}
+
+// DeleteOrder ...
+func (store GormStore) DeleteOrder(id string, deletedBy uuid.UUID) (int64, error) {
+	return (&Order{UpdatedBy: deletedBy}).DeleteOrder(store.DB, id)
+}
+
+// CreateRefreshToken ...
+func (store GormStore) CreateRefreshToken(refresh *RefreshToken) (string, error) {
+	return refresh.CreateRefreshToken(store.DB)
+}
+
+// RotateRefreshToken ...
+func (store GormStore) RotateRefreshToken(token string) (*RefreshToken, string, error) {
+	return (&RefreshToken{}).RotateRefreshToken(store.DB, token)
+}
+
+// RevokeSession ...
+func (store GormStore) RevokeSession(jti, sessionID string, expiresAt time.Time) error {
+	return RevokeSession(store.DB, jti, sessionID, expiresAt)
+}
+
+// RevokeOtherSessions ...
+func (store GormStore) RevokeOtherSessions(subjectID, keepSessionID string) error {
+	return RevokeOtherSessions(store.DB, subjectID, keepSessionID)
+}
+
+// IsRevoked ...
+func (store GormStore) IsRevoked(jti, sessionID string) (bool, error) {
+	return SessionRevoker{DB: store.DB}.IsRevoked(jti, sessionID)
+}
+
+// CreateEmailToken ...
+func (store GormStore) CreateEmailToken(emailToken *EmailToken) (string, error) {
+	return emailToken.CreateEmailToken(store.DB)
+}
+
+// VerifyEmail ...
+func (store GormStore) VerifyEmail(token string) (*EmailToken, error) {
+	return (&EmailToken{}).VerifyEmail(store.DB, token)
+}
+
+// ResetPassword ...
+func (store GormStore) ResetPassword(token, password string) (*EmailToken, error) {
+	return (&EmailToken{}).ResetPassword(store.DB, token, password)
+}
@@ -0,0 +1,1463 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/amaraliou/stakeout/auth"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// errEmailTaken -> what the unique index on emails gives GormStore
+var errEmailTaken = errors.New("Email already taken")
+
+// MemoryStore -> every repository kept in memory, for tests that don't need Postgres. It behaves like GormStore,
+// soft deletes included, which the conformance tests in tests/repositorytest check
+type MemoryStore struct {
+	mutex    sync.Mutex
+	students []Student
+	admins   []Admin
+	shops    []Shop
+	products []Product
+	orders   []Order
+	events   []OrderEvent
+	entries  []PointsEntry
+	index    *searchIndex // Built by the first search after products or shops change
+
+	refreshTokens []RefreshToken
+	revokedTokens []RevokedToken
+	emailTokens   []EmailToken
+	recoveryCodes []RecoveryCode
+}
+
+// NewMemoryStore -> an empty store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// newBase -> what the database fills in for a new row
+func newBase() (Base, error) {
+	id, err := uuid.NewV4()
+	if err != nil {
+		return Base{}, err
+	}
+
+	now := time.Now()
+	return Base{ID: id, CreatedAt: now, UpdatedAt: now}, nil
+}
+
+// live -> the row is there and not soft deleted, unscoped finds deleted rows too
+func live(base Base, id string, unscoped bool) bool {
+	return base.ID.String() == id && (unscoped || base.DeletedAt == nil)
+}
+
+// softDelete -> what gorm's Delete does to a model with a DeletedAt
+func softDelete(base *Base) {
+	now := time.Now()
+	base.DeletedAt = &now
+}
+
+func (store *MemoryStore) student(id string, unscoped bool) *Student {
+	for i := range store.students {
+		if live(store.students[i].Base, id, unscoped) {
+			return &store.students[i]
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) admin(id string) *Admin {
+	for i := range store.admins {
+		if live(store.admins[i].Base, id, false) {
+			return &store.admins[i]
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) shop(id string, unscoped bool) *Shop {
+	for i := range store.shops {
+		if live(store.shops[i].Base, id, unscoped) {
+			return &store.shops[i]
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) product(id string) *Product {
+	for i := range store.products {
+		if live(store.products[i].Base, id, false) {
+			return &store.products[i]
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) order(id string, unscoped bool) *Order {
+	for i := range store.orders {
+		if live(store.orders[i].Base, id, unscoped) {
+			return &store.orders[i]
+		}
+	}
+	return nil
+}
+
+// CreateStudent ...
+func (store *MemoryStore) CreateStudent(student *Student) (*Student, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, other := range store.students {
+		if other.Email == student.Email {
+			return &Student{}, errEmailTaken
+		}
+	}
+
+	err := student.BeforeSave()
+	if err != nil {
+		return &Student{}, err
+	}
+
+	student.Base, err = newBase()
+	if err != nil {
+		return &Student{}, err
+	}
+	student.Points = 0
+
+	store.students = append(store.students, *student)
+	return student, nil
+}
+
+// ListStudents ...
+func (store *MemoryStore) ListStudents(query ListQuery) (*[]Student, string, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	indexes, next := query.page(reflect.ValueOf(store.students), StudentList, func(i int) bool { return store.students[i].DeletedAt == nil })
+	students := make([]Student, 0, len(indexes))
+	for _, i := range indexes {
+		students = append(students, store.students[i])
+	}
+	return &students, next, nil
+}
+
+// FindStudentByID ...
+func (store *MemoryStore) FindStudentByID(id string) (*Student, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.findStudent(id)
+}
+
+// FindStudentByEmail ...
+func (store *MemoryStore) FindStudentByEmail(email string) (*Student, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, student := range store.students {
+		if student.Email == email && student.DeletedAt == nil {
+			return &student, nil
+		}
+	}
+	return &Student{}, ErrEmailNotFound
+}
+
+func (store *MemoryStore) findStudent(id string) (*Student, error) {
+	current := store.student(id, false)
+	if current == nil {
+		return &Student{}, errors.New("Student not found")
+	}
+
+	student := *current
+	return &student, nil
+}
+
+// UpdateStudent -> like gorm's Updates, only the fields set are written. Passwords, points, verification and status have their own functions,
+// but a new email has to be verified again
+func (store *MemoryStore) UpdateStudent(id string, student *Student) (*Student, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current := store.student(id, false)
+	if current == nil {
+		return &Student{}, errors.New("Student not found")
+	}
+
+	if student.Email != "" && student.Email != current.Email {
+		for _, other := range store.students {
+			if other.Email == student.Email {
+				return &Student{}, errEmailTaken
+			}
+		}
+		current.IsVerified = false
+		current.IsStudent = false
+		current.University = ""
+	}
+
+	setString(&current.Email, student.Email)
+	setString(&current.FirstName, student.FirstName)
+	setString(&current.LastName, student.LastName)
+	setString(&current.BirthDate, student.BirthDate)
+	setString(&current.MobileNumber, student.MobileNumber)
+	setString(&current.CountryCode, student.CountryCode)
+	if student.GraduationYear != 0 {
+		current.GraduationYear = student.GraduationYear
+	}
+	current.UpdatedAt = time.Now()
+
+	return store.findStudent(id)
+}
+
+// UpdateStudentPassword ...
+func (store *MemoryStore) UpdateStudentPassword(id, currentPassword, newPassword string) error {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current := store.student(id, false)
+	if current == nil {
+		return errors.New("Student not found")
+	}
+
+	return memoryChangePassword(&current.User, currentPassword, newPassword)
+}
+
+// UpdateStudentStatus ...
+func (store *MemoryStore) UpdateStudentStatus(id string, isStudent bool, university string) (*Student, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current := store.student(id, false)
+	if current == nil {
+		return &Student{}, errors.New("Student not found")
+	}
+
+	current.IsStudent = isStudent
+	current.University = university
+
+	return store.findStudent(id)
+}
+
+// DeleteStudent ...
+func (store *MemoryStore) DeleteStudent(id string) (int64, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current := store.student(id, false)
+	if current == nil {
+		return 0, gorm.ErrRecordNotFound
+	}
+
+	softDelete(&current.Base)
+	store.revokeSessions(id, "")
+	return 1, nil
+}
+
+// FindEntriesByStudent -> newest first
+func (store *MemoryStore) FindEntriesByStudent(studentID string) (*[]PointsEntry, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	entries := []PointsEntry{}
+	for i := len(store.entries) - 1; i >= 0; i-- {
+		if store.entries[i].StudentID.String() == studentID {
+			entries = append(entries, store.entries[i])
+		}
+	}
+	return &entries, nil
+}
+
+// CreateAdmin ...
+func (store *MemoryStore) CreateAdmin(admin *Admin) (*Admin, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, other := range store.admins {
+		if other.Email == admin.Email {
+			return &Admin{}, errEmailTaken
+		}
+	}
+
+	err := admin.BeforeSave()
+	if err != nil {
+		return &Admin{}, err
+	}
+
+	admin.Base, err = newBase()
+	if err != nil {
+		return &Admin{}, err
+	}
+
+	// The column default
+	if admin.Role == "" {
+		admin.Role = "shop_owner"
+	}
+
+	stored := *admin
+	stored.Shop = Shop{}
+	store.admins = append(store.admins, stored)
+	return admin, nil
+}
+
+// ListAdmins ...
+func (store *MemoryStore) ListAdmins(query ListQuery) (*[]Admin, string, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	indexes, next := query.page(reflect.ValueOf(store.admins), AdminList, func(i int) bool { return store.admins[i].DeletedAt == nil })
+	admins := make([]Admin, 0, len(indexes))
+	for _, i := range indexes {
+		admins = append(admins, store.admins[i])
+	}
+	return &admins, next, nil
+}
+
+// FindAdminByID ...
+func (store *MemoryStore) FindAdminByID(id string) (*Admin, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.findAdmin(id)
+}
+
+// FindAdminByEmail -> like the gorm one, the shop isn't loaded
+func (store *MemoryStore) FindAdminByEmail(email string) (*Admin, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, admin := range store.admins {
+		if admin.Email == email && admin.DeletedAt == nil {
+			return &admin, nil
+		}
+	}
+	return &Admin{}, ErrEmailNotFound
+}
+
+func (store *MemoryStore) findAdmin(id string) (*Admin, error) {
+	current := store.admin(id)
+	if current == nil {
+		return &Admin{}, errors.New("Admin not found")
+	}
+
+	admin := *current
+	if admin.ShopID != uuid.Nil {
+		shop := store.shop(admin.ShopID.String(), false)
+		if shop == nil {
+			return &admin, errors.New("Shop associated with this admin not found")
+		}
+		admin.Shop = *shop
+	}
+
+	return &admin, nil
+}
+
+// UpdateAdmin -> like gorm's Updates, only the fields set are written. Passwords, verification and two-factor authentication have their own functions,
+// but a new email has to be verified again
+func (store *MemoryStore) UpdateAdmin(id string, admin *Admin) (*Admin, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current := store.admin(id)
+	if current == nil {
+		return &Admin{}, errors.New("Admin not found")
+	}
+
+	if admin.Email != "" && admin.Email != current.Email {
+		for _, other := range store.admins {
+			if other.Email == admin.Email {
+				return &Admin{}, errEmailTaken
+			}
+		}
+		current.IsVerified = false
+	}
+
+	setString(&current.Email, admin.Email)
+	setString(&current.FirstName, admin.FirstName)
+	setString(&current.LastName, admin.LastName)
+	setString(&current.Role, admin.Role)
+	if admin.ShopID != uuid.Nil {
+		current.ShopID = admin.ShopID
+	}
+	current.UpdatedAt = time.Now()
+
+	return store.findAdmin(id)
+}
+
+// UpdateAdminPassword ...
+func (store *MemoryStore) UpdateAdminPassword(id, currentPassword, newPassword string) error {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current := store.admin(id)
+	if current == nil {
+		return errors.New("Admin not found")
+	}
+
+	return memoryChangePassword(&current.User, currentPassword, newPassword)
+}
+
+// UpdateAdminRole ...
+func (store *MemoryStore) UpdateAdminRole(id string, admin *Admin) (*Admin, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current := store.admin(id)
+	if current == nil {
+		return &Admin{}, errors.New("Admin not found")
+	}
+
+	current.Role = admin.Role
+	if admin.ShopID != uuid.Nil {
+		current.ShopID = admin.ShopID
+	}
+
+	return store.findAdmin(id)
+}
+
+// DeleteAdmin ...
+func (store *MemoryStore) DeleteAdmin(id string) (int64, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current := store.admin(id)
+	if current == nil {
+		return 0, gorm.ErrRecordNotFound
+	}
+
+	softDelete(&current.Base)
+	store.revokeSessions(id, "")
+	return 1, nil
+}
+
+// EnrollTwoFactor ...
+func (store *MemoryStore) EnrollTwoFactor(id string) (string, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	_, err := store.findAdmin(id)
+	if err != nil {
+		return "", err
+	}
+
+	current := store.admin(id)
+	if current.TwoFactorEnabled {
+		return "", ErrTwoFactorEnabled
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	current.TOTPSecret = secret
+	current.TOTPLastStep = 0
+	return secret, nil
+}
+
+// ConfirmTwoFactor ...
+func (store *MemoryStore) ConfirmTwoFactor(id, code string) ([]string, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current := store.admin(id)
+	if current == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+
+	if current.TwoFactorEnabled {
+		return nil, ErrTwoFactorEnabled
+	}
+
+	if current.TOTPSecret == "" {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+
+	step, ok := auth.ValidateTOTP(current.TOTPSecret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	codes, recoveryCodes, err := newRecoveryCodes(current.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range recoveryCodes {
+		recoveryCodes[i].Base, err = newBase()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	current.TwoFactorEnabled = true
+	current.TOTPLastStep = step
+	store.recoveryCodes = append(store.dropRecoveryCodes(current.ID), recoveryCodes...)
+	return codes, nil
+}
+
+// CheckTwoFactor ...
+func (store *MemoryStore) CheckTwoFactor(id, code string) error {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.useTwoFactorCode(id, code)
+}
+
+// DisableTwoFactor ...
+func (store *MemoryStore) DisableTwoFactor(id, code string) error {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	err := store.useTwoFactorCode(id, code)
+	if err != nil {
+		return err
+	}
+
+	current := store.admin(id)
+	current.TwoFactor = TwoFactor{}
+	store.recoveryCodes = store.dropRecoveryCodes(current.ID)
+	return nil
+}
+
+// useTwoFactorCode -> Admin.useTwoFactorCode on the stored admin
+func (store *MemoryStore) useTwoFactorCode(id, code string) error {
+
+	current := store.admin(id)
+	if current == nil {
+		return gorm.ErrRecordNotFound
+	}
+
+	if !current.TwoFactorEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	step, ok := auth.ValidateTOTP(current.TOTPSecret, code, time.Now())
+	if ok && step > current.TOTPLastStep {
+		current.TOTPLastStep = step
+		return nil
+	}
+
+	if ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	codeHash := auth.HashOpaqueToken(normalizeRecoveryCode(code))
+	for i := range store.recoveryCodes {
+		recoveryCode := &store.recoveryCodes[i]
+		if recoveryCode.AdminID == current.ID && recoveryCode.CodeHash == codeHash && recoveryCode.UsedAt == nil {
+			now := time.Now()
+			recoveryCode.UsedAt = &now
+			return nil
+		}
+	}
+
+	return ErrInvalidTwoFactorCode
+}
+
+// dropRecoveryCodes -> the recovery codes of every other admin
+func (store *MemoryStore) dropRecoveryCodes(adminID uuid.UUID) []RecoveryCode {
+	kept := []RecoveryCode{}
+	for _, recoveryCode := range store.recoveryCodes {
+		if recoveryCode.AdminID != adminID {
+			kept = append(kept, recoveryCode)
+		}
+	}
+	return kept
+}
+
+// CreateShop ...
+func (store *MemoryStore) CreateShop(shop *Shop) (*Shop, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var err error
+	shop.Base, err = newBase()
+	if err != nil {
+		return &Shop{}, err
+	}
+	shop.TwoFactorRequired = false
+
+	store.shops = append(store.shops, *shop)
+	store.index = nil
+	return shop, nil
+}
+
+// ListShops ...
+func (store *MemoryStore) ListShops(query ListQuery) (*[]Shop, string, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	indexes, next := query.page(reflect.ValueOf(store.shops), ShopList, func(i int) bool { return store.shops[i].DeletedAt == nil })
+	shops := make([]Shop, 0, len(indexes))
+	for _, i := range indexes {
+		shops = append(shops, store.shops[i])
+	}
+	return &shops, next, nil
+}
+
+// FindShopsNearby -> checks the bounding box like the database query does before measuring distances
+func (store *MemoryStore) FindShopsNearby(query NearbyQuery) (*[]NearbyShop, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	box := query.box()
+	shops := []Shop{}
+	for _, shop := range store.shops {
+		if shop.DeletedAt == nil && shop.HasLocation() && box.contains(CurrentLocation{Latitude: shop.Latitude, Longitude: shop.Longitude}) {
+			shops = append(shops, shop)
+		}
+	}
+
+	nearby := query.nearest(shops)
+	return &nearby, nil
+}
+
+// SearchShops -> looks the terms up in the inverted index of the store
+func (store *MemoryStore) SearchShops(query SearchQuery) (*[]ShopMatch, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	matches := store.currentIndex().searchShops(query, store.shops)
+	return &matches, nil
+}
+
+// FindShopByID ...
+func (store *MemoryStore) FindShopByID(id string) (*Shop, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.findShop(id)
+}
+
+func (store *MemoryStore) findShop(id string) (*Shop, error) {
+	current := store.shop(id, false)
+	if current == nil {
+		return &Shop{}, errors.New("Shop not found")
+	}
+
+	shop := *current
+	return &shop, nil
+}
+
+// UpdateShop -> like gorm's Updates, only the fields set are written. The points rate and requiring two-factor
+// authentication have their own functions
+func (store *MemoryStore) UpdateShop(id string, shop *Shop) (*Shop, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.index = nil
+
+	current := store.shop(id, false)
+	if current == nil {
+		return &Shop{}, errors.New("Shop not found")
+	}
+
+	setString(&current.Name, shop.Name)
+	setString(&current.Logo, shop.Logo)
+	setString(&current.Description, shop.Description)
+	if shop.Latitude != 0 {
+		current.Latitude = shop.Latitude
+	}
+	if shop.Longitude != 0 {
+		current.Longitude = shop.Longitude
+	}
+	if shop.AddressNumber != 0 {
+		current.AddressNumber = shop.AddressNumber
+	}
+	setString(&current.AddressLine1, shop.AddressLine1)
+	setString(&current.AddressLine2, shop.AddressLine2)
+	setString(&current.TownOrCity, shop.TownOrCity)
+	setString(&current.County, shop.County)
+	setString(&current.Postcode, shop.Postcode)
+	current.UpdatedAt = time.Now()
+
+	return store.findShop(id)
+}
+
+// SetPointsRate ...
+func (store *MemoryStore) SetPointsRate(id string, rate float32) (*Shop, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current := store.shop(id, false)
+	if current == nil {
+		return &Shop{}, errors.New("Shop not found")
+	}
+
+	current.PointsRate = rate
+	current.UpdatedAt = time.Now()
+	return store.findShop(id)
+}
+
+// SetTwoFactorRequired ...
+func (store *MemoryStore) SetTwoFactorRequired(id string, required bool) (*Shop, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current := store.shop(id, false)
+	if current == nil {
+		return &Shop{}, errors.New("Shop not found")
+	}
+
+	current.TwoFactorRequired = required
+	return store.findShop(id)
+}
+
+// DeleteShop ...
+func (store *MemoryStore) DeleteShop(id string) (int64, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.index = nil
+
+	current := store.shop(id, false)
+	if current == nil {
+		return 0, gorm.ErrRecordNotFound
+	}
+
+	softDelete(&current.Base)
+	return 1, nil
+}
+
+// CreateProduct ...
+func (store *MemoryStore) CreateProduct(product *Product) (*Product, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.index = nil
+
+	shop := store.shop(product.ShopID.String(), false)
+	if shop == nil {
+		return &Product{}, errors.New("Shop doesn't exist, can't create product")
+	}
+
+	var err error
+	product.Base, err = newBase()
+	if err != nil {
+		return &Product{}, err
+	}
+
+	stored := *product
+	stored.SoldBy = Shop{}
+	store.products = append(store.products, stored)
+
+	product.SoldBy = *shop
+	return product, nil
+}
+
+// ListProducts ...
+func (store *MemoryStore) ListProducts(query ListQuery) (*[]Product, string, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	indexes, next := query.page(reflect.ValueOf(store.products), ProductList, func(i int) bool { return store.products[i].DeletedAt == nil })
+	products := make([]Product, 0, len(indexes))
+	for _, i := range indexes {
+		products = append(products, store.products[i])
+	}
+	return &products, next, nil
+}
+
+// SearchProducts -> looks the terms up in the inverted index of the store
+func (store *MemoryStore) SearchProducts(query SearchQuery) (*[]ProductMatch, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	matches := store.currentIndex().searchProducts(query, store.products)
+	for i := range matches {
+		matches[i].SoldBy = *store.shop(matches[i].ShopID.String(), false)
+	}
+	return &matches, nil
+}
+
+// currentIndex -> the search index of the store, built again after products or shops changed
+func (store *MemoryStore) currentIndex() *searchIndex {
+	if store.index == nil {
+		store.index = newSearchIndex(store.products, store.shops)
+	}
+	return store.index
+}
+
+// FindProductByID ...
+func (store *MemoryStore) FindProductByID(id string) (*Product, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.findProduct(id)
+}
+
+func (store *MemoryStore) findProduct(id string) (*Product, error) {
+	current := store.product(id)
+	if current == nil {
+		return &Product{}, errors.New("Product not found")
+	}
+
+	product := *current
+	if product.ShopID != uuid.Nil {
+		shop := store.shop(product.ShopID.String(), false)
+		if shop == nil {
+			return &product, errors.New("Shop associated with this admin not found")
+		}
+		product.SoldBy = *shop
+	}
+
+	return &product, nil
+}
+
+// UpdateProduct -> like gorm's Updates, only the fields set are written
+func (store *MemoryStore) UpdateProduct(id string, product *Product) (*Product, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.index = nil
+
+	current := store.product(id)
+	if current == nil {
+		return &Product{}, errors.New("Product not found")
+	}
+
+	*current = current.WithUpdates(product)
+	current.UpdatedAt = time.Now()
+
+	return store.findProduct(id)
+}
+
+// DeleteProduct ...
+func (store *MemoryStore) DeleteProduct(id string) (int64, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.index = nil
+
+	current := store.product(id)
+	if current == nil {
+		return 0, gorm.ErrRecordNotFound
+	}
+
+	softDelete(&current.Base)
+	return 1, nil
+}
+
+// CreateOrder -> prices the lines and spends the redeemed points like GormStore, nothing is stored when any of it fails
+func (store *MemoryStore) CreateOrder(order *Order) (*Order, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	student := store.student(order.UserID.String(), false)
+	if student == nil {
+		return &Order{}, errors.New("Student doesn't exist, can't create order")
+	}
+
+	shop := store.shop(order.ShopID.String(), false)
+	if shop == nil {
+		return &Order{}, errors.New("Shop doesn't exist, can't create order")
+	}
+
+	var total float64
+	for i := range order.OrderItems {
+		line := &order.OrderItems[i]
+		if line.Quantity <= 0 {
+			return &Order{}, errors.New("Invalid quantity")
+		}
+
+		product := store.product(line.ProductID.String())
+		if product == nil || product.ShopID != order.ShopID {
+			return &Order{}, fmt.Errorf("Product %s not found in this shop", line.ProductID.String())
+		}
+
+		line.price(product)
+		total += float64(line.LineTotal)
+	}
+	subtotal := roundPrice(total)
+
+	order.PointsDiscount = 0
+	if order.RedeemedPoints > 0 {
+		var err error
+		order.PointsDiscount, err = redemptionDiscount(shop, student.Points, order.RedeemedPoints, subtotal)
+		if err != nil {
+			return &Order{}, err
+		}
+	}
+	order.OrderTotal = roundPrice(float64(subtotal) - float64(order.PointsDiscount))
+
+	order.Status = OrderPending
+	order.UpdatedBy = uuid.Nil
+	order.StatusUpdatedAt = nil
+
+	var err error
+	order.Base, err = newBase()
+	if err != nil {
+		return &Order{}, err
+	}
+
+	for i := range order.OrderItems {
+		order.OrderItems[i].Base, err = newBase()
+		if err != nil {
+			return &Order{}, err
+		}
+		order.OrderItems[i].OrderID = order.ID
+	}
+
+	order.OrderedBy = *student
+	order.OrderedFrom = *shop
+	store.orders = append(store.orders, copyOrder(order))
+
+	if order.RedeemedPoints > 0 {
+		err = store.addPointsEntry(order.UserID, order.ID, -order.RedeemedPoints, PointsRedeemed)
+		if err != nil {
+			return &Order{}, err
+		}
+	}
+
+	return order, store.recordOrderEvent(order.ID, OrderEventCreated, order.Status, order.Status, order.UserID)
+}
+
+// copyOrder -> the order as stored, its lines copied and its associations left out like gorm does
+func copyOrder(order *Order) Order {
+	stored := *order
+	stored.OrderedBy = Student{}
+	stored.OrderedFrom = Shop{}
+	stored.OrderItems = append([]OrderLine{}, order.OrderItems...)
+	return stored
+}
+
+// ListOrders ...
+func (store *MemoryStore) ListOrders(query ListQuery) (*[]Order, string, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	indexes, next := query.page(reflect.ValueOf(store.orders), OrderList, func(i int) bool { return store.orders[i].DeletedAt == nil })
+	orders := make([]Order, 0, len(indexes))
+	for _, i := range indexes {
+		orders = append(orders, copyOrder(&store.orders[i]))
+	}
+	return &orders, next, nil
+}
+
+// FindOrderByID ...
+func (store *MemoryStore) FindOrderByID(id string) (*Order, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.findOrder(id, false)
+}
+
+func (store *MemoryStore) findOrder(id string, unscoped bool) (*Order, error) {
+	current := store.order(id, unscoped)
+	if current == nil {
+		return &Order{}, errors.New("Order not found")
+	}
+
+	order := copyOrder(current)
+	if order.UserID != uuid.Nil {
+		student := store.student(order.UserID.String(), unscoped)
+		if student == nil {
+			return &order, errors.New("Student associated with this order not found")
+		}
+		order.OrderedBy = *student
+	}
+
+	if order.ShopID != uuid.Nil {
+		shop := store.shop(order.ShopID.String(), unscoped)
+		if shop == nil {
+			return &order, errors.New("Shop associated with this order not found")
+		}
+		order.OrderedFrom = *shop
+	}
+
+	return &order, nil
+}
+
+// FindOrderHistory ...
+func (store *MemoryStore) FindOrderHistory(id string) (*Order, *[]OrderEvent, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	order, err := store.findOrder(id, true)
+	if err != nil {
+		return order, &[]OrderEvent{}, err
+	}
+
+	events := []OrderEvent{}
+	for _, event := range store.events {
+		if event.OrderID.String() == id {
+			events = append(events, event)
+		}
+	}
+	return order, &events, nil
+}
+
+// UpdateOrder -> moves the order to order.Status, rejecting illegal transitions with an *InvalidTransitionError
+func (store *MemoryStore) UpdateOrder(id string, order *Order) (*Order, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current := store.order(id, false)
+	if current == nil {
+		return &Order{}, errors.New("Order not found")
+	}
+
+	err := CheckTransition(current.Status, order.Status)
+	if err != nil {
+		return &Order{}, err
+	}
+
+	from := current.Status
+	now := time.Now()
+	current.Status = order.Status
+	current.UpdatedBy = order.UpdatedBy
+	current.StatusUpdatedAt = &now
+
+	err = store.recordOrderEvent(current.ID, OrderEventStatusChanged, from, order.Status, order.UpdatedBy)
+	if err != nil {
+		return &Order{}, err
+	}
+
+	err = store.applyOrderPoints(current, order.Status)
+	if err != nil {
+		return &Order{}, err
+	}
+
+	return store.findOrder(id, false)
+}
+
+// DeleteOrder ...
+func (store *MemoryStore) DeleteOrder(id string, deletedBy uuid.UUID) (int64, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	current := store.order(id, false)
+	if current == nil {
+		return 0, gorm.ErrRecordNotFound
+	}
+
+	softDelete(&current.Base)
+	return 1, store.recordOrderEvent(current.ID, OrderEventDeleted, current.Status, current.Status, deletedBy)
+}
+
+// recordOrderEvent -> appends an event to the order's audit trail
+func (store *MemoryStore) recordOrderEvent(orderID uuid.UUID, action string, from, to uint8, actorID uuid.UUID) error {
+
+	base, err := newBase()
+	if err != nil {
+		return err
+	}
+
+	store.events = append(store.events, OrderEvent{
+		Base:       base,
+		OrderID:    orderID,
+		Action:     action,
+		FromStatus: from,
+		ToStatus:   to,
+		ActorID:    actorID,
+	})
+	return nil
+}
+
+// addPointsEntry -> writes a ledger entry and refreshes the student's balance
+func (store *MemoryStore) addPointsEntry(studentID, orderID uuid.UUID, amount int, reason string) error {
+
+	base, err := newBase()
+	if err != nil {
+		return err
+	}
+
+	store.entries = append(store.entries, PointsEntry{
+		Base:      base,
+		StudentID: studentID,
+		OrderID:   orderID,
+		Amount:    amount,
+		Reason:    reason,
+	})
+
+	balance := 0
+	for _, entry := range store.entries {
+		if entry.StudentID == studentID {
+			balance += entry.Amount
+		}
+	}
+
+	student := store.student(studentID.String(), true)
+	if student != nil {
+		student.Points = balance
+	}
+	return nil
+}
+
+// orderPoints -> net points already booked for an order with the given reason
+func (store *MemoryStore) orderPoints(orderID uuid.UUID, reason string) int {
+	total := 0
+	for _, entry := range store.entries {
+		if entry.OrderID == orderID && entry.Reason == reason {
+			total += entry.Amount
+		}
+	}
+	return total
+}
+
+// applyOrderPoints -> the points movements of applyOrderPoints, on the in-memory ledger
+func (store *MemoryStore) applyOrderPoints(order *Order, status uint8) error {
+
+	if status == OrderCancel || status == OrderRefunded {
+		redeemed := store.orderPoints(order.ID, PointsRedeemed)
+		if redeemed < 0 {
+			err := store.addPointsEntry(order.UserID, order.ID, -redeemed, PointsRedemptionReversed)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	switch status {
+	case OrderConfirmed:
+		reward := orderReward(order.OrderItems)
+		if reward <= 0 {
+			return nil
+		}
+
+		return store.addPointsEntry(order.UserID, order.ID, reward, PointsOrderConfirmed)
+
+	case OrderRefunded:
+		credited := store.orderPoints(order.ID, PointsOrderConfirmed)
+		if credited <= 0 {
+			return nil
+		}
+
+		return store.addPointsEntry(order.UserID, order.ID, -credited, PointsOrderRefunded)
+
+	default:
+		return nil
+	}
+}
+
+// CreateRefreshToken ...
+func (store *MemoryStore) CreateRefreshToken(refresh *RefreshToken) (string, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	return store.createRefreshToken(refresh)
+}
+
+func (store *MemoryStore) createRefreshToken(refresh *RefreshToken) (string, error) {
+
+	token, err := refresh.prepare()
+	if err != nil {
+		return "", err
+	}
+
+	refresh.Base, err = newBase()
+	if err != nil {
+		return "", err
+	}
+
+	store.refreshTokens = append(store.refreshTokens, *refresh)
+	return token, nil
+}
+
+// RotateRefreshToken ...
+func (store *MemoryStore) RotateRefreshToken(token string) (*RefreshToken, string, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	var current *RefreshToken
+	tokenHash := auth.HashOpaqueToken(token)
+	for i := range store.refreshTokens {
+		if store.refreshTokens[i].TokenHash == tokenHash {
+			current = &store.refreshTokens[i]
+		}
+	}
+
+	if current == nil || current.RevokedAt != nil || time.Now().After(current.ExpiresAt) {
+		return &RefreshToken{}, "", ErrInvalidRefreshToken
+	}
+
+	if current.UsedAt != nil {
+		store.revokeFamily(current.FamilyID.String())
+		return &RefreshToken{}, "", ErrRefreshTokenReused
+	}
+
+	now := time.Now()
+	current.UsedAt = &now
+
+	next := &RefreshToken{FamilyID: current.FamilyID, SubjectID: current.SubjectID, IsAdmin: current.IsAdmin}
+	nextToken, err := store.createRefreshToken(next)
+	if err != nil {
+		return &RefreshToken{}, "", err
+	}
+
+	return next, nextToken, nil
+}
+
+// RevokeSession ...
+func (store *MemoryStore) RevokeSession(jti, sessionID string, expiresAt time.Time) error {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	// Expired tokens fail validation anyway
+	kept := []RevokedToken{}
+	for _, revoked := range store.revokedTokens {
+		if !revoked.ExpiresAt.Before(time.Now()) {
+			kept = append(kept, revoked)
+		}
+	}
+	store.revokedTokens = kept
+
+	if jti != "" {
+		for _, revoked := range store.revokedTokens {
+			if revoked.JTI == jti {
+				return errors.New("Token already revoked")
+			}
+		}
+
+		base, err := newBase()
+		if err != nil {
+			return err
+		}
+		store.revokedTokens = append(store.revokedTokens, RevokedToken{Base: base, JTI: jti, ExpiresAt: expiresAt})
+	}
+
+	if sessionID != "" {
+		store.revokeFamily(sessionID)
+	}
+	return nil
+}
+
+// RevokeOtherSessions ...
+func (store *MemoryStore) RevokeOtherSessions(subjectID, keepSessionID string) error {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.revokeSessions(subjectID, keepSessionID)
+	return nil
+}
+
+// IsRevoked ...
+func (store *MemoryStore) IsRevoked(jti, sessionID string) (bool, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	for _, revoked := range store.revokedTokens {
+		if jti != "" && revoked.JTI == jti {
+			return true, nil
+		}
+	}
+
+	for _, refresh := range store.refreshTokens {
+		if sessionID != "" && refresh.FamilyID.String() == sessionID && refresh.RevokedAt != nil {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// revokeFamily -> revokes every refresh token of a family
+func (store *MemoryStore) revokeFamily(familyID string) {
+	now := time.Now()
+	for i := range store.refreshTokens {
+		if store.refreshTokens[i].FamilyID.String() == familyID && store.refreshTokens[i].RevokedAt == nil {
+			store.refreshTokens[i].RevokedAt = &now
+		}
+	}
+}
+
+// revokeSessions -> revokes every session of the subject but the one to keep, if any
+func (store *MemoryStore) revokeSessions(subjectID, keepSessionID string) {
+	now := time.Now()
+	for i := range store.refreshTokens {
+		refresh := &store.refreshTokens[i]
+		if refresh.SubjectID.String() == subjectID && refresh.FamilyID.String() != keepSessionID && refresh.RevokedAt == nil {
+			refresh.RevokedAt = &now
+		}
+	}
+}
+
+// CreateEmailToken ...
+func (store *MemoryStore) CreateEmailToken(emailToken *EmailToken) (string, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	token, err := emailToken.prepare()
+	if err != nil {
+		return "", err
+	}
+
+	emailToken.Base, err = newBase()
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	for i := range store.emailTokens {
+		earlier := &store.emailTokens[i]
+		if earlier.SubjectID == emailToken.SubjectID && earlier.Purpose == emailToken.Purpose && earlier.UsedAt == nil {
+			earlier.UsedAt = &now
+		}
+	}
+
+	store.emailTokens = append(store.emailTokens, *emailToken)
+	return token, nil
+}
+
+// VerifyEmail ...
+func (store *MemoryStore) VerifyEmail(token string) (*EmailToken, error) {
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	used, err := store.useEmailToken(token, EmailTokenVerify)
+	if err != nil {
+		return &EmailToken{}, err
+	}
+
+	user := store.emailTokenUser(used)
+	if user != nil {
+		user.IsVerified = true
+	}
+
+	return used, nil
+}
+
+// ResetPassword ...
+func (store *MemoryStore) ResetPassword(token, password string) (*EmailToken, error) {
+
+	hashedPassword, err := Hash(password)
+	if err != nil {
+		return &EmailToken{}, err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	used, err := store.useEmailToken(token, EmailTokenPasswordReset)
+	if err != nil {
+		return &EmailToken{}, err
+	}
+
+	user := store.emailTokenUser(used)
+	if user != nil {
+		user.Password = string(hashedPassword)
+		user.IsVerified = true
+		user.PasswordResetRequired = false
+	}
+
+	store.revokeSessions(used.SubjectID.String(), "")
+	return used, nil
+}
+
+// useEmailToken -> EmailToken.UseEmailToken on the stored tokens
+func (store *MemoryStore) useEmailToken(token, purpose string) (*EmailToken, error) {
+
+	tokenHash := auth.HashOpaqueToken(token)
+	for i := range store.emailTokens {
+		emailToken := &store.emailTokens[i]
+		if emailToken.TokenHash != tokenHash {
+			continue
+		}
+
+		if emailToken.Purpose != purpose || emailToken.UsedAt != nil || time.Now().After(emailToken.ExpiresAt) {
+			return &EmailToken{}, ErrInvalidEmailToken
+		}
+
+		user := store.emailTokenUser(emailToken)
+		if user == nil || emailToken.Email == "" || user.Email != emailToken.Email {
+			return &EmailToken{}, ErrInvalidEmailToken
+		}
+
+		now := time.Now()
+		emailToken.UsedAt = &now
+		used := *emailToken
+		return &used, nil
+	}
+
+	return &EmailToken{}, ErrInvalidEmailToken
+}
+
+// emailTokenUser -> the account the token was sent to, nil once it's deleted
+func (store *MemoryStore) emailTokenUser(emailToken *EmailToken) *User {
+
+	if emailToken.IsAdmin {
+		admin := store.admin(emailToken.SubjectID.String())
+		if admin == nil {
+			return nil
+		}
+		return &admin.User
+	}
+
+	student := store.student(emailToken.SubjectID.String(), false)
+	if student == nil {
+		return nil
+	}
+	return &student.User
+}
+
+// memoryChangePassword -> changePassword on a stored account
+func memoryChangePassword(user *User, currentPassword, newPassword string) error {
+
+	err := VerifyPassword(user.Password, currentPassword)
+	if err != nil {
+		return ErrWrongPassword
+	}
+
+	hashedPassword, err := Hash(newPassword)
+	if err != nil {
+		return err
+	}
+
+	user.Password = string(hashedPassword)
+	user.PasswordResetRequired = false
+	return nil
+}
+
+// setString -> writes the value unless it's empty, like gorm's Updates with a struct
+func setString(field *string, value string) {
+	if value != "" {
+		*field = value
+	}
+}
@@ -0,0 +1,257 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// MaxSearchTerms -> the most words a search can have, each one is a condition of the query
+const MaxSearchTerms = 8
+
+// TypoSimilarity -> how close a misspelt word has to be to a word of a product to match it, pg_trgm's default
+// word_similarity_threshold so both repositories agree
+const TypoSimilarity = 0.6
+
+// Weights of the fields searched, the ones ts_rank gives to the A, B, C and D labels of setweight
+const (
+	weightProductName        = 1.0
+	weightShopName           = 0.4
+	weightProductDescription = 0.2
+	weightShopDescription    = 0.1
+)
+
+// ProductMatch -> a product found by a search and how well it matched, higher is better
+type ProductMatch struct {
+	Product
+	Rank float64 `json:"rank"`
+}
+
+// ShopMatch -> a shop found by a search and how well it matched, higher is better
+type ShopMatch struct {
+	Shop
+	Rank float64 `json:"rank"`
+}
+
+// SearchResults -> the products and the shops a search found, each best match first
+type SearchResults struct {
+	Products []ProductMatch `json:"products"`
+	Shops    []ShopMatch    `json:"shops"`
+}
+
+// SearchQuery -> the words to look for and the filters of a search. Shops are only filtered by shop_id, the other
+// filters are about products
+type SearchQuery struct {
+	Terms    []string
+	InSale   *bool
+	MaxPrice *float64
+	ShopID   uuid.UUID
+	Limit    int
+}
+
+// ParseSearchQuery -> reads q, is_in_sale, max_price, shop_id and limit from a query string. q is required, max_price is
+// compared with the price once the sale discount is applied
+func ParseSearchQuery(values url.Values) (SearchQuery, error) {
+
+	query := SearchQuery{Terms: searchTerms(values.Get("q")), Limit: DefaultListLimit}
+
+	if len(query.Terms) == 0 {
+		return SearchQuery{}, errors.New("Required search query q")
+	}
+
+	if len(query.Terms) > MaxSearchTerms {
+		return SearchQuery{}, fmt.Errorf("Invalid search query, expected at most %d words", MaxSearchTerms)
+	}
+
+	if raw := values.Get("is_in_sale"); raw != "" {
+		inSale, err := strconv.ParseBool(raw)
+		if err != nil {
+			return SearchQuery{}, errors.New("Invalid value for is_in_sale")
+		}
+		query.InSale = &inSale
+	}
+
+	if raw := values.Get("max_price"); raw != "" {
+		maxPrice, err := strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(maxPrice) || maxPrice < 0 {
+			return SearchQuery{}, errors.New("Invalid value for max_price")
+		}
+		query.MaxPrice = &maxPrice
+	}
+
+	if raw := values.Get("shop_id"); raw != "" {
+		shopID, err := uuid.FromString(raw)
+		if err != nil {
+			return SearchQuery{}, errors.New("Invalid value for shop_id")
+		}
+		query.ShopID = shopID
+	}
+
+	if raw := values.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit <= 0 || limit > MaxListLimit {
+			return SearchQuery{}, fmt.Errorf("Invalid limit, expected a number between 1 and %d", MaxListLimit)
+		}
+		query.Limit = limit
+	}
+
+	return query, nil
+}
+
+// withDefaults -> fills what a query built in code left out
+func (query SearchQuery) withDefaults() SearchQuery {
+	if query.Limit <= 0 || query.Limit > MaxListLimit {
+		query.Limit = DefaultListLimit
+	}
+	return query
+}
+
+// keepsShop -> the shop passes the filters of the query
+func (query SearchQuery) keepsShop(shop *Shop) bool {
+	return query.ShopID == uuid.Nil || shop.ID == query.ShopID
+}
+
+// keeps -> the product passes the filters of the query
+func (query SearchQuery) keeps(product *Product) bool {
+
+	if query.InSale != nil && product.InSale != *query.InSale {
+		return false
+	}
+
+	if query.MaxPrice != nil {
+		// Through its shortest decimal form, like the database stores it
+		price, _ := strconv.ParseFloat(strconv.FormatFloat(float64(product.DiscountedPrice()), 'g', -1, 32), 64)
+		if price > *query.MaxPrice {
+			return false
+		}
+	}
+
+	return query.ShopID == uuid.Nil || product.ShopID == query.ShopID
+}
+
+// searchTerms -> the words of a text in lower case, anything but letters and digits separates them like in the
+// 'simple' text search configuration of Postgres
+func searchTerms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// trigrams -> the trigrams pg_trgm makes of a word, padded with two spaces in front and one behind, in order
+func trigrams(word string) []string {
+
+	runes := []rune("  " + word + " ")
+	grams := make([]string, 0, len(runes)-2)
+	for i := 0; i+3 <= len(runes); i++ {
+		grams = append(grams, string(runes[i:i+3]))
+	}
+	return grams
+}
+
+// wordSimilarity -> pg_trgm's word_similarity of a term and a word: the best share of trigrams they have in common over
+// the runs of consecutive trigrams of the word, so a term close to part of the word scores high too
+func wordSimilarity(term, word string) float64 {
+
+	termGrams := map[string]bool{}
+	for _, gram := range trigrams(term) {
+		termGrams[gram] = true
+	}
+
+	wordGrams := trigrams(word)
+	best := 0.0
+	for start := range wordGrams {
+		extent := map[string]bool{}
+		common := 0
+		for _, gram := range wordGrams[start:] {
+			if !extent[gram] {
+				extent[gram] = true
+				if termGrams[gram] {
+					common++
+				}
+			}
+
+			similarity := float64(common) / float64(len(termGrams)+len(extent)-common)
+			if similarity > best {
+				best = similarity
+			}
+		}
+	}
+
+	return best
+}
+
+// tsQuery -> the terms as a Postgres tsquery matching the words any of them starts. Terms are letters and digits only,
+// nothing in them needs escaping
+func tsQuery(terms []string) string {
+
+	prefixes := make([]string, len(terms))
+	for i, term := range terms {
+		prefixes[i] = term + ":*"
+	}
+	return strings.Join(prefixes, " | ")
+}
+
+// The documents and texts of products and shops searched in Postgres. The migration indexes the same expressions,
+// they have to be changed together
+const (
+	productDocument = `(setweight(to_tsvector('simple', coalesce(products.name, '') || ' ' || coalesce(products.code, '')), 'A') || setweight(to_tsvector('simple', coalesce(products.description, '')), 'C'))`
+	shopDocument    = `(setweight(to_tsvector('simple', coalesce(shops.name, '')), 'B') || setweight(to_tsvector('simple', coalesce(shops.description, '')), 'D'))`
+	productText     = `(coalesce(products.name, '') || ' ' || coalesce(products.code, '') || ' ' || coalesce(products.description, ''))`
+	shopText        = `(coalesce(shops.name, '') || ' ' || coalesce(shops.description, ''))`
+
+	// Ranks of the products and the shops found, their text search rank with a little for how close the words are
+	productRank = `ts_rank(` + productDocument + ` || ` + shopDocument + `, to_tsquery('simple', ?)) + 0.1 * GREATEST(word_similarity(?, ` + productText + `), word_similarity(?, ` + shopText + `))`
+	shopRank    = `ts_rank(` + shopDocument + `, to_tsquery('simple', ?)) + 0.1 * word_similarity(?, ` + shopText + `)`
+
+	// discountedPrice -> Product.DiscountedPrice in SQL
+	discountedPrice = `(CASE WHEN products.in_sale AND products.discount > 0 AND products.discount_unit IN ('percent', 'amount') THEN GREATEST(0, ROUND((CASE WHEN products.discount_unit = 'percent' THEN products.price * (100 - products.discount) / 100 ELSE products.price - products.discount / 100.0 END)::numeric, 2)) ELSE products.price END)`
+)
+
+// searchBranch -> one of the queries of the ids a term matches, each has a single condition one index of the migration
+// answers
+type searchBranch struct {
+	query  string
+	prefix bool // The term is looked up as a tsquery prefix rather than as a word for pg_trgm
+}
+
+// The ways a term matches a product, its own words or those of its shop, and a shop
+var (
+	productBranches = []searchBranch{
+		{query: `SELECT id FROM products WHERE ` + productDocument + ` @@ to_tsquery('simple', ?)`, prefix: true},
+		{query: `SELECT id FROM products WHERE ? <% ` + productText},
+		{query: `SELECT products.id FROM products JOIN shops ON shops.id = products.shop_id WHERE ` + shopDocument + ` @@ to_tsquery('simple', ?)`, prefix: true},
+		{query: `SELECT products.id FROM products JOIN shops ON shops.id = products.shop_id WHERE ? <% ` + shopText},
+	}
+	shopBranches = []searchBranch{
+		{query: `SELECT id FROM shops WHERE ` + shopDocument + ` @@ to_tsquery('simple', ?)`, prefix: true},
+		{query: `SELECT id FROM shops WHERE ? <% ` + shopText},
+	}
+)
+
+// matchingIDs -> the SQL of the ids matching every term and its values: the UNION of the branches for each term, the
+// INTERSECT of the terms. ORing the conditions in one WHERE instead would keep Postgres from using the indexes
+func matchingIDs(terms []string, branches []searchBranch) (string, []interface{}) {
+
+	matches := make([]string, len(terms))
+	values := []interface{}{}
+	for i, term := range terms {
+		queries := make([]string, len(branches))
+		for j, branch := range branches {
+			queries[j] = branch.query
+			if branch.prefix {
+				values = append(values, term+":*")
+			} else {
+				values = append(values, term)
+			}
+		}
+		matches[i] = "(" + strings.Join(queries, " UNION ") + ")"
+	}
+
+	return strings.Join(matches, " INTERSECT "), values
+}
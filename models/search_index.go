@@ -0,0 +1,220 @@
+package models
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+
+	uuid "github.com/satori/go.uuid"
+)
+
+// searchIndex -> inverted indexes of the words of products and of shops, what MemoryStore searches instead of the text
+// search of Postgres. Products and shops are indexed apart like in the migration, products and shops are their index in
+// the store
+type searchIndex struct {
+	products wordIndex
+	shops    wordIndex
+	sells    map[int][]int // The live products of each shop
+}
+
+// wordIndex -> the postings of each word of some documents, and the words in order
+type wordIndex struct {
+	postings map[string][]posting
+	words    []string
+}
+
+// posting -> a document having the word in one of its fields, weighted like the field
+type posting struct {
+	document int
+	weight   float64
+}
+
+// newSearchIndex -> the index of the live shops and of their live products
+func newSearchIndex(products []Product, shops []Shop) *searchIndex {
+
+	index := &searchIndex{
+		products: wordIndex{postings: map[string][]posting{}},
+		shops:    wordIndex{postings: map[string][]posting{}},
+		sells:    map[int][]int{},
+	}
+
+	live := map[uuid.UUID]int{}
+	for i, shop := range shops {
+		if shop.DeletedAt != nil {
+			continue
+		}
+
+		live[shop.ID] = i
+		index.shops.add(i, shop.Name, weightShopName)
+		index.shops.add(i, shop.Description, weightShopDescription)
+	}
+
+	for i, product := range products {
+		if product.DeletedAt != nil {
+			continue
+		}
+
+		shop, ok := live[product.ShopID]
+		if !ok {
+			continue
+		}
+
+		index.sells[shop] = append(index.sells[shop], i)
+		index.products.add(i, product.Name+" "+product.Code, weightProductName)
+		index.products.add(i, product.Description, weightProductDescription)
+	}
+
+	index.products.sortWords()
+	index.shops.sortWords()
+	return index
+}
+
+// add -> indexes the words of one field of the document, a word found in several fields keeps its best weight
+func (index *wordIndex) add(document int, text string, weight float64) {
+	for _, word := range searchTerms(text) {
+		postings := index.postings[word]
+		if n := len(postings); n > 0 && postings[n-1].document == document {
+			if weight > postings[n-1].weight {
+				postings[n-1].weight = weight
+			}
+			continue
+		}
+		index.postings[word] = append(postings, posting{document: document, weight: weight})
+	}
+}
+
+// sortWords -> lists the words indexed in order, for lookup to find the ones a term starts
+func (index *wordIndex) sortWords() {
+	for word := range index.postings {
+		index.words = append(index.words, word)
+	}
+	sort.Strings(index.words)
+}
+
+// lookup -> the best weight each document matches the term with. Words the term starts count fully, words it is a typo
+// away from count for half their similarity
+func (index *wordIndex) lookup(term string) map[int]float64 {
+
+	scores := map[int]float64{}
+	keep := func(word string, factor float64) {
+		for _, posting := range index.postings[word] {
+			if score := posting.weight * factor; score > scores[posting.document] {
+				scores[posting.document] = score
+			}
+		}
+	}
+
+	first := sort.SearchStrings(index.words, term)
+	for i := first; i < len(index.words) && strings.HasPrefix(index.words[i], term); i++ {
+		keep(index.words[i], 1)
+	}
+
+	for _, word := range index.words {
+		if strings.HasPrefix(word, term) {
+			continue
+		}
+
+		similarity := wordSimilarity(term, word)
+		if similarity >= TypoSimilarity {
+			keep(word, similarity/2)
+		}
+	}
+
+	return scores
+}
+
+// lookupProducts -> the products the term matches, through their own words or those of their shop like the UNION of
+// SearchProducts
+func (index *searchIndex) lookupProducts(term string) map[int]float64 {
+
+	scores := index.products.lookup(term)
+	for shop, score := range index.shops.lookup(term) {
+		for _, product := range index.sells[shop] {
+			if score > scores[product] {
+				scores[product] = score
+			}
+		}
+	}
+	return scores
+}
+
+// matchAll -> the documents every term matches, ranked by their average score
+func matchAll(terms []string, lookup func(term string) map[int]float64) map[int]float64 {
+
+	var ranks map[int]float64
+	for _, term := range terms {
+		scores := lookup(term)
+		if ranks == nil {
+			ranks = scores
+			continue
+		}
+
+		for document := range ranks {
+			score, ok := scores[document]
+			if !ok {
+				delete(ranks, document)
+				continue
+			}
+			ranks[document] += score
+		}
+	}
+
+	for document := range ranks {
+		ranks[document] /= float64(len(terms))
+	}
+	return ranks
+}
+
+// bestFirst -> orders matches by rank, then by id like the database does on ties
+func bestFirst(rank func(i int) float64, id func(i int) uuid.UUID) func(a, b int) bool {
+	return func(a, b int) bool {
+		if rank(a) != rank(b) {
+			return rank(a) > rank(b)
+		}
+		return bytes.Compare(id(a).Bytes(), id(b).Bytes()) < 0
+	}
+}
+
+// searchProducts -> the products matching every term of the query and its filters, best match first
+func (index *searchIndex) searchProducts(query SearchQuery, products []Product) []ProductMatch {
+
+	query = query.withDefaults()
+	if len(query.Terms) == 0 {
+		return []ProductMatch{}
+	}
+
+	matches := []ProductMatch{}
+	for product, rank := range matchAll(query.Terms, index.lookupProducts) {
+		if query.keeps(&products[product]) {
+			matches = append(matches, ProductMatch{Product: products[product], Rank: rank})
+		}
+	}
+
+	sort.Slice(matches, bestFirst(func(i int) float64 { return matches[i].Rank }, func(i int) uuid.UUID { return matches[i].ID }))
+	if len(matches) > query.Limit {
+		matches = matches[:query.Limit]
+	}
+	return matches
+}
+
+// searchShops -> the shops matching every term of the query and its shop filter, best match first
+func (index *searchIndex) searchShops(query SearchQuery, shops []Shop) []ShopMatch {
+
+	query = query.withDefaults()
+	if len(query.Terms) == 0 {
+		return []ShopMatch{}
+	}
+
+	matches := []ShopMatch{}
+	for shop, rank := range matchAll(query.Terms, index.shops.lookup) {
+		if query.keepsShop(&shops[shop]) {
+			matches = append(matches, ShopMatch{Shop: shops[shop], Rank: rank})
+		}
+	}
+
+	sort.Slice(matches, bestFirst(func(i int) float64 { return matches[i].Rank }, func(i int) uuid.UUID { return matches[i].ID }))
+	if len(matches) > query.Limit {
+		matches = matches[:query.Limit]
+	}
+	return matches
+}
@@ -2,23 +2,28 @@ package models
 
 import (
 	"errors"
+	"reflect"
 	"strings"
 
+	"github.com/amaraliou/stakeout/geocoding"
 	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
 )
 
 // Shop -> Struct to hold shop information (try to figure out how to handle shop reg.)
 type Shop struct {
 	Base
-	Name        string  `json:"name"`
-	Logo        string  `json:"logo_link"` // Amazon S3
-	Latitude    float64 `json:"latitude"`
-	Longitude   float64 `json:"longitude"`
-	Description string  `json:"description"`
+	Name              string  `json:"name"`
+	Logo              string  `json:"logo_link"` // Amazon S3
+	Latitude          float64 `json:"latitude"`
+	Longitude         float64 `json:"longitude"`
+	Description       string  `json:"description"`
+	PointsRate        float32 `json:"points_rate"` // Value of one loyalty point in the shop's currency, 0 disables redemption
+	TwoFactorRequired bool    `json:"require_2fa"` // Set by superusers, the shop's admins can't use it until they enable two-factor authentication
 	ShopAddress
 }
 
-// Validate ...
+// Validate -> checks the fields required for the action, and puts the postcode in its normal form
 func (shop *Shop) Validate(action string) error {
 	switch strings.ToLower(action) {
 	case "create":
@@ -46,16 +51,47 @@ func (shop *Shop) Validate(action string) error {
 			return errors.New("Required town or city")
 		}
 
-		return nil
+		if shop.PointsRate < 0 {
+			return errors.New("Invalid points rate")
+		}
+
+		return shop.normalisePostcode()
 
 	default:
+		if shop.PointsRate < 0 {
+			return errors.New("Invalid points rate")
+		}
+
+		if shop.Postcode != "" {
+			return shop.normalisePostcode()
+		}
+
 		return nil
 	}
 }
 
+func (shop *Shop) normalisePostcode() error {
+
+	postcode, err := geocoding.NormalisePostcode(shop.Postcode)
+	if err != nil {
+		return errors.New("Invalid shop postcode")
+	}
+
+	shop.Postcode = postcode
+	return nil
+}
+
+// HasLocation -> false for shops left at 0,0, which is where those whose postcode the directory didn't know end up
+func (shop *Shop) HasLocation() bool {
+	return shop.Latitude != 0 || shop.Longitude != 0
+}
+
 // CreateShop ...
 func (shop *Shop) CreateShop(db *gorm.DB) (*Shop, error) {
 
+	// Only superusers require two-factor authentication, through SetTwoFactorRequired
+	shop.TwoFactorRequired = false
+
 	err := db.Debug().Create(&shop).Error
 	if err != nil {
 		return &Shop{}, err
@@ -64,16 +100,115 @@ func (shop *Shop) CreateShop(db *gorm.DB) (*Shop, error) {
 	return shop, nil
 }
 
-// FindAllShops ...
-func (shop *Shop) FindAllShops(db *gorm.DB) (*[]Shop, error) {
+// ShopList -> what shop lists can be sorted and filtered on
+var ShopList = ListSpec{
+	Fields: map[string]ListField{
+		"created_at":   {Column: "created_at", Field: "CreatedAt", Kind: FieldTime, Sort: true},
+		"name":         {Column: "name", Field: "Name", Kind: FieldString, Sort: true, Filter: true},
+		"points_rate":  {Column: "points_rate", Field: "PointsRate", Kind: FieldNumber, Sort: true},
+		"town_or_city": {Column: "town_or_city", Field: "TownOrCity", Kind: FieldString, Sort: true, Filter: true},
+		"postcode":     {Column: "postcode", Field: "Postcode", Kind: FieldString, Filter: true},
+		"require_2fa":  {Column: "two_factor_required", Field: "TwoFactorRequired", Kind: FieldBool, Filter: true},
+	},
+	DefaultSort: "created_at",
+}
+
+// ListShops -> a page of shops and the cursor to the next one, empty on the last page
+func (shop *Shop) ListShops(db *gorm.DB, query ListQuery) (*[]Shop, string, error) {
+
+	shops := []Shop{}
+	err := query.scope(db.Debug().Model(&Shop{}), ShopList).Find(&shops).Error
+	if err != nil {
+		return &[]Shop{}, "", err
+	}
+
+	count, next := query.nextPage(reflect.ValueOf(shops), ShopList)
+	shops = shops[:count]
+	return &shops, next, nil
+}
+
+// FindShopsNearby -> the shops within the radius of the query's location, nearest first. Shops without a location are left
+// out, they would otherwise show up around 0,0
+func (shop *Shop) FindShopsNearby(db *gorm.DB, query NearbyQuery) (*[]NearbyShop, error) {
+
+	shops := []Shop{}
+	err := query.box().scope(db.Debug().Model(&Shop{})).Where("latitude <> 0 OR longitude <> 0").Find(&shops).Error
+	if err != nil {
+		return &[]NearbyShop{}, err
+	}
+
+	nearby := query.nearest(shops)
+	return &nearby, nil
+}
+
+// SearchShops -> the live shops matching every term of the query in their name or description, best match first. Terms
+// match like in SearchProducts
+func (shop *Shop) SearchShops(db *gorm.DB, query SearchQuery) (*[]ShopMatch, error) {
+
+	query = query.withDefaults()
+	if len(query.Terms) == 0 {
+		return &[]ShopMatch{}, nil
+	}
+
+	words := strings.Join(query.Terms, " ")
+	matching, values := matchingIDs(query.Terms, shopBranches)
+	search := db.Debug().Table("shops").
+		Select("shops.id, "+shopRank+" AS search_rank", tsQuery(query.Terms), words).
+		Where("shops.deleted_at IS NULL").
+		Where("shops.id IN ("+matching+")", values...)
+
+	if query.ShopID != uuid.Nil {
+		search = search.Where("shops.id = ?", query.ShopID)
+	}
+
+	rows, err := search.Order("search_rank DESC").Order("shops.id").Limit(query.Limit).Rows()
+	if err != nil {
+		return &[]ShopMatch{}, err
+	}
+	defer rows.Close()
+
+	order := []uuid.UUID{}
+	ids := []string{}
+	ranks := map[uuid.UUID]float64{}
+	for rows.Next() {
+		var id uuid.UUID
+		var rank float64
+		err = rows.Scan(&id, &rank)
+		if err != nil {
+			return &[]ShopMatch{}, err
+		}
+
+		order = append(order, id)
+		ids = append(ids, id.String())
+		ranks[id] = rank
+	}
+
+	err = rows.Err()
+	if err != nil || len(ids) == 0 {
+		return &[]ShopMatch{}, err
+	}
 
 	shops := []Shop{}
-	err := db.Debug().Model(&Shop{}).Limit(100).Find(&shops).Error
+	err = db.Debug().Model(&Shop{}).Where("id IN (?)", ids).Find(&shops).Error
 	if err != nil {
-		return &[]Shop{}, err
+		return &[]ShopMatch{}, err
 	}
 
-	return &shops, nil
+	// Back in the order of the search
+	found := map[uuid.UUID]Shop{}
+	for _, match := range shops {
+		found[match.ID] = match
+	}
+
+	matches := make([]ShopMatch, 0, len(order))
+	for _, id := range order {
+		match, ok := found[id]
+		if ok {
+			matches = append(matches, ShopMatch{Shop: match, Rank: ranks[id]})
+		}
+	}
+
+	return &matches, nil
 }
 
 // FindShopByID ...
@@ -94,7 +229,11 @@ func (shop *Shop) FindShopByID(db *gorm.DB, id string) (*Shop, error) {
 // UpdateShop ...
 func (shop *Shop) UpdateShop(db *gorm.DB, id string) (*Shop, error) {
 
-	err := db.Debug().Model(Shop{}).Updates(&shop).Error
+	// Only superusers require two-factor authentication, through SetTwoFactorRequired
+	shop.TwoFactorRequired = false
+
+	// Updates skips zero values, the points rate goes through SetPointsRate so redemption can be disabled
+	err := db.Debug().Model(&Shop{}).Where("id = ?", id).Omit("points_rate").Updates(&shop).Error
 	if err != nil {
 		return &Shop{}, err
 	}
@@ -102,6 +241,36 @@ func (shop *Shop) UpdateShop(db *gorm.DB, id string) (*Shop, error) {
 	return shop.FindShopByID(db, id)
 }
 
+// SetTwoFactorRequired -> Function to make two-factor authentication mandatory, or not, for the admins of a shop
+func (shop *Shop) SetTwoFactorRequired(db *gorm.DB, id string, required bool) (*Shop, error) {
+
+	result := db.Debug().Model(&Shop{}).Where("id = ?", id).UpdateColumn("two_factor_required", required)
+	if result.Error != nil {
+		return &Shop{}, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return &Shop{}, errors.New("Shop not found")
+	}
+
+	return shop.FindShopByID(db, id)
+}
+
+// SetPointsRate -> Function to change what one loyalty point is worth at the shop, 0 disables redemption
+func (shop *Shop) SetPointsRate(db *gorm.DB, id string, rate float32) (*Shop, error) {
+
+	result := db.Debug().Model(&Shop{}).Where("id = ?", id).Update("points_rate", rate)
+	if result.Error != nil {
+		return &Shop{}, result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return &Shop{}, errors.New("Shop not found")
+	}
+
+	return shop.FindShopByID(db, id)
+}
+
 // DeleteShop ...
 func (shop *Shop) DeleteShop(db *gorm.DB, id string) (int64, error) {
 
@@ -2,7 +2,7 @@ package models
 
 import (
 	"errors"
-	"log"
+	"reflect"
 	"strings"
 
 	"github.com/badoux/checkmail"
@@ -20,15 +20,24 @@ type Token struct {
 
 // User -> Struct to hold basic user information
 type User struct {
-	Email      string `json:"email" gorm:"unique;not null"` // to add  gorm:"unique;not null"
-	Password   string `json:"password"`
-	IsVerified bool   `json:"verified"`
+	Email                 string `json:"email" gorm:"unique;not null"` // to add  gorm:"unique;not null"
+	Password              string `json:"password"`
+	IsVerified            bool   `json:"verified"`
+	PasswordResetRequired bool   `json:"-"` // Set by FlagUnusablePasswords, cleared by a password reset or change
+
+	storedPassword string // The hash Password held when read from or written to the database, see hashPassword
+}
+
+// AfterFind -> remembers the stored hash, so saving the account again doesn't hash it twice
+func (user *User) AfterFind() error {
+	user.storedPassword = user.Password
+	return nil
 }
 
 // Student -> struct to hold all the User information
 type Student struct {
 	Base
-	User                  // Student Email to be verified (possibly use SheerID)
+	User                  // Email verified through EmailToken, IsStudent and University through the verification package
 	IsStudent      bool   `json:"is_student"`
 	FirstName      string `json:"first_name"`
 	LastName       string `json:"last_name"`
@@ -37,7 +46,7 @@ type Student struct {
 	MobileNumber   string `json:"mobile_number"`
 	CountryCode    string `json:"country"`
 	GraduationYear int    `json:"grad_year"`
-	Points         int    `json:"points"`
+	Points         int    `json:"points"` // Balance of the points ledger, see PointsEntry
 }
 
 // Hash -> Generate hash for given password
@@ -50,20 +59,21 @@ func VerifyPassword(hashedPassword, password string) error {
 	return bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 }
 
-// BeforeSave will check hashes for passwords
+// BeforeSave will hash passwords, only the hash read from the database is left alone
 func (student *Student) BeforeSave() error {
-	hashedPassword, err := Hash(student.Password)
-	if err != nil {
-		return err
-	}
-	student.Password = string(hashedPassword)
-	return nil
+	return student.hashPassword()
 }
 
 // Validate will validate the entries of the given student
 func (student *Student) Validate(action string) error {
 	switch strings.ToLower(action) {
 	case "update":
+		if student.Email != "" {
+			if err := checkmail.ValidateFormat(student.Email); err != nil {
+				return errors.New("Invalid Email")
+			}
+		}
+
 		if student.CountryCode != "" && student.MobileNumber != "" {
 			if _, err := phonenumbers.Parse(student.MobileNumber, student.CountryCode); err != nil {
 				return errors.New("Phone number ain't valid")
@@ -128,16 +138,32 @@ func (student *Student) CreateStudent(db *gorm.DB) (*Student, error) {
 	return student, nil
 }
 
-// FindAllStudents -> Function to retrieve all students
-func (student *Student) FindAllStudents(db *gorm.DB) (*[]Student, error) {
+// StudentList -> what student lists can be sorted and filtered on
+var StudentList = ListSpec{
+	Fields: map[string]ListField{
+		"created_at": {Column: "created_at", Field: "CreatedAt", Kind: FieldTime, Sort: true},
+		"first_name": {Column: "first_name", Field: "FirstName", Kind: FieldString, Sort: true, Filter: true},
+		"last_name":  {Column: "last_name", Field: "LastName", Kind: FieldString, Sort: true, Filter: true},
+		"university": {Column: "university", Field: "University", Kind: FieldString, Sort: true, Filter: true},
+		"is_student": {Column: "is_student", Field: "IsStudent", Kind: FieldBool, Filter: true},
+		"grad_year":  {Column: "graduation_year", Field: "GraduationYear", Kind: FieldNumber, Sort: true, Filter: true},
+		"points":     {Column: "points", Field: "Points", Kind: FieldNumber, Sort: true},
+	},
+	DefaultSort: "created_at",
+}
+
+// ListStudents -> a page of students and the cursor to the next one, empty on the last page
+func (student *Student) ListStudents(db *gorm.DB, query ListQuery) (*[]Student, string, error) {
 
 	students := []Student{}
-	err := db.Debug().Model(&Student{}).Limit(100).Find(&students).Error
+	err := query.scope(db.Debug().Model(&Student{}), StudentList).Find(&students).Error
 	if err != nil {
-		return &[]Student{}, err
+		return &[]Student{}, "", err
 	}
 
-	return &students, nil
+	count, next := query.nextPage(reflect.ValueOf(students), StudentList)
+	students = students[:count]
+	return &students, next, nil
 }
 
 // FindStudentByID -> Function to retrieve a student given its ID
@@ -155,15 +181,74 @@ func (student *Student) FindStudentByID(db *gorm.DB, id string) (*Student, error
 	return student, nil
 }
 
-// UpdateStudent -> Function to update a given student
+// FindStudentByEmail -> Function to find the student with the given email, the one lookup logins and emailed links need
+func (student *Student) FindStudentByEmail(db *gorm.DB, email string) (*Student, error) {
+
+	err := db.Debug().Model(Student{}).Where("email = ?", email).Take(&student).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return &Student{}, ErrEmailNotFound
+	}
+
+	if err != nil {
+		return &Student{}, err
+	}
+
+	return student, nil
+}
+
+// UpdateStudent -> Function to update a given student. A new email has to be verified again, and the student status
+// found from the old one goes until it is
 func (student *Student) UpdateStudent(db *gorm.DB, id string) (*Student, error) {
 
-	err := student.BeforeSave()
+	current, err := (&Student{}).FindStudentByID(db, id)
 	if err != nil {
-		log.Fatal(err)
+		return &Student{}, err
+	}
+
+	// Passwords are only changed through UpdatePassword and password resets
+	student.Password = ""
+
+	// Points are derived from the points ledger, never set directly
+	student.Points = 0
+
+	// Emails are only verified through VerifyEmail
+	student.IsVerified = false
+
+	// Student status only comes from UpdateStudentStatus
+	student.IsStudent = false
+	student.University = ""
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Debug().Model(&Student{}).Where("id = ?", id).Omit("password").Updates(&student).Error
+		if err != nil || student.Email == "" || student.Email == current.Email {
+			return err
+		}
+
+		// Updates skips zero values, the columns are cleared on their own
+		return tx.Debug().Model(&Student{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{"is_verified": false, "is_student": false, "university": ""}).Error
+	})
+	if err != nil {
+		return &Student{}, err
 	}
 
-	err = db.Debug().Model(Student{}).Updates(&student).Error
+	return student.FindStudentByID(db, id)
+}
+
+// UpdatePassword -> Function to change the password of a student who knows the current one
+func (student *Student) UpdatePassword(db *gorm.DB, id, currentPassword, newPassword string) error {
+
+	current, err := student.FindStudentByID(db, id)
+	if err != nil {
+		return err
+	}
+
+	return changePassword(db, &Student{}, id, current.Password, currentPassword, newPassword)
+}
+
+// UpdateStudentStatus -> Function to record the outcome of a student status check
+func (student *Student) UpdateStudentStatus(db *gorm.DB, id string, isStudent bool, university string) (*Student, error) {
+
+	err := db.Debug().Model(&Student{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{"is_student": isStudent, "university": university}).Error
 	if err != nil {
 		return &Student{}, err
 	}
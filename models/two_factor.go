@@ -0,0 +1,227 @@
+package models
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/amaraliou/stakeout/auth"
+	"github.com/jinzhu/gorm"
+	uuid "github.com/satori/go.uuid"
+)
+
+// TwoFactor -> TOTP settings of an admin. The secret is set by EnrollTwoFactor and only used for logins once ConfirmTwoFactor enabled it
+type TwoFactor struct {
+	TOTPSecret       string `json:"-" gorm:"column:totp_secret"`
+	TwoFactorEnabled bool   `json:"two_factor_enabled"`
+	TOTPLastStep     int64  `json:"-" gorm:"column:totp_last_step"` // Step of the last code used, codes can't be replayed
+}
+
+// RecoveryCode -> Struct to hold a hashed single use code that stands in for a TOTP code when the authenticator is lost
+type RecoveryCode struct {
+	Base
+	AdminID  uuid.UUID  `json:"-" gorm:"index;not null"`
+	CodeHash string     `json:"-" gorm:"unique;not null"`
+	UsedAt   *time.Time `json:"-"`
+}
+
+// RecoveryCodeCount -> how many recovery codes an admin gets when enabling two-factor authentication
+const RecoveryCodeCount = 10
+
+var (
+	// ErrInvalidTwoFactorCode -> the code is neither the current TOTP code nor an unused recovery code
+	ErrInvalidTwoFactorCode = errors.New("Invalid two-factor code")
+	// ErrTwoFactorEnabled -> enrollment was asked for by an admin who already uses two-factor authentication
+	ErrTwoFactorEnabled = errors.New("Two-factor authentication is already enabled")
+	// ErrTwoFactorNotEnabled -> a code was checked for an admin who doesn't use two-factor authentication
+	ErrTwoFactorNotEnabled = errors.New("Two-factor authentication is not enabled")
+	// ErrTwoFactorRequired -> the admin's login needs a TOTP code on top of the password
+	ErrTwoFactorRequired = errors.New("Two-factor code required")
+	// ErrTwoFactorNotEnrolled -> a confirmation came before the enrollment
+	ErrTwoFactorNotEnrolled = errors.New("Two-factor enrollment not started")
+)
+
+// EnrollTwoFactor -> Function to give an admin a new TOTP secret, two-factor authentication stays off until ConfirmTwoFactor
+func (admin *Admin) EnrollTwoFactor(db *gorm.DB, id string) (string, error) {
+
+	current, err := admin.FindAdminByID(db, id)
+	if err != nil {
+		return "", err
+	}
+
+	if current.TwoFactorEnabled {
+		return "", ErrTwoFactorEnabled
+	}
+
+	secret, err := auth.NewTOTPSecret()
+	if err != nil {
+		return "", err
+	}
+
+	err = db.Debug().Model(&Admin{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{"totp_secret": secret, "totp_last_step": 0}).Error
+	if err != nil {
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// ConfirmTwoFactor -> Function to turn two-factor authentication on once the admin proved their authenticator has the secret.
+// Returns the recovery codes, they are only stored hashed so this is the one time they can be shown
+func (admin *Admin) ConfirmTwoFactor(db *gorm.DB, id, code string) ([]string, error) {
+
+	codes := []string{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		current := Admin{}
+		err := tx.Debug().Set("gorm:query_option", "FOR UPDATE").Model(&Admin{}).Where("id = ?", id).Take(&current).Error
+		if err != nil {
+			return err
+		}
+
+		if current.TwoFactorEnabled {
+			return ErrTwoFactorEnabled
+		}
+
+		if current.TOTPSecret == "" {
+			return ErrTwoFactorNotEnrolled
+		}
+
+		step, ok := auth.ValidateTOTP(current.TOTPSecret, code, time.Now())
+		if !ok {
+			return ErrInvalidTwoFactorCode
+		}
+
+		err = tx.Debug().Model(&Admin{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{"two_factor_enabled": true, "totp_last_step": step}).Error
+		if err != nil {
+			return err
+		}
+
+		codes, err = replaceRecoveryCodes(tx, current.ID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// CheckTwoFactor -> Function to check the second factor of an admin's login: a TOTP code not used before, or an unused recovery code which gets used up
+func (admin *Admin) CheckTwoFactor(db *gorm.DB, id, code string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		return admin.useTwoFactorCode(tx, id, code)
+	})
+}
+
+// useTwoFactorCode -> spends a TOTP or recovery code, meant to be called inside a transaction
+func (admin *Admin) useTwoFactorCode(tx *gorm.DB, id, code string) error {
+
+	current := Admin{}
+	err := tx.Debug().Set("gorm:query_option", "FOR UPDATE").Model(&Admin{}).Where("id = ?", id).Take(&current).Error
+	if err != nil {
+		return err
+	}
+
+	if !current.TwoFactorEnabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	step, ok := auth.ValidateTOTP(current.TOTPSecret, code, time.Now())
+	if ok && step > current.TOTPLastStep {
+		return tx.Debug().Model(&Admin{}).Where("id = ?", id).UpdateColumn("totp_last_step", step).Error
+	}
+
+	if ok {
+		return ErrInvalidTwoFactorCode
+	}
+
+	result := tx.Debug().Model(&RecoveryCode{}).Where("admin_id = ? AND code_hash = ? AND used_at IS NULL", id, auth.HashOpaqueToken(normalizeRecoveryCode(code))).UpdateColumn("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrInvalidTwoFactorCode
+	}
+	return nil
+}
+
+// DisableTwoFactor -> Function to turn two-factor authentication off, it takes a valid code like a login does
+func (admin *Admin) DisableTwoFactor(db *gorm.DB, id, code string) error {
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := admin.useTwoFactorCode(tx, id, code)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Debug().Model(&Admin{}).Where("id = ?", id).UpdateColumns(map[string]interface{}{"totp_secret": "", "two_factor_enabled": false, "totp_last_step": 0}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Debug().Where("admin_id = ?", id).Delete(&RecoveryCode{}).Error
+	})
+}
+
+// replaceRecoveryCodes -> drops the admin's recovery codes and stores the hashes of a new set
+func replaceRecoveryCodes(tx *gorm.DB, adminID uuid.UUID) ([]string, error) {
+
+	err := tx.Debug().Where("admin_id = ?", adminID).Delete(&RecoveryCode{}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	codes, recoveryCodes, err := newRecoveryCodes(adminID)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range recoveryCodes {
+		err = tx.Debug().Create(&recoveryCodes[i]).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return codes, nil
+}
+
+// newRecoveryCodes -> a new set of codes for the admin, and the rows storing their hashes
+func newRecoveryCodes(adminID uuid.UUID) ([]string, []RecoveryCode, error) {
+
+	codes := make([]string, 0, RecoveryCodeCount)
+	recoveryCodes := make([]RecoveryCode, 0, RecoveryCodeCount)
+	for i := 0; i < RecoveryCodeCount; i++ {
+		code, err := newRecoveryCode()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		codes = append(codes, code)
+		recoveryCodes = append(recoveryCodes, RecoveryCode{AdminID: adminID, CodeHash: auth.HashOpaqueToken(normalizeRecoveryCode(code))})
+	}
+
+	return codes, recoveryCodes, nil
+}
+
+// newRecoveryCode -> 80 random bits written as four groups of four, easy to copy down
+func newRecoveryCode() (string, error) {
+	b := make([]byte, 10)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	code := strings.ToLower(base32.StdEncoding.EncodeToString(b))
+	return code[0:4] + "-" + code[4:8] + "-" + code[8:12] + "-" + code[12:16], nil
+}
+
+// normalizeRecoveryCode -> recovery codes are accepted whatever their case and grouping
+func normalizeRecoveryCode(code string) string {
+	code = strings.Replace(code, "-", "", -1)
+	code = strings.Replace(code, " ", "", -1)
+	return strings.ToLower(code)
+}
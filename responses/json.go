@@ -27,3 +27,14 @@ func ERROR(w http.ResponseWriter, statusCode int, err error) {
 	}
 	JSON(w, http.StatusBadRequest, nil)
 }
+
+// PAGE -> a page of a list endpoint in its envelope, next_cursor is empty on the last page
+func PAGE(w http.ResponseWriter, data interface{}, nextCursor string) {
+	JSON(w, http.StatusOK, struct {
+		Data       interface{} `json:"data"`
+		NextCursor string      `json:"next_cursor"`
+	}{
+		Data:       data,
+		NextCursor: nextCursor,
+	})
+}
@@ -4,28 +4,168 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
+	"text/tabwriter"
 
+	"github.com/amaraliou/stakeout/auth"
+	"github.com/amaraliou/stakeout/common"
+	"github.com/amaraliou/stakeout/geocoding"
 	"github.com/amaraliou/stakeout/handlers"
+	"github.com/amaraliou/stakeout/mailer"
+	"github.com/amaraliou/stakeout/migrations"
+	"github.com/amaraliou/stakeout/models"
 	"github.com/amaraliou/stakeout/utils"
-	"github.com/joho/godotenv"
+	"github.com/amaraliou/stakeout/verification"
 )
 
 var server = handlers.Server{}
 
+// loadConfig -> loads the configuration and hands each package its part of it
+func loadConfig() *common.Config {
+
+	config, err := common.LoadConfig("")
+	if err != nil {
+		log.Fatalf("Cannot load the configuration %v", err)
+	}
+	log.Printf("Configuration:\n%s", config.Redacted())
+
+	err = auth.Configure(config.Auth)
+	if err != nil {
+		log.Fatalf("Cannot load the JWT signing keys %v", err)
+	}
+	models.Configure(config.Auth)
+
+	return config
+}
+
 // Run -> start server
 func Run() {
 
-	var err error
-	err = godotenv.Load()
+	config := loadConfig()
+
+	server.Mailer = mailer.FromConfig(config.SMTP)
+
+	registry, err := verification.LoadRegistry(config.UniversityRegistry)
+	if err != nil {
+		log.Fatalf("Cannot load the university registry %v", err)
+	}
+	server.Verifier = verification.DomainProvider{Registry: registry}
+
+	server.Postcodes, err = geocoding.LoadDirectory(config.PostcodeDirectory)
 	if err != nil {
-		log.Fatalf("Error getting env, not comming through %v", err)
-	} else {
-		fmt.Println("We are getting the env values")
+		log.Fatalf("Cannot load the postcode directory %v", err)
 	}
+	log.Printf("Loaded %d postcodes from %s", server.Postcodes.Len(), config.PostcodeDirectory)
+
+	server.Initialize(config.Database)
 
-	server.Initialize(os.Getenv("DB_USER"), os.Getenv("DB_PASSWORD"), os.Getenv("DB_PORT"), os.Getenv("DB_HOST"), os.Getenv("DB_NAME"))
+	migrateUp()
 
 	utils.Load(server.DB)
 
-	server.Run(":8080")
+	server.Run(config.Server.Addr())
+}
+
+// FlagPasswords -> one-off fix for passwords overwritten by profile updates, their owners have to reset them before logging in again
+func FlagPasswords() {
+
+	config := loadConfig()
+
+	server.Initialize(config.Database)
+
+	flagged, err := models.FlagUnusablePasswords(server.DB)
+	if err != nil {
+		log.Fatalf("Cannot flag the unusable passwords %v", err)
+	}
+	fmt.Printf("%d accounts have to reset their password\n", flagged)
+}
+
+// migrator -> the migrations compiled into the binary, for server.DB
+func migrator() *migrations.Migrator {
+
+	migrator, err := migrations.New(server.DB)
+	if err != nil {
+		log.Fatalf("Cannot load the migrations %v", err)
+	}
+	return migrator
+}
+
+// migrateUp -> applies the pending migrations to server.DB
+func migrateUp() {
+
+	applied, err := migrator().Up()
+	for _, migration := range applied {
+		fmt.Printf("Applied %04d_%s\n", migration.Version, migration.Name)
+	}
+	if err != nil {
+		log.Fatalf("Cannot migrate the database %v", err)
+	}
+}
+
+// Migrate -> the migrate subcommand: up, down [steps], status or create <name>
+func Migrate(args []string) {
+
+	command := ""
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	if command == "up" || command == "down" || command == "status" {
+		config := loadConfig()
+		server.Initialize(config.Database)
+	}
+
+	switch command {
+	case "up":
+		migrateUp()
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatalf("Invalid number of steps %q", args[1])
+			}
+		}
+
+		rolledBack, err := migrator().Down(steps)
+		for _, migration := range rolledBack {
+			fmt.Printf("Rolled back %04d_%s\n", migration.Version, migration.Name)
+		}
+		if err != nil {
+			log.Fatalf("Cannot roll back the database %v", err)
+		}
+
+	case "status":
+		statuses, err := migrator().Status()
+		if err != nil {
+			log.Fatalf("Cannot read the migrations status %v", err)
+		}
+
+		writer := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(writer, "VERSION\tNAME\tAPPLIED")
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied {
+				applied = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(writer, "%04d\t%s\t%s\n", status.Version, status.Name, applied)
+		}
+		writer.Flush()
+
+	case "create":
+		if len(args) < 2 {
+			log.Fatal("Usage: stakeout migrate create <name>")
+		}
+
+		up, down, err := migrations.Create(migrations.SourceDir, args[1])
+		if err != nil {
+			log.Fatalf("Cannot create the migration %v", err)
+		}
+		fmt.Printf("Created %s and %s, run go generate ./migrations once they are written\n", up, down)
+
+	default:
+		log.Fatal("Usage: stakeout migrate up|down [steps]|status|create <name>")
+	}
 }
@@ -108,14 +108,17 @@ func TestGetAdmins(t *testing.T) {
 	handler := http.HandlerFunc(server.GetAdmins)
 	handler.ServeHTTP(rr, req)
 
-	var admins []models.Admin
-	err = json.Unmarshal([]byte(rr.Body.String()), &admins)
+	var page struct {
+		Data       []models.Admin `json:"data"`
+		NextCursor string         `json:"next_cursor"`
+	}
+	err = json.Unmarshal([]byte(rr.Body.String()), &page)
 	if err != nil {
 		log.Fatalf("Cannot convert to json: %v\n", err)
 	}
 
 	assert.Equal(t, rr.Code, http.StatusOK)
-	assert.Equal(t, len(admins), 2)
+	assert.Equal(t, len(page.Data), 2)
 }
 
 func TestGetAdminByID(t *testing.T) {
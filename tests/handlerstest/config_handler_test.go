@@ -0,0 +1,162 @@
+package handlerstest
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amaraliou/stakeout/common"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+// setenv -> sets the variables for one test, the returned function puts the previous values back
+func setenv(variables map[string]string) func() {
+
+	previous := map[string]*string{}
+	for name, value := range variables {
+		if old, ok := os.LookupEnv(name); ok {
+			previous[name] = &old
+		} else {
+			previous[name] = nil
+		}
+
+		if value == "" {
+			os.Unsetenv(name)
+		} else {
+			os.Setenv(name, value)
+		}
+	}
+
+	return func() {
+		for name, old := range previous {
+			if old == nil {
+				os.Unsetenv(name)
+			} else {
+				os.Setenv(name, *old)
+			}
+		}
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "stakeout-config")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "config.yml")
+	err = ioutil.WriteFile(path, []byte(`
+server:
+  port: 9000
+database:
+  host: db.internal
+  user: apetitoso
+  name: apetitoso
+auth:
+  api_secret: file-secret
+  access_token_ttl: 15m
+`), 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	restore := setenv(map[string]string{
+		"CONFIG_FILE":      "",
+		"PORT":             "",
+		"DB_HOST":          "",
+		"DB_PORT":          "",
+		"DB_USER":          "",
+		"DB_NAME":          "",
+		"DB_PASSWORD":      "",
+		"DB_PASS":          "postgres",
+		"API_SECRET":       "",
+		"JWT_KEYS_DIR":     "",
+		"SMTP_HOST":        "",
+		"ACCESS_TOKEN_TTL": "",
+	})
+	defer restore()
+
+	// Defaults, then the file, then the environment
+	config, err := common.LoadConfig(path)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, config.Server.Addr(), ":9000")
+	assert.Equal(t, config.Database.Host, "db.internal")
+	assert.Equal(t, config.Database.Port, 5432)
+	assert.Equal(t, config.Database.Password, "postgres")
+	assert.Equal(t, config.Auth.AccessTokenTTL, 15*time.Minute)
+	assert.Equal(t, config.Auth.RefreshTokenTTL, 30*24*time.Hour)
+
+	os.Setenv("PORT", "9001")
+	os.Setenv("DB_PASSWORD", "secret")
+	os.Setenv("ACCESS_TOKEN_TTL", "30m")
+
+	config, err = common.LoadConfig(path)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, config.Server.Port, 9001)
+	assert.Equal(t, config.Database.Password, "secret")
+	assert.Equal(t, config.Auth.AccessTokenTTL, 30*time.Minute)
+
+	// Secrets never make it to the logs
+	redacted := config.Redacted()
+	assert.Equal(t, strings.Contains(redacted, "file-secret"), false)
+	assert.Equal(t, strings.Contains(redacted, "password: secret"), false)
+	assert.Equal(t, strings.Contains(redacted, "host: db.internal"), true)
+
+	samples := []struct {
+		name         string
+		value        string
+		errorMessage string
+	}{
+		{
+			name:         "PORT",
+			value:        "http",
+			errorMessage: `Invalid PORT: "http" is not a number`,
+		},
+		{
+			name:         "ACCESS_TOKEN_TTL",
+			value:        "an hour",
+			errorMessage: `Invalid ACCESS_TOKEN_TTL: "an hour" is not a duration like 30m or 24h`,
+		},
+		{
+			name:         "ACCESS_TOKEN_TTL",
+			value:        "-1h",
+			errorMessage: "Invalid configuration: access token lifetime must be positive",
+		},
+		{
+			name:         "PORT",
+			value:        "70000",
+			errorMessage: "Invalid configuration: server port must be between 1 and 65535",
+		},
+		{
+			name:         "SMTP_HOST",
+			value:        "smtp.example.com",
+			errorMessage: "Invalid configuration: SMTP sender is required (SMTP_FROM) when SMTP_HOST is set",
+		},
+	}
+
+	for _, v := range samples {
+		undo := setenv(map[string]string{v.name: v.value})
+		_, err = common.LoadConfig(path)
+		undo()
+
+		assert.NotEqual(t, err, nil)
+		if err != nil {
+			assert.Equal(t, err.Error(), v.errorMessage)
+		}
+	}
+
+	// Unknown keys in the file are mistakes, not something to ignore
+	err = ioutil.WriteFile(path, []byte("server:\n  prot: 9000\n"), 0600)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = common.LoadConfig(path)
+	assert.NotEqual(t, err, nil)
+}
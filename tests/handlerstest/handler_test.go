@@ -6,13 +6,19 @@ import (
 	"os"
 	"testing"
 
+	"github.com/amaraliou/stakeout/auth"
+	"github.com/amaraliou/stakeout/common"
 	"github.com/amaraliou/stakeout/handlers"
+	"github.com/amaraliou/stakeout/mailer"
+	"github.com/amaraliou/stakeout/migrations"
 	"github.com/amaraliou/stakeout/models"
+	"github.com/amaraliou/stakeout/verification"
 	"github.com/jinzhu/gorm"
 	"github.com/joho/godotenv"
 )
 
 var server = handlers.Server{}
+var mails = &mailer.Memory{}
 var studentInstance = models.Student{}
 
 func TestMain(m *testing.M) {
@@ -28,24 +34,49 @@ func TestMain(m *testing.M) {
 }
 
 func Database() {
-	var err error
-	DBURL := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable password=%s", os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_USER"), os.Getenv("DB_NAME"), os.Getenv("DB_PASSWORD"))
-	server.DB, err = gorm.Open("postgres", DBURL)
+	config, err := common.LoadConfig("")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = auth.Configure(config.Auth)
+	if err != nil {
+		log.Fatal(err)
+	}
+	models.Configure(config.Auth)
+
+	server.DB, err = gorm.Open("postgres", config.Database.DSN())
 	if err != nil {
 		fmt.Print("Cannot connect to Postgres database\n")
 		log.Fatal("This is the error:", err)
 	} else {
 		fmt.Print("We are connected to the Postgres database\n")
 	}
+
+	migrator, err := migrations.New(server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = migrator.Up()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	server.Repositories = models.GormRepositories(server.DB)
+	auth.SetRevoker(server.Sessions)
+	server.Mailer = mails
+	server.Verifier = verification.DomainProvider{Registry: verification.NewRegistry(nil)}
+	server.Throttle = auth.NewThrottle(models.AttemptStore{DB: server.DB})
 }
 
 func refreshEverything() error {
-	err := server.DB.DropTableIfExists(&models.Student{}, &models.Admin{}, &models.Product{}, &models.Shop{}, &models.Order{}).Error
+	err := server.DB.DropTableIfExists(&models.Student{}, &models.Admin{}, &models.Product{}, &models.Shop{}, &models.Order{}, &models.OrderLine{}, &models.OrderEvent{}, &models.PointsEntry{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.EmailToken{}, &models.LoginAttempt{}, &models.RecoveryCode{}).Error
 	if err != nil {
 		return err
 	}
 
-	err = server.DB.AutoMigrate(&models.Student{}, &models.Shop{}, &models.Admin{}, &models.Product{}, &models.Order{}).Error
+	err = server.DB.AutoMigrate(&models.Student{}, &models.Shop{}, &models.Admin{}, &models.Product{}, &models.Order{}, &models.OrderLine{}, &models.OrderEvent{}, &models.PointsEntry{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.EmailToken{}, &models.LoginAttempt{}, &models.RecoveryCode{}).Error
 	if err != nil {
 		return err
 	}
@@ -54,11 +85,11 @@ func refreshEverything() error {
 }
 
 func refreshStudentTable() error {
-	err := server.DB.DropTableIfExists(&models.Student{}).Error
+	err := server.DB.DropTableIfExists(&models.Student{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.EmailToken{}, &models.LoginAttempt{}, &models.RecoveryCode{}).Error
 	if err != nil {
 		return err
 	}
-	err = server.DB.AutoMigrate(&models.Student{}).Error
+	err = server.DB.AutoMigrate(&models.Student{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.EmailToken{}, &models.LoginAttempt{}, &models.RecoveryCode{}).Error
 	if err != nil {
 		return err
 	}
@@ -66,11 +97,11 @@ func refreshStudentTable() error {
 }
 
 func refreshAdminTable() error {
-	err := server.DB.DropTableIfExists(&models.Admin{}).Error
+	err := server.DB.DropTableIfExists(&models.Admin{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.EmailToken{}, &models.LoginAttempt{}, &models.RecoveryCode{}).Error
 	if err != nil {
 		return err
 	}
-	err = server.DB.AutoMigrate(&models.Admin{}).Error
+	err = server.DB.AutoMigrate(&models.Admin{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.EmailToken{}, &models.LoginAttempt{}, &models.RecoveryCode{}).Error
 	if err != nil {
 		return err
 	}
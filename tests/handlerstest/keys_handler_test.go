@@ -0,0 +1,228 @@
+package handlerstest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/amaraliou/stakeout/auth"
+	jwt "github.com/dgrijalva/jwt-go"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func writeKey(dir, kid string, private interface{}) error {
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return err
+	}
+
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	return ioutil.WriteFile(filepath.Join(dir, kid+".pem"), data, 0600)
+}
+
+func tokenHeader(token string) map[string]interface{} {
+
+	segment, err := jwt.DecodeSegment(strings.Split(token, ".")[0])
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	header := map[string]interface{}{}
+	err = json.Unmarshal(segment, &header)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return header
+}
+
+func TestKeyringRotation(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "stakeout-keys")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer auth.SetKeyring(nil)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = writeKey(dir, "2020-01", rsaKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keyring, err := auth.LoadKeyring(dir, "")
+	if err != nil {
+		t.Errorf("this is the error loading the keys: %v\n", err)
+		return
+	}
+	auth.SetKeyring(keyring)
+
+	studentID, _ := uuid.NewV4()
+	oldToken, err := auth.CreateToken(studentID, uuid.Nil)
+	if err != nil {
+		t.Errorf("this is the error creating the token: %v\n", err)
+		return
+	}
+
+	header := tokenHeader(oldToken)
+	assert.Equal(t, header["alg"], "RS256")
+	assert.Equal(t, header["kid"], "2020-01")
+
+	// A newer key signs from now on, tokens of the old one stay valid
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = writeKey(dir, "2020-06", edKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keyring, err = auth.LoadKeyring(dir, "")
+	if err != nil {
+		t.Errorf("this is the error loading the keys: %v\n", err)
+		return
+	}
+	auth.SetKeyring(keyring)
+
+	newToken, err := auth.CreateToken(studentID, uuid.Nil)
+	if err != nil {
+		t.Errorf("this is the error creating the token: %v\n", err)
+		return
+	}
+
+	header = tokenHeader(newToken)
+	assert.Equal(t, header["alg"], "EdDSA")
+	assert.Equal(t, header["kid"], "2020-06")
+
+	for _, token := range []string{oldToken, newToken} {
+		claims, err := auth.ParseToken(token)
+		assert.Equal(t, err, nil)
+		if claims != nil {
+			assert.Equal(t, claims.SubjectID, studentID)
+		}
+	}
+
+	// Retired keys drop out of the keyring with their file
+	err = os.Remove(filepath.Join(dir, "2020-01.pem"))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keyring, err = auth.LoadKeyring(dir, "")
+	if err != nil {
+		t.Errorf("this is the error loading the keys: %v\n", err)
+		return
+	}
+	auth.SetKeyring(keyring)
+
+	_, err = auth.ParseToken(oldToken)
+	assert.NotEqual(t, err, nil)
+
+	// HS256 tokens aren't accepted once a keyring is set
+	auth.SetKeyring(nil)
+	hmacToken, err := auth.CreateToken(studentID, uuid.Nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	auth.SetKeyring(keyring)
+
+	_, err = auth.ParseToken(hmacToken)
+	assert.NotEqual(t, err, nil)
+}
+
+func TestJWKS(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "stakeout-keys")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer auth.SetKeyring(nil)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = writeKey(dir, "rsa", rsaKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = writeKey(dir, "ed", edKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	keyring, err := auth.LoadKeyring(dir, "rsa")
+	if err != nil {
+		t.Errorf("this is the error loading the keys: %v\n", err)
+		return
+	}
+	auth.SetKeyring(keyring)
+
+	req, err := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v\n", err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(server.JWKS)
+	handler.ServeHTTP(rr, req)
+
+	jwks := struct {
+		Keys []auth.JWK `json:"keys"`
+	}{}
+	err = json.Unmarshal([]byte(rr.Body.String()), &jwks)
+	if err != nil {
+		t.Errorf("Cannot convert to json: %v", err)
+	}
+
+	assert.Equal(t, rr.Code, http.StatusOK)
+	assert.Equal(t, len(jwks.Keys), 2)
+	if len(jwks.Keys) == 2 {
+		assert.Equal(t, jwks.Keys[0].KeyID, "ed")
+		assert.Equal(t, jwks.Keys[0].KeyType, "OKP")
+		assert.Equal(t, jwks.Keys[0].Algorithm, "EdDSA")
+		assert.Equal(t, jwks.Keys[0].X != "", true)
+		assert.Equal(t, jwks.Keys[1].KeyID, "rsa")
+		assert.Equal(t, jwks.Keys[1].KeyType, "RSA")
+		assert.Equal(t, jwks.Keys[1].Algorithm, "RS256")
+		assert.Equal(t, jwks.Keys[1].E, "AQAB")
+	}
+
+	adminID, _ := uuid.NewV4()
+	token, err := auth.CreateAdminToken(adminID, uuid.Nil, uuid.Nil, auth.RoleShopOwner)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	header := tokenHeader(token)
+	assert.Equal(t, header["alg"], "RS256")
+	assert.Equal(t, header["kid"], "rsa")
+}
@@ -4,11 +4,15 @@ import (
 	"bytes"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
+	"github.com/amaraliou/stakeout/auth"
+	"github.com/amaraliou/stakeout/middlewares"
 	"gopkg.in/go-playground/assert.v1"
 )
 
@@ -37,12 +41,12 @@ func TestSignIn(t *testing.T) {
 		{
 			email:        student.Email,
 			password:     "Wrong password",
-			errorMessage: "crypto/bcrypt: hashedPassword is not the hash of the given password",
+			errorMessage: "Invalid credentials",
 		},
 		{
 			email:        "Wrong email",
 			password:     "password",
-			errorMessage: "record not found",
+			errorMessage: "Invalid credentials",
 		},
 	}
 
@@ -81,12 +85,12 @@ func TestLogIn(t *testing.T) {
 		{
 			inputJSON:    `{"email": "email@email.com", "password": "Wrong password"}`,
 			statusCode:   422,
-			errorMessage: "crypto/bcrypt: hashedPassword is not the hash of the given password",
+			errorMessage: "Invalid credentials",
 		},
 		{
 			inputJSON:    `{"email": "fail@email.com", "password": "password"}`,
 			statusCode:   422,
-			errorMessage: "record not found",
+			errorMessage: "Invalid credentials",
 		},
 		{
 			inputJSON:    `{"email": "failmail.com", "password": "password"}`,
@@ -132,3 +136,217 @@ func TestLogIn(t *testing.T) {
 		}
 	}
 }
+
+func logInTokens(inputJSON string) (map[string]interface{}, int) {
+
+	req, err := http.NewRequest("POST", "/login", bytes.NewBufferString(inputJSON))
+	if err != nil {
+		log.Fatalf("this is the error: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(server.Login)
+	handler.ServeHTTP(rr, req)
+
+	responseMap := make(map[string]interface{})
+	err = json.Unmarshal([]byte(rr.Body.String()), &responseMap)
+	if err != nil {
+		log.Fatalf("Cannot convert to json: %v", err)
+	}
+
+	return responseMap, rr.Code
+}
+
+func refreshTokens(refreshToken string) (map[string]interface{}, int) {
+
+	inputJSON := fmt.Sprintf(`{"refresh_token": "%s"}`, refreshToken)
+	req, err := http.NewRequest("POST", "/token/refresh", bytes.NewBufferString(inputJSON))
+	if err != nil {
+		log.Fatalf("this is the error: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(server.RefreshToken)
+	handler.ServeHTTP(rr, req)
+
+	responseMap := make(map[string]interface{})
+	err = json.Unmarshal([]byte(rr.Body.String()), &responseMap)
+	if err != nil {
+		log.Fatalf("Cannot convert to json: %v", err)
+	}
+
+	return responseMap, rr.Code
+}
+
+func authenticated(token string) int {
+
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		log.Fatalf("this is the error: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := middlewares.SetMiddlewareAuthentication(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
+	handler.ServeHTTP(rr, req)
+
+	return rr.Code
+}
+
+func TestRefreshToken(t *testing.T) {
+
+	err := refreshStudentTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	login, statusCode := logInTokens(`{"email": "email@email.com", "password": "password"}`)
+	assert.Equal(t, statusCode, 200)
+
+	refreshed, statusCode := refreshTokens(login["refresh_token"].(string))
+	assert.Equal(t, statusCode, 200)
+	assert.Equal(t, refreshed["refresh_token"] != login["refresh_token"], true)
+	assert.Equal(t, authenticated(refreshed["token"].(string)), 200)
+
+	// Replaying the rotated token revokes the whole session
+	replayed, statusCode := refreshTokens(login["refresh_token"].(string))
+	assert.Equal(t, statusCode, 401)
+	assert.Equal(t, replayed["error"], "Refresh token reuse detected")
+
+	_, statusCode = refreshTokens(refreshed["refresh_token"].(string))
+	assert.Equal(t, statusCode, 401)
+	assert.Equal(t, authenticated(refreshed["token"].(string)), 401)
+	assert.Equal(t, authenticated(login["token"].(string)), 401)
+
+	_, statusCode = refreshTokens("")
+	assert.Equal(t, statusCode, 422)
+}
+
+func TestLogout(t *testing.T) {
+
+	err := refreshStudentTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	login, statusCode := logInTokens(`{"email": "email@email.com", "password": "password"}`)
+	assert.Equal(t, statusCode, 200)
+	other, statusCode := logInTokens(`{"email": "email@email.com", "password": "password"}`)
+	assert.Equal(t, statusCode, 200)
+
+	req, err := http.NewRequest("POST", "/logout", nil)
+	if err != nil {
+		t.Errorf("this is the error: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := middlewares.SetMiddlewareAuthentication(server.Logout)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", login["token"]))
+	handler.ServeHTTP(rr, req)
+	assert.Equal(t, rr.Code, 204)
+
+	assert.Equal(t, authenticated(login["token"].(string)), 401)
+	_, statusCode = refreshTokens(login["refresh_token"].(string))
+	assert.Equal(t, statusCode, 401)
+
+	// Other sessions of the student stay logged in
+	assert.Equal(t, authenticated(other["token"].(string)), 200)
+}
+
+func TestLoginThrottle(t *testing.T) {
+
+	err := refreshStudentTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student, err := seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	now := time.Now()
+	throttle := server.Throttle
+	server.Throttle = auth.NewThrottle(auth.NewMemoryAttempts())
+	server.Throttle.Now = func() time.Time { return now }
+	defer func() { server.Throttle = throttle }()
+
+	wrongPassword := fmt.Sprintf(`{"email": "%s", "password": "Wrong password"}`, student.Email)
+	rightPassword := fmt.Sprintf(`{"email": "%s", "password": "password"}`, student.Email)
+
+	// The first failures are free
+	for i := 0; i < auth.AccountBackoff.FreeAttempts; i++ {
+		responseMap, statusCode := logInTokens(wrongPassword)
+		assert.Equal(t, statusCode, 422)
+		assert.Equal(t, responseMap["error"], "Invalid credentials")
+	}
+
+	// Then even the right password has to wait
+	responseMap, statusCode := logInTokens(rightPassword)
+	assert.Equal(t, statusCode, 429)
+	assert.Equal(t, responseMap["error"], "Too many failed logins, try again in 1 seconds")
+
+	now = now.Add(auth.AccountBackoff.BaseDelay)
+	_, statusCode = logInTokens(rightPassword)
+	assert.Equal(t, statusCode, 200)
+
+	// A successful login starts the account over
+	for i := 0; i < auth.AccountBackoff.LockoutAfter; i++ {
+		now = now.Add(auth.AccountBackoff.MaxDelay)
+		_, statusCode = logInTokens(wrongPassword)
+		assert.Equal(t, statusCode, 422)
+	}
+
+	// Locked out for LockoutFor after too many failures
+	now = now.Add(auth.AccountBackoff.MaxDelay)
+	_, statusCode = logInTokens(rightPassword)
+	assert.Equal(t, statusCode, 429)
+
+	now = now.Add(auth.AccountBackoff.LockoutFor - auth.AccountBackoff.MaxDelay)
+	_, statusCode = logInTokens(rightPassword)
+	assert.Equal(t, statusCode, 200)
+}
+
+func TestBackoff(t *testing.T) {
+
+	now := time.Now()
+	backoff := auth.Backoff{
+		FreeAttempts: 2,
+		BaseDelay:    time.Second,
+		MaxDelay:     10 * time.Second,
+		LockoutAfter: 8,
+		LockoutFor:   time.Minute,
+		ResetAfter:   time.Hour,
+	}
+
+	samples := []struct {
+		attempts auth.Attempts
+		wait     time.Duration
+	}{
+		{attempts: auth.Attempts{}, wait: 0},
+		{attempts: auth.Attempts{Count: 1, LastFailure: now}, wait: 0},
+		{attempts: auth.Attempts{Count: 2, LastFailure: now}, wait: time.Second},
+		{attempts: auth.Attempts{Count: 3, LastFailure: now}, wait: 2 * time.Second},
+		{attempts: auth.Attempts{Count: 4, LastFailure: now.Add(-time.Second)}, wait: 3 * time.Second},
+		{attempts: auth.Attempts{Count: 7, LastFailure: now}, wait: 10 * time.Second},
+		{attempts: auth.Attempts{Count: 8, LastFailure: now}, wait: time.Minute},
+		{attempts: auth.Attempts{Count: 80, LastFailure: now.Add(-2 * time.Minute)}, wait: 0},
+		{attempts: auth.Attempts{Count: 8, LastFailure: now.Add(-2 * time.Hour)}, wait: 0},
+	}
+
+	for _, v := range samples {
+		assert.Equal(t, backoff.Wait(v.attempts, now), v.wait)
+	}
+}
@@ -0,0 +1,333 @@
+package handlerstest
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amaraliou/stakeout/auth"
+	"github.com/amaraliou/stakeout/middlewares"
+	"github.com/amaraliou/stakeout/models"
+	"github.com/gorilla/mux"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestShopAdminMiddleware(t *testing.T) {
+
+	err := refreshEverything()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	shops, err := seedShops()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	admin, err := seedOneAdmin()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = server.DB.Model(&models.Admin{}).Where("id = ?", admin.ID).Update("shop_id", shops[0].ID).Error
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student, err := seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	token, err := server.AdminSignIn(admin.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	tokenString := fmt.Sprintf("Bearer %v", token)
+
+	studentToken, err := server.SignIn(student.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	studentTokenString := fmt.Sprintf("Bearer %v", studentToken)
+
+	samples := []struct {
+		shopID       string
+		tokenGiven   string
+		statusCode   int
+		errorMessage string
+	}{
+		{
+			shopID:     shops[0].ID.String(),
+			tokenGiven: tokenString,
+			statusCode: 200,
+		},
+		{
+			shopID:       shops[1].ID.String(),
+			tokenGiven:   tokenString,
+			statusCode:   403,
+			errorMessage: "Forbidden: You are not the admin for this shop",
+		},
+		{
+			shopID:       shops[0].ID.String(),
+			tokenGiven:   studentTokenString,
+			statusCode:   401,
+			errorMessage: "Unauthorized: You are not an admin",
+		},
+		{
+			shopID:       shops[0].ID.String(),
+			tokenGiven:   "",
+			statusCode:   401,
+			errorMessage: "Unauthorized",
+		},
+	}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	for _, v := range samples {
+
+		req, err := http.NewRequest("GET", "/shops", nil)
+		if err != nil {
+			t.Errorf("This is the error: %v\n", err)
+		}
+
+		req = mux.SetURLVars(req, map[string]string{"shop_id": v.shopID})
+		rr := httptest.NewRecorder()
+		handler := middlewares.SetMiddlewareShopAdmin(server.Admins, next)
+		req.Header.Set("Authorization", v.tokenGiven)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.errorMessage != "" {
+			responseMap := make(map[string]interface{})
+			err = json.Unmarshal([]byte(rr.Body.String()), &responseMap)
+			if err != nil {
+				t.Errorf("Cannot convert to json: %v", err)
+			}
+			assert.Equal(t, responseMap["error"], v.errorMessage)
+		}
+	}
+}
+
+func TestPermissionMiddleware(t *testing.T) {
+
+	err := refreshEverything()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	admin, err := seedOneAdmin()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student, err := seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tokens := map[auth.Role]string{}
+	for _, role := range []auth.Role{auth.RoleSuperuser, auth.RoleShopOwner, auth.RoleShopStaff} {
+		token, err := auth.CreateAdminToken(admin.ID, uuid.Nil, uuid.Nil, role)
+		if err != nil {
+			log.Fatal(err)
+		}
+		tokens[role] = fmt.Sprintf("Bearer %v", token)
+	}
+
+	studentToken, err := server.SignIn(student.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+	tokens[auth.RoleStudent] = fmt.Sprintf("Bearer %v", studentToken)
+
+	samples := []struct {
+		permission   auth.Permission
+		tokenGiven   string
+		statusCode   int
+		errorMessage string
+	}{
+		{
+			permission: auth.PermListStudents,
+			tokenGiven: tokens[auth.RoleSuperuser],
+			statusCode: 200,
+		},
+		{
+			permission: auth.PermListAdmins,
+			tokenGiven: tokens[auth.RoleSuperuser],
+			statusCode: 200,
+		},
+		{
+			permission:   auth.PermListAdmins,
+			tokenGiven:   tokens[auth.RoleShopOwner],
+			statusCode:   403,
+			errorMessage: "Forbidden: shop_owner is not allowed to admins:list",
+		},
+		{
+			permission: auth.PermDeleteShop,
+			tokenGiven: tokens[auth.RoleShopOwner],
+			statusCode: 200,
+		},
+		{
+			permission: auth.PermUpdateOrderStatus,
+			tokenGiven: tokens[auth.RoleShopStaff],
+			statusCode: 200,
+		},
+		{
+			permission:   auth.PermDeleteShop,
+			tokenGiven:   tokens[auth.RoleShopStaff],
+			statusCode:   403,
+			errorMessage: "Forbidden: shop_staff is not allowed to shops:delete",
+		},
+		{
+			permission: auth.PermPlaceOrder,
+			tokenGiven: tokens[auth.RoleStudent],
+			statusCode: 200,
+		},
+		{
+			permission:   auth.PermListStudents,
+			tokenGiven:   tokens[auth.RoleStudent],
+			statusCode:   403,
+			errorMessage: "Forbidden: student is not allowed to students:list",
+		},
+		{
+			permission:   auth.PermListStudents,
+			tokenGiven:   "",
+			statusCode:   401,
+			errorMessage: "Unauthorized",
+		},
+	}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	for _, v := range samples {
+
+		req, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Errorf("This is the error: %v\n", err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := middlewares.SetMiddlewarePermission(v.permission, next)
+		req.Header.Set("Authorization", v.tokenGiven)
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.errorMessage != "" {
+			responseMap := make(map[string]interface{})
+			err = json.Unmarshal([]byte(rr.Body.String()), &responseMap)
+			if err != nil {
+				t.Errorf("Cannot convert to json: %v", err)
+			}
+			assert.Equal(t, responseMap["error"], v.errorMessage)
+		}
+	}
+}
+
+func TestRequestClaims(t *testing.T) {
+
+	err := refreshEverything()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	shop, err := seedOneShop()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	admin, err := seedOneAdmin()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = server.DB.Model(&models.Admin{}).Where("id = ?", admin.ID).Update("shop_id", shop.ID).Error
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student, err := seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	adminToken, err := server.AdminSignIn(admin.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+
+	studentToken, err := server.SignIn(student.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+
+	samples := []struct {
+		tokenGiven string
+		subjectID  uuid.UUID
+		isAdmin    bool
+		role       auth.Role
+		shopID     uuid.UUID
+	}{
+		{
+			tokenGiven: adminToken,
+			subjectID:  admin.ID,
+			isAdmin:    true,
+			role:       auth.RoleShopOwner,
+			shopID:     shop.ID,
+		},
+		{
+			tokenGiven: studentToken,
+			subjectID:  student.ID,
+			isAdmin:    false,
+			role:       auth.RoleStudent,
+			shopID:     uuid.Nil,
+		},
+	}
+
+	for _, v := range samples {
+
+		req, err := http.NewRequest("GET", "/", nil)
+		if err != nil {
+			t.Errorf("This is the error: %v\n", err)
+		}
+
+		var claims *auth.Claims
+		handler := middlewares.SetMiddlewareAuthentication(func(w http.ResponseWriter, r *http.Request) {
+			claims, _ = auth.RequestClaims(r)
+		})
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", v.tokenGiven))
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+
+		assert.Equal(t, claims != nil, true)
+		if claims != nil {
+			assert.Equal(t, claims.SubjectID, v.subjectID)
+			assert.Equal(t, claims.IsAdmin, v.isAdmin)
+			assert.Equal(t, claims.Role, v.role)
+			assert.Equal(t, claims.ShopID, v.shopID)
+			assert.Equal(t, claims.JTI != "", true)
+			assert.Equal(t, claims.SessionID != "", true)
+		}
+	}
+
+	// Admin tokens used to make student handlers panic
+	req, err := http.NewRequest("DELETE", "/students", nil)
+	if err != nil {
+		t.Errorf("This is the error: %v\n", err)
+	}
+
+	req = mux.SetURLVars(req, map[string]string{"id": student.ID.String()})
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(server.DeleteStudent)
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", adminToken))
+	handler.ServeHTTP(rr, req)
+
+	assert.Equal(t, rr.Code, 401)
+}
@@ -28,6 +28,22 @@ func refreshOrderTable() error {
 	return nil
 }
 
+func orderLines(products []models.Product) []models.OrderLine {
+
+	lines := []models.OrderLine{}
+	for _, product := range products {
+		lines = append(lines, models.OrderLine{
+			ProductID:   product.ID,
+			ProductName: product.Name,
+			Quantity:    1,
+			UnitPrice:   product.Price,
+			LineTotal:   product.Price,
+		})
+	}
+
+	return lines
+}
+
 func seedOneOrder() (models.Order, error) {
 
 	refreshEverything()
@@ -52,7 +68,7 @@ func seedOneOrder() (models.Order, error) {
 	order := models.Order{
 		UserID:     student.ID,
 		ShopID:     products[0].ShopID,
-		OrderItems: products,
+		OrderItems: orderLines(products),
 		OrderTotal: total,
 	}
 
@@ -89,17 +105,15 @@ func seedOrders() ([]models.Order, error) {
 
 	orders := []models.Order{
 		models.Order{
-			UserID: student.ID,
-			ShopID: products[0].ShopID,
-			OrderItems: []models.Product{
-				products[0],
-			},
+			UserID:     student.ID,
+			ShopID:     products[0].ShopID,
+			OrderItems: orderLines(products[:1]),
 			OrderTotal: total1,
 		},
 		models.Order{
 			UserID:     student.ID,
 			ShopID:     products[0].ShopID,
-			OrderItems: products,
+			OrderItems: orderLines(products),
 			OrderTotal: total2,
 		},
 	}
@@ -151,10 +165,7 @@ func TestCreateOrder(t *testing.T) {
 	unauthTokenString := fmt.Sprintf("Bearer %v", unauthToken)
 	fmt.Print(unauthTokenString)
 
-	orderProduct, err := json.Marshal(products[0])
-	if err != nil {
-		log.Fatal(err)
-	}
+	orderProduct := fmt.Sprintf(`{"product_id": "%s", "quantity": 2}`, products[0].ID.String())
 
 	samples := []struct {
 		studentID     string
@@ -167,42 +178,42 @@ func TestCreateOrder(t *testing.T) {
 	}{
 		{
 			studentID:     AuthID,
-			createJSON:    fmt.Sprintf(`{"shop_id": "%s", "ordered_items": [%s]}`, products[0].ShopID.String(), string(orderProduct)),
+			createJSON:    fmt.Sprintf(`{"shop_id": "%s", "ordered_items": [%s]}`, products[0].ShopID.String(), orderProduct),
 			statusCode:    201,
 			tokenGiven:    tokenString,
 			orderedByName: "Donald",
 		},
 		{
 			studentID:    AuthID,
-			createJSON:   fmt.Sprintf(`"shop_id": "%s", "ordered_items": [%s]}`, products[0].ShopID.String(), string(orderProduct)),
+			createJSON:   fmt.Sprintf(`"shop_id": "%s", "ordered_items": [%s]}`, products[0].ShopID.String(), orderProduct),
 			statusCode:   422,
 			tokenGiven:   tokenString,
 			errorMessage: "invalid character ':' after top-level value",
 		},
 		{
 			studentID:    AuthID,
-			createJSON:   fmt.Sprintf(`{"shop_id": "%s", "ordered_items": [%s]}`, products[0].ShopID.String(), string(orderProduct)),
+			createJSON:   fmt.Sprintf(`{"shop_id": "%s", "ordered_items": [%s]}`, products[0].ShopID.String(), orderProduct),
 			statusCode:   401,
 			tokenGiven:   unauthTokenString,
 			errorMessage: "Unauthorized",
 		},
 		{
 			studentID:    unauthStudent.ID.String(),
-			createJSON:   fmt.Sprintf(`{"shop_id": "%s", "ordered_items": [%s]}`, products[0].ShopID.String(), string(orderProduct)),
+			createJSON:   fmt.Sprintf(`{"shop_id": "%s", "ordered_items": [%s]}`, products[0].ShopID.String(), orderProduct),
 			statusCode:   401,
 			tokenGiven:   tokenString,
 			errorMessage: "Unauthorized",
 		},
 		{
 			studentID:    "33597717-e0cc-4d9e-bcab-65d48ecb2523",
-			createJSON:   fmt.Sprintf(`{"shop_id": "%s", "ordered_items": [%s]}`, products[0].ShopID.String(), string(orderProduct)),
+			createJSON:   fmt.Sprintf(`{"shop_id": "%s", "ordered_items": [%s]}`, products[0].ShopID.String(), orderProduct),
 			statusCode:   401,
 			tokenGiven:   tokenString,
 			errorMessage: "Unauthorized",
 		},
 		{
 			studentID:    AuthID,
-			createJSON:   fmt.Sprintf(`{"ordered_items": [%s]}`, string(orderProduct)),
+			createJSON:   fmt.Sprintf(`{"ordered_items": [%s]}`, orderProduct),
 			statusCode:   422,
 			tokenGiven:   tokenString,
 			errorMessage: "Required shop",
@@ -216,7 +227,7 @@ func TestCreateOrder(t *testing.T) {
 		},
 		{
 			studentID:    AuthID,
-			createJSON:   fmt.Sprintf(`{"shop_id": "33597717-e0cc-4d9e-bcab-65d48ecb2523", "ordered_items": [%s]}`, string(orderProduct)),
+			createJSON:   fmt.Sprintf(`{"shop_id": "33597717-e0cc-4d9e-bcab-65d48ecb2523", "ordered_items": [%s]}`, orderProduct),
 			statusCode:   500,
 			tokenGiven:   tokenString,
 			errorMessage: "Shop not found",
@@ -275,14 +286,17 @@ func TestGetOrders(t *testing.T) {
 	handler := http.HandlerFunc(server.GetAllOrders)
 	handler.ServeHTTP(rr, req)
 
-	var receivedOrders []models.Order
-	err = json.Unmarshal([]byte(rr.Body.String()), &receivedOrders)
+	var page struct {
+		Data       []models.Order `json:"data"`
+		NextCursor string         `json:"next_cursor"`
+	}
+	err = json.Unmarshal([]byte(rr.Body.String()), &page)
 	if err != nil {
 		log.Fatalf("Cannot convert to json: %v\n", err)
 	}
 
 	assert.Equal(t, rr.Code, http.StatusOK)
-	assert.Equal(t, len(orders), len(receivedOrders))
+	assert.Equal(t, len(orders), len(page.Data))
 
 	err = server.DB.DropTableIfExists(&models.Order{}).Error
 	if err != nil {
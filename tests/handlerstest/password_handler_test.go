@@ -0,0 +1,232 @@
+package handlerstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/amaraliou/stakeout/models"
+	"github.com/gorilla/mux"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestForgotPassword(t *testing.T) {
+
+	err := refreshStudentTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student, err := seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	sent := len(mails.Messages())
+
+	registered, statusCode := postJSON(server.ForgotPassword, "/password/forgot", fmt.Sprintf(`{"email": "%s"}`, student.Email))
+	assert.Equal(t, statusCode, 202)
+	server.WaitForEmails()
+	assert.Equal(t, len(mails.Messages()), sent+1)
+
+	unknown, statusCode := postJSON(server.ForgotPassword, "/password/forgot", `{"email": "nobody@email.com"}`)
+	assert.Equal(t, statusCode, 202)
+	server.WaitForEmails()
+	assert.Equal(t, len(mails.Messages()), sent+1)
+
+	// Nothing tells a registered email apart
+	assert.Equal(t, unknown, registered)
+
+	_, statusCode = postJSON(server.ForgotPassword, "/password/forgot", `{"email": ""}`)
+	assert.Equal(t, statusCode, 422)
+}
+
+func TestResetPassword(t *testing.T) {
+
+	err := refreshStudentTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student, err := seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	login, statusCode := logInTokens(fmt.Sprintf(`{"email": "%s", "password": "password"}`, student.Email))
+	assert.Equal(t, statusCode, 200)
+
+	_, statusCode = postJSON(server.ForgotPassword, "/password/forgot", fmt.Sprintf(`{"email": "%s"}`, student.Email))
+	assert.Equal(t, statusCode, 202)
+	token := emailedToken(student.Email)
+
+	samples := []struct {
+		inputJSON    string
+		statusCode   int
+		errorMessage string
+	}{
+		{
+			inputJSON:    `{"token": "", "password": "new password"}`,
+			statusCode:   422,
+			errorMessage: "Required Token",
+		},
+		{
+			inputJSON:    fmt.Sprintf(`{"token": "%s", "password": ""}`, token),
+			statusCode:   422,
+			errorMessage: "Required Password",
+		},
+		{
+			inputJSON:    `{"token": "not-the-token", "password": "new password"}`,
+			statusCode:   422,
+			errorMessage: "Invalid or expired token",
+		},
+		{
+			inputJSON:  fmt.Sprintf(`{"token": "%s", "password": "new password"}`, token),
+			statusCode: 200,
+		},
+		{
+			inputJSON:    fmt.Sprintf(`{"token": "%s", "password": "another password"}`, token),
+			statusCode:   422,
+			errorMessage: "Invalid or expired token",
+		},
+	}
+
+	for _, v := range samples {
+		responseMap, statusCode := postJSON(server.ResetPassword, "/password/reset", v.inputJSON)
+		assert.Equal(t, statusCode, v.statusCode)
+		if v.errorMessage != "" {
+			assert.Equal(t, responseMap["error"], v.errorMessage)
+		}
+	}
+
+	// Sessions opened with the old password are gone
+	assert.Equal(t, authenticated(login["token"].(string)), 401)
+	_, statusCode = refreshTokens(login["refresh_token"].(string))
+	assert.Equal(t, statusCode, 401)
+
+	_, err = server.SignIn(student.Email, "password")
+	assert.NotEqual(t, err, nil)
+
+	signedIn, err := server.SignIn(student.Email, "new password")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, authenticated(signedIn), 200)
+}
+
+func TestUpdateStudentPassword(t *testing.T) {
+
+	err := refreshStudentTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student, err := seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	login, statusCode := logInTokens(fmt.Sprintf(`{"email": "%s", "password": "password"}`, student.Email))
+	assert.Equal(t, statusCode, 200)
+
+	other, statusCode := logInTokens(fmt.Sprintf(`{"email": "%s", "password": "password"}`, student.Email))
+	assert.Equal(t, statusCode, 200)
+
+	samples := []struct {
+		inputJSON    string
+		statusCode   int
+		errorMessage string
+	}{
+		{
+			inputJSON:    `{"current_password": "", "new_password": "new password"}`,
+			statusCode:   422,
+			errorMessage: "Required Current Password",
+		},
+		{
+			inputJSON:    `{"current_password": "password", "new_password": ""}`,
+			statusCode:   422,
+			errorMessage: "Required New Password",
+		},
+		{
+			inputJSON:    `{"current_password": "wrong password", "new_password": "new password"}`,
+			statusCode:   422,
+			errorMessage: "Current password is incorrect",
+		},
+		{
+			inputJSON:  `{"current_password": "password", "new_password": "new password"}`,
+			statusCode: 200,
+		},
+	}
+
+	for _, v := range samples {
+
+		req, err := http.NewRequest("PUT", "/students", bytes.NewBufferString(v.inputJSON))
+		if err != nil {
+			t.Errorf("This is the error: %v\n", err)
+		}
+
+		req = mux.SetURLVars(req, map[string]string{"id": student.ID.String()})
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(server.UpdateStudentPassword)
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", login["token"]))
+		handler.ServeHTTP(rr, req)
+
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.errorMessage != "" {
+			responseMap := make(map[string]interface{})
+			err = json.Unmarshal([]byte(rr.Body.String()), &responseMap)
+			if err != nil {
+				t.Errorf("Cannot convert to json: %v", err)
+			}
+			assert.Equal(t, responseMap["error"], v.errorMessage)
+		}
+	}
+
+	// The session that changed the password stays, the others are logged out
+	assert.Equal(t, authenticated(login["token"].(string)), 200)
+	assert.Equal(t, authenticated(other["token"].(string)), 401)
+
+	_, err = server.SignIn(student.Email, "password")
+	assert.NotEqual(t, err, nil)
+
+	_, err = server.SignIn(student.Email, "new password")
+	assert.Equal(t, err, nil)
+}
+
+func TestLogInPasswordResetRequired(t *testing.T) {
+
+	err := refreshStudentTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student, err := seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = server.DB.Model(&models.Student{}).Where("id = ?", student.ID).UpdateColumn("password_reset_required", true).Error
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// A wrong password gets the usual answer, the flag isn't given away
+	responseMap, statusCode := logInTokens(fmt.Sprintf(`{"email": "%s", "password": "wrong password"}`, student.Email))
+	assert.Equal(t, statusCode, 422)
+	assert.Equal(t, responseMap["error"], models.ErrInvalidCredentials.Error())
+
+	responseMap, statusCode = logInTokens(fmt.Sprintf(`{"email": "%s", "password": "password"}`, student.Email))
+	assert.Equal(t, statusCode, 403)
+	assert.Equal(t, responseMap["error"], models.ErrPasswordResetRequired.Error())
+
+	_, statusCode = postJSON(server.ForgotPassword, "/password/forgot", fmt.Sprintf(`{"email": "%s"}`, student.Email))
+	assert.Equal(t, statusCode, 202)
+
+	_, statusCode = postJSON(server.ResetPassword, "/password/reset", fmt.Sprintf(`{"token": "%s", "password": "new password"}`, emailedToken(student.Email)))
+	assert.Equal(t, statusCode, 200)
+
+	_, statusCode = logInTokens(fmt.Sprintf(`{"email": "%s", "password": "new password"}`, student.Email))
+	assert.Equal(t, statusCode, 200)
+}
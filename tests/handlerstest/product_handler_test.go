@@ -284,14 +284,17 @@ func TestGetProducts(t *testing.T) {
 	handler := http.HandlerFunc(server.GetProducts)
 	handler.ServeHTTP(rr, req)
 
-	var receivedProducts []models.Product
-	err = json.Unmarshal([]byte(rr.Body.String()), &receivedProducts)
+	var page struct {
+		Data       []models.Product `json:"data"`
+		NextCursor string           `json:"next_cursor"`
+	}
+	err = json.Unmarshal([]byte(rr.Body.String()), &page)
 	if err != nil {
 		log.Fatalf("Cannot convert to json: %v\n", err)
 	}
 
 	assert.Equal(t, rr.Code, http.StatusOK)
-	assert.Equal(t, len(products), 2)
+	assert.Equal(t, len(page.Data), len(products))
 }
 
 func TestGetProductsByShop(t *testing.T) {
@@ -326,8 +329,8 @@ func TestGetProductsByShop(t *testing.T) {
 		},
 		{
 			shopID:       "jkshdksjhjfdk",
-			statusCode:   500,
-			errorMessage: "pq: invalid input syntax for type uuid: \"jkshdksjhjfdk\"",
+			statusCode:   422,
+			errorMessage: "Invalid value for shop_id",
 		},
 		{
 			shopID:       "1b56f03e-823c-4861-bee3-223c82e91c1f",
@@ -356,7 +359,7 @@ func TestGetProductsByShop(t *testing.T) {
 
 		assert.Equal(t, rr.Code, v.statusCode)
 		if v.statusCode == 200 {
-			products := responseMap["products"].([]interface{})
+			products := responseMap["data"].([]interface{})
 			assert.Equal(t, len(products), v.length)
 		}
 
@@ -0,0 +1,89 @@
+package handlerstest
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/amaraliou/stakeout/handlers"
+	"github.com/gorilla/mux"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestRoutesCoverHandlers(t *testing.T) {
+
+	routeServer := &handlers.Server{}
+	routes := map[string]handlers.Route{}
+	for _, route := range append(routeServer.Routes(), routeServer.WellKnownRoutes()...) {
+		_, duplicated := routes[route.Name]
+		assert.Equal(t, duplicated, false)
+		routes[route.Name] = route
+	}
+
+	handlerType := reflect.TypeOf(func(http.ResponseWriter, *http.Request) {})
+	serverValue := reflect.ValueOf(routeServer)
+	serverType := serverValue.Type()
+
+	for i := 0; i < serverType.NumMethod(); i++ {
+		if serverValue.Method(i).Type() != handlerType {
+			continue
+		}
+
+		name := serverType.Method(i).Name
+		_, routed := routes[name]
+		if !routed {
+			t.Errorf("Handler %s has no route\n", name)
+		}
+	}
+
+	for name := range routes {
+		_, exists := serverType.MethodByName(name)
+		if !exists {
+			t.Errorf("Route %s doesn't name a handler\n", name)
+		}
+	}
+}
+
+func TestRoutesAreVersioned(t *testing.T) {
+
+	routeServer := &handlers.Server{Router: mux.NewRouter()}
+	api := routeServer.Router.PathPrefix(handlers.APIPrefix).Subrouter()
+	for _, route := range routeServer.Routes() {
+		api.HandleFunc(route.Path, route.Handler).Methods(route.Method).Name(route.Name)
+	}
+	for _, route := range routeServer.WellKnownRoutes() {
+		routeServer.Router.HandleFunc(route.Path, route.Handler).Methods(route.Method).Name(route.Name)
+	}
+
+	samples := []struct {
+		method string
+		path   string
+		name   string
+	}{
+		{method: "GET", path: "/api/v1/", name: "Home"},
+		{method: "POST", path: "/api/v1/shops/33597717-e0cc-4d9e-bcab-65d48ecb2523/products", name: "CreateProduct"},
+		{method: "PUT", path: "/api/v1/shops/33597717-e0cc-4d9e-bcab-65d48ecb2523/products/33597717-e0cc-4d9e-bcab-65d48ecb2523", name: "UpdateProduct"},
+		{method: "POST", path: "/api/v1/students/33597717-e0cc-4d9e-bcab-65d48ecb2523/orders", name: "CreateOrder"},
+		{method: "DELETE", path: "/api/v1/shops/33597717-e0cc-4d9e-bcab-65d48ecb2523/orders/33597717-e0cc-4d9e-bcab-65d48ecb2523", name: "DeleteOrder"},
+		{method: "GET", path: "/api/v1/orders", name: "GetAllOrders"},
+		{method: "GET", path: "/api/v1/search", name: "Search"},
+		{method: "GET", path: "/api/v1/shops/nearby", name: "GetNearbyShops"},
+		{method: "GET", path: "/api/v1/shops/33597717-e0cc-4d9e-bcab-65d48ecb2523", name: "GetShopByID"},
+		{method: "POST", path: "/api/v1/admins/login", name: "AdminLogin"},
+		{method: "GET", path: "/.well-known/jwks.json", name: "JWKS"},
+	}
+
+	for _, v := range samples {
+		req := httptest.NewRequest(v.method, v.path, nil)
+		match := mux.RouteMatch{}
+		matched := routeServer.Router.Match(req, &match)
+		assert.Equal(t, matched, true)
+		if matched {
+			assert.Equal(t, match.Route.GetName(), v.name)
+		}
+	}
+
+	unversioned := httptest.NewRequest("GET", "/products", nil)
+	assert.Equal(t, routeServer.Router.Match(unversioned, &mux.RouteMatch{}), false)
+}
@@ -7,8 +7,10 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
+	"github.com/amaraliou/stakeout/geocoding"
 	"github.com/amaraliou/stakeout/models"
 	"github.com/gorilla/mux"
 	"gopkg.in/go-playground/assert.v1"
@@ -208,6 +210,13 @@ func TestCreateShop(t *testing.T) {
 			tokenGiven:   tokenString,
 			errorMessage: "Required town or city",
 		},
+		{
+			id:           AuthID,
+			createJSON:   `{"name":"Some random shop", "description":"bruh", "postcode":"G12 *BY", "number":8, "address_1": "Amar Street", "town_or_city":"Glasgow"}`,
+			statusCode:   422,
+			tokenGiven:   tokenString,
+			errorMessage: "Invalid shop postcode",
+		},
 		{
 			id:           AuthID,
 			createJSON:   `{"name":"Some random shop", "description":"bruh", "postcode":"G12 *BY", "number":8, "address_1": "Amar Street", "town_or_city":"Glasgow"}`,
@@ -317,14 +326,17 @@ func TestGetShops(t *testing.T) {
 	handler := http.HandlerFunc(server.GetShops)
 	handler.ServeHTTP(rr, req)
 
-	var receivedShops []models.Shop
-	err = json.Unmarshal([]byte(rr.Body.String()), &receivedShops)
+	var page struct {
+		Data       []models.Shop `json:"data"`
+		NextCursor string        `json:"next_cursor"`
+	}
+	err = json.Unmarshal([]byte(rr.Body.String()), &page)
 	if err != nil {
 		log.Fatalf("Cannot convert to json: %v\n", err)
 	}
 
 	assert.Equal(t, rr.Code, http.StatusOK)
-	assert.Equal(t, len(shops), 2)
+	assert.Equal(t, len(page.Data), 2)
 }
 
 func TestGetShopByID(t *testing.T) {
@@ -526,9 +538,9 @@ func TestUpdateShop(t *testing.T) {
 			adminID:      AuthID,
 			shopID:       unauthShop.ID.String(),
 			updateJSON:   `{"name": "Some random shop 2"}`,
-			statusCode:   401,
+			statusCode:   403,
 			tokenGiven:   tokenString,
-			errorMessage: "Unauthorized: You are not the admin for this shop",
+			errorMessage: "Forbidden: You are not the admin for this shop",
 		},
 	}
 
@@ -559,7 +571,7 @@ func TestUpdateShop(t *testing.T) {
 			assert.Equal(t, responseMap["name"], v.updateName)
 		}
 
-		if v.statusCode == 401 || v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
+		if v.statusCode == 401 || v.statusCode == 403 || v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
 			assert.Equal(t, responseMap["error"], v.errorMessage)
 		}
 	}
@@ -651,9 +663,9 @@ func TestDeleteShop(t *testing.T) {
 		{
 			adminID:      AuthID,
 			shopID:       unauthShop.ID.String(),
-			statusCode:   401,
+			statusCode:   403,
 			tokenGiven:   tokenString,
-			errorMessage: "Unauthorized: You are not the admin for this shop",
+			errorMessage: "Forbidden: You are not the admin for this shop",
 		},
 		{
 			adminID:      AuthID,
@@ -709,7 +721,7 @@ func TestDeleteShop(t *testing.T) {
 		handler.ServeHTTP(rr, req)
 
 		assert.Equal(t, rr.Code, v.statusCode)
-		if v.statusCode == 401 || v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
+		if v.statusCode == 401 || v.statusCode == 403 || v.statusCode == 422 || v.statusCode == 500 && v.errorMessage != "" {
 			responseMap := make(map[string]interface{})
 			err = json.Unmarshal([]byte(rr.Body.String()), &responseMap)
 			if err != nil {
@@ -719,3 +731,69 @@ func TestDeleteShop(t *testing.T) {
 		}
 	}
 }
+
+func TestNormalisePostcode(t *testing.T) {
+
+	samples := []struct {
+		postcode   string
+		normalised string
+	}{
+		{postcode: "G12 8QQ", normalised: "G12 8QQ"},
+		{postcode: "g128qq", normalised: "G12 8QQ"},
+		{postcode: " sw1a  1aa ", normalised: "SW1A 1AA"},
+		{postcode: "M1 1AE", normalised: "M1 1AE"},
+		{postcode: "W1A 0AX", normalised: "W1A 0AX"},
+		{postcode: "EH1 1YZ", normalised: "EH1 1YZ"},
+		{postcode: "GIR 0AA", normalised: "GIR 0AA"},
+		{postcode: "G12 *BY"},
+		{postcode: "G12 8CY"},
+		{postcode: "Q1 1AA"},
+		{postcode: "G12"},
+		{postcode: "12 8QQ"},
+		{postcode: ""},
+	}
+
+	for _, v := range samples {
+		normalised, err := geocoding.NormalisePostcode(v.postcode)
+		assert.Equal(t, normalised, v.normalised)
+		if v.normalised == "" {
+			assert.Equal(t, err, geocoding.ErrInvalidPostcode)
+		}
+	}
+}
+
+func TestPostcodeDirectory(t *testing.T) {
+
+	directory, err := geocoding.ReadDirectory(strings.NewReader("# pcd,pcds,lat,long\npcd,pcds,lat,long\nG128QQ,G12 8QQ,55.871860,-4.288330\nZE1 0AA,ZE1 0AA,99.999999,0.000000\n"))
+	if err != nil {
+		t.Errorf("this is the error reading the directory: %v\n", err)
+		return
+	}
+	assert.Equal(t, directory.Len(), 1)
+
+	location, found := directory.Locate("g12 8qq")
+	assert.Equal(t, found, true)
+	assert.Equal(t, location, geocoding.Location{Latitude: 55.871860, Longitude: -4.288330})
+
+	_, found = directory.Locate("ZE1 0AA")
+	assert.Equal(t, found, false)
+
+	// Bad rows are skipped, the rest of the file is still loaded
+	directory, err = geocoding.ReadDirectory(strings.NewReader("postcode,lat,long\nnot a postcode,55.8,-4.2\nG12 8QQ,north,west\nG2\n\"G3,55.8\nG2 1DU,55.861,-4.250\n"))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, directory.Len(), 1)
+
+	_, found = directory.Locate("G2 1DU")
+	assert.Equal(t, found, true)
+
+	samples := []string{
+		"",
+		"# only a comment\n\n",
+		"postcode,easting,northing\nG12 8QQ,256800,666900\n",
+	}
+
+	for _, v := range samples {
+		_, err = geocoding.ReadDirectory(strings.NewReader(v))
+		assert.NotEqual(t, err, nil)
+	}
+}
@@ -120,13 +120,17 @@ func TestGetStudents(t *testing.T) {
 	handler := http.HandlerFunc(server.GetStudents)
 	handler.ServeHTTP(rr, req)
 
-	var students []models.Student
-	err = json.Unmarshal([]byte(rr.Body.String()), &students)
+	var page struct {
+		Data       []models.Student `json:"data"`
+		NextCursor string           `json:"next_cursor"`
+	}
+	err = json.Unmarshal([]byte(rr.Body.String()), &page)
 	if err != nil {
 		log.Fatalf("Cannot convert to json: %v\n", err)
 	}
 	assert.Equal(t, rr.Code, http.StatusOK)
-	assert.Equal(t, len(students), 2)
+	assert.Equal(t, len(page.Data), 2)
+	assert.Equal(t, page.NextCursor, "")
 }
 
 func TestGetStudentByID(t *testing.T) {
@@ -0,0 +1,271 @@
+package handlerstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/amaraliou/stakeout/auth"
+	"github.com/amaraliou/stakeout/middlewares"
+	"github.com/amaraliou/stakeout/models"
+	"github.com/gorilla/mux"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func sendAsAdmin(handler http.HandlerFunc, method, id, token, inputJSON string) (map[string]interface{}, int) {
+
+	req, err := http.NewRequest(method, "/admins", bytes.NewBufferString(inputJSON))
+	if err != nil {
+		log.Fatalf("this is the error: %v", err)
+	}
+
+	req = mux.SetURLVars(req, map[string]string{"id": id})
+	rr := httptest.NewRecorder()
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
+	handler.ServeHTTP(rr, req)
+
+	responseMap := make(map[string]interface{})
+	json.Unmarshal([]byte(rr.Body.String()), &responseMap)
+
+	return responseMap, rr.Code
+}
+
+func enableTwoFactor(admin models.Admin, token string) (string, []string) {
+
+	enrollment, statusCode := sendAsAdmin(server.EnrollTwoFactor, "POST", admin.ID.String(), token, "")
+	if statusCode != 200 {
+		log.Fatalf("cannot enroll: %v", enrollment)
+	}
+	secret := enrollment["secret"].(string)
+
+	code, err := auth.TOTPCode(secret, auth.TOTPStep(time.Now()))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	confirmation, statusCode := sendAsAdmin(server.ConfirmTwoFactor, "POST", admin.ID.String(), token, fmt.Sprintf(`{"code": "%s"}`, code))
+	if statusCode != 200 {
+		log.Fatalf("cannot confirm: %v", confirmation)
+	}
+
+	recoveryCodes := []string{}
+	for _, recoveryCode := range confirmation["recovery_codes"].([]interface{}) {
+		recoveryCodes = append(recoveryCodes, recoveryCode.(string))
+	}
+	return secret, recoveryCodes
+}
+
+func TestTOTP(t *testing.T) {
+
+	// RFC 6238 test vectors, secret "12345678901234567890", truncated to six digits
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	samples := []struct {
+		unix int64
+		code string
+	}{
+		{unix: 59, code: "287082"},
+		{unix: 1111111109, code: "081804"},
+		{unix: 1234567890, code: "005924"},
+		{unix: 2000000000, code: "279037"},
+	}
+
+	for _, v := range samples {
+		code, err := auth.TOTPCode(secret, auth.TOTPStep(time.Unix(v.unix, 0)))
+		assert.Equal(t, err, nil)
+		assert.Equal(t, code, v.code)
+
+		_, ok := auth.ValidateTOTP(secret, v.code, time.Unix(v.unix+auth.TOTPPeriod, 0))
+		assert.Equal(t, ok, true)
+
+		_, ok = auth.ValidateTOTP(secret, v.code, time.Unix(v.unix+3*auth.TOTPPeriod, 0))
+		assert.Equal(t, ok, false)
+	}
+
+	uri := auth.TOTPURI("Apetitoso", "email@email.com", secret)
+	assert.Equal(t, uri, "otpauth://totp/Apetitoso:email@email.com?algorithm=SHA1&digits=6&issuer=Apetitoso&period=30&secret="+secret)
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+
+	err := refreshAdminTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	admin, err := seedOneAdmin()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	token, err := server.AdminSignIn(admin.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+
+	secret, recoveryCodes := enableTwoFactor(admin, token)
+	assert.Equal(t, len(recoveryCodes), models.RecoveryCodeCount)
+
+	_, statusCode := sendAsAdmin(server.EnrollTwoFactor, "POST", admin.ID.String(), token, "")
+	assert.Equal(t, statusCode, 422)
+
+	// The password alone only gets a challenge
+	_, err = server.AdminSignIn(admin.Email, "password")
+	assert.Equal(t, err, models.ErrTwoFactorRequired)
+
+	login, statusCode := postJSON(server.AdminLogin, "/admins/login", fmt.Sprintf(`{"email": "%s", "password": "password"}`, admin.Email))
+	assert.Equal(t, statusCode, 200)
+	assert.Equal(t, login["token"], nil)
+	challengeToken := login["challenge_token"].(string)
+
+	// Challenge tokens don't authenticate anything
+	assert.Equal(t, authenticated(challengeToken), 401)
+
+	// The code used to confirm the enrollment can't be replayed
+	enabled, err := admin.FindAdminByID(server.DB, admin.ID.String())
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	usedCode, err := auth.TOTPCode(secret, enabled.TOTPLastStep)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	nextCode, err := auth.TOTPCode(secret, enabled.TOTPLastStep+1)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	samples := []struct {
+		inputJSON    string
+		statusCode   int
+		errorMessage string
+	}{
+		{
+			inputJSON:    fmt.Sprintf(`{"challenge_token": "%s", "code": ""}`, challengeToken),
+			statusCode:   422,
+			errorMessage: "Required Code",
+		},
+		{
+			inputJSON:    fmt.Sprintf(`{"challenge_token": "not-a-token", "code": "%s"}`, nextCode),
+			statusCode:   401,
+			errorMessage: "Invalid or expired challenge token",
+		},
+		{
+			inputJSON:    fmt.Sprintf(`{"challenge_token": "%s", "code": "%s"}`, challengeToken, usedCode),
+			statusCode:   422,
+			errorMessage: "Invalid two-factor code",
+		},
+		{
+			inputJSON:  fmt.Sprintf(`{"challenge_token": "%s", "code": "%s"}`, challengeToken, nextCode),
+			statusCode: 200,
+		},
+		{
+			inputJSON:  fmt.Sprintf(`{"challenge_token": "%s", "code": "%s"}`, challengeToken, recoveryCodes[0]),
+			statusCode: 200,
+		},
+		{
+			inputJSON:    fmt.Sprintf(`{"challenge_token": "%s", "code": "%s"}`, challengeToken, recoveryCodes[0]),
+			statusCode:   422,
+			errorMessage: "Invalid two-factor code",
+		},
+	}
+
+	for _, v := range samples {
+		responseMap, statusCode := postJSON(server.AdminLoginTwoFactor, "/admins/login/2fa", v.inputJSON)
+		assert.Equal(t, statusCode, v.statusCode)
+		if v.errorMessage != "" {
+			assert.Equal(t, responseMap["error"], v.errorMessage)
+		} else {
+			assert.Equal(t, authenticated(responseMap["token"].(string)), 200)
+		}
+	}
+
+	// Turning it off takes a code too
+	_, statusCode = sendAsAdmin(server.DisableTwoFactor, "DELETE", admin.ID.String(), token, `{"code": "000000"}`)
+	assert.Equal(t, statusCode, 422)
+
+	_, statusCode = sendAsAdmin(server.DisableTwoFactor, "DELETE", admin.ID.String(), token, fmt.Sprintf(`{"code": "%s"}`, recoveryCodes[1]))
+	assert.Equal(t, statusCode, 204)
+
+	_, err = server.AdminSignIn(admin.Email, "password")
+	assert.Equal(t, err, nil)
+}
+
+func TestShopRequiresTwoFactor(t *testing.T) {
+
+	err := refreshEverything()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	shop, err := seedOneShop()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	admin, err := seedOneAdmin()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = server.DB.Model(&models.Admin{}).Where("id = ?", admin.ID).Update("shop_id", shop.ID).Error
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	token, err := server.AdminSignIn(admin.Email, "password")
+	if err != nil {
+		log.Fatalf("cannot login: %v\n", err)
+	}
+
+	superuserToken, err := auth.CreateAdminToken(admin.ID, uuid.Nil, uuid.Nil, auth.RoleSuperuser)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	shopAdmin := func() int {
+		req, err := http.NewRequest("GET", "/shops", nil)
+		if err != nil {
+			log.Fatalf("this is the error: %v", err)
+		}
+
+		req = mux.SetURLVars(req, map[string]string{"shop_id": shop.ID.String()})
+		rr := httptest.NewRecorder()
+		handler := middlewares.SetMiddlewareShopAdmin(server.Admins, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusOK)
+		})
+		req.Header.Set("Authorization", fmt.Sprintf("Bearer %v", token))
+		handler.ServeHTTP(rr, req)
+		return rr.Code
+	}
+
+	assert.Equal(t, shopAdmin(), 200)
+
+	// Shop owners can't require it themselves
+	_, statusCode := sendAsAdmin(middlewares.SetMiddlewarePermission(auth.PermRequireTwoFactor, server.RequireShopTwoFactor), "PUT", shop.ID.String(), token, `{"require_2fa": true}`)
+	assert.Equal(t, statusCode, 403)
+
+	_, statusCode = sendAsAdmin(server.RequireShopTwoFactor, "PUT", shop.ID.String(), superuserToken, `{}`)
+	assert.Equal(t, statusCode, 422)
+
+	responseMap, statusCode := sendAsAdmin(middlewares.SetMiddlewarePermission(auth.PermRequireTwoFactor, server.RequireShopTwoFactor), "PUT", shop.ID.String(), superuserToken, `{"require_2fa": true}`)
+	assert.Equal(t, statusCode, 200)
+	assert.Equal(t, responseMap["require_2fa"], true)
+
+	assert.Equal(t, shopAdmin(), 403)
+
+	_, recoveryCodes := enableTwoFactor(admin, token)
+	assert.Equal(t, shopAdmin(), 200)
+
+	// And can't turn it off while the shop requires it
+	responseMap, statusCode = sendAsAdmin(server.DisableTwoFactor, "DELETE", admin.ID.String(), token, fmt.Sprintf(`{"code": "%s"}`, recoveryCodes[0]))
+	assert.Equal(t, statusCode, 403)
+	assert.Equal(t, responseMap["error"], "Forbidden: This shop requires two-factor authentication")
+}
@@ -0,0 +1,242 @@
+package handlerstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amaraliou/stakeout/models"
+	"github.com/amaraliou/stakeout/verification"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func emailedToken(email string) string {
+
+	server.WaitForEmails()
+	message, ok := mails.Last(email)
+	if !ok {
+		return ""
+	}
+
+	fields := strings.Fields(message.Body)
+	return fields[len(fields)-1]
+}
+
+func postJSON(handler http.HandlerFunc, path, inputJSON string) (map[string]interface{}, int) {
+
+	req, err := http.NewRequest("POST", path, bytes.NewBufferString(inputJSON))
+	if err != nil {
+		log.Fatalf("this is the error: %v", err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	responseMap := make(map[string]interface{})
+	json.Unmarshal([]byte(rr.Body.String()), &responseMap)
+
+	return responseMap, rr.Code
+}
+
+func TestVerifyEmail(t *testing.T) {
+
+	err := refreshStudentTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	email := "2310549a@student.gla.ac.uk"
+	created, statusCode := postJSON(server.CreateStudent, "/students", fmt.Sprintf(`{"email":"%s", "password": "password", "country": "GB", "mobile_number":"07547775660", "verified": true}`, email))
+	assert.Equal(t, statusCode, 201)
+	assert.Equal(t, created["verified"], false)
+
+	_, err = server.SignIn(email, "password")
+	assert.Equal(t, err, models.ErrEmailNotVerified)
+
+	login, statusCode := postJSON(server.Login, "/login", fmt.Sprintf(`{"email": "%s", "password": "password"}`, email))
+	assert.Equal(t, statusCode, 403)
+	assert.Equal(t, login["error"], "Email not verified")
+
+	token := emailedToken(email)
+	assert.NotEqual(t, token, "")
+
+	samples := []struct {
+		inputJSON    string
+		statusCode   int
+		errorMessage string
+	}{
+		{
+			inputJSON:    `{"token": ""}`,
+			statusCode:   422,
+			errorMessage: "Required Token",
+		},
+		{
+			inputJSON:    `{"token": "not-the-token"}`,
+			statusCode:   422,
+			errorMessage: "Invalid or expired token",
+		},
+		{
+			inputJSON:  fmt.Sprintf(`{"token": "%s"}`, token),
+			statusCode: 200,
+		},
+		{
+			// Tokens are single use
+			inputJSON:    fmt.Sprintf(`{"token": "%s"}`, token),
+			statusCode:   422,
+			errorMessage: "Invalid or expired token",
+		},
+	}
+
+	for _, v := range samples {
+		responseMap, statusCode := postJSON(server.VerifyEmail, "/verify-email", v.inputJSON)
+		assert.Equal(t, statusCode, v.statusCode)
+		if v.errorMessage != "" {
+			assert.Equal(t, responseMap["error"], v.errorMessage)
+		}
+	}
+
+	signedIn, err := server.SignIn(email, "password")
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, signedIn, "")
+}
+
+func TestResendVerificationEmail(t *testing.T) {
+
+	err := refreshStudentTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	email := "2310549a@student.gla.ac.uk"
+	_, statusCode := postJSON(server.CreateStudent, "/students", fmt.Sprintf(`{"email":"%s", "password": "password", "country": "GB", "mobile_number":"07547775660"}`, email))
+	assert.Equal(t, statusCode, 201)
+
+	firstToken := emailedToken(email)
+
+	_, statusCode = postJSON(server.ResendVerificationEmail, "/verify-email/resend", fmt.Sprintf(`{"email": "%s"}`, email))
+	assert.Equal(t, statusCode, 202)
+
+	secondToken := emailedToken(email)
+	assert.NotEqual(t, secondToken, firstToken)
+
+	// Only the last token sent works
+	_, statusCode = postJSON(server.VerifyEmail, "/verify-email", fmt.Sprintf(`{"token": "%s"}`, firstToken))
+	assert.Equal(t, statusCode, 422)
+
+	_, statusCode = postJSON(server.VerifyEmail, "/verify-email", fmt.Sprintf(`{"token": "%s"}`, secondToken))
+	assert.Equal(t, statusCode, 200)
+
+	// Unknown and verified emails get the same answer and no email
+	sent := len(mails.Messages())
+	for _, address := range []string{email, "nobody@student.gla.ac.uk"} {
+		_, statusCode = postJSON(server.ResendVerificationEmail, "/verify-email/resend", fmt.Sprintf(`{"email": "%s"}`, address))
+		assert.Equal(t, statusCode, 202)
+	}
+	server.WaitForEmails()
+	assert.Equal(t, len(mails.Messages()), sent)
+}
+
+func TestUniversityRegistry(t *testing.T) {
+
+	registry, err := verification.ReadRegistry(strings.NewReader("# domain,university\nstudent.gla.ac.uk,University of Glasgow\n\nsms.ed.ac.uk, University of Edinburgh\n"))
+	if err != nil {
+		t.Errorf("this is the error reading the registry: %v\n", err)
+		return
+	}
+
+	samples := []struct {
+		email      string
+		university string
+		found      bool
+	}{
+		{email: "2310549a@student.gla.ac.uk", university: "University of Glasgow", found: true},
+		{email: "2310549a@STUDENT.GLA.AC.UK", university: "University of Glasgow", found: true},
+		{email: "s1234567@sms.ed.ac.uk", university: "University of Edinburgh", found: true},
+		{email: "someone@dcs.student.gla.ac.uk", university: "University of Glasgow", found: true},
+		{email: "someone@gla.ac.uk", found: false},
+		{email: "someone@student.gla.ac.uk.example.com", found: false},
+		{email: "not-an-email", found: false},
+	}
+
+	for _, v := range samples {
+		university, found := registry.University(v.email)
+		assert.Equal(t, found, v.found)
+		assert.Equal(t, university, v.university)
+	}
+
+	_, err = verification.ReadRegistry(strings.NewReader("student.gla.ac.uk\n"))
+	assert.NotEqual(t, err, nil)
+}
+
+func TestVerifyEmailSetsStudentStatus(t *testing.T) {
+
+	err := refreshStudentTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	defaultVerifier := server.Verifier
+	defer func() {
+		server.Verifier = defaultVerifier
+	}()
+
+	server.Verifier = verification.DomainProvider{Registry: verification.NewRegistry(map[string]string{
+		"student.gla.ac.uk": "University of Glasgow",
+	})}
+
+	samples := []struct {
+		email      string
+		isStudent  bool
+		university string
+	}{
+		{email: "2310549a@student.gla.ac.uk", isStudent: true, university: "University of Glasgow"},
+		{email: "someone@gmail.com", isStudent: false, university: ""},
+	}
+
+	for _, v := range samples {
+		// What the client claims is ignored
+		created, statusCode := postJSON(server.CreateStudent, "/students", fmt.Sprintf(`{"email":"%s", "password": "password", "country": "GB", "mobile_number":"07547775660", "is_student": true, "university": "Harvard"}`, v.email))
+		assert.Equal(t, statusCode, 201)
+		assert.Equal(t, created["is_student"], false)
+		assert.Equal(t, created["university"], "")
+
+		_, statusCode = postJSON(server.VerifyEmail, "/verify-email", fmt.Sprintf(`{"token": "%s"}`, emailedToken(v.email)))
+		assert.Equal(t, statusCode, 200)
+
+		student := models.Student{}
+		verified, err := student.FindStudentByID(server.DB, created["ID"].(string))
+		if err != nil {
+			t.Errorf("this is the error getting the student: %v\n", err)
+			continue
+		}
+
+		assert.Equal(t, verified.IsVerified, true)
+		assert.Equal(t, verified.IsStudent, v.isStudent)
+		assert.Equal(t, verified.University, v.university)
+	}
+
+	// A provider failing leaves the email verified and the student status unset
+	server.Verifier = verification.Fake{Err: errors.New("Verifier unavailable")}
+
+	email := "2310549b@student.gla.ac.uk"
+	created, statusCode := postJSON(server.CreateStudent, "/students", fmt.Sprintf(`{"email":"%s", "password": "password", "country": "GB", "mobile_number":"07547775660"}`, email))
+	assert.Equal(t, statusCode, 201)
+
+	_, statusCode = postJSON(server.VerifyEmail, "/verify-email", fmt.Sprintf(`{"token": "%s"}`, emailedToken(email)))
+	assert.Equal(t, statusCode, 200)
+
+	student := models.Student{}
+	verified, err := student.FindStudentByID(server.DB, created["ID"].(string))
+	if err != nil {
+		t.Errorf("this is the error getting the student: %v\n", err)
+		return
+	}
+	assert.Equal(t, verified.IsVerified, true)
+	assert.Equal(t, verified.IsStudent, false)
+}
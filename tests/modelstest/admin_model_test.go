@@ -10,7 +10,7 @@ import (
 	"gopkg.in/go-playground/assert.v1"
 )
 
-func TestFindAllAdmins(t *testing.T) {
+func TestListAdmins(t *testing.T) {
 
 	err := refreshAdminTable()
 	if err != nil {
@@ -22,7 +22,7 @@ func TestFindAllAdmins(t *testing.T) {
 		log.Fatal(err)
 	}
 
-	admins, err := adminInstance.FindAllAdmins(server.DB)
+	admins, _, err := adminInstance.ListAdmins(server.DB, models.ListQuery{})
 	if err != nil {
 		t.Errorf("This is the error getting the adminss: %v\n", err)
 		return
@@ -31,14 +31,14 @@ func TestFindAllAdmins(t *testing.T) {
 	assert.Equal(t, len(*admins), 2)
 }
 
-func TestFindAllAdminsNonExistentTable(t *testing.T) {
+func TestListAdminsNonExistentTable(t *testing.T) {
 
 	err := server.DB.DropTableIfExists(&models.Admin{}).Error
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	_, err = adminInstance.FindAllAdmins(server.DB)
+	_, _, err = adminInstance.ListAdmins(server.DB, models.ListQuery{})
 	assert.Equal(t, err.(*pq.Error).Message, "relation \"admins\" does not exist")
 }
 
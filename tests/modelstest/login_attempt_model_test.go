@@ -0,0 +1,51 @@
+package modelstest
+
+import (
+	"log"
+	"testing"
+	"time"
+
+	"github.com/amaraliou/stakeout/models"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestAttemptStore(t *testing.T) {
+
+	err := refreshEverything()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	store := models.AttemptStore{DB: server.DB}
+	at := time.Now().UTC().Truncate(time.Second)
+
+	attempts, err := store.Attempts("account:email@email.com")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, attempts.Count, 0)
+
+	for i := 1; i <= 3; i++ {
+		attempts, err = store.RecordFailure("account:email@email.com", at)
+		if err != nil {
+			t.Errorf("This is the error recording the failure: %v\n", err)
+			return
+		}
+		assert.Equal(t, attempts.Count, i)
+	}
+
+	attempts, err = store.Attempts("account:email@email.com")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, attempts.Count, 3)
+	assert.Equal(t, attempts.LastFailure.Equal(at), true)
+
+	// Keys are counted apart
+	attempts, err = store.Attempts("ip:192.0.2.1")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, attempts.Count, 0)
+
+	err = store.Reset("account:email@email.com")
+	assert.Equal(t, err, nil)
+
+	attempts, err = store.Attempts("account:email@email.com")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, attempts.Count, 0)
+}
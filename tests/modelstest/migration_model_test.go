@@ -0,0 +1,134 @@
+package modelstest
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/amaraliou/stakeout/migrations"
+	"github.com/amaraliou/stakeout/models"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestMigrationsGenerated(t *testing.T) {
+
+	sources, err := migrations.ReadDir("../../migrations/sql")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	onDisk, err := migrations.Parse(sources)
+	assert.Equal(t, err, nil)
+
+	// files.go is stale when this fails, run go generate ./migrations
+	compiled, err := migrations.All()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, compiled, onDisk)
+
+	for i := 1; i < len(compiled); i++ {
+		assert.Equal(t, compiled[i].Version > compiled[i-1].Version, true)
+	}
+}
+
+func TestParseMigrations(t *testing.T) {
+
+	samples := []struct {
+		files        map[string]string
+		errorMessage string
+	}{
+		{
+			files:        map[string]string{"0001_init.up.sql": "CREATE TABLE a ();"},
+			errorMessage: "Migration 1_init needs both an up and a down file",
+		},
+		{
+			files:        map[string]string{"init.up.sql": "CREATE TABLE a ();"},
+			errorMessage: "Invalid migration file name init.up.sql, expected <version>_<name>.up.sql or .down.sql",
+		},
+		{
+			files:        map[string]string{"0001_init.up.sql": "CREATE TABLE a ();", "0001_other.down.sql": "DROP TABLE a;"},
+			errorMessage: "Migration 1 has two names",
+		},
+	}
+
+	for _, v := range samples {
+		_, err := migrations.Parse(v.files)
+		assert.NotEqual(t, err, nil)
+		if err != nil {
+			assert.Equal(t, err.Error()[:len(v.errorMessage)], v.errorMessage)
+		}
+	}
+
+	parsed, err := migrations.Parse(map[string]string{
+		"0002_b.up.sql":   "CREATE TABLE b ();",
+		"0002_b.down.sql": "DROP TABLE b;",
+		"0001_a.up.sql":   "CREATE TABLE a ();",
+		"0001_a.down.sql": "DROP TABLE a;",
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(parsed), 2)
+	assert.Equal(t, parsed[0].Name, "a")
+	assert.Equal(t, parsed[1].Version, int64(2))
+}
+
+func TestCreateMigration(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "stakeout-migrations")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	up, down, err := migrations.Create(dir, "Add shop locations")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, filepath.Base(up), "0001_add_shop_locations.up.sql")
+	assert.Equal(t, filepath.Base(down), "0001_add_shop_locations.down.sql")
+
+	up, _, err = migrations.Create(dir, "index names")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, filepath.Base(up), "0002_index_names.up.sql")
+
+	_, _, err = migrations.Create(dir, "--")
+	assert.NotEqual(t, err, nil)
+}
+
+func TestMigrateDownAndUp(t *testing.T) {
+
+	migrator, err := migrations.New(server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = migrator.Up()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Nothing left to apply
+	applied, err := migrator.Up()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(applied), 0)
+
+	rolledBack, err := migrator.Down(len(migrator.Migrations) + 1)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(rolledBack), len(migrator.Migrations))
+	assert.Equal(t, server.DB.HasTable(&models.Student{}), false)
+
+	statuses, err := migrator.Status()
+	assert.Equal(t, err, nil)
+	for _, status := range statuses {
+		assert.Equal(t, status.Applied, false)
+	}
+
+	applied, err = migrator.Up()
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(applied), len(migrator.Migrations))
+	assert.Equal(t, server.DB.HasTable(&models.Student{}), true)
+
+	statuses, err = migrator.Status()
+	assert.Equal(t, err, nil)
+	for _, status := range statuses {
+		assert.Equal(t, status.Applied, true)
+	}
+}
@@ -2,7 +2,9 @@ package modelstest
 
 import (
 	"fmt"
+	"github.com/amaraliou/stakeout/common"
 	"github.com/amaraliou/stakeout/handlers"
+	"github.com/amaraliou/stakeout/migrations"
 	"github.com/amaraliou/stakeout/models"
 	"github.com/jinzhu/gorm"
 	"github.com/joho/godotenv"
@@ -30,24 +32,38 @@ func TestMain(m *testing.M) {
 }
 
 func Database() {
-	var err error
-	DBURL := fmt.Sprintf("host=%s port=%s user=%s dbname=%s sslmode=disable password=%s", os.Getenv("DB_HOST"), os.Getenv("DB_PORT"), os.Getenv("DB_USER"), os.Getenv("DB_NAME"), os.Getenv("DB_PASSWORD"))
-	server.DB, err = gorm.Open("postgres", DBURL)
+	config, err := common.LoadConfig("")
+	if err != nil {
+		log.Fatal(err)
+	}
+	models.Configure(config.Auth)
+
+	server.DB, err = gorm.Open("postgres", config.Database.DSN())
 	if err != nil {
 		fmt.Print("Cannot connect to Postgres database\n")
 		log.Fatal("This is the error:", err)
 	} else {
 		fmt.Print("We are connected to the Postgres database\n")
 	}
+
+	migrator, err := migrations.New(server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = migrator.Up()
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 func refreshEverything() error {
-	err := server.DB.DropTableIfExists(&models.Student{}, &models.Admin{}, &models.Product{}, &models.Shop{}, &models.Order{}).Error
+	err := server.DB.DropTableIfExists(&models.Student{}, &models.Admin{}, &models.Product{}, &models.Shop{}, &models.Order{}, &models.OrderLine{}, &models.OrderEvent{}, &models.PointsEntry{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.EmailToken{}, &models.LoginAttempt{}, &models.RecoveryCode{}).Error
 	if err != nil {
 		return err
 	}
 
-	err = server.DB.AutoMigrate(&models.Student{}, &models.Shop{}, &models.Admin{}, &models.Product{}, &models.Order{}).Error
+	err = server.DB.AutoMigrate(&models.Student{}, &models.Shop{}, &models.Admin{}, &models.Product{}, &models.Order{}, &models.OrderLine{}, &models.OrderEvent{}, &models.PointsEntry{}, &models.RefreshToken{}, &models.RevokedToken{}, &models.EmailToken{}, &models.LoginAttempt{}, &models.RecoveryCode{}).Error
 	if err != nil {
 		return err
 	}
@@ -399,6 +415,22 @@ func seedProducts() ([]models.Product, error) {
 	return products, nil
 }
 
+func orderLines(products []models.Product) []models.OrderLine {
+
+	lines := []models.OrderLine{}
+	for _, product := range products {
+		lines = append(lines, models.OrderLine{
+			ProductID:   product.ID,
+			ProductName: product.Name,
+			Quantity:    1,
+			UnitPrice:   product.Price,
+			LineTotal:   product.Price,
+		})
+	}
+
+	return lines
+}
+
 func seedOneOrder() (models.Order, error) {
 
 	refreshEverything()
@@ -423,7 +455,7 @@ func seedOneOrder() (models.Order, error) {
 	order := models.Order{
 		UserID:     student.ID,
 		ShopID:     products[0].ShopID,
-		OrderItems: products,
+		OrderItems: orderLines(products),
 		OrderTotal: total,
 	}
 
@@ -460,17 +492,15 @@ func seedOrders() ([]models.Order, error) {
 
 	orders := []models.Order{
 		models.Order{
-			UserID: student.ID,
-			ShopID: products[0].ShopID,
-			OrderItems: []models.Product{
-				products[0],
-			},
+			UserID:     student.ID,
+			ShopID:     products[0].ShopID,
+			OrderItems: orderLines(products[:1]),
 			OrderTotal: total1,
 		},
 		models.Order{
 			UserID:     student.ID,
 			ShopID:     products[0].ShopID,
-			OrderItems: products,
+			OrderItems: orderLines(products),
 			OrderTotal: total2,
 		},
 	}
@@ -9,7 +9,7 @@ import (
 	"gopkg.in/go-playground/assert.v1"
 )
 
-func TestFindAllOrders(t *testing.T) {
+func TestListOrders(t *testing.T) {
 
 	err := refreshEverything()
 	if err != nil {
@@ -21,7 +21,7 @@ func TestFindAllOrders(t *testing.T) {
 		log.Fatal(err)
 	}
 
-	orders, err := orderInstance.FindAllOrders(server.DB)
+	orders, _, err := orderInstance.ListOrders(server.DB, models.ListQuery{})
 	if err != nil {
 		t.Errorf("This is the error getting the orders: %v\n", err)
 		return
@@ -42,7 +42,7 @@ func TestFindOrdersByShop(t *testing.T) {
 		log.Fatal(err)
 	}
 
-	ordersByShop, err := orderInstance.FindAllOrdersByShop(server.DB, orders[0].ShopID.String())
+	ordersByShop, _, err := orderInstance.ListOrders(server.DB, models.ListQuery{Filters: map[string]interface{}{"shop_id": orders[0].ShopID.String()}})
 	if err != nil {
 		t.Errorf("This is the error getting the orders by shop: %v\n", err)
 		return
@@ -51,7 +51,7 @@ func TestFindOrdersByShop(t *testing.T) {
 	assert.Equal(t, len(*ordersByShop), 2)
 }
 
-func TestFindAllOrdersByStudent(t *testing.T) {
+func TestListOrdersByStudent(t *testing.T) {
 
 	err := refreshEverything()
 	if err != nil {
@@ -63,7 +63,7 @@ func TestFindAllOrdersByStudent(t *testing.T) {
 		log.Fatal(err)
 	}
 
-	ordersByStudent, err := orderInstance.FindAllOrdersByStudent(server.DB, orders[0].UserID.String())
+	ordersByStudent, _, err := orderInstance.ListOrders(server.DB, models.ListQuery{Filters: map[string]interface{}{"student_id": orders[0].UserID.String()}})
 	if err != nil {
 		t.Errorf("This is the error getting the orders by student: %v\n", err)
 		return
@@ -117,10 +117,10 @@ func TestCreateOrder(t *testing.T) {
 	newOrder := models.Order{
 		UserID: student.ID,
 		ShopID: products[0].ShopID,
-		OrderItems: []models.Product{
-			products[0],
+		OrderItems: []models.OrderLine{
+			models.OrderLine{ProductID: products[0].ID, Quantity: 1},
 		},
-		OrderTotal: products[0].Price,
+		OrderTotal: 100.0,
 		Status:     0,
 	}
 
@@ -131,7 +131,103 @@ func TestCreateOrder(t *testing.T) {
 	}
 
 	assert.Equal(t, newOrder.ShopID, savedOrder.ShopID)
-	assert.Equal(t, newOrder.OrderTotal, savedOrder.OrderTotal)
+	assert.Equal(t, savedOrder.OrderTotal, products[0].Price)
+	assert.Equal(t, savedOrder.OrderItems[0].UnitPrice, products[0].Price)
+}
+
+func TestCreateOrderComputesTotal(t *testing.T) {
+
+	err := refreshEverything()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	products, err := seedProducts()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student, err := seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 2.95 with 10% off
+	err = server.DB.Model(&products[0]).Updates(map[string]interface{}{"in_sale": true, "discount": 10, "discount_unit": models.DiscountPercent}).Error
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// 2.45 with 45p off
+	err = server.DB.Model(&products[1]).Updates(map[string]interface{}{"in_sale": true, "discount": 45, "discount_unit": models.DiscountAmount}).Error
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	newOrder := models.Order{
+		UserID: student.ID,
+		ShopID: products[0].ShopID,
+		OrderItems: []models.OrderLine{
+			models.OrderLine{ProductID: products[0].ID, Quantity: 2},
+			models.OrderLine{ProductID: products[1].ID, Quantity: 3},
+		},
+		OrderTotal: 0.01,
+	}
+
+	savedOrder, err := newOrder.CreateOrder(server.DB)
+	if err != nil {
+		t.Errorf("This is the error creating the order: %v\n", err)
+		return
+	}
+
+	assert.Equal(t, savedOrder.OrderItems[0].LineTotal, float32(5.32))
+	assert.Equal(t, savedOrder.OrderItems[0].Discount, float32(0.29))
+	assert.Equal(t, savedOrder.OrderItems[1].LineTotal, float32(6))
+	assert.Equal(t, savedOrder.OrderTotal, float32(11.32))
+
+	// Editing the product afterwards doesn't rewrite the order
+	err = server.DB.Model(&products[0]).Update("price", 10.0).Error
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	foundOrder, err := orderInstance.FindOrderByID(server.DB, savedOrder.ID.String())
+	if err != nil {
+		t.Errorf("This is the error getting the order: %v\n", err)
+		return
+	}
+
+	assert.Equal(t, foundOrder.OrderTotal, float32(11.32))
+	assert.Equal(t, len(foundOrder.OrderItems), 2)
+}
+
+func TestCreateOrderProductFromOtherShop(t *testing.T) {
+
+	err := refreshEverything()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	products, err := seedProducts()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student, err := seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	newOrder := models.Order{
+		UserID: student.ID,
+		ShopID: products[0].ShopID,
+		OrderItems: []models.OrderLine{
+			models.OrderLine{ProductID: student.ID, Quantity: 1},
+		},
+	}
+
+	_, err = newOrder.CreateOrder(server.DB)
+	assert.Equal(t, err.Error(), "Product "+student.ID.String()+" not found in this shop")
 }
 
 func TestUpdateOrder(t *testing.T) {
@@ -147,7 +243,8 @@ func TestUpdateOrder(t *testing.T) {
 	}
 
 	orderUpdate := models.Order{
-		Status: 2,
+		Status:    models.OrderPayed,
+		UpdatedBy: order.ShopID,
 	}
 
 	updatedOrder, err := orderUpdate.UpdateOrder(server.DB, order.ID.String())
@@ -158,6 +255,70 @@ func TestUpdateOrder(t *testing.T) {
 
 	assert.Equal(t, updatedOrder.ID, orderUpdate.ID)
 	assert.NotEqual(t, order.Status, updatedOrder.Status)
+	assert.Equal(t, updatedOrder.UpdatedBy, order.ShopID)
+	assert.Equal(t, updatedOrder.StatusUpdatedAt != nil, true)
+}
+
+func TestUpdateOrderInvalidTransition(t *testing.T) {
+
+	err := refreshEverything()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	order, err := seedOneOrder()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	orderUpdate := models.Order{
+		Status: models.OrderRefunded,
+	}
+
+	_, err = orderUpdate.UpdateOrder(server.DB, order.ID.String())
+	transitionErr, ok := err.(*models.InvalidTransitionError)
+	assert.Equal(t, ok, true)
+	assert.Equal(t, transitionErr.From, models.OrderPending)
+	assert.Equal(t, transitionErr.To, models.OrderRefunded)
+
+	foundOrder, err := orderInstance.FindOrderByID(server.DB, order.ID.String())
+	if err != nil {
+		t.Errorf("This is the error getting the order: %v\n", err)
+		return
+	}
+
+	assert.Equal(t, foundOrder.Status, models.OrderPending)
+}
+
+func TestOrderStatusTransitions(t *testing.T) {
+
+	samples := []struct {
+		from    uint8
+		to      uint8
+		allowed bool
+	}{
+		{from: models.OrderPending, to: models.OrderPayed, allowed: true},
+		{from: models.OrderPayed, to: models.OrderReceived, allowed: true},
+		{from: models.OrderReceived, to: models.OrderConfirmed, allowed: true},
+		{from: models.OrderPayed, to: models.OrderRefunding, allowed: true},
+		{from: models.OrderRefunding, to: models.OrderRefunded, allowed: true},
+		{from: models.OrderPending, to: models.OrderCancel, allowed: true},
+		{from: models.OrderPending, to: models.OrderRefunded, allowed: false},
+		{from: models.OrderPending, to: models.OrderConfirmed, allowed: false},
+		{from: models.OrderCancel, to: models.OrderPayed, allowed: false},
+		{from: models.OrderRefunded, to: models.OrderPending, allowed: false},
+		{from: models.OrderPayed, to: models.OrderPayed, allowed: false},
+	}
+
+	for _, v := range samples {
+		assert.Equal(t, models.CanTransition(v.from, v.to), v.allowed)
+		err := models.CheckTransition(v.from, v.to)
+		if v.allowed {
+			assert.Equal(t, err, nil)
+		} else {
+			assert.NotEqual(t, err, nil)
+		}
+	}
 }
 
 func TestDeleteOrder(t *testing.T) {
@@ -181,6 +342,76 @@ func TestDeleteOrder(t *testing.T) {
 	assert.Equal(t, isDeleted, int64(1))
 }
 
+func TestFindEventsByOrder(t *testing.T) {
+
+	err := refreshEverything()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	products, err := seedProducts()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student, err := seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	newOrder := models.Order{
+		UserID: student.ID,
+		ShopID: products[0].ShopID,
+		OrderItems: []models.OrderLine{
+			models.OrderLine{ProductID: products[0].ID, Quantity: 1},
+		},
+	}
+
+	savedOrder, err := newOrder.CreateOrder(server.DB)
+	if err != nil {
+		t.Errorf("This is the error creating the order: %v\n", err)
+		return
+	}
+
+	orderUpdate := models.Order{
+		Status:    models.OrderPayed,
+		UpdatedBy: products[0].ShopID,
+	}
+
+	_, err = orderUpdate.UpdateOrder(server.DB, savedOrder.ID.String())
+	if err != nil {
+		t.Errorf("This is the error updating the order: %v\n", err)
+		return
+	}
+
+	orderDeleter := models.Order{
+		UpdatedBy: products[0].ShopID,
+	}
+
+	_, err = orderDeleter.DeleteOrder(server.DB, savedOrder.ID.String())
+	if err != nil {
+		t.Errorf("This is the error deleting the order: %v\n", err)
+		return
+	}
+
+	event := models.OrderEvent{}
+	events, err := event.FindEventsByOrder(server.DB, savedOrder.ID.String())
+	if err != nil {
+		t.Errorf("This is the error getting the order history: %v\n", err)
+		return
+	}
+
+	history := *events
+	assert.Equal(t, len(history), 3)
+	assert.Equal(t, history[0].Action, models.OrderEventCreated)
+	assert.Equal(t, history[0].ActorID, student.ID)
+	assert.Equal(t, history[1].Action, models.OrderEventStatusChanged)
+	assert.Equal(t, history[1].FromStatus, models.OrderPending)
+	assert.Equal(t, history[1].ToStatus, models.OrderPayed)
+	assert.Equal(t, history[1].ActorID, products[0].ShopID)
+	assert.Equal(t, history[2].Action, models.OrderEventDeleted)
+}
+
 func TestNonExistentOrderTable(t *testing.T) {
 
 	err := refreshEverything()
@@ -208,13 +439,13 @@ func TestNonExistentOrderTable(t *testing.T) {
 		ShopID: shop.ID,
 	}
 
-	_, err = orderInstance.FindAllOrders(server.DB)
+	_, _, err = orderInstance.ListOrders(server.DB, models.ListQuery{})
 	assert.Equal(t, err.(*pq.Error).Message, "relation \"orders\" does not exist")
 
-	_, err = orderInstance.FindAllOrdersByStudent(server.DB, student.ID.String())
+	_, _, err = orderInstance.ListOrders(server.DB, models.ListQuery{Filters: map[string]interface{}{"student_id": student.ID.String()}})
 	assert.Equal(t, err.(*pq.Error).Message, "relation \"orders\" does not exist")
 
-	_, err = orderInstance.FindAllOrdersByShop(server.DB, shop.ID.String())
+	_, _, err = orderInstance.ListOrders(server.DB, models.ListQuery{Filters: map[string]interface{}{"shop_id": shop.ID.String()}})
 	assert.Equal(t, err.(*pq.Error).Message, "relation \"orders\" does not exist")
 
 	_, err = orderInstance.FindOrderByID(server.DB, shop.ID.String())
@@ -0,0 +1,184 @@
+package modelstest
+
+import (
+	"log"
+	"testing"
+
+	"github.com/amaraliou/stakeout/models"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestUpdateStudentKeepsPassword(t *testing.T) {
+
+	err := refreshStudentTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = seedStudents()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	students := []models.Student{}
+	err = server.DB.Order("email").Find(&students).Error
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	studentUpdate := models.Student{
+		User: models.User{
+			Password: "not the password",
+		},
+		FirstName: "Renamed",
+	}
+
+	_, err = studentUpdate.UpdateStudent(server.DB, students[0].ID.String())
+	if err != nil {
+		t.Errorf("This is the error updating the student: %v\n", err)
+		return
+	}
+
+	for i, student := range students {
+		stored := models.Student{}
+		err = server.DB.Where("id = ?", student.ID).Take(&stored).Error
+		if err != nil {
+			t.Errorf("This is the error getting the student: %v\n", err)
+			return
+		}
+
+		assert.Equal(t, stored.Password, student.Password)
+		assert.Equal(t, models.VerifyPassword(stored.Password, "password"), nil)
+		assert.Equal(t, stored.FirstName == "Renamed", i == 0)
+	}
+}
+
+func TestSavePasswordHashing(t *testing.T) {
+
+	err := refreshStudentTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Passwords from clients are hashed even when they look like a hash
+	lookalike, err := models.Hash("password")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student := models.Student{User: models.User{Email: "hash@gmail.com", Password: string(lookalike)}}
+	err = server.DB.Create(&student).Error
+	if err != nil {
+		t.Errorf("This is the error creating the student: %v\n", err)
+		return
+	}
+
+	// The hash read back is left alone when the student is saved again
+	stored := models.Student{}
+	err = server.DB.Where("id = ?", student.ID).Take(&stored).Error
+	if err != nil {
+		t.Errorf("This is the error getting the student: %v\n", err)
+		return
+	}
+
+	err = server.DB.Save(&stored).Error
+	if err != nil {
+		t.Errorf("This is the error saving the student: %v\n", err)
+		return
+	}
+
+	saved := models.Student{}
+	err = server.DB.Where("id = ?", student.ID).Take(&saved).Error
+	if err != nil {
+		t.Errorf("This is the error getting the student: %v\n", err)
+		return
+	}
+
+	assert.Equal(t, saved.Password, stored.Password)
+	assert.Equal(t, models.VerifyPassword(saved.Password, string(lookalike)), nil)
+	assert.NotEqual(t, models.VerifyPassword(saved.Password, "password"), nil)
+}
+
+func TestUpdatePassword(t *testing.T) {
+
+	err := refreshStudentTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student, err := seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = student.UpdatePassword(server.DB, student.ID.String(), "wrong password", "new password")
+	assert.Equal(t, err, models.ErrWrongPassword)
+
+	err = student.UpdatePassword(server.DB, student.ID.String(), "password", "new password")
+	if err != nil {
+		t.Errorf("This is the error changing the password: %v\n", err)
+		return
+	}
+
+	stored, err := student.FindStudentByID(server.DB, student.ID.String())
+	if err != nil {
+		t.Errorf("This is the error getting the student: %v\n", err)
+		return
+	}
+
+	assert.NotEqual(t, models.VerifyPassword(stored.Password, "password"), nil)
+	assert.Equal(t, models.VerifyPassword(stored.Password, "new password"), nil)
+}
+
+func TestFlagUnusablePasswords(t *testing.T) {
+
+	err := refreshEverything()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student, err := seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	admin, err := seedOneAdmin()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	emptyHash, err := models.Hash("")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// What profile updates used to leave behind
+	err = server.DB.Model(&models.Student{}).Where("id = ?", student.ID).UpdateColumn("password", string(emptyHash)).Error
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	flagged, err := models.FlagUnusablePasswords(server.DB)
+	if err != nil {
+		t.Errorf("This is the error flagging the passwords: %v\n", err)
+		return
+	}
+	assert.Equal(t, flagged, 1)
+
+	storedStudent, err := student.FindStudentByID(server.DB, student.ID.String())
+	if err != nil {
+		log.Fatal(err)
+	}
+	assert.Equal(t, storedStudent.PasswordResetRequired, true)
+
+	storedAdmin, err := admin.FindAdminByID(server.DB, admin.ID.String())
+	if err != nil {
+		log.Fatal(err)
+	}
+	assert.Equal(t, storedAdmin.PasswordResetRequired, false)
+
+	// Flagged accounts aren't counted twice
+	flagged, err = models.FlagUnusablePasswords(server.DB)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, flagged, 0)
+}
@@ -0,0 +1,229 @@
+package modelstest
+
+import (
+	"log"
+	"testing"
+
+	"github.com/amaraliou/stakeout/models"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func moveOrder(orderID string, statuses ...uint8) error {
+
+	for _, status := range statuses {
+		orderUpdate := models.Order{
+			Status: status,
+		}
+
+		_, err := orderUpdate.UpdateOrder(server.DB, orderID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func TestOrderPointsLedger(t *testing.T) {
+
+	err := refreshEverything()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	products, err := seedProducts()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student, err := seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	newOrder := models.Order{
+		UserID: student.ID,
+		ShopID: products[0].ShopID,
+		OrderItems: []models.OrderLine{
+			models.OrderLine{ProductID: products[0].ID, Quantity: 2},
+			models.OrderLine{ProductID: products[1].ID, Quantity: 1},
+		},
+	}
+
+	savedOrder, err := newOrder.CreateOrder(server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	orderID := savedOrder.ID.String()
+
+	err = moveOrder(orderID, models.OrderPayed, models.OrderReceived)
+	if err != nil {
+		t.Errorf("This is the error updating the order: %v\n", err)
+		return
+	}
+
+	foundStudent, err := studentInstance.FindStudentByID(server.DB, student.ID.String())
+	if err != nil {
+		log.Fatal(err)
+	}
+	assert.Equal(t, foundStudent.Points, 0)
+
+	err = moveOrder(orderID, models.OrderConfirmed)
+	if err != nil {
+		t.Errorf("This is the error confirming the order: %v\n", err)
+		return
+	}
+
+	foundStudent, err = studentInstance.FindStudentByID(server.DB, student.ID.String())
+	if err != nil {
+		log.Fatal(err)
+	}
+	assert.Equal(t, foundStudent.Points, products[0].Reward*2+products[1].Reward)
+
+	err = moveOrder(orderID, models.OrderRefunding, models.OrderRefunded)
+	if err != nil {
+		t.Errorf("This is the error refunding the order: %v\n", err)
+		return
+	}
+
+	foundStudent, err = studentInstance.FindStudentByID(server.DB, student.ID.String())
+	if err != nil {
+		log.Fatal(err)
+	}
+	assert.Equal(t, foundStudent.Points, 0)
+
+	entry := models.PointsEntry{}
+	entries, err := entry.FindEntriesByStudent(server.DB, student.ID.String())
+	if err != nil {
+		t.Errorf("This is the error getting the points ledger: %v\n", err)
+		return
+	}
+
+	ledger := *entries
+	assert.Equal(t, len(ledger), 2)
+	assert.Equal(t, ledger[0].Reason, models.PointsOrderRefunded)
+	assert.Equal(t, ledger[0].Amount, -(products[0].Reward*2 + products[1].Reward))
+	assert.Equal(t, ledger[1].Reason, models.PointsOrderConfirmed)
+	assert.Equal(t, ledger[1].OrderID, savedOrder.ID)
+}
+
+func TestUpdateStudentIgnoresPoints(t *testing.T) {
+
+	err := refreshStudentTable()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student, err := seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	studentUpdate := models.Student{
+		FirstName: "Joe",
+		Points:    1000,
+	}
+	studentUpdate.ID = student.ID
+
+	updatedStudent, err := studentUpdate.UpdateStudent(server.DB, student.ID.String())
+	if err != nil {
+		t.Errorf("This is the error updating the student: %v\n", err)
+		return
+	}
+
+	assert.Equal(t, updatedStudent.FirstName, "Joe")
+	assert.Equal(t, updatedStudent.Points, 0)
+}
+
+func TestRedeemPoints(t *testing.T) {
+
+	err := refreshEverything()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	products, err := seedProducts()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student, err := seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	earningOrder := models.Order{
+		UserID: student.ID,
+		ShopID: products[0].ShopID,
+		OrderItems: []models.OrderLine{
+			models.OrderLine{ProductID: products[0].ID, Quantity: 2},
+		},
+	}
+
+	savedOrder, err := earningOrder.CreateOrder(server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = moveOrder(savedOrder.ID.String(), models.OrderPayed, models.OrderReceived, models.OrderConfirmed)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	redeemingOrder := models.Order{
+		UserID: student.ID,
+		ShopID: products[0].ShopID,
+		OrderItems: []models.OrderLine{
+			models.OrderLine{ProductID: products[1].ID, Quantity: 1},
+		},
+		RedeemedPoints: 8,
+	}
+
+	_, err = redeemingOrder.CreateOrder(server.DB)
+	assert.Equal(t, err, models.ErrRedemptionDisabled)
+
+	err = server.DB.Model(&models.Shop{}).Where("id = ?", products[0].ShopID).Update("points_rate", 0.1).Error
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	redeemedOrder, err := redeemingOrder.CreateOrder(server.DB)
+	if err != nil {
+		t.Errorf("This is the error redeeming points: %v\n", err)
+		return
+	}
+
+	assert.Equal(t, redeemedOrder.PointsDiscount, float32(0.8))
+	assert.Equal(t, redeemedOrder.OrderTotal, float32(1.65))
+
+	foundStudent, err := studentInstance.FindStudentByID(server.DB, student.ID.String())
+	if err != nil {
+		log.Fatal(err)
+	}
+	assert.Equal(t, foundStudent.Points, products[0].Reward*2-8)
+
+	overspendingOrder := models.Order{
+		UserID: student.ID,
+		ShopID: products[0].ShopID,
+		OrderItems: []models.OrderLine{
+			models.OrderLine{ProductID: products[1].ID, Quantity: 1},
+		},
+		RedeemedPoints: 8,
+	}
+
+	_, err = overspendingOrder.CreateOrder(server.DB)
+	assert.Equal(t, err, models.ErrInsufficientPoints)
+
+	err = moveOrder(redeemedOrder.ID.String(), models.OrderCancel)
+	if err != nil {
+		t.Errorf("This is the error cancelling the order: %v\n", err)
+		return
+	}
+
+	foundStudent, err = studentInstance.FindStudentByID(server.DB, student.ID.String())
+	if err != nil {
+		log.Fatal(err)
+	}
+	assert.Equal(t, foundStudent.Points, products[0].Reward*2)
+}
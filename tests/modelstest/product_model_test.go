@@ -7,7 +7,7 @@ import (
 	"testing"
 )
 
-func TestFindAllProducts(t *testing.T) {
+func TestListProducts(t *testing.T) {
 
 	err := refreshEverything()
 	if err != nil {
@@ -19,7 +19,7 @@ func TestFindAllProducts(t *testing.T) {
 		log.Fatal(err)
 	}
 
-	products, err := productInstance.FindAllProducts(server.DB)
+	products, _, err := productInstance.ListProducts(server.DB, models.ListQuery{})
 	if err != nil {
 		t.Errorf("This is the error getting the products: %v\n", err)
 		return
@@ -28,7 +28,7 @@ func TestFindAllProducts(t *testing.T) {
 	assert.Equal(t, len(*products), 2)
 }
 
-func TestFindAllProductsByShop(t *testing.T) {
+func TestListProductsByShop(t *testing.T) {
 
 	err := refreshEverything()
 	if err != nil {
@@ -42,7 +42,7 @@ func TestFindAllProductsByShop(t *testing.T) {
 
 	shopID := ps[0].ShopID.String()
 
-	products, err := productInstance.FindAllProductsByShop(server.DB, shopID)
+	products, _, err := productInstance.ListProducts(server.DB, models.ListQuery{Filters: map[string]interface{}{"shop_id": shopID}})
 	if err != nil {
 		t.Errorf("This is the error getting the products by shop: %v\n", err)
 		return
@@ -159,3 +159,40 @@ func TestDeleteProduct(t *testing.T) {
 
 	assert.Equal(t, isDeleted, int64(1))
 }
+
+func TestDiscountedPrice(t *testing.T) {
+
+	samples := []struct {
+		product models.Product
+		price   float32
+	}{
+		{
+			product: models.Product{Price: 2.95},
+			price:   2.95,
+		},
+		{
+			product: models.Product{Price: 2.95, Discount: 10, DiscountUnit: models.DiscountPercent},
+			price:   2.95,
+		},
+		{
+			product: models.Product{Price: 2.95, InSale: true, Discount: 10, DiscountUnit: models.DiscountPercent},
+			price:   2.66,
+		},
+		{
+			product: models.Product{Price: 2.45, InSale: true, Discount: 45, DiscountUnit: models.DiscountAmount},
+			price:   2.00,
+		},
+		{
+			product: models.Product{Price: 2.45, InSale: true, Discount: 500, DiscountUnit: models.DiscountAmount},
+			price:   0,
+		},
+		{
+			product: models.Product{Price: 2.95, InSale: true, Discount: 10, DiscountUnit: "%"},
+			price:   2.95,
+		},
+	}
+
+	for _, v := range samples {
+		assert.Equal(t, v.product.DiscountedPrice(), v.price)
+	}
+}
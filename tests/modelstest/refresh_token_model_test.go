@@ -0,0 +1,106 @@
+package modelstest
+
+import (
+	"log"
+	"testing"
+	"time"
+
+	"github.com/amaraliou/stakeout/models"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+func TestRotateRefreshToken(t *testing.T) {
+
+	err := refreshEverything()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student, err := seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	first := &models.RefreshToken{SubjectID: student.ID}
+	firstToken, err := first.CreateRefreshToken(server.DB)
+	if err != nil {
+		t.Errorf("this is the error creating the refresh token: %v\n", err)
+		return
+	}
+
+	refresh := models.RefreshToken{}
+	second, secondToken, err := refresh.RotateRefreshToken(server.DB, firstToken)
+	if err != nil {
+		t.Errorf("this is the error rotating the refresh token: %v\n", err)
+		return
+	}
+
+	assert.Equal(t, second.FamilyID, first.FamilyID)
+	assert.Equal(t, second.SubjectID, student.ID)
+	assert.Equal(t, secondToken != firstToken, true)
+
+	revoker := models.SessionRevoker{DB: server.DB}
+	revoked, err := revoker.IsRevoked("", first.FamilyID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, revoked, false)
+
+	// The first token was already rotated, presenting it again revokes the family
+	_, _, err = refresh.RotateRefreshToken(server.DB, firstToken)
+	assert.Equal(t, err, models.ErrRefreshTokenReused)
+
+	_, _, err = refresh.RotateRefreshToken(server.DB, secondToken)
+	assert.Equal(t, err, models.ErrInvalidRefreshToken)
+
+	revoked, err = revoker.IsRevoked("", first.FamilyID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, revoked, true)
+
+	_, _, err = refresh.RotateRefreshToken(server.DB, "not-a-refresh-token")
+	assert.Equal(t, err, models.ErrInvalidRefreshToken)
+}
+
+func TestRevokeSession(t *testing.T) {
+
+	err := refreshEverything()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	student, err := seedOneStudent()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	refresh := &models.RefreshToken{SubjectID: student.ID}
+	_, err = refresh.CreateRefreshToken(server.DB)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	revoker := models.SessionRevoker{DB: server.DB}
+	jti := "33597717-e0cc-4d9e-bcab-65d48ecb2523"
+
+	err = models.RevokeSession(server.DB, jti, "", time.Now().Add(time.Hour))
+	if err != nil {
+		t.Errorf("this is the error revoking the session: %v\n", err)
+		return
+	}
+
+	revoked, err := revoker.IsRevoked(jti, "")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, revoked, true)
+
+	revoked, err = revoker.IsRevoked("", refresh.FamilyID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, revoked, false)
+
+	err = models.RevokeSubjectSessions(server.DB, student.ID.String())
+	if err != nil {
+		t.Errorf("this is the error revoking the student's sessions: %v\n", err)
+		return
+	}
+
+	revoked, err = revoker.IsRevoked("", refresh.FamilyID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, revoked, true)
+}
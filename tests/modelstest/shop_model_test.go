@@ -8,7 +8,7 @@ import (
 	"testing"
 )
 
-func TestFindAllShops(t *testing.T) {
+func TestListShops(t *testing.T) {
 
 	err := refreshShopTable()
 	if err != nil {
@@ -20,7 +20,7 @@ func TestFindAllShops(t *testing.T) {
 		log.Fatal(err)
 	}
 
-	shops, err := shopInstance.FindAllShops(server.DB)
+	shops, _, err := shopInstance.ListShops(server.DB, models.ListQuery{})
 	if err != nil {
 		t.Errorf("This is the error getting the shops: %v\n", err)
 		return
@@ -147,7 +147,7 @@ func TestNonExistentShopsTable(t *testing.T) {
 		log.Fatal(err)
 	}
 
-	_, err = shopInstance.FindAllShops(server.DB)
+	_, _, err = shopInstance.ListShops(server.DB, models.ListQuery{})
 	assert.Equal(t, err.(*pq.Error).Message, "relation \"shops\" does not exist")
 
 	_, err = shopInstance.FindShopByID(server.DB, "random_id")
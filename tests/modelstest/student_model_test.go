@@ -9,7 +9,7 @@ import (
 	"testing"
 )
 
-func TestFindAllStudents(t *testing.T) {
+func TestListStudents(t *testing.T) {
 
 	err := refreshStudentTable()
 	if err != nil {
@@ -21,7 +21,7 @@ func TestFindAllStudents(t *testing.T) {
 		log.Fatal(err)
 	}
 
-	students, err := studentInstance.FindAllStudents(server.DB)
+	students, _, err := studentInstance.ListStudents(server.DB, models.ListQuery{})
 	if err != nil {
 		t.Errorf("This is the error getting the students: %v\n", err)
 		return
@@ -166,7 +166,7 @@ func TestNonExistentStudentsTable(t *testing.T) {
 		log.Fatal(err)
 	}
 
-	_, err = studentInstance.FindAllStudents(server.DB)
+	_, _, err = studentInstance.ListStudents(server.DB, models.ListQuery{})
 	assert.Equal(t, err.(*pq.Error).Message, "relation \"students\" does not exist")
 
 	_, err = studentInstance.FindStudentByID(server.DB, "random_id")
@@ -0,0 +1,721 @@
+package repositorytest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/amaraliou/stakeout/auth"
+	"github.com/amaraliou/stakeout/geocoding"
+	"github.com/amaraliou/stakeout/handlers"
+	"github.com/amaraliou/stakeout/mailer"
+	"github.com/amaraliou/stakeout/middlewares"
+	"github.com/amaraliou/stakeout/models"
+	"github.com/amaraliou/stakeout/verification"
+	"github.com/gorilla/mux"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+// serve -> runs the handler as the given subject, claims are put on the request so no token or database is needed.
+// Public routes get nil claims
+func serve(handler http.HandlerFunc, method, body string, vars map[string]string, claims *auth.Claims) (*httptest.ResponseRecorder, map[string]interface{}) {
+
+	req, err := http.NewRequest(method, "/", bytes.NewBufferString(body))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	req = mux.SetURLVars(req, vars)
+	if claims != nil {
+		req = auth.WithClaims(req, claims)
+	}
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	responseMap := make(map[string]interface{})
+	json.Unmarshal(rr.Body.Bytes(), &responseMap)
+	return rr, responseMap
+}
+
+func TestMemoryShopHandlers(t *testing.T) {
+
+	server := handlers.Server{
+		Repositories: models.MemoryRepositories(),
+		Postcodes:    geocoding.NewDirectory(map[string]geocoding.Location{"G12 8BY": {Latitude: 55.8738, Longitude: -4.2925}}),
+	}
+
+	admin, err := server.Admins.CreateAdmin(&models.Admin{User: models.User{Email: "admin@gmail.com", Password: "password"}})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	adminClaims := &auth.Claims{SubjectID: admin.ID, IsAdmin: true, Role: auth.RoleShopOwner}
+	studentClaims := &auth.Claims{SubjectID: uuid.Must(uuid.NewV4()), Role: auth.RoleStudent}
+
+	samples := []struct {
+		createJSON   string
+		claims       *auth.Claims
+		statusCode   int
+		errorMessage string
+	}{
+		{
+			createJSON:   `{"name":"Some random shop", "description":"Random shop for testing", "postcode":"G12 *BY", "number":8, "address_1": "Amar Street", "town_or_city":"Glasgow"}`,
+			claims:       adminClaims,
+			statusCode:   422,
+			errorMessage: "Invalid shop postcode",
+		},
+		{
+			createJSON: `{"name":"Some random shop", "description":"Random shop for testing", "postcode":" g128by", "number":8, "address_1": "Amar Street", "town_or_city":"Glasgow", "require_2fa":true}`,
+			claims:     adminClaims,
+			statusCode: 201,
+		},
+		{
+			createJSON:   `{"name":"", "description":"Random shop for testing", "postcode":"G12 8BY", "number":8, "address_1": "Amar Street", "town_or_city":"Glasgow"}`,
+			claims:       adminClaims,
+			statusCode:   422,
+			errorMessage: "Required shop name",
+		},
+		{
+			createJSON:   `{"name":"Some random shop", "description":"Random shop for testing", "postcode":"G12 8BY", "number":8, "address_1": "Amar Street", "town_or_city":"Glasgow"}`,
+			claims:       studentClaims,
+			statusCode:   401,
+			errorMessage: "Unauthorized: This is not an admin token",
+		},
+	}
+
+	for _, v := range samples {
+		rr, responseMap := serve(server.CreateShop, "POST", v.createJSON, map[string]string{"admin_id": admin.ID.String()}, v.claims)
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode != 201 {
+			assert.Equal(t, responseMap["error"], v.errorMessage)
+		}
+	}
+
+	owner, err := server.Admins.FindAdminByID(admin.ID.String())
+	if err != nil {
+		log.Fatal(err)
+	}
+	assert.Equal(t, owner.Shop.Name, "Some random shop")
+	assert.Equal(t, owner.Shop.Postcode, "G12 8BY")
+	assert.Equal(t, owner.Shop.Latitude, 55.8738)
+	assert.Equal(t, owner.Shop.Longitude, -4.2925)
+	// Only superusers require two-factor authentication
+	assert.Equal(t, owner.Shop.TwoFactorRequired, false)
+
+	shopID := owner.ShopID.String()
+	rr, responseMap := serve(server.UpdateShop, "PUT", `{"name":"Renamed shop"}`, map[string]string{"admin_id": admin.ID.String(), "shop_id": shopID}, adminClaims)
+	assert.Equal(t, rr.Code, 200)
+	assert.Equal(t, responseMap["name"], "Renamed shop")
+	assert.Equal(t, responseMap["postcode"], "G12 8BY")
+
+	rr, responseMap = serve(server.GetShopByID, "GET", "", map[string]string{"id": shopID}, nil)
+	assert.Equal(t, rr.Code, 200)
+	assert.Equal(t, responseMap["name"], "Renamed shop")
+
+	// Redemption is enabled with a rate and disabled with a rate of 0, other updates leave the rate alone
+	rateSamples := []struct {
+		updateJSON   string
+		statusCode   int
+		pointsRate   float64
+		errorMessage string
+	}{
+		{updateJSON: `{"points_rate":0.1}`, statusCode: 200, pointsRate: 0.1},
+		{updateJSON: `{"name":"Renamed shop"}`, statusCode: 200, pointsRate: 0.1},
+		{updateJSON: `{"points_rate":-1}`, statusCode: 422, errorMessage: "Invalid points rate"},
+		{updateJSON: `{"points_rate":0}`, statusCode: 200, pointsRate: 0},
+	}
+
+	for _, v := range rateSamples {
+		rr, responseMap = serve(server.UpdateShop, "PUT", v.updateJSON, map[string]string{"admin_id": admin.ID.String(), "shop_id": shopID}, adminClaims)
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode == 200 {
+			assert.Equal(t, float32(responseMap["points_rate"].(float64)), float32(v.pointsRate))
+		} else {
+			assert.Equal(t, responseMap["error"], v.errorMessage)
+		}
+	}
+
+	rr, _ = serve(server.DeleteShop, "DELETE", "", map[string]string{"admin_id": admin.ID.String(), "shop_id": shopID}, adminClaims)
+	assert.Equal(t, rr.Code, 204)
+
+	rr, _ = serve(server.GetShopByID, "GET", "", map[string]string{"id": shopID}, nil)
+	assert.Equal(t, rr.Code, 500)
+}
+
+func TestMemoryNearbyShopsHandler(t *testing.T) {
+
+	server := handlers.Server{Repositories: models.MemoryRepositories()}
+	seedShopAt(server.Repositories, "University", 55.8721, -4.2882)
+
+	samples := []struct {
+		rawQuery     string
+		statusCode   int
+		length       int
+		errorMessage string
+	}{
+		{rawQuery: "lat=55.8591&lng=-4.2581", statusCode: 200, length: 1},
+		{rawQuery: "lat=55.8591&lng=-4.2581&radius=2", statusCode: 200, length: 0},
+		{rawQuery: "lng=-4.2581", statusCode: 422, errorMessage: "Required lat and lng"},
+	}
+
+	for _, v := range samples {
+		req, err := http.NewRequest("GET", "/shops/nearby?"+v.rawQuery, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.GetNearbyShops).ServeHTTP(rr, req)
+
+		responseMap := make(map[string]interface{})
+		json.Unmarshal(rr.Body.Bytes(), &responseMap)
+
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode == 200 {
+			shops := responseMap["data"].([]interface{})
+			assert.Equal(t, len(shops), v.length)
+			if len(shops) > 0 {
+				shop := shops[0].(map[string]interface{})
+				assert.Equal(t, shop["name"], "University")
+				assert.Equal(t, shop["distance_km"].(float64) > 2.36 && shop["distance_km"].(float64) < 2.38, true)
+			}
+		} else {
+			assert.Equal(t, responseMap["error"], v.errorMessage)
+		}
+	}
+}
+
+func TestMemoryProductHandlers(t *testing.T) {
+
+	server := handlers.Server{Repositories: models.MemoryRepositories()}
+	shop := seedShop(server.Repositories, 0)
+	adminClaims := &auth.Claims{SubjectID: uuid.Must(uuid.NewV4()), IsAdmin: true, Role: auth.RoleShopOwner, ShopID: shop.ID}
+	vars := map[string]string{"shop_id": shop.ID.String()}
+
+	rr, responseMap := serve(server.CreateProduct, "POST", `{"name":"Latte", "description":"Coffee with milk", "price":2.5}`, vars, adminClaims)
+	assert.Equal(t, rr.Code, 201)
+	assert.Equal(t, responseMap["name"], "Latte")
+
+	rr, responseMap = serve(server.CreateProduct, "POST", `{"name":"Latte", "description":"Coffee with milk"}`, vars, adminClaims)
+	assert.Equal(t, rr.Code, 422)
+	assert.Equal(t, responseMap["error"], "Required product price")
+
+	rr, responseMap = serve(server.CreateProduct, "POST", `{"name":"Mocha", "price":3, "is_in_sale":true, "discount":10, "discount_unit":"%"}`, vars, adminClaims)
+	assert.Equal(t, rr.Code, 422)
+	assert.Equal(t, responseMap["error"], "Invalid discount unit, expected percent or amount")
+
+	rr, responseMap = serve(server.CreateProduct, "POST", `{"name":"Mocha", "price":3, "is_in_sale":true, "discount":10}`, vars, adminClaims)
+	assert.Equal(t, rr.Code, 422)
+	assert.Equal(t, responseMap["error"], "Required discount unit")
+
+	rr, responseMap = serve(server.CreateProduct, "POST", `{"name":"Mocha", "price":-3}`, vars, adminClaims)
+	assert.Equal(t, rr.Code, 422)
+	assert.Equal(t, responseMap["error"], "Invalid product price")
+
+	rr, responseMap = serve(server.GetProductsByShop, "GET", "", vars, nil)
+	assert.Equal(t, rr.Code, 200)
+	assert.Equal(t, len(responseMap["data"].([]interface{})), 1)
+
+	product := responseMap["data"].([]interface{})[0].(map[string]interface{})
+	productVars := map[string]string{"shop_id": shop.ID.String(), "product_id": product["ID"].(string)}
+
+	rr, responseMap = serve(server.UpdateProduct, "PUT", `{"discount":120, "discount_unit":"percent"}`, productVars, adminClaims)
+	assert.Equal(t, rr.Code, 422)
+	assert.Equal(t, responseMap["error"], "Invalid product discount")
+
+	rr, responseMap = serve(server.UpdateProduct, "PUT", `{"price":3}`, productVars, adminClaims)
+	assert.Equal(t, rr.Code, 200)
+	assert.Equal(t, responseMap["price"], float64(3))
+	assert.Equal(t, responseMap["name"], "Latte")
+
+	rr, _ = serve(server.UpdateProduct, "PUT", `{"is_in_sale":true, "discount":250, "discount_unit":"amount"}`, productVars, adminClaims)
+	assert.Equal(t, rr.Code, 200)
+
+	// Updates are checked against the rest of the product, not on their own
+	updateSamples := []struct {
+		updateJSON   string
+		errorMessage string
+	}{
+		{updateJSON: `{"discount_unit":"percent"}`, errorMessage: "Invalid product discount"},
+		{updateJSON: `{"discount":400}`, errorMessage: "Invalid product discount"},
+		{updateJSON: `{"price":2}`, errorMessage: "Invalid product discount"},
+		{updateJSON: `{"price":-4}`, errorMessage: "Invalid product price"},
+	}
+
+	for _, v := range updateSamples {
+		rr, responseMap = serve(server.UpdateProduct, "PUT", v.updateJSON, productVars, adminClaims)
+		assert.Equal(t, rr.Code, 422)
+		assert.Equal(t, responseMap["error"], v.errorMessage)
+	}
+
+	rr, _ = serve(server.UpdateProduct, "PUT", `{"discount":50, "discount_unit":"percent"}`, productVars, adminClaims)
+	assert.Equal(t, rr.Code, 200)
+
+	rr, responseMap = serve(server.UpdateProduct, "PUT", `{"discount":250}`, productVars, adminClaims)
+	assert.Equal(t, rr.Code, 422)
+	assert.Equal(t, responseMap["error"], "Invalid product discount")
+
+	current, err := server.Products.FindProductByID(product["ID"].(string))
+	assert.Equal(t, err, nil)
+	assert.Equal(t, current.Price, float32(3))
+	assert.Equal(t, current.Discount, 50)
+	assert.Equal(t, current.DiscountedPrice(), float32(1.5))
+
+	// Products are only changed through the shop selling them
+	other := seedShop(server.Repositories, 0)
+	rr, _ = serve(server.UpdateProduct, "PUT", `{"price":1}`, map[string]string{"shop_id": other.ID.String(), "product_id": product["ID"].(string)}, adminClaims)
+	assert.Equal(t, rr.Code, 401)
+
+	rr, _ = serve(server.DeleteProduct, "DELETE", "", productVars, adminClaims)
+	assert.Equal(t, rr.Code, 204)
+
+	rr, _ = serve(server.GetProductByID, "GET", "", map[string]string{"id": product["ID"].(string)}, nil)
+	assert.Equal(t, rr.Code, 500)
+}
+
+func TestMemorySearchHandler(t *testing.T) {
+
+	server := handlers.Server{Repositories: models.MemoryRepositories()}
+	shop := seedShop(server.Repositories, 0)
+	_, err := server.Products.CreateProduct(&models.Product{Name: "Latte", Description: "Coffee with milk", Price: 2.5, ShopID: shop.ID})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	samples := []struct {
+		rawQuery     string
+		statusCode   int
+		products     int
+		shops        int
+		errorMessage string
+	}{
+		{rawQuery: "q=latte", statusCode: 200, products: 1},
+		{rawQuery: "q=lattes", statusCode: 200, products: 1},
+		{rawQuery: "q=latte&max_price=2", statusCode: 200},
+		{rawQuery: "q=starbucks", statusCode: 200, products: 1, shops: 1},
+		{rawQuery: "q=starbucks&max_price=2", statusCode: 200, shops: 1},
+		{rawQuery: "q=", statusCode: 422, errorMessage: "Required search query q"},
+		{rawQuery: "q=latte&max_price=cheap", statusCode: 422, errorMessage: "Invalid value for max_price"},
+	}
+
+	for _, v := range samples {
+		req, err := http.NewRequest("GET", "/search?"+v.rawQuery, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(server.Search).ServeHTTP(rr, req)
+
+		responseMap := make(map[string]interface{})
+		json.Unmarshal(rr.Body.Bytes(), &responseMap)
+
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode == 200 {
+			results := responseMap["data"].(map[string]interface{})
+			products := results["products"].([]interface{})
+			assert.Equal(t, len(products), v.products)
+			if len(products) > 0 {
+				match := products[0].(map[string]interface{})
+				assert.Equal(t, match["name"], "Latte")
+				assert.Equal(t, match["sold_by"].(map[string]interface{})["name"], "Starbucks")
+				assert.Equal(t, match["rank"].(float64) > 0, true)
+			}
+
+			shops := results["shops"].([]interface{})
+			assert.Equal(t, len(shops), v.shops)
+			if len(shops) > 0 {
+				match := shops[0].(map[string]interface{})
+				assert.Equal(t, match["name"], "Starbucks")
+				assert.Equal(t, match["rank"].(float64) > 0, true)
+			}
+		} else {
+			assert.Equal(t, responseMap["error"], v.errorMessage)
+		}
+	}
+}
+
+func TestMemoryOrderHandlers(t *testing.T) {
+
+	server := handlers.Server{Repositories: models.MemoryRepositories()}
+	student := seedStudent(server.Repositories, "amar@gmail.com")
+	shop := seedShop(server.Repositories, 0)
+	product := seedProduct(server.Repositories, shop.ID, 2.5, 1)
+
+	studentClaims := &auth.Claims{SubjectID: student.ID, Role: auth.RoleStudent}
+	adminClaims := &auth.Claims{SubjectID: uuid.Must(uuid.NewV4()), IsAdmin: true, Role: auth.RoleShopOwner, ShopID: shop.ID}
+
+	// The status and who set it are the server's, new orders are pending whatever the client sends
+	createJSON := fmt.Sprintf(`{"shop_id":"%s", "status":3, "updated_by":"%s", "status_updated_at":"2020-01-01T00:00:00Z", "ordered_items":[{"product_id":"%s", "quantity":2}]}`, shop.ID.String(), student.ID.String(), product.ID.String())
+	rr, responseMap := serve(server.CreateOrder, "POST", createJSON, map[string]string{"student_id": student.ID.String()}, studentClaims)
+	assert.Equal(t, rr.Code, 201)
+	assert.Equal(t, responseMap["total_price"], float64(5))
+	assert.Equal(t, responseMap["status"], float64(models.OrderPending))
+	assert.Equal(t, responseMap["updated_by"], uuid.Nil.String())
+	assert.Equal(t, responseMap["status_updated_at"], nil)
+
+	orderID := responseMap["ID"].(string)
+	orderVars := map[string]string{"shop_id": shop.ID.String(), "order_id": orderID}
+
+	// Only the student who placed the order and the admins of its shop can read it
+	other := seedStudent(server.Repositories, "other@gmail.com")
+	shopAdmin, err := server.Admins.CreateAdmin(&models.Admin{User: models.User{Email: "admin@gmail.com", Password: "password"}, ShopID: shop.ID})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	otherShop := seedShop(server.Repositories, 0)
+	otherAdmin, err := server.Admins.CreateAdmin(&models.Admin{User: models.User{Email: "other.admin@gmail.com", Password: "password"}, ShopID: otherShop.ID})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	readers := []struct {
+		claims     *auth.Claims
+		statusCode int
+	}{
+		{claims: studentClaims, statusCode: 200},
+		{claims: &auth.Claims{SubjectID: other.ID, Role: auth.RoleStudent}, statusCode: 404},
+		{claims: &auth.Claims{SubjectID: shopAdmin.ID, IsAdmin: true, Role: auth.RoleShopStaff}, statusCode: 200},
+		{claims: &auth.Claims{SubjectID: otherAdmin.ID, IsAdmin: true, Role: auth.RoleShopOwner, ShopID: shop.ID}, statusCode: 404},
+		{claims: &auth.Claims{SubjectID: uuid.Must(uuid.NewV4()), IsAdmin: true, Role: auth.RoleSuperuser}, statusCode: 200},
+	}
+
+	for _, v := range readers {
+		rr, responseMap = serve(server.GetOrderByID, "GET", "", map[string]string{"id": orderID}, v.claims)
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.statusCode == 200 {
+			assert.Equal(t, responseMap["ID"], orderID)
+		} else {
+			assert.Equal(t, responseMap["error"], "Order not found")
+		}
+	}
+
+	samples := []struct {
+		updateJSON string
+		statusCode int
+	}{
+		{updateJSON: `{"status":1}`, statusCode: 200},
+		{updateJSON: `{"status":1}`, statusCode: 409},
+		{updateJSON: `{"status":42}`, statusCode: 422},
+		{updateJSON: `{"status":2}`, statusCode: 200},
+	}
+
+	for _, v := range samples {
+		rr, _ = serve(server.UpdateOrder, "PUT", v.updateJSON, orderVars, adminClaims)
+		assert.Equal(t, rr.Code, v.statusCode)
+	}
+
+	rr, _ = serve(server.DeleteOrder, "DELETE", "", orderVars, adminClaims)
+	assert.Equal(t, rr.Code, 204)
+
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	req = mux.SetURLVars(req, map[string]string{"student_id": student.ID.String(), "order_id": orderID})
+	req = auth.WithClaims(req, studentClaims)
+	history := httptest.NewRecorder()
+	http.HandlerFunc(server.GetOrderHistoryByStudent).ServeHTTP(history, req)
+
+	events := []models.OrderEvent{}
+	err = json.Unmarshal(history.Body.Bytes(), &events)
+	if err != nil {
+		log.Fatalf("Cannot convert to json: %v", err)
+	}
+
+	assert.Equal(t, history.Code, 200)
+	assert.Equal(t, len(events), 4)
+	assert.Equal(t, events[0].ToStatus, models.OrderPending)
+	assert.Equal(t, events[3].Action, models.OrderEventDeleted)
+}
+
+// lastEmailedToken -> the token ends the body of the last email sent
+func lastEmailedToken(mails *mailer.Memory) string {
+	messages := mails.Messages()
+	if len(messages) == 0 {
+		return ""
+	}
+
+	fields := strings.Fields(messages[len(messages)-1].Body)
+	return fields[len(fields)-1]
+}
+
+func TestMemorySessionHandlers(t *testing.T) {
+
+	mails := &mailer.Memory{}
+	server := handlers.Server{
+		Repositories: models.MemoryRepositories(),
+		Mailer:       mails,
+		Throttle:     auth.NewThrottle(auth.NewMemoryAttempts()),
+	}
+	auth.SetRevoker(server.Sessions)
+	defer auth.SetRevoker(nil)
+
+	student := seedStudent(server.Repositories, "amar@gmail.com")
+	loginJSON := `{"email":"amar@gmail.com", "password":"password"}`
+
+	rr, responseMap := serve(server.Login, "POST", loginJSON, nil, nil)
+	assert.Equal(t, rr.Code, 403)
+	assert.Equal(t, responseMap["error"], models.ErrEmailNotVerified.Error())
+
+	// The reset link is read from the email, which verifies it
+	rr, _ = serve(server.ForgotPassword, "POST", `{"email":"amar@gmail.com"}`, nil, nil)
+	assert.Equal(t, rr.Code, 202)
+	server.WaitForEmails()
+	assert.Equal(t, len(mails.Messages()), 1)
+
+	rr, _ = serve(server.ResetPassword, "POST", fmt.Sprintf(`{"token":"%s", "password":"newpassword"}`, lastEmailedToken(mails)), nil, nil)
+	assert.Equal(t, rr.Code, 200)
+
+	rr, _ = serve(server.Login, "POST", loginJSON, nil, nil)
+	assert.Equal(t, rr.Code, 422)
+
+	rr, responseMap = serve(server.Login, "POST", `{"email":"amar@gmail.com", "password":"newpassword"}`, nil, nil)
+	assert.Equal(t, rr.Code, 200)
+	refreshToken := responseMap["refresh_token"].(string)
+
+	rr, responseMap = serve(server.RefreshToken, "POST", fmt.Sprintf(`{"refresh_token":"%s"}`, refreshToken), nil, nil)
+	assert.Equal(t, rr.Code, 200)
+	assert.NotEqual(t, responseMap["refresh_token"], refreshToken)
+
+	claims, err := auth.ParseToken(responseMap["token"].(string))
+	if err != nil {
+		log.Fatal(err)
+	}
+	assert.Equal(t, claims.SubjectID, student.ID)
+
+	rr, _ = serve(server.Logout, "POST", "", nil, claims)
+	assert.Equal(t, rr.Code, 204)
+
+	req, err := http.NewRequest("GET", "/", nil)
+	if err != nil {
+		log.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer "+responseMap["token"].(string))
+	_, err = auth.Authenticate(req)
+	assert.NotEqual(t, err, nil)
+
+	rr, _ = serve(server.RefreshToken, "POST", fmt.Sprintf(`{"refresh_token":"%s"}`, responseMap["refresh_token"]), nil, nil)
+	assert.Equal(t, rr.Code, 401)
+}
+
+func TestMemoryShopAdminMiddleware(t *testing.T) {
+
+	server := handlers.Server{Repositories: models.MemoryRepositories()}
+	shop := seedShop(server.Repositories, 0)
+	guarded := seedShop(server.Repositories, 0)
+
+	_, err := server.Shops.SetTwoFactorRequired(guarded.ID.String(), true)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	admin, err := server.Admins.CreateAdmin(&models.Admin{User: models.User{Email: "admin@gmail.com", Password: "password"}, ShopID: shop.ID})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	guardedAdmin, err := server.Admins.CreateAdmin(&models.Admin{User: models.User{Email: "guarded@gmail.com", Password: "password"}, ShopID: guarded.ID})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	samples := []struct {
+		claims       *auth.Claims
+		shopID       string
+		statusCode   int
+		errorMessage string
+	}{
+		{claims: &auth.Claims{SubjectID: admin.ID, IsAdmin: true, Role: auth.RoleShopOwner}, shopID: shop.ID.String(), statusCode: 200},
+		{claims: &auth.Claims{SubjectID: admin.ID, IsAdmin: true, Role: auth.RoleShopOwner, ShopID: guarded.ID}, shopID: guarded.ID.String(), statusCode: 403, errorMessage: "Forbidden: You are not the admin for this shop"},
+		{claims: &auth.Claims{SubjectID: guardedAdmin.ID, IsAdmin: true, Role: auth.RoleShopOwner}, shopID: guarded.ID.String(), statusCode: 403, errorMessage: "Forbidden: This shop requires two-factor authentication"},
+		{claims: &auth.Claims{SubjectID: uuid.Must(uuid.NewV4()), IsAdmin: true, Role: auth.RoleSuperuser}, shopID: guarded.ID.String(), statusCode: 200},
+	}
+
+	next := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	for _, v := range samples {
+		rr, responseMap := serve(middlewares.SetMiddlewareShopAdmin(server.Admins, next), "GET", "", map[string]string{"shop_id": v.shopID}, v.claims)
+		assert.Equal(t, rr.Code, v.statusCode)
+		if v.errorMessage != "" {
+			assert.Equal(t, responseMap["error"], v.errorMessage)
+		}
+	}
+}
+
+func TestMemoryEmailChangeHandlers(t *testing.T) {
+
+	mails := &mailer.Memory{}
+	server := handlers.Server{
+		Repositories: models.MemoryRepositories(),
+		Mailer:       mails,
+		Throttle:     auth.NewThrottle(auth.NewMemoryAttempts()),
+		Verifier:     verification.DomainProvider{Registry: verification.NewRegistry(nil)},
+	}
+
+	student := seedStudent(server.Repositories, "amar@gmail.com")
+	verifyToken, err := server.EmailTokens.CreateEmailToken(&models.EmailToken{SubjectID: student.ID, Email: "amar@gmail.com", Purpose: models.EmailTokenVerify})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = server.EmailTokens.VerifyEmail(verifyToken)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	studentVars := map[string]string{"id": student.ID.String()}
+	studentClaims := &auth.Claims{SubjectID: student.ID, Role: auth.RoleStudent}
+
+	rr, responseMap := serve(server.UpdateStudent, "PUT", `{"email":"not an email"}`, studentVars, studentClaims)
+	assert.Equal(t, rr.Code, 422)
+	assert.Equal(t, responseMap["error"], "Invalid Email")
+
+	_, err = server.Students.UpdateStudentStatus(student.ID.String(), true, "University of Glasgow")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// The student status can't be set by the client
+	rr, responseMap = serve(server.UpdateStudent, "PUT", `{"email":"amar@gmail.com", "first_name":"Jo", "is_student":false, "university":"Elsewhere"}`, studentVars, studentClaims)
+	assert.Equal(t, rr.Code, 200)
+	assert.Equal(t, responseMap["verified"], true)
+	assert.Equal(t, responseMap["is_student"], true)
+	assert.Equal(t, responseMap["university"], "University of Glasgow")
+	assert.Equal(t, len(mails.Messages()), 0)
+
+	// A new email is unverified, and not a student's, until the link sent to it is used
+	rr, responseMap = serve(server.UpdateStudent, "PUT", `{"email":"new.amar@gmail.com"}`, studentVars, studentClaims)
+	assert.Equal(t, rr.Code, 200)
+	assert.Equal(t, responseMap["verified"], false)
+	assert.Equal(t, responseMap["is_student"], false)
+	assert.Equal(t, responseMap["university"], "")
+	assert.Equal(t, len(mails.Messages()), 1)
+	assert.Equal(t, mails.Messages()[0].To, "new.amar@gmail.com")
+
+	rr, _ = serve(server.VerifyEmail, "POST", fmt.Sprintf(`{"token":"%s"}`, lastEmailedToken(mails)), nil, nil)
+	assert.Equal(t, rr.Code, 200)
+
+	updated, err := server.Students.FindStudentByID(student.ID.String())
+	if err != nil {
+		log.Fatal(err)
+	}
+	assert.Equal(t, updated.IsVerified, true)
+
+	admin, err := server.Admins.CreateAdmin(&models.Admin{User: models.User{Email: "admin@gmail.com", Password: "password", IsVerified: true}})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	adminVars := map[string]string{"id": admin.ID.String()}
+	adminClaims := &auth.Claims{SubjectID: admin.ID, IsAdmin: true, Role: auth.RoleShopOwner}
+
+	rr, responseMap = serve(server.UpdateAdmin, "PUT", `{"email":"not an email"}`, adminVars, adminClaims)
+	assert.Equal(t, rr.Code, 422)
+	assert.Equal(t, responseMap["error"], "Invalid Email")
+
+	rr, responseMap = serve(server.UpdateAdmin, "PUT", `{"email":"new.admin@gmail.com"}`, adminVars, adminClaims)
+	assert.Equal(t, rr.Code, 200)
+	assert.Equal(t, responseMap["verified"], false)
+	assert.Equal(t, len(mails.Messages()), 2)
+	assert.Equal(t, mails.Messages()[1].To, "new.admin@gmail.com")
+
+	// A reset link sent before the email changed doesn't verify the new one
+	rr, _ = serve(server.ForgotPassword, "POST", `{"email":"new.amar@gmail.com"}`, nil, nil)
+	assert.Equal(t, rr.Code, 202)
+	server.WaitForEmails()
+	assert.Equal(t, len(mails.Messages()), 3)
+	resetToken := lastEmailedToken(mails)
+
+	rr, _ = serve(server.UpdateStudent, "PUT", `{"email":"victim@student.gla.ac.uk"}`, studentVars, studentClaims)
+	assert.Equal(t, rr.Code, 200)
+
+	rr, responseMap = serve(server.ResetPassword, "POST", fmt.Sprintf(`{"token":"%s", "password":"newpassword"}`, resetToken), nil, nil)
+	assert.Equal(t, rr.Code, 422)
+	assert.Equal(t, responseMap["error"], models.ErrInvalidEmailToken.Error())
+
+	updated, err = server.Students.FindStudentByID(student.ID.String())
+	if err != nil {
+		log.Fatal(err)
+	}
+	assert.Equal(t, updated.Email, "victim@student.gla.ac.uk")
+	assert.Equal(t, updated.IsVerified, false)
+	assert.Equal(t, updated.IsStudent, false)
+}
+
+func TestMemoryEmailRequestThrottle(t *testing.T) {
+
+	mails := &mailer.Memory{}
+	server := handlers.Server{
+		Repositories: models.MemoryRepositories(),
+		Mailer:       mails,
+		Throttle:     auth.NewThrottle(auth.NewMemoryAttempts()),
+	}
+	seedStudent(server.Repositories, "amar@gmail.com")
+
+	// Registered or not, an email gets a few links before it has to wait
+	for _, email := range []string{"amar@gmail.com", "nobody@gmail.com"} {
+		for i := 0; i < auth.AccountBackoff.FreeAttempts; i++ {
+			rr, _ := serve(server.ForgotPassword, "POST", fmt.Sprintf(`{"email":"%s"}`, email), nil, nil)
+			assert.Equal(t, rr.Code, 202)
+		}
+
+		rr, responseMap := serve(server.ForgotPassword, "POST", fmt.Sprintf(`{"email":"%s"}`, email), nil, nil)
+		assert.Equal(t, rr.Code, 429)
+		assert.Equal(t, responseMap["error"], "Too many emails requested, try again in 1 seconds")
+	}
+
+	// Verification links are counted on their own
+	rr, _ := serve(server.ResendVerificationEmail, "POST", `{"email":"amar@gmail.com"}`, nil, nil)
+	assert.Equal(t, rr.Code, 202)
+
+	server.WaitForEmails()
+	assert.Equal(t, len(mails.Messages()), auth.AccountBackoff.FreeAttempts+1)
+}
+
+func TestMemoryPasswordResetRequired(t *testing.T) {
+
+	server := handlers.Server{
+		Repositories: models.MemoryRepositories(),
+		Throttle:     auth.NewThrottle(auth.NewMemoryAttempts()),
+	}
+
+	_, err := server.Students.CreateStudent(&models.Student{User: models.User{Email: "amar@gmail.com", Password: "password", IsVerified: true, PasswordResetRequired: true}})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = server.Admins.CreateAdmin(&models.Admin{User: models.User{Email: "admin@gmail.com", Password: "password", IsVerified: true, PasswordResetRequired: true}})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Only whoever knows the password learns the account is flagged
+	samples := []struct {
+		handler      http.HandlerFunc
+		loginJSON    string
+		statusCode   int
+		errorMessage string
+	}{
+		{handler: server.Login, loginJSON: `{"email":"amar@gmail.com", "password":"wrong"}`, statusCode: 422, errorMessage: models.ErrInvalidCredentials.Error()},
+		{handler: server.Login, loginJSON: `{"email":"amar@gmail.com", "password":"password"}`, statusCode: 403, errorMessage: models.ErrPasswordResetRequired.Error()},
+		{handler: server.AdminLogin, loginJSON: `{"email":"admin@gmail.com", "password":"wrong"}`, statusCode: 422, errorMessage: models.ErrInvalidCredentials.Error()},
+		{handler: server.AdminLogin, loginJSON: `{"email":"admin@gmail.com", "password":"password"}`, statusCode: 403, errorMessage: models.ErrPasswordResetRequired.Error()},
+	}
+
+	for _, v := range samples {
+		rr, responseMap := serve(v.handler, "POST", v.loginJSON, nil, nil)
+		assert.Equal(t, rr.Code, v.statusCode)
+		assert.Equal(t, responseMap["error"], v.errorMessage)
+	}
+}
@@ -0,0 +1,1192 @@
+package repositorytest
+
+import (
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/amaraliou/stakeout/auth"
+	"github.com/amaraliou/stakeout/common"
+	"github.com/amaraliou/stakeout/migrations"
+	"github.com/amaraliou/stakeout/models"
+	"github.com/jinzhu/gorm"
+	"github.com/joho/godotenv"
+	uuid "github.com/satori/go.uuid"
+	"gopkg.in/go-playground/assert.v1"
+)
+
+// suites -> the behaviour every implementation of the repositories has to share
+var suites = []struct {
+	name string
+	run  func(t *testing.T, repositories models.Repositories)
+}{
+	{name: "Students", run: testStudents},
+	{name: "Admins", run: testAdmins},
+	{name: "Shops", run: testShops},
+	{name: "Products", run: testProducts},
+	{name: "Orders", run: testOrders},
+	{name: "Points", run: testPoints},
+	{name: "Lists", run: testLists},
+	{name: "Nearby", run: testNearby},
+	{name: "Search", run: testSearch},
+	{name: "Sessions", run: testSessions},
+	{name: "EmailTokens", run: testEmailTokens},
+	{name: "TwoFactor", run: testTwoFactor},
+}
+
+var db *gorm.DB
+
+func TestMain(m *testing.M) {
+	err := godotenv.Load(os.ExpandEnv("../../.env"))
+	if err != nil {
+		fmt.Printf("Error getting env %v\n", err)
+	}
+
+	// The memory suites run anywhere, the GORM ones only next to a database
+	config, err := common.LoadConfig("")
+	if err == nil {
+		models.Configure(config.Auth)
+		db, err = gorm.Open("postgres", config.Database.DSN())
+	}
+	if err != nil {
+		fmt.Printf("Running without Postgres: %v\n", err)
+		db = nil
+	}
+
+	os.Exit(m.Run())
+}
+
+// resetDatabase -> rolls every migration back and applies them again, leaving empty tables
+func resetDatabase() {
+
+	migrator, err := migrations.New(db)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = migrator.Down(len(migrator.Migrations))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	_, err = migrator.Up()
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func TestMemoryRepositories(t *testing.T) {
+	for _, suite := range suites {
+		t.Run(suite.name, func(t *testing.T) {
+			suite.run(t, models.MemoryRepositories())
+		})
+	}
+}
+
+func TestGormRepositories(t *testing.T) {
+	if db == nil {
+		t.Skip("No database to run against")
+	}
+
+	for _, suite := range suites {
+		t.Run(suite.name, func(t *testing.T) {
+			resetDatabase()
+			suite.run(t, models.GormRepositories(db))
+		})
+	}
+}
+
+func seedStudent(repositories models.Repositories, email string) *models.Student {
+
+	student, err := repositories.Students.CreateStudent(&models.Student{
+		User:      models.User{Email: email, Password: "password"},
+		FirstName: "Amar",
+		LastName:  "Aliou",
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return student
+}
+
+func seedShop(repositories models.Repositories, pointsRate float32) *models.Shop {
+
+	shop, err := repositories.Shops.CreateShop(&models.Shop{
+		Name:        "Starbucks",
+		Description: "blah blah blah",
+		PointsRate:  pointsRate,
+		ShopAddress: models.ShopAddress{
+			Postcode:      "G12 8BG",
+			AddressNumber: 10,
+			AddressLine1:  "Bruh Street",
+			TownOrCity:    "Bruh Town",
+		},
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return shop
+}
+
+func seedShopAt(repositories models.Repositories, name string, latitude, longitude float64) *models.Shop {
+
+	shop := seedShop(repositories, 0)
+	shop, err := repositories.Shops.UpdateShop(shop.ID.String(), &models.Shop{Name: name, Latitude: latitude, Longitude: longitude})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return shop
+}
+
+func seedProduct(repositories models.Repositories, shopID uuid.UUID, price float32, reward int) *models.Product {
+
+	product, err := repositories.Products.CreateProduct(&models.Product{
+		Name:          "Latte",
+		Description:   "Coffee with milk",
+		Price:         price,
+		PriceCurrency: "GBP",
+		ShopID:        shopID,
+		Reward:        reward,
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return product
+}
+
+func testStudents(t *testing.T, repositories models.Repositories) {
+
+	student := seedStudent(repositories, "amar@gmail.com")
+	assert.NotEqual(t, student.ID, uuid.Nil)
+	assert.NotEqual(t, student.Password, "password")
+
+	_, err := repositories.Students.CreateStudent(&models.Student{User: models.User{Email: "amar@gmail.com", Password: "password"}})
+	assert.NotEqual(t, err, nil)
+
+	found, err := repositories.Students.FindStudentByID(student.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Email, "amar@gmail.com")
+	assert.Equal(t, found.Points, 0)
+
+	_, err = repositories.Students.FindStudentByID(uuid.Nil.String())
+	assert.Equal(t, err.Error(), "Student not found")
+
+	updated, err := repositories.Students.UpdateStudent(student.ID.String(), &models.Student{FirstName: "Amara", IsStudent: true, Points: 500})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, updated.FirstName, "Amara")
+	assert.Equal(t, updated.LastName, "Aliou")
+	assert.Equal(t, updated.IsStudent, false)
+	assert.Equal(t, updated.Points, 0)
+
+	err = repositories.Students.UpdateStudentPassword(student.ID.String(), "wrong", "newpassword")
+	assert.Equal(t, err, models.ErrWrongPassword)
+
+	err = repositories.Students.UpdateStudentPassword(student.ID.String(), "password", "newpassword")
+	assert.Equal(t, err, nil)
+
+	found, err = repositories.Students.FindStudentByID(student.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, models.VerifyPassword(found.Password, "newpassword"), nil)
+
+	verified, err := repositories.Students.UpdateStudentStatus(student.ID.String(), true, "University of Glasgow")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, verified.IsStudent, true)
+	assert.Equal(t, verified.University, "University of Glasgow")
+
+	seedStudent(repositories, "someone@gmail.com")
+	students, _, err := repositories.Students.ListStudents(models.ListQuery{})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*students), 2)
+
+	rowsAffected, err := repositories.Students.DeleteStudent(student.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, rowsAffected, int64(1))
+
+	_, err = repositories.Students.DeleteStudent(student.ID.String())
+	assert.Equal(t, err, gorm.ErrRecordNotFound)
+
+	_, err = repositories.Students.FindStudentByID(student.ID.String())
+	assert.NotEqual(t, err, nil)
+
+	students, _, err = repositories.Students.ListStudents(models.ListQuery{})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*students), 1)
+
+	// A password that looks like a hash is still a password, storing it as it is would make it the hash
+	lookalike, err := models.Hash("password")
+	assert.Equal(t, err, nil)
+	hashed, err := repositories.Students.CreateStudent(&models.Student{User: models.User{Email: "hash@gmail.com", Password: string(lookalike)}})
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, hashed.Password, string(lookalike))
+
+	found, err = repositories.Students.FindStudentByID(hashed.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, models.VerifyPassword(found.Password, string(lookalike)), nil)
+	assert.NotEqual(t, models.VerifyPassword(found.Password, "password"), nil)
+}
+
+func testAdmins(t *testing.T, repositories models.Repositories) {
+
+	shop := seedShop(repositories, 0)
+
+	admin, err := repositories.Admins.CreateAdmin(&models.Admin{
+		User:      models.User{Email: "admin@gmail.com", Password: "password"},
+		FirstName: "Amar",
+		LastName:  "Aliou",
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, admin.Role, "shop_owner")
+
+	_, err = repositories.Admins.CreateAdmin(&models.Admin{User: models.User{Email: "admin@gmail.com", Password: "password"}})
+	assert.NotEqual(t, err, nil)
+
+	_, err = repositories.Admins.FindAdminByID(uuid.Nil.String())
+	assert.Equal(t, err.Error(), "Admin not found")
+
+	updated, err := repositories.Admins.UpdateAdmin(admin.ID.String(), &models.Admin{LastName: "Smith", ShopID: shop.ID})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, updated.FirstName, "Amar")
+	assert.Equal(t, updated.LastName, "Smith")
+	assert.Equal(t, updated.Shop.ID, shop.ID)
+
+	updated, err = repositories.Admins.UpdateAdminRole(admin.ID.String(), &models.Admin{Role: "shop_staff"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, updated.Role, "shop_staff")
+	assert.Equal(t, updated.ShopID, shop.ID)
+
+	err = repositories.Admins.UpdateAdminPassword(admin.ID.String(), "wrong", "newpassword")
+	assert.Equal(t, err, models.ErrWrongPassword)
+
+	err = repositories.Admins.UpdateAdminPassword(admin.ID.String(), "password", "newpassword")
+	assert.Equal(t, err, nil)
+
+	admins, _, err := repositories.Admins.ListAdmins(models.ListQuery{})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*admins), 1)
+
+	rowsAffected, err := repositories.Admins.DeleteAdmin(admin.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, rowsAffected, int64(1))
+
+	_, err = repositories.Admins.FindAdminByID(admin.ID.String())
+	assert.NotEqual(t, err, nil)
+}
+
+func testShops(t *testing.T, repositories models.Repositories) {
+
+	shop := seedShop(repositories, 0.5)
+	assert.NotEqual(t, shop.ID, uuid.Nil)
+
+	_, err := repositories.Shops.FindShopByID(uuid.Nil.String())
+	assert.Equal(t, err.Error(), "Shop not found")
+
+	updated, err := repositories.Shops.UpdateShop(shop.ID.String(), &models.Shop{Name: "Costa", TwoFactorRequired: true})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, updated.Name, "Costa")
+	assert.Equal(t, updated.Description, "blah blah blah")
+	assert.Equal(t, updated.Postcode, "G12 8BG")
+
+	updated, err = repositories.Shops.SetTwoFactorRequired(shop.ID.String(), true)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, updated.TwoFactorRequired, true)
+
+	// UpdateShop leaves the rate alone, a rate of 0 disables redemption
+	updated, err = repositories.Shops.UpdateShop(shop.ID.String(), &models.Shop{PointsRate: 2})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, updated.PointsRate, float32(0.5))
+
+	updated, err = repositories.Shops.SetPointsRate(shop.ID.String(), 0)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, updated.PointsRate, float32(0))
+
+	_, err = repositories.Shops.SetPointsRate(uuid.Nil.String(), 0.1)
+	assert.Equal(t, err.Error(), "Shop not found")
+
+	// Only one shop was updated
+	other := seedShop(repositories, 0)
+	_, err = repositories.Shops.UpdateShop(other.ID.String(), &models.Shop{Name: "Pret"})
+	assert.Equal(t, err, nil)
+
+	found, err := repositories.Shops.FindShopByID(shop.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Name, "Costa")
+
+	shops, _, err := repositories.Shops.ListShops(models.ListQuery{})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*shops), 2)
+
+	rowsAffected, err := repositories.Shops.DeleteShop(shop.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, rowsAffected, int64(1))
+
+	_, err = repositories.Shops.FindShopByID(shop.ID.String())
+	assert.NotEqual(t, err, nil)
+
+	// Only superusers require two-factor authentication, a new shop can't
+	guarded, err := repositories.Shops.CreateShop(&models.Shop{Name: "Guarded", TwoFactorRequired: true})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, guarded.TwoFactorRequired, false)
+}
+
+func testProducts(t *testing.T, repositories models.Repositories) {
+
+	shop := seedShop(repositories, 0)
+	other := seedShop(repositories, 0)
+
+	_, err := repositories.Products.CreateProduct(&models.Product{Name: "Latte", ShopID: uuid.Nil})
+	assert.Equal(t, err.Error(), "Shop doesn't exist, can't create product")
+
+	product := seedProduct(repositories, shop.ID, 2.5, 1)
+	seedProduct(repositories, other.ID, 3, 1)
+
+	found, err := repositories.Products.FindProductByID(product.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Name, "Latte")
+	assert.Equal(t, found.SoldBy.ID, shop.ID)
+
+	_, err = repositories.Products.FindProductByID(uuid.Nil.String())
+	assert.Equal(t, err.Error(), "Product not found")
+
+	updated, err := repositories.Products.UpdateProduct(product.ID.String(), &models.Product{Price: 3.2})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, updated.Price, float32(3.2))
+	assert.Equal(t, updated.Name, "Latte")
+
+	products, _, err := repositories.Products.ListProducts(models.ListQuery{})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*products), 2)
+
+	byShop := models.ListQuery{Filters: map[string]interface{}{"shop_id": shop.ID}}
+	products, _, err = repositories.Products.ListProducts(byShop)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*products), 1)
+	assert.Equal(t, (*products)[0].Price, float32(3.2))
+
+	rowsAffected, err := repositories.Products.DeleteProduct(product.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, rowsAffected, int64(1))
+
+	products, _, err = repositories.Products.ListProducts(byShop)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*products), 0)
+}
+
+func testOrders(t *testing.T, repositories models.Repositories) {
+
+	student := seedStudent(repositories, "amar@gmail.com")
+	shop := seedShop(repositories, 0)
+	other := seedShop(repositories, 0)
+	product := seedProduct(repositories, shop.ID, 2.5, 1)
+	elsewhere := seedProduct(repositories, other.ID, 3, 1)
+
+	samples := []struct {
+		order        models.Order
+		errorMessage string
+	}{
+		{
+			order:        models.Order{UserID: uuid.Nil, ShopID: shop.ID},
+			errorMessage: "Student doesn't exist, can't create order",
+		},
+		{
+			order:        models.Order{UserID: student.ID, ShopID: uuid.Nil},
+			errorMessage: "Shop doesn't exist, can't create order",
+		},
+		{
+			order:        models.Order{UserID: student.ID, ShopID: shop.ID, OrderItems: []models.OrderLine{{ProductID: product.ID, Quantity: 0}}},
+			errorMessage: "Invalid quantity",
+		},
+		{
+			order:        models.Order{UserID: student.ID, ShopID: shop.ID, OrderItems: []models.OrderLine{{ProductID: elsewhere.ID, Quantity: 1}}},
+			errorMessage: fmt.Sprintf("Product %s not found in this shop", elsewhere.ID.String()),
+		},
+	}
+
+	for _, v := range samples {
+		_, err := repositories.Orders.CreateOrder(&v.order)
+		assert.NotEqual(t, err, nil)
+		if err != nil {
+			assert.Equal(t, err.Error(), v.errorMessage)
+		}
+	}
+
+	order, err := repositories.Orders.CreateOrder(&models.Order{
+		UserID:     student.ID,
+		ShopID:     shop.ID,
+		OrderItems: []models.OrderLine{{ProductID: product.ID, Quantity: 3}},
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, order.OrderTotal, float32(7.5))
+	assert.Equal(t, order.OrderItems[0].ProductName, "Latte")
+
+	found, err := repositories.Orders.FindOrderByID(order.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.OrderedBy.ID, student.ID)
+	assert.Equal(t, found.OrderedFrom.ID, shop.ID)
+	assert.Equal(t, len(found.OrderItems), 1)
+
+	_, err = repositories.Orders.FindOrderByID(uuid.Nil.String())
+	assert.Equal(t, err.Error(), "Order not found")
+
+	_, err = repositories.Orders.UpdateOrder(order.ID.String(), &models.Order{Status: models.OrderConfirmed})
+	_, isTransitionErr := err.(*models.InvalidTransitionError)
+	assert.Equal(t, isTransitionErr, true)
+
+	updated, err := repositories.Orders.UpdateOrder(order.ID.String(), &models.Order{Status: models.OrderPayed, UpdatedBy: shop.ID})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, updated.Status, models.OrderPayed)
+	assert.Equal(t, updated.UpdatedBy, shop.ID)
+
+	orders, _, err := repositories.Orders.ListOrders(models.ListQuery{Filters: map[string]interface{}{"student_id": student.ID}})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*orders), 1)
+
+	orders, _, err = repositories.Orders.ListOrders(models.ListQuery{Filters: map[string]interface{}{"shop_id": other.ID}})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*orders), 0)
+
+	rowsAffected, err := repositories.Orders.DeleteOrder(order.ID.String(), shop.ID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, rowsAffected, int64(1))
+
+	orders, _, err = repositories.Orders.ListOrders(models.ListQuery{})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*orders), 0)
+
+	// Deleted orders keep their history
+	deleted, events, err := repositories.Orders.FindOrderHistory(order.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, deleted.ID, order.ID)
+	assert.Equal(t, len(*events), 3)
+	assert.Equal(t, (*events)[0].Action, models.OrderEventCreated)
+	assert.Equal(t, (*events)[1].ToStatus, models.OrderPayed)
+	assert.Equal(t, (*events)[2].Action, models.OrderEventDeleted)
+}
+
+func testPoints(t *testing.T, repositories models.Repositories) {
+
+	student := seedStudent(repositories, "amar@gmail.com")
+	shop := seedShop(repositories, 0.1)
+	product := seedProduct(repositories, shop.ID, 2.5, 4)
+
+	order, err := repositories.Orders.CreateOrder(&models.Order{
+		UserID:     student.ID,
+		ShopID:     shop.ID,
+		OrderItems: []models.OrderLine{{ProductID: product.ID, Quantity: 2}},
+	})
+	assert.Equal(t, err, nil)
+
+	for _, status := range []uint8{models.OrderPayed, models.OrderReceived, models.OrderConfirmed} {
+		_, err = repositories.Orders.UpdateOrder(order.ID.String(), &models.Order{Status: status})
+		assert.Equal(t, err, nil)
+	}
+
+	found, err := repositories.Students.FindStudentByID(student.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Points, 8)
+
+	_, err = repositories.Orders.CreateOrder(&models.Order{
+		UserID:         student.ID,
+		ShopID:         shop.ID,
+		OrderItems:     []models.OrderLine{{ProductID: product.ID, Quantity: 1}},
+		RedeemedPoints: 9,
+	})
+	assert.Equal(t, err, models.ErrInsufficientPoints)
+
+	redeemed, err := repositories.Orders.CreateOrder(&models.Order{
+		UserID:         student.ID,
+		ShopID:         shop.ID,
+		OrderItems:     []models.OrderLine{{ProductID: product.ID, Quantity: 1}},
+		RedeemedPoints: 5,
+	})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, redeemed.PointsDiscount, float32(0.5))
+	assert.Equal(t, redeemed.OrderTotal, float32(2))
+
+	found, err = repositories.Students.FindStudentByID(student.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Points, 3)
+
+	// Cancelling gives the redeemed points back
+	_, err = repositories.Orders.UpdateOrder(redeemed.ID.String(), &models.Order{Status: models.OrderCancel})
+	assert.Equal(t, err, nil)
+
+	found, err = repositories.Students.FindStudentByID(student.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.Points, 8)
+
+	entries, err := repositories.Students.FindEntriesByStudent(student.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*entries), 3)
+	assert.Equal(t, (*entries)[0].Reason, models.PointsRedemptionReversed)
+	assert.Equal(t, (*entries)[2].Reason, models.PointsOrderConfirmed)
+
+	// A rate of 0 disables redemption at the shop
+	_, err = repositories.Shops.SetPointsRate(shop.ID.String(), 0)
+	assert.Equal(t, err, nil)
+
+	_, err = repositories.Orders.CreateOrder(&models.Order{
+		UserID:         student.ID,
+		ShopID:         shop.ID,
+		OrderItems:     []models.OrderLine{{ProductID: product.ID, Quantity: 1}},
+		RedeemedPoints: 5,
+	})
+	assert.Equal(t, err, models.ErrRedemptionDisabled)
+}
+
+// walkProducts -> every product of the list for the query string, following next_cursor from page to page
+func walkProducts(t *testing.T, repositories models.Repositories, rawQuery string) ([]models.Product, int) {
+
+	products := []models.Product{}
+	cursor := ""
+	for pages := 1; pages <= 10; pages++ {
+		values, err := url.ParseQuery(rawQuery)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if cursor != "" {
+			values.Set("cursor", cursor)
+		}
+
+		query, err := models.ParseListQuery(values, models.ProductList)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		page, next, err := repositories.Products.ListProducts(query)
+		assert.Equal(t, err, nil)
+		products = append(products, *page...)
+
+		if next == "" {
+			return products, pages
+		}
+		cursor = next
+	}
+
+	t.Fatal("The cursor never reached the last page")
+	return products, 0
+}
+
+func testLists(t *testing.T, repositories models.Repositories) {
+
+	shop := seedShop(repositories, 0)
+	other := seedShop(repositories, 0)
+	seedProduct(repositories, other.ID, 1, 0)
+
+	// Equal prices make the ID break ties, names in both cases check the collation
+	samples := []struct {
+		name   string
+		price  float32
+		inSale bool
+	}{
+		{name: "b", price: 3, inSale: true},
+		{name: "B", price: 1.5},
+		{name: "a", price: 3, inSale: true},
+		{name: "c", price: 2.3},
+		{name: "A", price: 1.5, inSale: true},
+	}
+
+	for _, v := range samples {
+		_, err := repositories.Products.CreateProduct(&models.Product{Name: v.name, Price: v.price, InSale: v.inSale, ShopID: shop.ID})
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	byShop := "shop_id=" + shop.ID.String()
+
+	products, pages := walkProducts(t, repositories, byShop+"&limit=2&sort=price")
+	assert.Equal(t, pages, 3)
+	assert.Equal(t, len(products), 5)
+	for i := 1; i < len(products); i++ {
+		assert.Equal(t, products[i-1].Price <= products[i].Price, true)
+		assert.NotEqual(t, products[i-1].ID, products[i].ID)
+	}
+
+	products, _ = walkProducts(t, repositories, byShop+"&limit=2&sort=-price")
+	assert.Equal(t, len(products), 5)
+	assert.Equal(t, products[0].Price, float32(3))
+	assert.Equal(t, products[4].Price, float32(1.5))
+
+	products, pages = walkProducts(t, repositories, byShop+"&limit=3&sort=name")
+	assert.Equal(t, pages, 2)
+	names := ""
+	for _, product := range products {
+		names += product.Name
+	}
+	assert.Equal(t, names, "ABabc")
+
+	products, pages = walkProducts(t, repositories, byShop+"&is_in_sale=true&limit=3")
+	assert.Equal(t, pages, 1)
+	assert.Equal(t, len(products), 3)
+
+	products, _ = walkProducts(t, repositories, "limit=5")
+	assert.Equal(t, len(products), 6)
+
+	// An exact page still says there's nothing after it
+	products, pages = walkProducts(t, repositories, byShop+"&limit=5")
+	assert.Equal(t, pages, 1)
+	assert.Equal(t, len(products), 5)
+
+	_, next, err := repositories.Products.ListProducts(models.ListQuery{Limit: 2, Sort: "price"})
+	assert.Equal(t, err, nil)
+	assert.NotEqual(t, next, "")
+
+	errorSamples := []struct {
+		rawQuery     string
+		errorMessage string
+	}{
+		{rawQuery: "limit=0", errorMessage: "Invalid limit, expected a number between 1 and 100"},
+		{rawQuery: "limit=101", errorMessage: "Invalid limit, expected a number between 1 and 100"},
+		{rawQuery: "sort=description", errorMessage: "Invalid sort field description"},
+		{rawQuery: "sort=-shop_id", errorMessage: "Invalid sort field shop_id"},
+		{rawQuery: "is_in_sale=maybe", errorMessage: "Invalid value for is_in_sale"},
+		{rawQuery: "shop_id=1234", errorMessage: "Invalid value for shop_id"},
+		{rawQuery: "cursor=nonsense", errorMessage: "Invalid cursor"},
+		// Cursors only work with the sort they were made for
+		{rawQuery: "sort=name&cursor=" + next, errorMessage: "Invalid cursor"},
+	}
+
+	for _, v := range errorSamples {
+		values, err := url.ParseQuery(v.rawQuery)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		_, err = models.ParseListQuery(values, models.ProductList)
+		assert.NotEqual(t, err, nil)
+		if err != nil {
+			assert.Equal(t, err.Error(), v.errorMessage)
+		}
+	}
+}
+
+func testNearby(t *testing.T, repositories models.Repositories) {
+
+	university := seedShopAt(repositories, "University", 55.8721, -4.2882)
+	central := seedShopAt(repositories, "Central", 55.8591, -4.2581)
+	seedShopAt(repositories, "Edinburgh", 55.9533, -3.1883)
+	seedShopAt(repositories, "West of the antimeridian", -17, 179.9)
+	seedShopAt(repositories, "East of the antimeridian", -17, -179.98)
+	seedShopAt(repositories, "Arctic", 89.99, 0)
+	seedShopAt(repositories, "Other side of the pole", 89.99, 180)
+	// Where shops whose postcode couldn't be located are left
+	seedShopAt(repositories, "Unlocated", 0, 0)
+
+	samples := []struct {
+		rawQuery string
+		names    []string
+	}{
+		{rawQuery: "lat=55.8721&lng=-4.2882", names: []string{"University", "Central"}},
+		{rawQuery: "lat=55.8721&lng=-4.2882&limit=1", names: []string{"University"}},
+		{rawQuery: "lat=55.8721&lng=-4.2882&radius=1", names: []string{"University"}},
+		// Edinburgh is 69km away
+		{rawQuery: "lat=55.8721&lng=-4.2882&radius=50", names: []string{"University", "Central"}},
+		{rawQuery: "lat=-17&lng=179.98&radius=10", names: []string{"East of the antimeridian", "West of the antimeridian"}},
+		{rawQuery: "lat=89.99&lng=60", names: []string{"Arctic", "Other side of the pole"}},
+		{rawQuery: "lat=40.4168&lng=-3.7038", names: []string{}},
+		{rawQuery: "lat=0.01&lng=0.01", names: []string{}},
+	}
+
+	for _, v := range samples {
+		values, err := url.ParseQuery(v.rawQuery)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		query, err := models.ParseNearbyQuery(values)
+		assert.Equal(t, err, nil)
+
+		shops, err := repositories.Shops.FindShopsNearby(query)
+		assert.Equal(t, err, nil)
+
+		names := []string{}
+		for _, shop := range *shops {
+			names = append(names, shop.Name)
+		}
+		assert.Equal(t, names, v.names)
+	}
+
+	query := models.NearbyQuery{Location: models.CurrentLocation{Latitude: university.Latitude, Longitude: university.Longitude}}
+	shops, err := repositories.Shops.FindShopsNearby(query)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*shops), 2)
+	assert.Equal(t, (*shops)[0].Distance < 0.001, true)
+	assert.Equal(t, (*shops)[1].Distance > 2.36 && (*shops)[1].Distance < 2.38, true)
+
+	_, err = repositories.Shops.DeleteShop(central.ID.String())
+	assert.Equal(t, err, nil)
+
+	shops, err = repositories.Shops.FindShopsNearby(query)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*shops), 1)
+
+	errorSamples := []struct {
+		rawQuery     string
+		errorMessage string
+	}{
+		{rawQuery: "lat=55.8721", errorMessage: "Required lat and lng"},
+		{rawQuery: "lat=91&lng=0", errorMessage: "Invalid latitude, expected a number between -90 and 90"},
+		{rawQuery: "lat=north&lng=0", errorMessage: "Invalid latitude, expected a number between -90 and 90"},
+		{rawQuery: "lat=0&lng=-180.5", errorMessage: "Invalid longitude, expected a number between -180 and 180"},
+		{rawQuery: "lat=0&lng=0&radius=0", errorMessage: "Invalid radius, expected a number of kilometres up to 50"},
+		{rawQuery: "lat=0&lng=0&radius=51", errorMessage: "Invalid radius, expected a number of kilometres up to 50"},
+		{rawQuery: "lat=0&lng=0&limit=101", errorMessage: "Invalid limit, expected a number between 1 and 100"},
+	}
+
+	for _, v := range errorSamples {
+		values, err := url.ParseQuery(v.rawQuery)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		_, err = models.ParseNearbyQuery(values)
+		assert.NotEqual(t, err, nil)
+		if err != nil {
+			assert.Equal(t, err.Error(), v.errorMessage)
+		}
+	}
+}
+
+// searchNames -> the names of the products found for the query string, in the order of the results
+func searchNames(t *testing.T, repositories models.Repositories, rawQuery string) []string {
+
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	query, err := models.ParseSearchQuery(values)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	matches, err := repositories.Products.SearchProducts(query)
+	assert.Equal(t, err, nil)
+
+	names := []string{}
+	for _, match := range *matches {
+		names = append(names, match.Name)
+	}
+	return names
+}
+
+// searchShopNames -> the names of the shops found for the query string, in the order of the results
+func searchShopNames(t *testing.T, repositories models.Repositories, rawQuery string) []string {
+
+	values, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	query, err := models.ParseSearchQuery(values)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	matches, err := repositories.Shops.SearchShops(query)
+	assert.Equal(t, err, nil)
+
+	names := []string{}
+	for _, match := range *matches {
+		names = append(names, match.Name)
+	}
+	return names
+}
+
+func testSearch(t *testing.T, repositories models.Repositories) {
+
+	shops := []*models.Shop{}
+	for _, v := range []models.Shop{
+		{Name: "Bean There", Description: "Speciality coffee roasters"},
+		{Name: "Tea Total", Description: "Loose leaf teas"},
+	} {
+		shop := seedShop(repositories, 0)
+		shop, err := repositories.Shops.UpdateShop(shop.ID.String(), &models.Shop{Name: v.Name, Description: v.Description})
+		if err != nil {
+			log.Fatal(err)
+		}
+		shops = append(shops, shop)
+	}
+	beanThere, teaTotal := shops[0], shops[1]
+
+	products := []models.Product{
+		{Name: "Cappuccino", Description: "Espresso with steamed milk foam", Code: "CAP1", Price: 2.8, ShopID: beanThere.ID},
+		{Name: "Flat white", Description: "Smooth coffee with microfoam", Price: 2.6, InSale: true, Discount: 50, DiscountUnit: models.DiscountPercent, ShopID: beanThere.ID},
+		{Name: "Banana bread", Description: "Goes well with a cappuccino", Price: 2, ShopID: beanThere.ID},
+		{Name: "Earl Grey", Description: "Black tea with bergamot", Price: 2.2, ShopID: teaTotal.ID},
+		{Name: "Matcha latte", Description: "Whisked green tea and milk", Price: 3.2, InSale: true, Discount: 20, DiscountUnit: models.DiscountAmount, ShopID: teaTotal.ID},
+	}
+
+	for i := range products {
+		_, err := repositories.Products.CreateProduct(&products[i])
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	samples := []struct {
+		rawQuery string
+		names    []string
+	}{
+		// Names rank above descriptions
+		{rawQuery: "q=cappuccino", names: []string{"Cappuccino", "Banana bread"}},
+		{rawQuery: "q=CAPPUCCINO&limit=1", names: []string{"Cappuccino"}},
+		{rawQuery: "q=flat", names: []string{"Flat white"}},
+		{rawQuery: "q=matcha+lat", names: []string{"Matcha latte"}},
+		{rawQuery: "q=tea+milk", names: []string{"Matcha latte"}},
+		{rawQuery: "q=coffee&is_in_sale=true", names: []string{"Flat white"}},
+		{rawQuery: "q=tea&max_price=2.5", names: []string{"Earl Grey"}},
+		{rawQuery: "q=with&shop_id=" + teaTotal.ID.String(), names: []string{"Earl Grey"}},
+		{rawQuery: "q=pizza", names: []string{}},
+	}
+
+	for _, v := range samples {
+		assert.Equal(t, searchNames(t, repositories, v.rawQuery), v.names)
+	}
+
+	shopSamples := []struct {
+		rawQuery string
+		names    []string
+	}{
+		{rawQuery: "q=bean", names: []string{"Bean There"}},
+		{rawQuery: "q=coffee+roasters", names: []string{"Bean There"}},
+		{rawQuery: "q=loose+tea", names: []string{"Tea Total"}},
+		{rawQuery: "q=speciallity", names: []string{"Bean There"}},
+		{rawQuery: "q=tea&shop_id=" + beanThere.ID.String(), names: []string{}},
+		// Product filters don't apply to shops
+		{rawQuery: "q=tea&is_in_sale=true&max_price=0", names: []string{"Tea Total"}},
+		{rawQuery: "q=cappuccino", names: []string{}},
+	}
+
+	for _, v := range shopSamples {
+		assert.Equal(t, searchShopNames(t, repositories, v.rawQuery), v.names)
+	}
+
+	// Products are found through their shop too, words of a product before those of its shop
+	names := searchNames(t, repositories, "q=coffee")
+	assert.Equal(t, len(names), 3)
+	assert.Equal(t, names[0], "Flat white")
+
+	names = searchNames(t, repositories, "q=bean")
+	sort.Strings(names)
+	assert.Equal(t, names, []string{"Banana bread", "Cappuccino", "Flat white"})
+
+	// The sale price is the one compared with max_price
+	names = searchNames(t, repositories, "q=tea&max_price=3")
+	sort.Strings(names)
+	assert.Equal(t, names, []string{"Earl Grey", "Matcha latte"})
+
+	names = searchNames(t, repositories, "q=cap1")
+	assert.Equal(t, names[0], "Cappuccino")
+
+	// Typos
+	names = searchNames(t, repositories, "q=capuccino")
+	sort.Strings(names)
+	assert.Equal(t, names, []string{"Banana bread", "Cappuccino"})
+
+	names = searchNames(t, repositories, "q=earl+bergamott")
+	assert.Equal(t, names, []string{"Earl Grey"})
+
+	// Changes show up in the next search
+	_, err := repositories.Products.DeleteProduct(products[2].ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, searchNames(t, repositories, "q=cappuccino"), []string{"Cappuccino"})
+
+	_, err = repositories.Shops.UpdateShop(teaTotal.ID.String(), &models.Shop{Name: "Teapot"})
+	assert.Equal(t, err, nil)
+	names = searchNames(t, repositories, "q=teapot")
+	sort.Strings(names)
+	assert.Equal(t, names, []string{"Earl Grey", "Matcha latte"})
+	assert.Equal(t, searchShopNames(t, repositories, "q=teapot"), []string{"Teapot"})
+
+	_, err = repositories.Shops.DeleteShop(teaTotal.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, searchNames(t, repositories, "q=teapot"), []string{})
+	assert.Equal(t, searchShopNames(t, repositories, "q=teapot"), []string{})
+
+	shop := seedShop(repositories, 0)
+	_, err = repositories.Shops.UpdateShop(shop.ID.String(), &models.Shop{Name: "Fika", Description: "Swedish coffee breaks with beans from Bean There"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, searchShopNames(t, repositories, "q=swedish"), []string{"Fika"})
+	// Names rank above descriptions
+	assert.Equal(t, searchShopNames(t, repositories, "q=bean"), []string{"Bean There", "Fika"})
+
+	matches, err := repositories.Products.SearchProducts(models.SearchQuery{Terms: []string{"flat"}})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(*matches), 1)
+	assert.Equal(t, (*matches)[0].SoldBy.Name, "Bean There")
+	assert.Equal(t, (*matches)[0].Rank > 0, true)
+
+	errorSamples := []struct {
+		rawQuery     string
+		errorMessage string
+	}{
+		{rawQuery: "", errorMessage: "Required search query q"},
+		{rawQuery: "q=+-+", errorMessage: "Required search query q"},
+		{rawQuery: "q=a+b+c+d+e+f+g+h+i", errorMessage: "Invalid search query, expected at most 8 words"},
+		{rawQuery: "q=tea&is_in_sale=maybe", errorMessage: "Invalid value for is_in_sale"},
+		{rawQuery: "q=tea&max_price=-1", errorMessage: "Invalid value for max_price"},
+		{rawQuery: "q=tea&shop_id=1234", errorMessage: "Invalid value for shop_id"},
+		{rawQuery: "q=tea&limit=0", errorMessage: "Invalid limit, expected a number between 1 and 100"},
+	}
+
+	for _, v := range errorSamples {
+		values, err := url.ParseQuery(v.rawQuery)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		_, err = models.ParseSearchQuery(values)
+		assert.NotEqual(t, err, nil)
+		if err != nil {
+			assert.Equal(t, err.Error(), v.errorMessage)
+		}
+	}
+}
+
+func testSessions(t *testing.T, repositories models.Repositories) {
+
+	student := seedStudent(repositories, "sessions@gmail.com")
+
+	first := &models.RefreshToken{SubjectID: student.ID}
+	token, err := repositories.Sessions.CreateRefreshToken(first)
+	assert.Equal(t, err, nil)
+
+	next, nextToken, err := repositories.Sessions.RotateRefreshToken(token)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, next.FamilyID, first.FamilyID)
+	assert.Equal(t, next.SubjectID, student.ID)
+
+	revoked, err := repositories.Sessions.IsRevoked("", first.FamilyID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, revoked, false)
+
+	// A rotated token coming back revokes its whole family
+	_, _, err = repositories.Sessions.RotateRefreshToken(token)
+	assert.Equal(t, err, models.ErrRefreshTokenReused)
+
+	_, _, err = repositories.Sessions.RotateRefreshToken(nextToken)
+	assert.Equal(t, err, models.ErrInvalidRefreshToken)
+
+	revoked, err = repositories.Sessions.IsRevoked("", first.FamilyID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, revoked, true)
+
+	_, _, err = repositories.Sessions.RotateRefreshToken("unknown")
+	assert.Equal(t, err, models.ErrInvalidRefreshToken)
+
+	// Logging out denies the jti and revokes the session
+	loggedOut := &models.RefreshToken{SubjectID: student.ID}
+	_, err = repositories.Sessions.CreateRefreshToken(loggedOut)
+	assert.Equal(t, err, nil)
+
+	err = repositories.Sessions.RevokeSession("logged-out-jti", loggedOut.FamilyID.String(), time.Now().Add(time.Hour))
+	assert.Equal(t, err, nil)
+
+	revoked, _ = repositories.Sessions.IsRevoked("logged-out-jti", "")
+	assert.Equal(t, revoked, true)
+	revoked, _ = repositories.Sessions.IsRevoked("", loggedOut.FamilyID.String())
+	assert.Equal(t, revoked, true)
+	revoked, _ = repositories.Sessions.IsRevoked("other-jti", "")
+	assert.Equal(t, revoked, false)
+
+	// A password change keeps the session it was made from
+	kept := &models.RefreshToken{SubjectID: student.ID}
+	_, err = repositories.Sessions.CreateRefreshToken(kept)
+	assert.Equal(t, err, nil)
+	other := &models.RefreshToken{SubjectID: student.ID}
+	_, err = repositories.Sessions.CreateRefreshToken(other)
+	assert.Equal(t, err, nil)
+
+	err = repositories.Sessions.RevokeOtherSessions(student.ID.String(), kept.FamilyID.String())
+	assert.Equal(t, err, nil)
+
+	revoked, _ = repositories.Sessions.IsRevoked("", kept.FamilyID.String())
+	assert.Equal(t, revoked, false)
+	revoked, _ = repositories.Sessions.IsRevoked("", other.FamilyID.String())
+	assert.Equal(t, revoked, true)
+
+	// Deleting the account ends the sessions left
+	_, err = repositories.Students.DeleteStudent(student.ID.String())
+	assert.Equal(t, err, nil)
+
+	revoked, _ = repositories.Sessions.IsRevoked("", kept.FamilyID.String())
+	assert.Equal(t, revoked, true)
+}
+
+func testEmailTokens(t *testing.T, repositories models.Repositories) {
+
+	student := seedStudent(repositories, "tokens@gmail.com")
+
+	found, err := repositories.Students.FindStudentByEmail("tokens@gmail.com")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.ID, student.ID)
+
+	_, err = repositories.Students.FindStudentByEmail("nobody@gmail.com")
+	assert.Equal(t, err, models.ErrEmailNotFound)
+
+	firstToken, err := repositories.EmailTokens.CreateEmailToken(&models.EmailToken{SubjectID: student.ID, Email: "tokens@gmail.com", Purpose: models.EmailTokenVerify})
+	assert.Equal(t, err, nil)
+	secondToken, err := repositories.EmailTokens.CreateEmailToken(&models.EmailToken{SubjectID: student.ID, Email: "tokens@gmail.com", Purpose: models.EmailTokenVerify})
+	assert.Equal(t, err, nil)
+
+	// Only the last token sent for a purpose works, and only for that purpose
+	_, err = repositories.EmailTokens.VerifyEmail(firstToken)
+	assert.Equal(t, err, models.ErrInvalidEmailToken)
+
+	_, err = repositories.EmailTokens.ResetPassword(secondToken, "newpassword")
+	assert.Equal(t, err, models.ErrInvalidEmailToken)
+
+	verified, err := repositories.EmailTokens.VerifyEmail(secondToken)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, verified.SubjectID, student.ID)
+	assert.Equal(t, verified.IsAdmin, false)
+
+	found, err = repositories.Students.FindStudentByID(student.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.IsVerified, true)
+
+	_, err = repositories.EmailTokens.VerifyEmail(secondToken)
+	assert.Equal(t, err, models.ErrInvalidEmailToken)
+
+	_, err = repositories.Students.UpdateStudentStatus(student.ID.String(), true, "University of Glasgow")
+	assert.Equal(t, err, nil)
+
+	// Sending the same email back keeps it verified, a new one has to be verified again and loses the student status
+	updated, err := repositories.Students.UpdateStudent(student.ID.String(), &models.Student{User: models.User{Email: "tokens@gmail.com"}, FirstName: "Jo"})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, updated.IsVerified, true)
+	assert.Equal(t, updated.IsStudent, true)
+
+	pendingToken, err := repositories.EmailTokens.CreateEmailToken(&models.EmailToken{SubjectID: student.ID, Email: "tokens@gmail.com", Purpose: models.EmailTokenVerify})
+	assert.Equal(t, err, nil)
+
+	updated, err = repositories.Students.UpdateStudent(student.ID.String(), &models.Student{User: models.User{Email: "new.tokens@gmail.com"}})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, updated.Email, "new.tokens@gmail.com")
+	assert.Equal(t, updated.IsVerified, false)
+	assert.Equal(t, updated.IsStudent, false)
+	assert.Equal(t, updated.University, "")
+
+	// A link sent to the previous address doesn't verify the new one, even when no new link replaced it
+	_, err = repositories.EmailTokens.VerifyEmail(pendingToken)
+	assert.Equal(t, err, models.ErrInvalidEmailToken)
+
+	found, err = repositories.Students.FindStudentByID(student.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.IsVerified, false)
+
+	// A password reset ends every session
+	session := &models.RefreshToken{SubjectID: student.ID}
+	_, err = repositories.Sessions.CreateRefreshToken(session)
+	assert.Equal(t, err, nil)
+
+	// A reset link only works while the account has the address it was sent to, it can't verify the one changed to
+	staleToken, err := repositories.EmailTokens.CreateEmailToken(&models.EmailToken{SubjectID: student.ID, Email: "tokens@gmail.com", Purpose: models.EmailTokenPasswordReset})
+	assert.Equal(t, err, nil)
+
+	_, err = repositories.EmailTokens.ResetPassword(staleToken, "newpassword")
+	assert.Equal(t, err, models.ErrInvalidEmailToken)
+
+	found, err = repositories.Students.FindStudentByID(student.ID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.IsVerified, false)
+	assert.Equal(t, models.VerifyPassword(found.Password, "newpassword") == nil, false)
+
+	resetToken, err := repositories.EmailTokens.CreateEmailToken(&models.EmailToken{SubjectID: student.ID, Email: "new.tokens@gmail.com", Purpose: models.EmailTokenPasswordReset})
+	assert.Equal(t, err, nil)
+
+	_, err = repositories.EmailTokens.ResetPassword(resetToken, "newpassword")
+	assert.Equal(t, err, nil)
+
+	found, err = repositories.Students.FindStudentByEmail("new.tokens@gmail.com")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.IsVerified, true)
+	assert.Equal(t, models.VerifyPassword(found.Password, "newpassword"), nil)
+
+	revoked, err := repositories.Sessions.IsRevoked("", session.FamilyID.String())
+	assert.Equal(t, err, nil)
+	assert.Equal(t, revoked, true)
+}
+
+func testTwoFactor(t *testing.T, repositories models.Repositories) {
+
+	admin, err := repositories.Admins.CreateAdmin(&models.Admin{User: models.User{Email: "twofactor@gmail.com", Password: "password"}})
+	assert.Equal(t, err, nil)
+	adminID := admin.ID.String()
+
+	found, err := repositories.Admins.FindAdminByEmail("twofactor@gmail.com")
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.ID, admin.ID)
+
+	_, err = repositories.Admins.FindAdminByEmail("nobody@gmail.com")
+	assert.Equal(t, err, models.ErrEmailNotFound)
+
+	err = repositories.Admins.CheckTwoFactor(adminID, "000000")
+	assert.Equal(t, err, models.ErrTwoFactorNotEnabled)
+
+	_, err = repositories.Admins.ConfirmTwoFactor(adminID, "000000")
+	assert.Equal(t, err, models.ErrTwoFactorNotEnrolled)
+
+	secret, err := repositories.Admins.EnrollTwoFactor(adminID)
+	assert.Equal(t, err, nil)
+
+	_, err = repositories.Admins.ConfirmTwoFactor(adminID, "wrong")
+	assert.Equal(t, err, models.ErrInvalidTwoFactorCode)
+
+	code, err := auth.TOTPCode(secret, auth.TOTPStep(time.Now()))
+	assert.Equal(t, err, nil)
+
+	recoveryCodes, err := repositories.Admins.ConfirmTwoFactor(adminID, code)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, len(recoveryCodes), models.RecoveryCodeCount)
+
+	_, err = repositories.Admins.EnrollTwoFactor(adminID)
+	assert.Equal(t, err, models.ErrTwoFactorEnabled)
+
+	// The code used to confirm can't be replayed, recovery codes work once whatever their case
+	err = repositories.Admins.CheckTwoFactor(adminID, code)
+	assert.Equal(t, err, models.ErrInvalidTwoFactorCode)
+
+	err = repositories.Admins.CheckTwoFactor(adminID, strings.ToUpper(recoveryCodes[0]))
+	assert.Equal(t, err, nil)
+
+	err = repositories.Admins.CheckTwoFactor(adminID, recoveryCodes[0])
+	assert.Equal(t, err, models.ErrInvalidTwoFactorCode)
+
+	err = repositories.Admins.DisableTwoFactor(adminID, recoveryCodes[1])
+	assert.Equal(t, err, nil)
+
+	found, err = repositories.Admins.FindAdminByID(adminID)
+	assert.Equal(t, err, nil)
+	assert.Equal(t, found.TwoFactorEnabled, false)
+
+	// Admins changing their email have to verify it again too
+	verifyToken, err := repositories.EmailTokens.CreateEmailToken(&models.EmailToken{SubjectID: admin.ID, IsAdmin: true, Email: "twofactor@gmail.com", Purpose: models.EmailTokenVerify})
+	assert.Equal(t, err, nil)
+	_, err = repositories.EmailTokens.VerifyEmail(verifyToken)
+	assert.Equal(t, err, nil)
+
+	pendingToken, err := repositories.EmailTokens.CreateEmailToken(&models.EmailToken{SubjectID: admin.ID, IsAdmin: true, Email: "twofactor@gmail.com", Purpose: models.EmailTokenVerify})
+	assert.Equal(t, err, nil)
+
+	updated, err := repositories.Admins.UpdateAdmin(adminID, &models.Admin{User: models.User{Email: "new.twofactor@gmail.com"}})
+	assert.Equal(t, err, nil)
+	assert.Equal(t, updated.IsVerified, false)
+
+	_, err = repositories.EmailTokens.VerifyEmail(pendingToken)
+	assert.Equal(t, err, models.ErrInvalidEmailToken)
+
+	err = repositories.Admins.CheckTwoFactor(adminID, recoveryCodes[2])
+	assert.Equal(t, err, models.ErrTwoFactorNotEnabled)
+}
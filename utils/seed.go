@@ -35,30 +35,22 @@ var admins = []models.Admin{
 		},
 		FirstName: "Admin",
 		LastName:  "Admin",
+		Role:      "superuser",
 	},
 }
 
-// Load ... making my linter happy
+// Load -> seeds the accounts above, the ones already in the database are left as they are
 func Load(db *gorm.DB) {
 
-	err := db.Debug().DropTableIfExists(&models.Student{}, &models.Admin{}).Error
-	if err != nil {
-		log.Fatalf("cannot drop table: %v", err)
-	}
-	err = db.Debug().AutoMigrate(&models.Student{}, &models.Admin{}).Error
-	if err != nil {
-		log.Fatalf("cannot migrate table: %v", err)
-	}
-
 	for i := range students {
-		err = db.Debug().Model(&models.Student{}).Create(&students[i]).Error
+		err := db.Debug().Where("email = ?", students[i].Email).FirstOrCreate(&students[i]).Error
 		if err != nil {
 			log.Fatalf("cannot seed students table: %v", err)
 		}
 	}
 
 	for i := range admins {
-		err = db.Debug().Model(&models.Admin{}).Create(&admins[i]).Error
+		err := db.Debug().Where("email = ?", admins[i].Email).FirstOrCreate(&admins[i]).Error
 		if err != nil {
 			log.Fatalf("cannot seed admins table: %v", err)
 		}
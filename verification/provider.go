@@ -0,0 +1,50 @@
+package verification
+
+// Subject -> what a provider is told about the student being checked
+type Subject struct {
+	Email     string
+	FirstName string
+	LastName  string
+	BirthDate string
+}
+
+// Result -> outcome of a student status check
+type Result struct {
+	IsStudent  bool
+	University string
+}
+
+// Provider -> checks whether someone is a student, by email domain here, an external verifier like SheerID can implement it too
+type Provider interface {
+	Verify(subject Subject) (Result, error)
+}
+
+// DomainProvider -> Provider trusting a verified email from a domain of the registry
+type DomainProvider struct {
+	Registry *Registry
+}
+
+// Verify -> students are whoever has an email of a listed domain
+func (provider DomainProvider) Verify(subject Subject) (Result, error) {
+	university, ok := provider.Registry.University(subject.Email)
+	if !ok {
+		return Result{}, nil
+	}
+
+	return Result{IsStudent: true, University: university}, nil
+}
+
+// Fake -> Provider answering from a table keyed by email, for tests
+type Fake struct {
+	Results map[string]Result
+	Err     error
+}
+
+// Verify -> the result listed for the email, not a student otherwise
+func (provider Fake) Verify(subject Subject) (Result, error) {
+	if provider.Err != nil {
+		return Result{}, provider.Err
+	}
+
+	return provider.Results[subject.Email], nil
+}
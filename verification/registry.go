@@ -0,0 +1,85 @@
+package verification
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Registry -> maps the email domains given to students to their university
+type Registry struct {
+	domains map[string]string
+}
+
+// NewRegistry -> registry of the given domain -> university pairs
+func NewRegistry(domains map[string]string) *Registry {
+	registry := &Registry{domains: map[string]string{}}
+	for domain, university := range domains {
+		registry.domains[strings.ToLower(domain)] = university
+	}
+	return registry
+}
+
+// LoadRegistry -> reads a registry file, one "domain,university" per line, blank lines and # comments are skipped
+func LoadRegistry(path string) (*Registry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return ReadRegistry(file)
+}
+
+// ReadRegistry -> reads a registry in the format of LoadRegistry
+func ReadRegistry(reader io.Reader) (*Registry, error) {
+
+	domains := map[string]string{}
+	scanner := bufio.NewScanner(reader)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, ",", 2)
+		if len(fields) != 2 || strings.TrimSpace(fields[0]) == "" || strings.TrimSpace(fields[1]) == "" {
+			return nil, fmt.Errorf("Invalid registry line %d: %s", number, line)
+		}
+
+		domains[strings.TrimSpace(fields[0])] = strings.TrimSpace(fields[1])
+	}
+
+	err := scanner.Err()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRegistry(domains), nil
+}
+
+// University -> the university of the email's domain, or of its closest listed parent domain
+func (registry *Registry) University(email string) (string, bool) {
+
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return "", false
+	}
+
+	domain := strings.ToLower(email[at+1:])
+	for domain != "" {
+		if university, ok := registry.domains[domain]; ok {
+			return university, true
+		}
+
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			break
+		}
+		domain = domain[dot+1:]
+	}
+
+	return "", false
+}